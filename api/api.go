@@ -1,35 +1,18 @@
-//go:generate swagger generate spec
-
 // Package api Payment API.
 //
 // This package contains all the realated features af the API. Which includes
 // the logic, the data layer and the controllers.
 //
-// Terms Of Service:
-//
-// There are no TOS at this moment, use at your own risk we take no
-// responsibility
-//
-//     Schemes: http, https
-//     Host: localhost
-//     BasePath: /v1
-//     Version: 0.0.1
-//
-//     Consumes:
-//     - application/json
-//
-//     Produces:
-//     - application/json
-//
-//     Extension:
-//     x-go-name
-//
-// swagger:meta
+// The OpenAPI document of the API is built from the routes and served at
+// /v1/openapi.json, see NewOpenAPI.
 package api
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -52,16 +35,31 @@ func Now() *time.Time {
 }
 
 var (
-	mongo        *mgo.Session
-	mongoHealthy bool
-	config       *APIConfig
-	store        PaymentStore
+	mongo *mgo.Session
+	// mongoSupervisor keeps the connection of the server to MongoDB healthy
+	mongoSupervisor *MongoSupervisor
+	config          *APIConfig
+	store           PaymentStore
+	relay           *OutboxRelay
+	// cache is the read-through cache of the payments, when enabled
+	cache *PaymentCacheStore
+	// tracer traces the requests, when enabled
+	tracer *Tracer
+	// storeCloser releases the store on shutdown, when it holds a file or a
+	// connection pool
+	storeCloser io.Closer
 )
 
 func Config() *APIConfig {
 	return config
 }
 
+// mongoHealthy tells whether the connection to MongoDB answered its last
+// check
+func mongoHealthy() bool {
+	return mongoSupervisor != nil && mongoSupervisor.Healthy()
+}
+
 // NotFound is the default handler that is called when an unknown route is
 // called. It will return the following body:
 //   {
@@ -95,13 +93,16 @@ func Ping(w http.ResponseWriter, r *http.Request) {
 //   }
 func datasourceHealthy(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := StartSpan(r.Context(), "datasourceHealthy")
 		switch config.DBType {
 		case DatabaseTypeMongo:
-			if mongo == nil || !mongoHealthy {
-				handleError(w, r, ErrAPIMaintainance)
+			if !mongoHealthy() {
+				handleError(w, r.WithContext(ctx), ErrAPIMaintainance)
+				span.Finish()
 				return
 			}
 		}
+		span.Finish()
 		next.ServeHTTP(w, r)
 	})
 }
@@ -112,40 +113,31 @@ func datasourceHealthy(next http.Handler) http.Handler {
 // On failure it will stop the chain and return the error in the body.
 func paymentContext(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := StartSpan(r.Context(), "paymentContext")
 		paymentID, err := uuid.Parse(chi.URLParam(r, "paymentID"))
 		if err != nil {
-			render.Render(w, r, NewJSENDData(ErrInvalidInput))
+			render.Render(w, r.WithContext(ctx), NewJSENDData(ErrInvalidInput))
+			span.Finish()
 			return
 		}
-		payment, err := store.GetByID(paymentID)
+		payment, err := store.GetByID(ctx, paymentID)
 		if err != nil {
-			handleError(w, r, err)
+			handleError(w, r.WithContext(ctx), err)
+			span.Finish()
 			return
 		}
-		ctx := context.WithValue(r.Context(), "payment", payment)
+		span.Finish()
+		ctx = context.WithValue(r.Context(), CtxKeyPayment, payment)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// swagger:route GET /payments payments listPayments
-//
-// Lists payments with pagination
+// ListPayments lists payments with pagination
 //
 // This will show a list of payments stored in the database
-//
-//     Consumes:
-//     - application/json
-//
-//     Produces:
-//     - application/json
-//
-//     Schemes: http, https
-//
-//     Responses:
-//       200: paymentList
 func ListPayments(w http.ResponseWriter, r *http.Request) {
 	limit, offset := readLimOff(r)
-	ret, err := store.GetMany(limit, offset)
+	ret, err := store.GetMany(r.Context(), limit, offset, readFilters(r)...)
 	if err != nil {
 		handleError(w, r, err)
 		return
@@ -153,25 +145,10 @@ func ListPayments(w http.ResponseWriter, r *http.Request) {
 	render.Render(w, r, NewJSENDData(ret, http.StatusOK))
 }
 
-// swagger:route GET /payments/{id} payments getPayment
-//
-// Retrieves a single payment
-//
-//     Consumes:
-//     	- application/json
-//
-//     Produces:
-//     	- application/json
-//
-//     Schemes: http, https
-//
-//     Responses:
-//       200: singlePayment
-//       404: reqError
-//       400: reqError
+// GetPayment retrieves a single payment
 func GetPayment(w http.ResponseWriter, r *http.Request) {
 	render.Render(w, r, NewJSENDData(
-		r.Context().Value("payment"),
+		r.Context().Value(CtxKeyPayment),
 		http.StatusOK,
 	))
 }
@@ -186,33 +163,49 @@ func NewSavePaymentReq() *SavePaymentReq {
 }
 
 func (p *SavePaymentReq) Bind(req *http.Request) error {
-	// TODO: Validate
-	return nil
+	return ValidatePayment(p.Payment)
+}
+
+// ValidatePayment checks a payment before it is saved, the payments written
+// without going through the REST API must be checked with it as well
+func ValidatePayment(p *Payment) error {
+	if p == nil {
+		return ErrInvalidInput
+	}
+	return p.Validate()
+}
+
+// DecodePayment decodes and validates a JSON payment the way SavePayment does,
+// following the decoding configuration
+func DecodePayment(body []byte) (*Payment, error) {
+	r, err := http.NewRequest(http.MethodPost, URLRoot, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set(HeaderContentType, ContentTypeJSON)
+	payload := &SavePaymentReq{&Payment{}}
+	if err := bindRequest(r, payload); err != nil {
+		return nil, err
+	}
+	return payload.Payment, nil
 }
 
 // SavePayment will read the request's body and create or update a payment in
-// the data source
-// swagger:route POST /payments/{id} payments savePayment
-//
-// Creates or update a payment. When id is specified, updates the given payment
-//
-// Responses:
-//    201: singlePayment
-//		200: singlePayment
+// the data source. When id is specified, updates the given payment
 func SavePayment(w http.ResponseWriter, r *http.Request) {
 	code := http.StatusCreated
 	payload := NewSavePaymentReq()
-	if err := render.Bind(r, payload); err != nil {
-		handleError(w, r, ErrInvalidInput)
+	if err := bindRequest(r, payload); err != nil {
+		handleError(w, r, err)
 		return
 	}
-	if pCtx, ok := r.Context().Value("payment").(*Payment); ok {
+	if pCtx, ok := r.Context().Value(CtxKeyPayment).(*Payment); ok {
 		code = http.StatusOK
 		payload.Payment.ID = pCtx.ID
 		payload.CreatedAt = pCtx.CreatedAt
 		payload.UpdatedAt = pCtx.UpdatedAt
 	}
-	if err := store.Save(payload.Payment); err != nil {
+	if err := store.Save(r.Context(), payload.Payment); err != nil {
 		handleError(w, r, err)
 		return
 	}
@@ -220,16 +213,9 @@ func SavePayment(w http.ResponseWriter, r *http.Request) {
 }
 
 // DeletePayment removes a payment from the datasource
-// swagger:route DELETE /payments/{id} payments deletePayment
-//
-// Deletes a pet from the store.
-//
-// Responses:
-//    default: reqError
-//        204:
 func DeletePayment(w http.ResponseWriter, r *http.Request) {
-	payment := r.Context().Value("payment").(*Payment)
-	if err := store.Delete(payment.ID); err != nil {
+	payment := r.Context().Value(CtxKeyPayment).(*Payment)
+	if err := store.Delete(r.Context(), payment.ID); err != nil {
 		handleError(w, r, err)
 		return
 	}
@@ -239,6 +225,10 @@ func DeletePayment(w http.ResponseWriter, r *http.Request) {
 // Routes initializes the multiplexer and returns the http.Handler
 func Routes() http.Handler {
 	r := chi.NewRouter()
+	root := r
+	r.Use(serveMetrics)
+	r.Use(traceRequests(root))
+	r.Use(instrumentRequests(root))
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.AllowContentType(strings.Split(APIV1ContentTypes, ",")...))
 	if config != nil {
@@ -252,17 +242,28 @@ func Routes() http.Handler {
 		r.Use(cors.Handler)
 		if config.DevMode {
 			logrus.Info("Dev mode enabled")
-			r.Use(middleware.Logger)
+			r.Use(AccessLogger(os.Stdout))
 		}
 	}
+	r.Use(requestTimeout(root, APIV1Prefix+"/payments/events"))
+	r.Use(limitBody)
 	r.Use(datasourceHealthy)
 	r.NotFound(NotFound)
+	// the requests are validated once they went through the signature and
+	// the rate limiters of their group, the IP one runs before the signature
+	validated := validateOpenAPI(root)
 	r.Route(APIV1Prefix, func(r chi.Router) {
-		r.Get("/ping", Ping)
+		r.With(validated).Get("/ping", Ping)
+		r.With(validated).Get("/openapi.json", openAPIHandler(root))
+		r.With(validated).Get("/docs", Docs)
 		r.Route("/payments", func(r chi.Router) {
-			r.Use()
+			r.Use(ipRateLimited)
+			r.Use(signedRequest)
+			r.Use(rateLimited)
+			r.Use(validated)
 			r.Get(URLRoot, ListPayments)
 			r.Post(URLRoot, SavePayment)
+			r.Get("/events", StreamPayments)
 			r.Route("/{paymentID}", func(r chi.Router) {
 				r.Use(paymentContext)
 				r.Get(URLRoot, GetPayment)
@@ -271,6 +272,23 @@ func Routes() http.Handler {
 				r.Delete(URLRoot, DeletePayment)
 			})
 		})
+		r.Route("/webhooks", func(r chi.Router) {
+			r.Use(ipRateLimited)
+			r.Use(signedRequest)
+			r.Use(rateLimited)
+			r.Use(validated)
+			webhookRoutes(r)
+		})
+		r.Route("/debug", debugRoutes(validated))
+		r.Route("/cache", cacheRoutes(validated))
+		r.Group(func(r chi.Router) {
+			r.Use(ipRateLimited)
+			r.Use(signedRequest)
+			r.Use(rateLimited)
+			r.Use(validated)
+			r.Get("/graphql", GraphQL)
+			r.Post("/graphql", GraphQL)
+		})
 	})
 	return r
 }
@@ -281,6 +299,14 @@ func Start() error {
 		Addr:    config.GetFullHost(),
 		Handler: Routes(),
 	}
+	srv.RegisterOnShutdown(func() {
+		close(closing)
+	})
+	grpcSrv, err := startGRPC()
+	if err != nil {
+		return fmt.Errorf("Error: could not start gRPC server: %s", err)
+	}
+	adminSrv := startAdmin()
 
 	done := make(chan bool)
 	sigint := make(chan os.Signal, 1)
@@ -290,13 +316,34 @@ func Start() error {
 		if err := srv.Shutdown(context.Background()); err != nil {
 			logrus.Fatalf("Could not shutdown: %v", err)
 		}
-		if mongo != nil {
+		if grpcSrv != nil {
+			grpcSrv.GracefulStop()
+		}
+		if adminSrv != nil {
+			if err := adminSrv.Shutdown(context.Background()); err != nil {
+				logrus.Errorf("Could not shutdown the admin server: %v", err)
+			}
+		}
+		if webhooks != nil {
+			webhooks.Stop()
+		}
+		if relay != nil {
+			relay.Stop()
+		}
+		if mongoSupervisor != nil {
 			logrus.Info("Closing connection to Mongo")
-			mongo.Close()
+			mongoSupervisor.Stop()
+		}
+		if storeCloser != nil {
+			if err := storeCloser.Close(); err != nil {
+				logrus.Errorf("Could not close the store: %v", err)
+			}
+		}
+		if tracer != nil {
+			tracer.Stop()
 		}
 		close(done)
 	}()
-	var err error
 	logrus.Infof("Starting server on %s", config.GetHostURL())
 	if config.GetTLS() {
 		logrus.Info("TLS Enabled")
@@ -313,3 +360,24 @@ func Start() error {
 	<-done
 	return nil
 }
+
+// startAdmin serves the metrics on their own port when one is configured, it
+// returns nil otherwise
+func startAdmin() *http.Server {
+	if !config.Metrics.Enabled || config.Metrics.AdminPort == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle(config.Metrics.Path, MetricsHandler())
+	srv := &http.Server{
+		Addr:    net.JoinHostPort(config.Host, config.Metrics.AdminPort),
+		Handler: mux,
+	}
+	go func() {
+		logrus.Infof("Serving metrics on %s%s", srv.Addr, config.Metrics.Path)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			logrus.Errorf("Admin server: %v", err)
+		}
+	}()
+	return srv
+}
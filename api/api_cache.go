@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// cacheEnabled hides the cache routes unless the payments are cached
+func cacheEnabled(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cache == nil {
+			handleError(w, r, ErrNotFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetCacheStats returns the statistics of the cache of the payments
+func GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	render.Render(w, r, NewJSENDData(cache.Stats(), http.StatusOK))
+}
+
+// ReceiveCacheInvalidation applies the invalidation sent by a peer, it is
+// not found unless the invalidations are shared over HTTP
+func ReceiveCacheInvalidation(w http.ResponseWriter, r *http.Request) {
+	receiver, ok := cache.Invalidator.(*HTTPInvalidator)
+	if !ok {
+		handleError(w, r, ErrNotFound)
+		return
+	}
+	receiver.ServeHTTP(w, r)
+}
+
+func cacheRoutes(validated func(http.Handler) http.Handler) func(chi.Router) {
+	return func(r chi.Router) {
+		r.Use(cacheEnabled)
+		r.Use(signedRequest)
+		// the invalidations are not rate limited, dropping them leaves stale
+		// payments in the caches of the peers
+		r.With(rateLimited, validated).Get("/stats", GetCacheStats)
+		r.With(validated).Post("/invalidations", ReceiveCacheInvalidation)
+	}
+}
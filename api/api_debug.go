@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// debugEnabled hides the debug routes unless debug.enabled is set
+func debugEnabled(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config == nil || config.Debug == nil || !config.Debug.Enabled {
+			handleError(w, r, ErrNotFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// DebugMongo returns the effective settings of the connection to MongoDB,
+// resolved with the options of the URI, without their secrets
+func DebugMongo(w http.ResponseWriter, r *http.Request) {
+	settings, err := config.Mongo.Effective()
+	if err != nil {
+		handleError(w, r, ErrSomethingWentWrong(err))
+		return
+	}
+	render.Render(w, r, NewJSENDData(settings.Redacted(), http.StatusOK))
+}
+
+// DebugMongoHealth returns the health of the connection to MongoDB, it is
+// not found when the server does not use the mongo store
+func DebugMongoHealth(w http.ResponseWriter, r *http.Request) {
+	if mongoSupervisor == nil {
+		handleError(w, r, ErrNotFound)
+		return
+	}
+	render.Render(w, r, NewJSENDData(mongoSupervisor.Health(), http.StatusOK))
+}
+
+func debugRoutes(validated func(http.Handler) http.Handler) func(chi.Router) {
+	return func(r chi.Router) {
+		r.Use(debugEnabled)
+		r.Use(signedRequest)
+		r.Use(rateLimited)
+		r.Use(validated)
+		r.Get("/mongo", DebugMongo)
+		r.Get("/mongo/health", DebugMongoHealth)
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api/wire"
+)
+
+const (
+	// StreamEventReset is sent when the client resumes from an event that is
+	// no longer in the replay buffer, it should reload the payments
+	StreamEventReset = wire.StreamEventReset
+
+	streamRetry = 3 * time.Second
+)
+
+// closing is closed when the server shuts down to end the streams
+var closing = make(chan struct{})
+
+func writeStreamEvent(w http.ResponseWriter, e *BusEvent) error {
+	b, err := json.Marshal(e.Event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Sequence, e.Event.Type, b)
+	return err
+}
+
+// readLastEventID returns the ID of the last event received by the client or
+// -1. EventSource sends it in the Last-Event-ID header when reconnecting, it
+// can also be given with the lastEventId query parameter.
+func readLastEventID(r *http.Request) int64 {
+	value := r.Header.Get(HeaderLastEventID)
+	if value == "" {
+		value = r.URL.Query().Get("lastEventId")
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return -1
+	}
+	return id
+}
+
+// StreamPayments sends the payment events as Server-Sent Events. It accepts
+// the same filters as ListPayments and sends a heartbeat comment regularly to
+// keep the connection open. A client too slow to keep up is disconnected and
+// can resume with the Last-Event-ID header.
+func StreamPayments(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		handleError(w, r, ErrNotImplemented)
+		return
+	}
+	settings := &EventsSettings{
+		Buffer:    DefaultEventsBuffer,
+		Heartbeat: DefaultEventsHeartbeat,
+	}
+	if config != nil && config.Events != nil {
+		settings = config.Events
+	}
+	match, err := CompileFilters(readFilters(r)...)
+	if err != nil {
+		handleError(w, r, ErrSomethingWentWrong(err))
+		return
+	}
+	sub, missed, complete := bus.Subscribe(readLastEventID(r), settings.Buffer)
+	defer bus.Unsubscribe(sub)
+
+	w.Header().Set(HeaderContentType, ContentTypeEventStream)
+	w.Header().Set(HeaderCacheControl, "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry/time.Millisecond)
+	if !complete {
+		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", StreamEventReset)
+	}
+	for _, e := range missed {
+		if match(e.Event.Payment) {
+			if err := writeStreamEvent(w, e); err != nil {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(settings.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-closing:
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if !match(e.Event.Payment) {
+				continue
+			}
+			if err := writeStreamEvent(w, e); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -2,15 +2,18 @@ package api
 
 import (
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/render"
 	"github.com/sirupsen/logrus"
 )
 
 func handleError(w http.ResponseWriter, r *http.Request, err error) {
-	logrus.Error(err)
-	if apiErr, ok := err.(*APIError); ok {
+	logrus.WithContext(r.Context()).Error(err)
+	SpanFromContext(r.Context()).SetError(err)
+	if apiErr, ok := contextError(err).(*APIError); ok {
 		render.Render(w, r, NewJSENDData(apiErr))
 		return
 	}
@@ -36,3 +39,46 @@ func readLimOff(r *http.Request) (lim int, off int) {
 	}
 	return lim, off
 }
+
+// readFilters reads the payment filters from the query. A field given several
+// values, or comma separated values, matches any of them:
+//
+//	/payments?scheme=FPS&currency=GBP,EUR
+func readFilters(r *http.Request) []*PaymentStoreFilter {
+	if r == nil {
+		return []*PaymentStoreFilter{}
+	}
+	return FiltersFromQuery(r.URL.Query())
+}
+
+// FiltersFromQuery creates the store filters of the query parameters naming a
+// payment field, comma separated values match any of them
+func FiltersFromQuery(query url.Values) []*PaymentStoreFilter {
+	ret := []*PaymentStoreFilter{}
+	for name, field := range PaymentFilterFields {
+		values := []string{}
+		for _, v := range query[name] {
+			values = append(values, strings.Split(v, ",")...)
+		}
+		if len(values) > 0 {
+			ret = append(ret, newValuesFilter(field, values))
+		}
+	}
+	return ret
+}
+
+// newValuesFilter creates a filter matching any of the values
+func newValuesFilter(field string, values []string) *PaymentStoreFilter {
+	if len(values) == 1 {
+		return &PaymentStoreFilter{
+			Field: field,
+			Want:  values[0],
+			Type:  PaymentStoreFilterTypeEqual,
+		}
+	}
+	return &PaymentStoreFilter{
+		Field: field,
+		Want:  values,
+		Type:  PaymentStoreFilterTypeIn,
+	}
+}
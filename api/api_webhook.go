@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+)
+
+// webhookContext is a middleware that will try to fetch the webhook from the
+// store and inject it in the request's context.
+func webhookContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		webhookID, err := uuid.Parse(chi.URLParam(r, "webhookID"))
+		if err != nil {
+			render.Render(w, r, NewJSENDData(ErrInvalidInput))
+			return
+		}
+		hook, err := webhooks.Store.GetByID(webhookID)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+		ctx := context.WithValue(r.Context(), CtxKeyWebhook, hook)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// deliveryContext is a middleware that will try to fetch a delivery of the
+// webhook in context and inject it in the request's context.
+func deliveryContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deliveryID, err := uuid.Parse(chi.URLParam(r, "deliveryID"))
+		if err != nil {
+			render.Render(w, r, NewJSENDData(ErrInvalidInput))
+			return
+		}
+		delivery, err := webhooks.Store.GetDelivery(deliveryID)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+		if delivery.WebhookID != r.Context().Value(CtxKeyWebhook).(*Webhook).ID {
+			handleError(w, r, ErrNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), CtxKeyDelivery, delivery)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// webhooksEnabled is a middleware returning ErrNotImplemented when no
+// dispatcher is configured
+func webhooksEnabled(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if webhooks == nil {
+			handleError(w, r, ErrNotImplemented)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ListWebhooks returns the webhooks with pagination, secrets are redacted
+func ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	limit, offset := readLimOff(r)
+	ret, err := webhooks.Store.GetMany(limit, offset)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	hooks := ret.Results.([]*Webhook)
+	for i, hook := range hooks {
+		hooks[i] = hook.Redacted()
+	}
+	render.Render(w, r, NewJSENDData(ret, http.StatusOK))
+}
+
+// GetWebhook returns a single webhook, its secret is redacted
+func GetWebhook(w http.ResponseWriter, r *http.Request) {
+	render.Render(w, r, NewJSENDData(
+		r.Context().Value(CtxKeyWebhook).(*Webhook).Redacted(),
+		http.StatusOK,
+	))
+}
+
+// SaveWebhookReq is the payload for a webhook creation request
+type SaveWebhookReq struct {
+	*Webhook
+
+	// current is the secret of the webhook being updated, it is kept when
+	// the request gives none
+	current string
+	kept    bool
+}
+
+func NewSaveWebhookReq() *SaveWebhookReq {
+	return &SaveWebhookReq{Webhook: NewWebhook()}
+}
+
+func (p *SaveWebhookReq) Bind(req *http.Request) error {
+	if p.Webhook.Secret == "" && p.current != "" {
+		p.Webhook.Secret = p.current
+		p.kept = true
+	}
+	return p.Webhook.Validate()
+}
+
+// SaveWebhook creates or updates a webhook. The response contains the secret
+// used to sign the deliveries when it is generated or given by the request,
+// it is redacted when an update keeps the current one.
+func SaveWebhook(w http.ResponseWriter, r *http.Request) {
+	code := http.StatusCreated
+	payload := NewSaveWebhookReq()
+	hCtx, update := r.Context().Value(CtxKeyWebhook).(*Webhook)
+	if update {
+		code = http.StatusOK
+		payload.current = hCtx.Secret
+	}
+	if err := bindRequest(r, payload); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	if update {
+		payload.Webhook.ID = hCtx.ID
+		payload.CreatedAt = hCtx.CreatedAt
+	}
+	if err := webhooks.Store.Save(payload.Webhook); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	if payload.kept {
+		render.Render(w, r, NewJSENDData(payload.Webhook.Redacted(), code))
+		return
+	}
+	render.Render(w, r, NewJSENDData(payload.Webhook, code))
+}
+
+// DeleteWebhook removes a webhook and its delivery log
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	hook := r.Context().Value(CtxKeyWebhook).(*Webhook)
+	if err := webhooks.Store.Delete(hook.ID); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	render.NoContent(w, r)
+}
+
+// ListWebhookDeliveries returns the delivery log of a webhook, most recent
+// first
+func ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	hook := r.Context().Value(CtxKeyWebhook).(*Webhook)
+	limit, offset := readLimOff(r)
+	ret, err := webhooks.Store.GetDeliveries(hook.ID, limit, offset)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	render.Render(w, r, NewJSENDData(ret, http.StatusOK))
+}
+
+// GetWebhookDelivery returns a single delivery
+func GetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	render.Render(w, r, NewJSENDData(
+		r.Context().Value(CtxKeyDelivery),
+		http.StatusOK,
+	))
+}
+
+// RedeliverWebhook resets a delivery and attempts it again, unless it is
+// pending
+func RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	delivery := r.Context().Value(CtxKeyDelivery).(*WebhookDelivery)
+	ret, err := webhooks.Redeliver(delivery.ID)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	render.Render(w, r, NewJSENDData(ret, http.StatusAccepted))
+}
+
+func webhookRoutes(r chi.Router) {
+	r.Use(webhooksEnabled)
+	r.Get(URLRoot, ListWebhooks)
+	r.Post(URLRoot, SaveWebhook)
+	r.Route("/{webhookID}", func(r chi.Router) {
+		r.Use(webhookContext)
+		r.Get(URLRoot, GetWebhook)
+		r.Put(URLRoot, SaveWebhook)
+		r.Post(URLRoot, SaveWebhook)
+		r.Delete(URLRoot, DeleteWebhook)
+		r.Route("/deliveries", func(r chi.Router) {
+			r.Get(URLRoot, ListWebhookDeliveries)
+			r.Route("/{deliveryID}", func(r chi.Router) {
+				r.Use(deliveryContext)
+				r.Get(URLRoot, GetWebhookDelivery)
+				r.Post("/redeliver", RedeliverWebhook)
+			})
+		})
+	})
+}
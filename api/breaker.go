@@ -0,0 +1,101 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// CircuitBreaker stops the calls to a failing dependency. It opens after
+// Threshold consecutive failures and rejects the calls until Cooldown has
+// passed, then lets a single call through: its success closes the breaker,
+// its failure opens it again. A Threshold of 0 disables the breaker.
+type CircuitBreaker struct {
+	Name      string
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	// changed is when the breaker was opened, or the probe let through when
+	// it is half open
+	changed time.Time
+}
+
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Name:      name,
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		state:     BreakerClosed,
+	}
+}
+
+// Allow tells whether a call can go through, its outcome must then be given
+// to Success or Failure. A probe whose outcome is never given is replaced
+// after Cooldown.
+func (b *CircuitBreaker) Allow() bool {
+	if b.Threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.changed) < b.Cooldown {
+			return false
+		}
+		logrus.Infof("%s: circuit breaker half open, probing", b.Name)
+		b.state = BreakerHalfOpen
+		b.changed = time.Now()
+	case BreakerHalfOpen:
+		if time.Since(b.changed) < b.Cooldown {
+			return false
+		}
+		b.changed = time.Now()
+	}
+	return true
+}
+
+// Success records a call that went through
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerClosed {
+		logrus.Infof("%s: circuit breaker closed", b.Name)
+	}
+	b.state = BreakerClosed
+	b.failures = 0
+}
+
+// Failure records a call that failed
+func (b *CircuitBreaker) Failure() {
+	if b.Threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.Threshold {
+		if b.state != BreakerOpen {
+			logrus.Warnf("%s: circuit breaker open after %d failures", b.Name, b.failures)
+		}
+		b.state = BreakerOpen
+		b.changed = time.Now()
+	}
+}
+
+// State returns one of the Breaker states
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"sync"
+)
+
+var bus = NewEventBus(DefaultEventsReplay)
+
+// Bus returns the in-process event bus the stores publish to
+func Bus() *EventBus {
+	return bus
+}
+
+// BusEvent is a payment event numbered by the bus. Sequences are strictly
+// increasing for the lifetime of the process.
+type BusEvent struct {
+	Sequence int64
+	Event    *PaymentEvent
+}
+
+// BusSubscription receives the events published on the bus. C is closed
+// when the subscription is cancelled or when the subscriber is too slow to
+// keep up.
+type BusSubscription struct {
+	C  <-chan *BusEvent
+	c  chan *BusEvent
+	id int
+}
+
+// EventBus fans the payment events out to the in-process subscribers. It
+// keeps the last events in a bounded buffer so subscribers can resume
+// after a disconnection.
+type EventBus struct {
+	mu       sync.Mutex
+	seq      int64
+	replay   []*BusEvent
+	size     int
+	nextID   int
+	subs     map[int]*BusSubscription
+	handlers map[int]func(*PaymentEvent)
+}
+
+func NewEventBus(replaySize int) *EventBus {
+	return &EventBus{
+		size:     replaySize,
+		replay:   []*BusEvent{},
+		subs:     map[int]*BusSubscription{},
+		handlers: map[int]func(*PaymentEvent){},
+	}
+}
+
+// SetReplaySize changes the amount of events kept for resuming
+func (b *EventBus) SetReplaySize(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.size = n
+	b.trim()
+}
+
+func (b *EventBus) trim() {
+	if over := len(b.replay) - b.size; over > 0 {
+		b.replay = append([]*BusEvent{}, b.replay[over:]...)
+	}
+}
+
+// Publish numbers the event, stores it in the replay buffer and forwards it
+// to the handlers and subscribers. Subscribers whose buffer is full are
+// dropped instead of blocking the publisher.
+func (b *EventBus) Publish(e *PaymentEvent) {
+	b.mu.Lock()
+	b.seq++
+	be := &BusEvent{Sequence: b.seq, Event: e}
+	b.replay = append(b.replay, be)
+	b.trim()
+	for id, sub := range b.subs {
+		select {
+		case sub.c <- be:
+		default:
+			delete(b.subs, id)
+			close(sub.c)
+		}
+	}
+	handlers := make([]func(*PaymentEvent), 0, len(b.handlers))
+	for _, h := range b.handlers {
+		handlers = append(handlers, h)
+	}
+	b.mu.Unlock()
+	for _, h := range handlers {
+		h(e)
+	}
+}
+
+// Handle registers a function called synchronously for every event. The
+// returned function unregisters it.
+func (b *EventBus) Handle(fn func(*PaymentEvent)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	id := b.nextID
+	b.handlers[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}
+}
+
+// Subscribe creates a subscription with a buffer of the given size. The
+// events published after since that are still in the replay buffer are
+// returned, ok is false if some of them were already dropped from it. A
+// negative since means no replay.
+func (b *EventBus) Subscribe(since int64, buffer int) (sub *BusSubscription, missed []*BusEvent, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ok = true
+	missed = []*BusEvent{}
+	if since >= 0 && since < b.seq {
+		if len(b.replay) == 0 || b.replay[0].Sequence > since+1 {
+			ok = false
+		}
+		for _, e := range b.replay {
+			if e.Sequence > since {
+				missed = append(missed, e)
+			}
+		}
+	}
+	b.nextID++
+	c := make(chan *BusEvent, buffer)
+	sub = &BusSubscription{C: c, c: c, id: b.nextID}
+	b.subs[sub.id] = sub
+	return sub, missed, ok
+}
+
+// Unsubscribe cancels the subscription and closes its channel
+func (b *EventBus) Unsubscribe(sub *BusSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub.id]; ok {
+		delete(b.subs, sub.id)
+		close(sub.c)
+	}
+}
+
+// Subscribers returns the amount of active subscriptions
+func (b *EventBus) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// Sequence returns the sequence of the last event published
+func (b *EventBus) Sequence() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.seq
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruCache holds up to size values, the least recently used one is evicted
+// to make room for a new one. A value older than ttl is expired when it is
+// read, a ttl of 0 never expires them.
+type lruCache struct {
+	size int
+	ttl  time.Duration
+
+	mu          sync.Mutex
+	order       *list.List
+	entries     map[string]*list.Element
+	evictions   int64
+	expirations int64
+}
+
+type lruEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func newLRUCache(size int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// Get returns the value of key, ok is false when it is missing or expired
+func (c *lruCache) Get(key string) (value interface{}, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*lruEntry)
+	if c.ttl > 0 && time.Now().After(entry.expires) {
+		c.removeLocked(e)
+		c.expirations++
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return entry.value, true
+}
+
+// Put sets the value of key
+func (c *lruCache) Put(key string, value interface{}) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(c.ttl)
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*lruEntry)
+		entry.value, entry.expires = value, expires
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.size {
+		c.removeLocked(c.order.Back())
+		c.evictions++
+	}
+}
+
+// Remove removes key, it returns false when it was not cached
+func (c *lruCache) Remove(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if ok {
+		c.removeLocked(e)
+	}
+	return ok
+}
+
+// Clear removes every value
+func (c *lruCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = map[string]*list.Element{}
+}
+
+func (c *lruCache) removeLocked(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.entries, e.Value.(*lruEntry).key)
+}
+
+// stats returns the amount of values cached, evicted and expired
+func (c *lruCache) stats() (entries int, evictions, expirations int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len(), c.evictions, c.expirations
+}
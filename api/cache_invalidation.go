@@ -0,0 +1,145 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+)
+
+const (
+	CacheInvalidationNone = "none"
+	CacheInvalidationHTTP = "http"
+)
+
+// CacheInvalidation drops payments from the caches, every payment when IDs
+// is empty. The cached pages are always dropped.
+type CacheInvalidation struct {
+	IDs []uuid.UUID `json:"ids"`
+}
+
+func (inv *CacheInvalidation) Bind(r *http.Request) error {
+	return nil
+}
+
+// CacheInvalidator carries the invalidations between the nodes caching the
+// same store. Publish sends the invalidations of this node to the others, the
+// ones received from the others are given to the function registered with
+// Listen.
+type CacheInvalidator interface {
+	Publish(ctx context.Context, inv *CacheInvalidation) error
+	Listen(fn func(inv *CacheInvalidation))
+}
+
+// NewCacheInvalidator creates the invalidator described by the settings, nil
+// when the invalidations are not shared
+func NewCacheInvalidator(settings *CacheSettings) (CacheInvalidator, error) {
+	switch settings.Invalidation {
+	case CacheInvalidationNone, "":
+		return nil, nil
+	case CacheInvalidationHTTP:
+		if len(settings.Peers) == 0 {
+			return nil, fmt.Errorf("the %s cache invalidation needs peers", CacheInvalidationHTTP)
+		}
+		i := NewHTTPInvalidator(settings.Peers, settings.Timeout)
+		if config != nil && config.Client != nil {
+			i.ClientID, i.Secret = config.Client.ClientID, config.Client.Secret
+		}
+		return i, nil
+	default:
+		return nil, fmt.Errorf("unknown cache invalidation %q", settings.Invalidation)
+	}
+}
+
+// HTTPInvalidator POSTs the invalidations to the peers, signed when ClientID
+// is set, and receives theirs on the route it is served on
+type HTTPInvalidator struct {
+	Peers    []string
+	Client   *http.Client
+	ClientID string
+	Secret   string
+
+	mu sync.RWMutex
+	fn func(inv *CacheInvalidation)
+}
+
+func NewHTTPInvalidator(peers []string, timeout time.Duration) *HTTPInvalidator {
+	return &HTTPInvalidator{
+		Peers:  peers,
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Publish sends the invalidation to every peer, the errors of the peers are
+// joined
+func (i *HTTPInvalidator) Publish(ctx context.Context, inv *CacheInvalidation) error {
+	b, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	problems := []string{}
+	for _, peer := range i.Peers {
+		if err := i.send(ctx, peer, b); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", peer, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("could not invalidate the peers: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (i *HTTPInvalidator) send(ctx context.Context, peer string, body []byte) error {
+	url := strings.TrimSuffix(peer, "/") + APIV1Prefix + "/cache/invalidations"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(HeaderContentType, ContentTypeJSON)
+	if i.ClientID != "" {
+		if err := SignRequest(req, i.ClientID, i.Secret, uuid.New().String()); err != nil {
+			return err
+		}
+	}
+	resp, err := i.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (i *HTTPInvalidator) Listen(fn func(inv *CacheInvalidation)) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.fn = fn
+}
+
+// ServeHTTP receives an invalidation from a peer
+func (i *HTTPInvalidator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	inv := &CacheInvalidation{}
+	if err := bindRequest(r, inv); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	i.mu.RLock()
+	fn := i.fn
+	i.mu.RUnlock()
+	if fn != nil {
+		fn(inv)
+	}
+	render.NoContent(w, r)
+}
@@ -2,7 +2,10 @@ package api
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
@@ -20,50 +23,459 @@ func NewCORSSettings() *CORSSettings {
 	}
 }
 
-type MongoSettings struct {
-	Database   string `json:"database"`
-	URI        string `json:"uri"`
-	Collection string `json:"collection"`
-	MaxRetries int    `json:"max_retries"`
+// BoltSettings holds the location of the embedded store file. Timeout is how
+// long to wait for the lock of a file opened by another process.
+type BoltSettings struct {
+	Path    string        `json:"path"`
+	Timeout time.Duration `json:"timeout"`
 }
 
-func NewMongoSettings() *MongoSettings {
-	return &MongoSettings{
-		Database:   viper.GetString(ConfigKeyMongoDatabase),
-		URI:        viper.GetString(ConfigKeyMongoURI),
-		Collection: viper.GetString(ConfigKeyMongoCollection),
-		MaxRetries: viper.GetInt(ConfigKeyMongoMaxRetries),
+func NewBoltSettings() *BoltSettings {
+	return &BoltSettings{
+		Path:    viper.GetString(ConfigKeyBoltPath),
+		Timeout: viper.GetDuration(ConfigKeyBoltTimeout),
+	}
+}
+
+// InMemSettings holds the persistence of the in memory store. When Persist is
+// set, the writes are logged in Dir and synced to the disk according to Fsync:
+// always, every FsyncInterval or never. A snapshot is taken every
+// SnapshotInterval or SnapshotRecords writes, 0 disables either. Indexes are
+// the secondary indexes of the store, as parsed by ParsePaymentIndex.
+type InMemSettings struct {
+	Indexes          []string      `json:"indexes"`
+	Persist          bool          `json:"persist"`
+	Dir              string        `json:"dir"`
+	Fsync            string        `json:"fsync"`
+	FsyncInterval    time.Duration `json:"fsync_interval"`
+	SnapshotInterval time.Duration `json:"snapshot_interval"`
+	SnapshotRecords  int           `json:"snapshot_records"`
+}
+
+func NewInMemSettings() *InMemSettings {
+	return &InMemSettings{
+		Indexes:          viper.GetStringSlice(ConfigKeyInMemIndexes),
+		Persist:          viper.GetBool(ConfigKeyInMemPersist),
+		Dir:              viper.GetString(ConfigKeyInMemDir),
+		Fsync:            viper.GetString(ConfigKeyInMemFsync),
+		FsyncInterval:    viper.GetDuration(ConfigKeyInMemFsyncInterval),
+		SnapshotInterval: viper.GetDuration(ConfigKeyInMemSnapshotInterval),
+		SnapshotRecords:  viper.GetInt(ConfigKeyInMemSnapshotRecords),
+	}
+}
+
+// SQLSettings holds the connection to the SQL store. Driver is sqlite3 or
+// postgres, DSN is the file of SQLite or the connection string of PostgreSQL.
+// With AutoMigrate, the pending migrations are applied when the API starts.
+type SQLSettings struct {
+	Driver      string `json:"driver"`
+	DSN         string `json:"-"`
+	AutoMigrate bool   `json:"auto_migrate"`
+}
+
+func NewSQLSettings() *SQLSettings {
+	return &SQLSettings{
+		Driver:      viper.GetString(ConfigKeySQLDriver),
+		DSN:         viper.GetString(ConfigKeySQLDSN),
+		AutoMigrate: viper.GetBool(ConfigKeySQLAutoMigrate),
+	}
+}
+
+// SignatureSettings holds the configuration of the request signing
+// verification. Clients maps a client ID to its shared secret.
+type SignatureSettings struct {
+	Enabled bool              `json:"enabled"`
+	Window  time.Duration     `json:"window"`
+	Clients map[string]string `json:"-"`
+}
+
+func NewSignatureSettings() *SignatureSettings {
+	return &SignatureSettings{
+		Enabled: viper.GetBool(ConfigKeySignatureEnabled),
+		Window:  viper.GetDuration(ConfigKeySignatureWindow),
+		Clients: viper.GetStringMapString(ConfigKeySignatureClients),
+	}
+}
+
+// RateBudget is the amount of requests allowed during Period
+type RateBudget struct {
+	Limit  int           `json:"limit"`
+	Period time.Duration `json:"period"`
+}
+
+// RateLimitSettings holds the configuration of the rate limiter. Key defines
+// how the clients are identified: by verified client or IP. IP is the budget
+// of every IP, taken before the signature is verified.
+type RateLimitSettings struct {
+	Enabled bool        `json:"enabled"`
+	Key     string      `json:"key"`
+	Read    *RateBudget `json:"read"`
+	Write   *RateBudget `json:"write"`
+	IP      *RateBudget `json:"ip"`
+}
+
+func NewRateLimitSettings() *RateLimitSettings {
+	return &RateLimitSettings{
+		Enabled: viper.GetBool(ConfigKeyRateLimitEnabled),
+		Key:     viper.GetString(ConfigKeyRateLimitKey),
+		Read: &RateBudget{
+			Limit:  viper.GetInt(ConfigKeyRateLimitReadLimit),
+			Period: viper.GetDuration(ConfigKeyRateLimitReadPeriod),
+		},
+		Write: &RateBudget{
+			Limit:  viper.GetInt(ConfigKeyRateLimitWriteLimit),
+			Period: viper.GetDuration(ConfigKeyRateLimitWritePeriod),
+		},
+		IP: &RateBudget{
+			Limit:  viper.GetInt(ConfigKeyRateLimitIPLimit),
+			Period: viper.GetDuration(ConfigKeyRateLimitIPPeriod),
+		},
+	}
+}
+
+// Validate checks the clients are identified in a known way, the settings
+// are not checked when the rate limiting is disabled
+func (s *RateLimitSettings) Validate() error {
+	if s == nil || !s.Enabled {
+		return nil
+	}
+	switch s.Key {
+	case RateLimitKeyClient, RateLimitKeyIP:
+		return nil
+	}
+	return fmt.Errorf("unknown key %q, expected %s or %s", s.Key, RateLimitKeyClient, RateLimitKeyIP)
+}
+
+// DecodingSettings holds the configuration of the request decoding. When
+// Strict is set, unknown fields, duplicate keys and trailing data are
+// rejected. MaxBodySize is in bytes.
+type DecodingSettings struct {
+	Strict      bool  `json:"strict"`
+	MaxBodySize int64 `json:"max_body_size"`
+}
+
+func NewDecodingSettings() *DecodingSettings {
+	return &DecodingSettings{
+		Strict:      viper.GetBool(ConfigKeyDecodingStrict),
+		MaxBodySize: viper.GetInt64(ConfigKeyDecodingMaxBodySize),
+	}
+}
+
+// WebhookSettings holds the configuration of the webhook deliveries. Backoff
+// is the delay before the first retry, it doubles on every attempt up to
+// MaxBackoff. MaxDeliveries bounds the deliveries kept in the log.
+type WebhookSettings struct {
+	MaxAttempts   int           `json:"max_attempts"`
+	Backoff       time.Duration `json:"backoff"`
+	MaxBackoff    time.Duration `json:"max_backoff"`
+	Timeout       time.Duration `json:"timeout"`
+	MaxDeliveries int           `json:"max_deliveries"`
+}
+
+func NewWebhookSettings() *WebhookSettings {
+	return &WebhookSettings{
+		MaxAttempts: viper.GetInt(ConfigKeyWebhookMaxAttempts),
+		Backoff:     viper.GetDuration(ConfigKeyWebhookBackoff),
+		MaxBackoff:  viper.GetDuration(ConfigKeyWebhookMaxBackoff),
+		Timeout:     viper.GetDuration(ConfigKeyWebhookTimeout),
+
+		MaxDeliveries: viper.GetInt(ConfigKeyWebhookMaxDeliveries),
+	}
+}
+
+// OutboxSettings holds the configuration of the payment events outbox and
+// of its relay. Publisher is one of 'log', 'file' or 'http', File and URL
+// being used by the last two.
+type OutboxSettings struct {
+	Enabled      bool          `json:"enabled"`
+	Publisher    string        `json:"publisher"`
+	File         string        `json:"file"`
+	URL          string        `json:"url"`
+	Timeout      time.Duration `json:"timeout"`
+	Interval     time.Duration `json:"interval"`
+	BatchSize    int           `json:"batch_size"`
+	RecoverAfter time.Duration `json:"recover_after"`
+}
+
+func NewOutboxSettings() *OutboxSettings {
+	return &OutboxSettings{
+		Enabled:      viper.GetBool(ConfigKeyOutboxEnabled),
+		Publisher:    viper.GetString(ConfigKeyOutboxPublisher),
+		File:         viper.GetString(ConfigKeyOutboxFile),
+		URL:          viper.GetString(ConfigKeyOutboxURL),
+		Timeout:      viper.GetDuration(ConfigKeyOutboxTimeout),
+		Interval:     viper.GetDuration(ConfigKeyOutboxInterval),
+		BatchSize:    viper.GetInt(ConfigKeyOutboxBatchSize),
+		RecoverAfter: viper.GetDuration(ConfigKeyOutboxRecoverAfter),
+	}
+}
+
+// EventsSettings holds the configuration of the payment events stream.
+// Replay is the amount of events kept for resuming, Buffer the amount of
+// events a slow client can lag behind before being disconnected.
+type EventsSettings struct {
+	Replay    int           `json:"replay"`
+	Buffer    int           `json:"buffer"`
+	Heartbeat time.Duration `json:"heartbeat"`
+}
+
+func NewEventsSettings() *EventsSettings {
+	return &EventsSettings{
+		Replay:    viper.GetInt(ConfigKeyEventsReplay),
+		Buffer:    viper.GetInt(ConfigKeyEventsBuffer),
+		Heartbeat: viper.GetDuration(ConfigKeyEventsHeartbeat),
+	}
+}
+
+// GRPCSettings holds the configuration of the gRPC server, it listens on the
+// same host as the REST API
+type GRPCSettings struct {
+	Enabled bool   `json:"enabled"`
+	Port    string `json:"port"`
+}
+
+func NewGRPCSettings() *GRPCSettings {
+	return &GRPCSettings{
+		Enabled: viper.GetBool(ConfigKeyGRPCEnabled),
+		Port:    viper.GetString(ConfigKeyGRPCPort),
+	}
+}
+
+// GraphQLSettings holds the limits applied to the GraphQL queries. The
+// complexity of a query is its amount of fields, the fields of a list being
+// counted once per item requested.
+type GraphQLSettings struct {
+	MaxDepth      int `json:"max_depth"`
+	MaxComplexity int `json:"max_complexity"`
+}
+
+func NewGraphQLSettings() *GraphQLSettings {
+	return &GraphQLSettings{
+		MaxDepth:      viper.GetInt(ConfigKeyGraphQLMaxDepth),
+		MaxComplexity: viper.GetInt(ConfigKeyGraphQLMaxComplexity),
+	}
+}
+
+// OpenAPISettings enables the validation of the requests, and of the
+// responses, against the OpenAPI document. Validating the responses holds
+// them until they are complete, it is meant for the tests.
+type OpenAPISettings struct {
+	ValidateRequests  bool `json:"validate_requests"`
+	ValidateResponses bool `json:"validate_responses"`
+}
+
+func NewOpenAPISettings() *OpenAPISettings {
+	return &OpenAPISettings{
+		ValidateRequests:  viper.GetBool(ConfigKeyOpenAPIValidateRequests),
+		ValidateResponses: viper.GetBool(ConfigKeyOpenAPIValidateResponses),
+	}
+}
+
+// DebugSettings enables the debug routes, they show the effective settings
+// without their secrets
+type DebugSettings struct {
+	Enabled bool `json:"enabled"`
+}
+
+func NewDebugSettings() *DebugSettings {
+	return &DebugSettings{
+		Enabled: viper.GetBool(ConfigKeyDebugEnabled),
+	}
+}
+
+// CacheSettings holds the read-through cache of the payments. Size bounds the
+// payments cached by id and ListSize the pages cached when Lists is set, the
+// least recently used are evicted first. A TTL of 0 keeps the entries until
+// they are evicted or invalidated. Invalidation is 'none' or 'http', the
+// latter sending the invalidations to the Peers, the base URLs of the other
+// nodes, within Timeout.
+type CacheSettings struct {
+	Enabled      bool          `json:"enabled"`
+	Size         int           `json:"size"`
+	TTL          time.Duration `json:"ttl"`
+	Lists        bool          `json:"lists"`
+	ListSize     int           `json:"list_size"`
+	Invalidation string        `json:"invalidation"`
+	Peers        []string      `json:"peers"`
+	Timeout      time.Duration `json:"timeout"`
+}
+
+func NewCacheSettings() *CacheSettings {
+	return &CacheSettings{
+		Enabled:      viper.GetBool(ConfigKeyCacheEnabled),
+		Size:         viper.GetInt(ConfigKeyCacheSize),
+		TTL:          viper.GetDuration(ConfigKeyCacheTTL),
+		Lists:        viper.GetBool(ConfigKeyCacheLists),
+		ListSize:     viper.GetInt(ConfigKeyCacheListSize),
+		Invalidation: viper.GetString(ConfigKeyCacheInvalidation),
+		Peers:        viper.GetStringSlice(ConfigKeyCachePeers),
+		Timeout:      viper.GetDuration(ConfigKeyCacheTimeout),
+	}
+}
+
+// MetricsSettings exposes the Prometheus metrics on Path, next to the API or
+// on their own port when AdminPort is set
+type MetricsSettings struct {
+	Enabled   bool   `json:"enabled"`
+	Path      string `json:"path"`
+	AdminPort string `json:"admin_port"`
+}
+
+func NewMetricsSettings() *MetricsSettings {
+	return &MetricsSettings{
+		Enabled:   viper.GetBool(ConfigKeyMetricsEnabled),
+		Path:      viper.GetString(ConfigKeyMetricsPath),
+		AdminPort: viper.GetString(ConfigKeyMetricsAdminPort),
+	}
+}
+
+// TracingSettings holds the tracing of the requests. Exporter is one of
+// 'stdout', 'file' or 'otlp', File and URL being used by the last two, and
+// Headers are sent to the OTLP collector. The new traces are sampled at
+// SampleRatio, the traces continued from a traceparent header follow its
+// sampled flag. The spans are exported by batches of BatchSize at least every
+// Interval, up to QueueSize of them wait for the exporter.
+type TracingSettings struct {
+	Enabled     bool              `json:"enabled"`
+	Exporter    string            `json:"exporter"`
+	File        string            `json:"file"`
+	URL         string            `json:"url"`
+	Headers     map[string]string `json:"-"`
+	Timeout     time.Duration     `json:"timeout"`
+	SampleRatio float64           `json:"sample_ratio"`
+	BatchSize   int               `json:"batch_size"`
+	QueueSize   int               `json:"queue_size"`
+	Interval    time.Duration     `json:"interval"`
+}
+
+func NewTracingSettings() *TracingSettings {
+	return &TracingSettings{
+		Enabled:     viper.GetBool(ConfigKeyTracingEnabled),
+		Exporter:    viper.GetString(ConfigKeyTracingExporter),
+		File:        viper.GetString(ConfigKeyTracingFile),
+		URL:         viper.GetString(ConfigKeyTracingURL),
+		Headers:     viper.GetStringMapString(ConfigKeyTracingHeaders),
+		Timeout:     viper.GetDuration(ConfigKeyTracingTimeout),
+		SampleRatio: viper.GetFloat64(ConfigKeyTracingSampleRatio),
+		BatchSize:   viper.GetInt(ConfigKeyTracingBatchSize),
+		QueueSize:   viper.GetInt(ConfigKeyTracingQueueSize),
+		Interval:    viper.GetDuration(ConfigKeyTracingInterval),
+	}
+}
+
+// TimeoutSettings holds the deadlines of the requests. Routes overrides
+// Default for the routes keyed by their method and pattern, such as
+// "GET /v1/payments/{paymentID}". A zero duration disables the deadline.
+type TimeoutSettings struct {
+	Default time.Duration            `json:"default"`
+	Routes  map[string]time.Duration `json:"routes"`
+}
+
+func NewTimeoutSettings() *TimeoutSettings {
+	routes := map[string]time.Duration{}
+	for route, value := range viper.GetStringMapString(ConfigKeyTimeoutRoutes) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			logrus.Warnf("Ignoring the timeout of %q: %v", route, err)
+			continue
+		}
+		routes[strings.ToLower(route)] = d
+	}
+	return &TimeoutSettings{
+		Default: viper.GetDuration(ConfigKeyTimeoutDefault),
+		Routes:  routes,
+	}
+}
+
+// Route returns the timeout of the route, ok is false when it has none
+// configured. The keys are case insensitive since viper lowercases them.
+func (s *TimeoutSettings) Route(method, pattern string) (d time.Duration, ok bool) {
+	d, ok = s.Routes[strings.ToLower(method+" "+pattern)]
+	return d, ok
+}
+
+// ClientSettings holds how the CLI reaches a running server, URL defaults to
+// the address the server listens on
+type ClientSettings struct {
+	URL      string `json:"url"`
+	APIKey   string `json:"-"`
+	ClientID string `json:"client_id"`
+	Secret   string `json:"-"`
+}
+
+func NewClientSettings() *ClientSettings {
+	return &ClientSettings{
+		URL:      viper.GetString(ConfigKeyClientURL),
+		APIKey:   viper.GetString(ConfigKeyClientAPIKey),
+		ClientID: viper.GetString(ConfigKeyClientID),
+		Secret:   viper.GetString(ConfigKeyClientSecret),
 	}
 }
 
 type DatabaseType string
 
 type APIConfig struct {
-	DBType   DatabaseType   `json:"database"`
-	NodeName string         `json:"node_name"`
-	DevMode  bool           `json:"dev_mode"`
-	Host     string         `json:"host" validate:"required"`
-	Port     string         `json:"port" validate:"required"`
-	TLS      bool           `json:"tls"`
-	TLSKey   string         `json:"tls_key"`
-	TLSCert  string         `json:"tls_cert"`
-	Cors     *CORSSettings  `json:"cors"`
-	Mongo    *MongoSettings `json:"mongo"`
+	DBType    DatabaseType       `json:"database"`
+	NodeName  string             `json:"node_name"`
+	DevMode   bool               `json:"dev_mode"`
+	Host      string             `json:"host" validate:"required"`
+	Port      string             `json:"port" validate:"required"`
+	TLS       bool               `json:"tls"`
+	TLSKey    string             `json:"tls_key"`
+	TLSCert   string             `json:"tls_cert"`
+	Cors      *CORSSettings      `json:"cors"`
+	InMem     *InMemSettings     `json:"inmem"`
+	Mongo     *MongoSettings     `json:"mongo"`
+	Bolt      *BoltSettings      `json:"bolt"`
+	SQL       *SQLSettings       `json:"sql"`
+	Signature *SignatureSettings `json:"signature"`
+	RateLimit *RateLimitSettings `json:"rate_limit"`
+	Decoding  *DecodingSettings  `json:"decoding"`
+	Webhooks  *WebhookSettings   `json:"webhooks"`
+	Outbox    *OutboxSettings    `json:"outbox"`
+	Events    *EventsSettings    `json:"events"`
+	GRPC      *GRPCSettings      `json:"grpc"`
+	GraphQL   *GraphQLSettings   `json:"graphql"`
+	OpenAPI   *OpenAPISettings   `json:"openapi"`
+	Timeouts  *TimeoutSettings   `json:"timeouts"`
+	Debug     *DebugSettings     `json:"debug"`
+	Cache     *CacheSettings     `json:"cache"`
+	Metrics   *MetricsSettings   `json:"metrics"`
+	Tracing   *TracingSettings   `json:"tracing"`
+	Client    *ClientSettings    `json:"client"`
 }
 
 // NewAPIConfig creates a new APIConfig struct.
 func NewAPIConfig() *APIConfig {
 	return &APIConfig{
-		NodeName: viper.GetString(ConfigKeyNodeName),
-		DevMode:  viper.GetBool(ConfigKeyDevMode),
-		Host:     viper.GetString(ConfigKeyHost),
-		Port:     viper.GetString(ConfigKeyPort),
-		TLS:      viper.GetBool(ConfigKeyTLS),
-		TLSKey:   viper.GetString(ConfigKeyTLSKey),
-		TLSCert:  viper.GetString(ConfigKeyTLSCert),
-		Cors:     NewCORSSettings(),
-		DBType:   DatabaseType(viper.GetString(ConfigKeyDatabaseType)),
-		Mongo:    NewMongoSettings(),
+		NodeName:  viper.GetString(ConfigKeyNodeName),
+		DevMode:   viper.GetBool(ConfigKeyDevMode),
+		Host:      viper.GetString(ConfigKeyHost),
+		Port:      viper.GetString(ConfigKeyPort),
+		TLS:       viper.GetBool(ConfigKeyTLS),
+		TLSKey:    viper.GetString(ConfigKeyTLSKey),
+		TLSCert:   viper.GetString(ConfigKeyTLSCert),
+		Cors:      NewCORSSettings(),
+		DBType:    DatabaseType(viper.GetString(ConfigKeyDatabaseType)),
+		InMem:     NewInMemSettings(),
+		Mongo:     NewMongoSettings(),
+		Bolt:      NewBoltSettings(),
+		SQL:       NewSQLSettings(),
+		Signature: NewSignatureSettings(),
+		RateLimit: NewRateLimitSettings(),
+		Decoding:  NewDecodingSettings(),
+		Webhooks:  NewWebhookSettings(),
+		Outbox:    NewOutboxSettings(),
+		Events:    NewEventsSettings(),
+		GRPC:      NewGRPCSettings(),
+		GraphQL:   NewGraphQLSettings(),
+		OpenAPI:   NewOpenAPISettings(),
+		Timeouts:  NewTimeoutSettings(),
+		Debug:     NewDebugSettings(),
+		Cache:     NewCacheSettings(),
+		Metrics:   NewMetricsSettings(),
+		Tracing:   NewTracingSettings(),
+		Client:    NewClientSettings(),
 	}
 }
 
@@ -137,3 +549,16 @@ func (c *APIConfig) GetFullHost() string {
 	}
 	return fmt.Sprintf("%s:%s", host, port)
 }
+
+// GetGRPCHost returns the address the gRPC server listens on
+func (c *APIConfig) GetGRPCHost() string {
+	host := c.Host
+	if host == "" {
+		host = DefaultHost
+	}
+	port := DefaultGRPCPort
+	if c.GRPC != nil && c.GRPC.Port != "" {
+		port = c.GRPC.Port
+	}
+	return fmt.Sprintf("%s:%s", host, port)
+}
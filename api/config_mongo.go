@@ -0,0 +1,521 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/spf13/viper"
+)
+
+// The settings of the connection to MongoDB override the options of the URI
+// they are given for, the URI alone is enough for a local database. The
+// settings are validated when the API starts, the effective ones are shown by
+// the debug endpoint with their secrets redacted.
+
+const (
+	MongoReadPrimary            = "primary"
+	MongoReadPrimaryPreferred   = "primaryPreferred"
+	MongoReadSecondary          = "secondary"
+	MongoReadSecondaryPreferred = "secondaryPreferred"
+	MongoReadNearest            = "nearest"
+
+	MongoWriteMajority = "majority"
+
+	MongoAuthSCRAMSHA1 = "SCRAM-SHA-1"
+	MongoAuthCR        = "MONGODB-CR"
+	MongoAuthPlain     = "PLAIN"
+	MongoAuthX509      = "MONGODB-X509"
+
+	// RedactedValue replaces the secrets of the settings
+	RedactedValue = "[redacted]"
+)
+
+var mongoReadModes = map[string]mgo.Mode{
+	MongoReadPrimary:            mgo.Primary,
+	MongoReadPrimaryPreferred:   mgo.PrimaryPreferred,
+	MongoReadSecondary:          mgo.Secondary,
+	MongoReadSecondaryPreferred: mgo.SecondaryPreferred,
+	MongoReadNearest:            mgo.Nearest,
+}
+
+var mongoAuthMechanisms = map[string]bool{
+	MongoAuthSCRAMSHA1: true,
+	MongoAuthCR:        true,
+	MongoAuthPlain:     true,
+	MongoAuthX509:      true,
+}
+
+// MongoSettings holds the connection to MongoDB. Indexes are the declared
+// indexes of the payments collection, see ParseMongoIndex. ReadPreference is
+// one of the MongoRead modes.
+type MongoSettings struct {
+	Database       string             `json:"database"`
+	URI            string             `json:"uri"`
+	Collection     string             `json:"collection"`
+	Indexes        []string           `json:"indexes"`
+	AppName        string             `json:"app_name"`
+	ReplicaSet     string             `json:"replica_set"`
+	ReadPreference string             `json:"read_preference"`
+	Pool           *MongoPoolSettings `json:"pool"`
+	Timeouts       *MongoTimeouts     `json:"timeouts"`
+	WriteConcern   *MongoWriteConcern `json:"write_concern"`
+	TLS            *MongoTLSSettings  `json:"tls"`
+	Auth           *MongoAuthSettings `json:"auth"`
+	Reconnect      *MongoReconnect    `json:"reconnect"`
+	Breaker        *MongoBreaker      `json:"breaker"`
+}
+
+// MongoPoolSettings bounds the connections to each server. WaitTimeout is how
+// long an operation waits for a connection when MaxSize are in use, forever
+// when 0.
+type MongoPoolSettings struct {
+	MaxSize     int           `json:"max_size"`
+	MinSize     int           `json:"min_size"`
+	MaxIdleTime time.Duration `json:"max_idle_time"`
+	WaitTimeout time.Duration `json:"wait_timeout"`
+}
+
+// MongoTimeouts holds the time allowed to connect to the servers and to read
+// or write on a connection
+type MongoTimeouts struct {
+	Connect time.Duration `json:"connect"`
+	Socket  time.Duration `json:"socket"`
+}
+
+// MongoWriteConcern holds the acknowledgment of the writes. W is a number of
+// servers, "majority" or the name of a tag set. Journal waits for the writes
+// to reach the journal.
+type MongoWriteConcern struct {
+	W       string        `json:"w"`
+	Journal bool          `json:"journal"`
+	Timeout time.Duration `json:"timeout"`
+}
+
+// MongoTLSSettings holds the TLS connection to the servers. CAFile verifies
+// the servers instead of the system pool, CertFile and KeyFile are the client
+// certificate presented to them.
+type MongoTLSSettings struct {
+	Enabled            bool   `json:"enabled"`
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// MongoAuthSettings holds the credentials. The password is read from
+// PasswordFile when set, MONGODB-X509 authenticates with the client
+// certificate and uses its subject as the username by default.
+type MongoAuthSettings struct {
+	Mechanism    string `json:"mechanism"`
+	Source       string `json:"source"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	PasswordFile string `json:"password_file"`
+}
+
+// MongoReconnect holds the supervision of the connection. The health of the
+// connection is checked every Interval, the failed attempts are retried after
+// a backoff doubled from MinBackoff up to MaxBackoff, less a random part of up
+// to Jitter of it.
+type MongoReconnect struct {
+	Interval   time.Duration `json:"interval"`
+	MinBackoff time.Duration `json:"min_backoff"`
+	MaxBackoff time.Duration `json:"max_backoff"`
+	Jitter     float64       `json:"jitter"`
+}
+
+// MongoBreaker holds the circuit breaker of the store, it opens after
+// Threshold consecutive failures and lets an operation through again after
+// Cooldown. A Threshold of 0 disables it.
+type MongoBreaker struct {
+	Threshold int           `json:"threshold"`
+	Cooldown  time.Duration `json:"cooldown"`
+}
+
+func NewMongoSettings() *MongoSettings {
+	return &MongoSettings{
+		Database:       viper.GetString(ConfigKeyMongoDatabase),
+		URI:            viper.GetString(ConfigKeyMongoURI),
+		Collection:     viper.GetString(ConfigKeyMongoCollection),
+		Indexes:        viper.GetStringSlice(ConfigKeyMongoIndexes),
+		AppName:        viper.GetString(ConfigKeyMongoAppName),
+		ReplicaSet:     viper.GetString(ConfigKeyMongoReplicaSet),
+		ReadPreference: viper.GetString(ConfigKeyMongoReadPreference),
+		Pool: &MongoPoolSettings{
+			MaxSize:     viper.GetInt(ConfigKeyMongoPoolMaxSize),
+			MinSize:     viper.GetInt(ConfigKeyMongoPoolMinSize),
+			MaxIdleTime: viper.GetDuration(ConfigKeyMongoPoolMaxIdleTime),
+			WaitTimeout: viper.GetDuration(ConfigKeyMongoPoolWaitTimeout),
+		},
+		Timeouts: &MongoTimeouts{
+			Connect: viper.GetDuration(ConfigKeyMongoConnectTimeout),
+			Socket:  viper.GetDuration(ConfigKeyMongoSocketTimeout),
+		},
+		WriteConcern: &MongoWriteConcern{
+			W:       viper.GetString(ConfigKeyMongoWriteW),
+			Journal: viper.GetBool(ConfigKeyMongoWriteJournal),
+			Timeout: viper.GetDuration(ConfigKeyMongoWriteTimeout),
+		},
+		TLS: &MongoTLSSettings{
+			Enabled:            viper.GetBool(ConfigKeyMongoTLS),
+			CAFile:             viper.GetString(ConfigKeyMongoTLSCAFile),
+			CertFile:           viper.GetString(ConfigKeyMongoTLSCertFile),
+			KeyFile:            viper.GetString(ConfigKeyMongoTLSKeyFile),
+			InsecureSkipVerify: viper.GetBool(ConfigKeyMongoTLSInsecure),
+		},
+		Auth: &MongoAuthSettings{
+			Mechanism:    viper.GetString(ConfigKeyMongoAuthMechanism),
+			Source:       viper.GetString(ConfigKeyMongoAuthSource),
+			Username:     viper.GetString(ConfigKeyMongoAuthUsername),
+			Password:     viper.GetString(ConfigKeyMongoAuthPassword),
+			PasswordFile: viper.GetString(ConfigKeyMongoAuthPasswordFile),
+		},
+		Reconnect: &MongoReconnect{
+			Interval:   viper.GetDuration(ConfigKeyMongoReconnectInterval),
+			MinBackoff: viper.GetDuration(ConfigKeyMongoReconnectMinBackoff),
+			MaxBackoff: viper.GetDuration(ConfigKeyMongoReconnectMaxBackoff),
+			Jitter:     viper.GetFloat64(ConfigKeyMongoReconnectJitter),
+		},
+		Breaker: &MongoBreaker{
+			Threshold: viper.GetInt(ConfigKeyMongoBreakerThreshold),
+			Cooldown:  viper.GetDuration(ConfigKeyMongoBreakerCooldown),
+		},
+	}
+}
+
+// Backoff returns the delay before retrying after the given amount of failed
+// attempts
+func (s *MongoReconnect) Backoff(attempts int) time.Duration {
+	delay := s.MinBackoff
+	for i := 1; i < attempts && delay < s.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if s.MaxBackoff > 0 && delay > s.MaxBackoff {
+		delay = s.MaxBackoff
+	}
+	if s.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * s.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// Validate returns every problem of the settings at once, the files they
+// point to are read
+func (s *MongoSettings) Validate() error {
+	problems := s.check()
+	if len(problems) == 0 {
+		if _, err := s.DialInfo(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// check returns the problems found without reading any file
+func (s *MongoSettings) check() []string {
+	problems := []string{}
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	// the mechanism of the URI applies unless the settings override it
+	mechanism := ""
+	if info, err := mgo.ParseURL(s.URI); err != nil {
+		add("invalid uri: %v", err)
+	} else {
+		mechanism = info.Mechanism
+	}
+	if s.Database == "" {
+		add("database is required")
+	}
+	if s.Collection == "" {
+		add("collection is required")
+	}
+	if _, ok := mongoReadModes[s.ReadPreference]; s.ReadPreference != "" && !ok {
+		add("unknown read_preference %q", s.ReadPreference)
+	}
+	if len(s.AppName) > 128 {
+		add("app_name is longer than 128 bytes")
+	}
+	if p := s.Pool; p != nil {
+		if p.MaxSize < 0 || p.MinSize < 0 {
+			add("the pool sizes cannot be negative")
+		}
+		if p.MaxSize > 0 && p.MinSize > p.MaxSize {
+			add("pool.min_size %d is above pool.max_size %d", p.MinSize, p.MaxSize)
+		}
+		if p.MaxIdleTime < 0 || p.WaitTimeout < 0 {
+			add("the pool durations cannot be negative")
+		}
+	}
+	if t := s.Timeouts; t != nil && (t.Connect < 0 || t.Socket < 0) {
+		add("the timeouts cannot be negative")
+	}
+	if wc := s.WriteConcern; wc != nil {
+		if n, err := strconv.Atoi(wc.W); err == nil && n < 0 {
+			add("write_concern.w cannot be negative")
+		} else if err == nil && n == 0 && wc.Journal {
+			add("write_concern.journal needs acknowledged writes, w is 0")
+		}
+		if wc.Timeout < 0 {
+			add("write_concern.timeout cannot be negative")
+		}
+	}
+	tlsEnabled := s.TLS != nil && s.TLS.Enabled
+	if t := s.TLS; t != nil {
+		if !t.Enabled && (t.CAFile != "" || t.CertFile != "" || t.KeyFile != "") {
+			add("tls files are given but tls.enabled is false")
+		}
+		if (t.CertFile == "") != (t.KeyFile == "") {
+			add("tls.cert_file and tls.key_file go together")
+		}
+	}
+	if a := s.Auth; a != nil && a.Mechanism != "" {
+		mechanism = a.Mechanism
+	}
+	if a := s.Auth; a != nil {
+		if a.Mechanism != "" && !mongoAuthMechanisms[a.Mechanism] {
+			add("unsupported auth.mechanism %q, expected %s, %s, %s or %s",
+				a.Mechanism, MongoAuthSCRAMSHA1, MongoAuthCR, MongoAuthPlain, MongoAuthX509)
+		}
+		if a.Password != "" && a.PasswordFile != "" {
+			add("auth.password and auth.password_file cannot be both set")
+		}
+		if mechanism == MongoAuthX509 && (a.Password != "" || a.PasswordFile != "") {
+			add("%s does not take a password", MongoAuthX509)
+		}
+	}
+	if mechanism == MongoAuthX509 && (!tlsEnabled || s.TLS.CertFile == "") {
+		add("%s needs tls.enabled and a client certificate", MongoAuthX509)
+	}
+	if r := s.Reconnect; r != nil {
+		if r.Interval <= 0 || r.MinBackoff <= 0 {
+			add("reconnect.interval and reconnect.min_backoff must be positive")
+		}
+		if r.MaxBackoff < r.MinBackoff {
+			add("reconnect.max_backoff %s is below reconnect.min_backoff %s", r.MaxBackoff, r.MinBackoff)
+		}
+		if r.Jitter < 0 || r.Jitter > 1 {
+			add("reconnect.jitter must be between 0 and 1")
+		}
+	}
+	if b := s.Breaker; b != nil {
+		if b.Threshold < 0 {
+			add("breaker.threshold cannot be negative")
+		}
+		if b.Threshold > 0 && b.Cooldown <= 0 {
+			add("breaker.cooldown must be positive")
+		}
+	}
+	return problems
+}
+
+// tlsConfig loads the certificates of the TLS settings
+func (s *MongoTLSSettings) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: s.InsecureSkipVerify}
+	if s.CAFile != "" {
+		pem, err := ioutil.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read tls.ca_file: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in tls.ca_file %s", s.CAFile)
+		}
+	}
+	if s.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load the client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// DialInfo returns the options of the URI overridden by the settings
+func (s *MongoSettings) DialInfo() (*mgo.DialInfo, error) {
+	info, err := mgo.ParseURL(s.URI)
+	if err != nil {
+		return nil, err
+	}
+	if s.Database != "" {
+		info.Database = s.Database
+	}
+	if s.AppName != "" {
+		info.AppName = s.AppName
+	}
+	if s.ReplicaSet != "" {
+		info.ReplicaSetName = s.ReplicaSet
+	}
+	if mode, ok := mongoReadModes[s.ReadPreference]; ok {
+		info.ReadPreference = &mgo.ReadPreference{Mode: mode}
+	}
+	if p := s.Pool; p != nil {
+		if p.MaxSize > 0 {
+			info.PoolLimit = p.MaxSize
+		}
+		if p.MinSize > 0 {
+			info.MinPoolSize = p.MinSize
+		}
+		if p.MaxIdleTime > 0 {
+			info.MaxIdleTimeMS = int(p.MaxIdleTime / time.Millisecond)
+		}
+		info.PoolTimeout = p.WaitTimeout
+	}
+	if t := s.Timeouts; t != nil {
+		info.Timeout = t.Connect
+		info.ReadTimeout = t.Socket
+		info.WriteTimeout = t.Socket
+	}
+	if wc := s.WriteConcern; wc != nil {
+		if n, err := strconv.Atoi(wc.W); err == nil {
+			info.Safe.W, info.Safe.WMode = n, ""
+		} else if wc.W != "" {
+			info.Safe.W, info.Safe.WMode = 0, wc.W
+		}
+		if wc.Journal {
+			info.Safe.J = true
+		}
+		if wc.Timeout > 0 {
+			info.Safe.WTimeout = int(wc.Timeout / time.Millisecond)
+		}
+	}
+	if a := s.Auth; a != nil {
+		if a.Mechanism != "" {
+			info.Mechanism = a.Mechanism
+		}
+		if a.Source != "" {
+			info.Source = a.Source
+		}
+		if a.Username != "" {
+			info.Username = a.Username
+		}
+		if a.Password != "" {
+			info.Password = a.Password
+		}
+		if a.PasswordFile != "" {
+			password, err := ioutil.ReadFile(a.PasswordFile)
+			if err != nil {
+				return nil, fmt.Errorf("could not read auth.password_file: %w", err)
+			}
+			info.Password = strings.TrimRight(string(password), "\r\n")
+		}
+	}
+	// the mechanism may come from the URI, which Validate does not check
+	tlsEnabled := s.TLS != nil && s.TLS.Enabled
+	if info.Mechanism == MongoAuthX509 && (!tlsEnabled || s.TLS.CertFile == "") {
+		return nil, fmt.Errorf("%s needs tls.enabled and a client certificate", MongoAuthX509)
+	}
+	if tlsEnabled {
+		cfg, err := s.TLS.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		if info.Mechanism == MongoAuthX509 && info.Username == "" {
+			cert, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+			if err != nil {
+				return nil, fmt.Errorf("could not parse the client certificate: %w", err)
+			}
+			info.Username = cert.Subject.String()
+		}
+		dialer := &net.Dialer{Timeout: info.Timeout}
+		info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+			return tls.DialWithDialer(dialer, "tcp", addr.String(), cfg)
+		}
+	}
+	return info, nil
+}
+
+// Effective returns the settings resolved with the options of the URI, the
+// files are read
+func (s *MongoSettings) Effective() (*MongoSettings, error) {
+	info, err := s.DialInfo()
+	if err != nil {
+		return nil, err
+	}
+	ret := *s
+	ret.Database = info.Database
+	ret.AppName = info.AppName
+	ret.ReplicaSet = info.ReplicaSetName
+	if info.ReadPreference != nil {
+		for name, mode := range mongoReadModes {
+			if mode == info.ReadPreference.Mode {
+				ret.ReadPreference = name
+			}
+		}
+	}
+	ret.Pool = &MongoPoolSettings{
+		MaxSize:     info.PoolLimit,
+		MinSize:     info.MinPoolSize,
+		MaxIdleTime: time.Duration(info.MaxIdleTimeMS) * time.Millisecond,
+		WaitTimeout: info.PoolTimeout,
+	}
+	ret.Timeouts = &MongoTimeouts{Connect: info.Timeout, Socket: info.ReadTimeout}
+	w := info.Safe.WMode
+	if w == "" && info.Safe.W > 0 {
+		w = strconv.Itoa(info.Safe.W)
+	}
+	ret.WriteConcern = &MongoWriteConcern{
+		W:       w,
+		Journal: info.Safe.J,
+		Timeout: time.Duration(info.Safe.WTimeout) * time.Millisecond,
+	}
+	tlsSettings := MongoTLSSettings{}
+	if s.TLS != nil {
+		tlsSettings = *s.TLS
+	}
+	tlsSettings.Enabled = info.DialServer != nil
+	ret.TLS = &tlsSettings
+	auth := MongoAuthSettings{}
+	if s.Auth != nil {
+		auth = *s.Auth
+	}
+	auth.Mechanism, auth.Source, auth.Username = info.Mechanism, info.Source, info.Username
+	auth.Password = info.Password
+	ret.Auth = &auth
+	return &ret, nil
+}
+
+// Redacted returns a copy of the settings without the passwords
+func (s MongoSettings) Redacted() *MongoSettings {
+	s.URI = redactMongoURI(s.URI)
+	if s.Auth != nil && s.Auth.Password != "" {
+		auth := *s.Auth
+		auth.Password = RedactedValue
+		s.Auth = &auth
+	}
+	return &s
+}
+
+// redactMongoURI replaces the password of the URI
+func redactMongoURI(uri string) string {
+	prefix, rest := "", uri
+	if i := strings.Index(uri, "://"); i >= 0 {
+		prefix, rest = uri[:i+3], uri[i+3:]
+	}
+	end := strings.IndexAny(rest, "/?")
+	if end < 0 {
+		end = len(rest)
+	}
+	at := strings.LastIndex(rest[:end], "@")
+	if at < 0 {
+		return uri
+	}
+	user := rest[:at]
+	if i := strings.Index(user, ":"); i >= 0 {
+		user = user[:i+1] + RedactedValue
+	}
+	return prefix + user + rest[at:]
+}
@@ -1,5 +1,11 @@
 package api
 
+import (
+	"time"
+
+	"github.com/ganitzsh/f3-te/api/wire"
+)
+
 const (
 	ReleaseName       = "elliot"
 	Version           = "0.0.1"
@@ -8,13 +14,15 @@ const (
 	URLRoot = "/"
 
 	APIV1ContentTypes = "application/json,application/json+v1"
-	APIV1Prefix       = "/v1"
+	APIV1Prefix       = wire.APIV1Prefix
 
 	ReqDataKey = "data"
 	ReqCodeKey = "code"
 
 	DatabaseTypeInMem = "inmem"
 	DatabaseTypeMongo = "mongo"
+	DatabaseTypeBolt  = "bolt"
+	DatabaseTypeSQL   = "sql"
 
 	DefaultNodeName        = "Payment API"
 	DefaultHost            = "127.0.0.1"
@@ -26,8 +34,62 @@ const (
 	DefaultMongoDatabase   = "payment_api"
 	DefaultMongoCollection = "payments"
 	DefaultMongoURI        = "localhost"
-	DefaultMongoMaxRetries = 10
+	DefaultMongoInterval   = 3 * time.Second
+	DefaultMongoMinBackoff = 500 * time.Millisecond
+	DefaultMongoMaxBackoff = 30 * time.Second
+	DefaultMongoJitter     = 0.2
+	DefaultMongoThreshold  = 5
+	DefaultMongoCooldown   = 10 * time.Second
+	DefaultMongoConnect    = 10 * time.Second
+	DefaultMongoSocket     = 2 * time.Second
+	DefaultBoltPath        = "payments.db"
+	DefaultBoltTimeout     = time.Second
+	DefaultSQLDriver       = SQLDriverSQLite
+	DefaultSQLDSN          = "payments.sqlite"
+	DefaultInMemDir        = "data"
+	DefaultInMemFsync      = FsyncInterval
+	DefaultInMemFsyncEvery = time.Second
+	DefaultInMemSnapshot   = 5 * time.Minute
+	DefaultInMemSnapRecs   = 10000
 	DefaultDBType          = DatabaseTypeInMem
+	DefaultSignatureWindow = 5 * time.Minute
+	DefaultRateLimitKey    = RateLimitKeyIP
+	DefaultRateReadLimit   = 300
+	DefaultRateWriteLimit  = 60
+	DefaultRateIPLimit     = 1200
+	DefaultRatePeriod      = time.Minute
+	DefaultStrictDecoding  = false
+	DefaultMaxBodySize     = 1 << 20
+	DefaultWebhookAttempts = 8
+	DefaultWebhookBackoff  = time.Second
+	DefaultWebhookMaxDelay = time.Hour
+	DefaultWebhookTimeout  = 10 * time.Second
+	DefaultWebhookLogSize  = 10000
+	DefaultOutboxPublisher = PublisherTypeLog
+	DefaultOutboxInterval  = time.Second
+	DefaultOutboxBatchSize = 100
+	DefaultOutboxRecovery  = time.Minute
+	DefaultOutboxTimeout   = 10 * time.Second
+	DefaultOutboxSuffix    = "_outbox"
+	DefaultEventsReplay    = 1000
+	DefaultEventsBuffer    = 64
+	DefaultEventsHeartbeat = 15 * time.Second
+	DefaultGRPCPort        = "8081"
+	DefaultGraphQLDepth    = 10
+	DefaultGraphQLCost     = 2000
+	DefaultGraphQLPageSize = 20
+	DefaultRequestTimeout  = 30 * time.Second
+	DefaultCacheSize       = 10000
+	DefaultCacheTTL        = time.Minute
+	DefaultCacheListSize   = 100
+	DefaultCacheTimeout    = 2 * time.Second
+	DefaultMetricsPath     = "/metrics"
+	DefaultTracingExporter = ExporterTypeStdout
+	DefaultTracingURL      = "http://localhost:4318/v1/traces"
+	DefaultTracingTimeout  = 10 * time.Second
+	DefaultTracingBatch    = 512
+	DefaultTracingQueue    = 2048
+	DefaultTracingInterval = 5 * time.Second
 
 	EnvPrefix                = "api"
 	ConfigFileName           = "config"
@@ -43,12 +105,192 @@ const (
 	ConfigKeyMongoDatabase   = "database.mongo.database"
 	ConfigKeyMongoCollection = "database.mongo.collection"
 	ConfigKeyMongoURI        = "database.mongo.uri"
-	ConfigKeyMongoMaxRetries = "database.mongo.max_retries"
+	ConfigKeyMongoIndexes    = "database.mongo.indexes"
+	ConfigKeyBoltPath        = "database.bolt.path"
+	ConfigKeyBoltTimeout     = "database.bolt.timeout"
+	ConfigKeySQLDriver       = "database.sql.driver"
+	ConfigKeySQLDSN          = "database.sql.dsn"
+	ConfigKeySQLAutoMigrate  = "database.sql.auto_migrate"
 	ConfigKeyDevMode         = "dev_mode"
 	ConfigKeyNodeName        = "name"
 
+	ConfigKeyMongoAppName          = "database.mongo.app_name"
+	ConfigKeyMongoReplicaSet       = "database.mongo.replica_set"
+	ConfigKeyMongoReadPreference   = "database.mongo.read_preference"
+	ConfigKeyMongoPoolMaxSize      = "database.mongo.pool.max_size"
+	ConfigKeyMongoPoolMinSize      = "database.mongo.pool.min_size"
+	ConfigKeyMongoPoolMaxIdleTime  = "database.mongo.pool.max_idle_time"
+	ConfigKeyMongoPoolWaitTimeout  = "database.mongo.pool.wait_timeout"
+	ConfigKeyMongoConnectTimeout   = "database.mongo.timeouts.connect"
+	ConfigKeyMongoSocketTimeout    = "database.mongo.timeouts.socket"
+	ConfigKeyMongoWriteW           = "database.mongo.write_concern.w"
+	ConfigKeyMongoWriteJournal     = "database.mongo.write_concern.journal"
+	ConfigKeyMongoWriteTimeout     = "database.mongo.write_concern.timeout"
+	ConfigKeyMongoTLS              = "database.mongo.tls.enabled"
+	ConfigKeyMongoTLSCAFile        = "database.mongo.tls.ca_file"
+	ConfigKeyMongoTLSCertFile      = "database.mongo.tls.cert_file"
+	ConfigKeyMongoTLSKeyFile       = "database.mongo.tls.key_file"
+	ConfigKeyMongoTLSInsecure      = "database.mongo.tls.insecure_skip_verify"
+	ConfigKeyMongoAuthMechanism    = "database.mongo.auth.mechanism"
+	ConfigKeyMongoAuthSource       = "database.mongo.auth.source"
+	ConfigKeyMongoAuthUsername     = "database.mongo.auth.username"
+	ConfigKeyMongoAuthPassword     = "database.mongo.auth.password"
+	ConfigKeyMongoAuthPasswordFile = "database.mongo.auth.password_file"
+
+	ConfigKeyMongoReconnectInterval   = "database.mongo.reconnect.interval"
+	ConfigKeyMongoReconnectMinBackoff = "database.mongo.reconnect.min_backoff"
+	ConfigKeyMongoReconnectMaxBackoff = "database.mongo.reconnect.max_backoff"
+	ConfigKeyMongoReconnectJitter     = "database.mongo.reconnect.jitter"
+	ConfigKeyMongoBreakerThreshold    = "database.mongo.breaker.threshold"
+	ConfigKeyMongoBreakerCooldown     = "database.mongo.breaker.cooldown"
+
+	ConfigKeyInMemIndexes          = "database.inmem.indexes"
+	ConfigKeyInMemPersist          = "database.inmem.persist"
+	ConfigKeyInMemDir              = "database.inmem.dir"
+	ConfigKeyInMemFsync            = "database.inmem.fsync"
+	ConfigKeyInMemFsyncInterval    = "database.inmem.fsync_interval"
+	ConfigKeyInMemSnapshotInterval = "database.inmem.snapshot_interval"
+	ConfigKeyInMemSnapshotRecords  = "database.inmem.snapshot_records"
+
+	ConfigKeySignatureEnabled = "signature.enabled"
+	ConfigKeySignatureWindow  = "signature.window"
+	ConfigKeySignatureClients = "signature.clients"
+
+	ConfigKeyRateLimitEnabled     = "rate_limit.enabled"
+	ConfigKeyRateLimitKey         = "rate_limit.key"
+	ConfigKeyRateLimitReadLimit   = "rate_limit.read.limit"
+	ConfigKeyRateLimitReadPeriod  = "rate_limit.read.period"
+	ConfigKeyRateLimitWriteLimit  = "rate_limit.write.limit"
+	ConfigKeyRateLimitWritePeriod = "rate_limit.write.period"
+	ConfigKeyRateLimitIPLimit     = "rate_limit.ip.limit"
+	ConfigKeyRateLimitIPPeriod    = "rate_limit.ip.period"
+
+	ConfigKeyDecodingStrict      = "decoding.strict"
+	ConfigKeyDecodingMaxBodySize = "decoding.max_body_size"
+
+	ConfigKeyWebhookMaxAttempts = "webhooks.max_attempts"
+	ConfigKeyWebhookBackoff     = "webhooks.backoff"
+	ConfigKeyWebhookMaxBackoff  = "webhooks.max_backoff"
+	ConfigKeyWebhookTimeout     = "webhooks.timeout"
+
+	ConfigKeyWebhookMaxDeliveries = "webhooks.max_deliveries"
+
+	ConfigKeyOutboxEnabled      = "outbox.enabled"
+	ConfigKeyOutboxPublisher    = "outbox.publisher"
+	ConfigKeyOutboxFile         = "outbox.file"
+	ConfigKeyOutboxURL          = "outbox.url"
+	ConfigKeyOutboxTimeout      = "outbox.timeout"
+	ConfigKeyOutboxInterval     = "outbox.interval"
+	ConfigKeyOutboxBatchSize    = "outbox.batch_size"
+	ConfigKeyOutboxRecoverAfter = "outbox.recover_after"
+
+	ConfigKeyEventsReplay    = "events.replay"
+	ConfigKeyEventsBuffer    = "events.buffer"
+	ConfigKeyEventsHeartbeat = "events.heartbeat"
+
+	ConfigKeyGRPCEnabled = "grpc.enabled"
+	ConfigKeyGRPCPort    = "grpc.port"
+
+	ConfigKeyGraphQLMaxDepth      = "graphql.max_depth"
+	ConfigKeyGraphQLMaxComplexity = "graphql.max_complexity"
+
+	ConfigKeyDebugEnabled = "debug.enabled"
+
+	ConfigKeyCacheEnabled      = "cache.enabled"
+	ConfigKeyCacheSize         = "cache.size"
+	ConfigKeyCacheTTL          = "cache.ttl"
+	ConfigKeyCacheLists        = "cache.lists"
+	ConfigKeyCacheListSize     = "cache.list_size"
+	ConfigKeyCacheInvalidation = "cache.invalidation"
+	ConfigKeyCachePeers        = "cache.peers"
+	ConfigKeyCacheTimeout      = "cache.timeout"
+
+	ConfigKeyMetricsEnabled   = "metrics.enabled"
+	ConfigKeyMetricsPath      = "metrics.path"
+	ConfigKeyMetricsAdminPort = "metrics.admin_port"
+
+	ConfigKeyTracingEnabled     = "tracing.enabled"
+	ConfigKeyTracingExporter    = "tracing.exporter"
+	ConfigKeyTracingFile        = "tracing.file"
+	ConfigKeyTracingURL         = "tracing.url"
+	ConfigKeyTracingHeaders     = "tracing.headers"
+	ConfigKeyTracingTimeout     = "tracing.timeout"
+	ConfigKeyTracingSampleRatio = "tracing.sample_ratio"
+	ConfigKeyTracingBatchSize   = "tracing.batch_size"
+	ConfigKeyTracingQueueSize   = "tracing.queue_size"
+	ConfigKeyTracingInterval    = "tracing.interval"
+
+	ConfigKeyTimeoutDefault = "timeouts.default"
+	ConfigKeyTimeoutRoutes  = "timeouts.routes"
+
+	ConfigKeyOpenAPIValidateRequests  = "openapi.validate_requests"
+	ConfigKeyOpenAPIValidateResponses = "openapi.validate_responses"
+
+	ConfigKeyClientURL    = "client.url"
+	ConfigKeyClientAPIKey = "client.api_key"
+	ConfigKeyClientID     = "client.client_id"
+	ConfigKeyClientSecret = "client.secret"
+
+	RateLimitKeyClient = "client"
+	RateLimitKeyIP     = "ip"
+
 	PaymentIDPrefix = "payment_id"
 
-	HeaderContentType = "Content-Type"
+	CtxKeyPayment  = "payment"
+	CtxKeyWebhook  = "webhook"
+	CtxKeyDelivery = "delivery"
+
+	HeaderContentType = wire.HeaderContentType
+	HeaderDate        = wire.HeaderDate
+	HeaderDigest      = wire.HeaderDigest
+	HeaderNonce       = wire.HeaderNonce
+	HeaderSignature   = wire.HeaderSignature
+	HeaderAPIKey      = wire.HeaderAPIKey
+	HeaderTenantID    = "X-Tenant-ID"
+	HeaderRetryAfter  = "Retry-After"
 	ContentTypeJSON   = "application/json; charset=utf-8"
+
+	HeaderLastEventID      = wire.HeaderLastEventID
+	HeaderCacheControl     = "Cache-Control"
+	ContentTypeEventStream = wire.ContentTypeEventStream
+
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+)
+
+// contextKey types the keys of the values the middlewares store in the
+// request context, they cannot collide with the keys of other packages
+type contextKey string
+
+const (
+	// ctxKeyClient holds the id of the client whose signature was verified
+	ctxKeyClient contextKey = "client"
+	// ctxKeySpan holds the current span of a traced request
+	ctxKeySpan contextKey = "span"
 )
+
+// DefaultInMemIndexes are the secondary indexes of the in memory store
+var DefaultInMemIndexes = []string{
+	"scheme:hash",
+	"currency:hash",
+	"beneficiary.bankId:hash",
+	"processingDate:sorted",
+	"createdAt:sorted",
+}
+
+// DefaultMongoIndexes are the indexes of the payments collection, on the
+// fields the payments are filtered on
+var DefaultMongoIndexes = []string{
+	"scheme",
+	"currency",
+	"beneficiary.bankid",
+	"processingdate",
+	"createdat",
+}
+
+// MongoIndexEndToEndReference makes the end-to-end reference of a payment
+// unique within its organisation. It is opt-in: the payments do not hold
+// their organisation yet, the index only covers the documents having one,
+// and creating it fails on a collection already holding duplicates.
+const MongoIndexEndToEndReference = "organisationid,endtoendreference:unique"
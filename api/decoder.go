@@ -0,0 +1,274 @@
+package api
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-chi/render"
+)
+
+// JSONPathRoot is the root of the JSON paths reported on decoding errors
+const JSONPathRoot = "$"
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// limitedBody is a body that fails with ErrBodyTooLarge once more than n
+// bytes have been read
+type limitedBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.n < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.n -= int64(n)
+	if b.n < 0 {
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// limitBody is a middleware that limits the size of the request bodies to
+// the configured maximum. Reading past it will fail with ErrBodyTooLarge.
+func limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config == nil || config.Decoding == nil || config.Decoding.MaxBodySize <= 0 || r.Body == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > config.Decoding.MaxBodySize {
+			handleError(w, r, ErrBodyTooLarge)
+			return
+		}
+		r.Body = &limitedBody{ReadCloser: r.Body, n: config.Decoding.MaxBodySize}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bindRequest decodes the body of the request into v and calls its Bind
+// method. In strict mode, unknown fields, duplicate keys and trailing data
+// are rejected with an error pointing at the JSON path of the problem.
+func bindRequest(r *http.Request, v render.Binder) error {
+	if config == nil || config.Decoding == nil || !config.Decoding.Strict {
+		if err := render.Bind(r, v); err != nil {
+			if apiErr, ok := err.(*APIError); ok {
+				return apiErr
+			}
+			return ErrInvalidInput
+		}
+		return nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		if apiErr, ok := err.(*APIError); ok {
+			return apiErr
+		}
+		return ErrInvalidInput
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return ErrInvalidInput
+	}
+	if err := checkJSON(body, reflect.TypeOf(v)); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			return ErrInvalidBody(
+				ErrorCodeInvalidType,
+				jsonPath(typeErr.Field),
+				fmt.Sprintf("Expected %s, got %s", typeErr.Type, typeErr.Value),
+			)
+		}
+		return ErrInvalidBody(ErrorCodeMalformedBody, JSONPathRoot, err.Error())
+	}
+	return v.Bind(r)
+}
+
+func jsonPath(field string) string {
+	if field == "" {
+		return JSONPathRoot
+	}
+	return JSONPathRoot + "." + field
+}
+
+// checkJSON walks through the JSON document alongside the type it is going
+// to be decoded in
+func checkJSON(body []byte, t reflect.Type) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	c := &jsonChecker{dec: dec}
+	if err := c.value(t, JSONPathRoot); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return ErrInvalidBody(
+			ErrorCodeTrailingData,
+			JSONPathRoot,
+			fmt.Sprintf("Unexpected data after offset %d", dec.InputOffset()),
+		)
+	}
+	return nil
+}
+
+type jsonChecker struct {
+	dec *json.Decoder
+}
+
+func (c *jsonChecker) syntaxError(err error, path string) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return ErrInvalidBody(ErrorCodeMalformedBody, path, err.Error())
+}
+
+func (c *jsonChecker) value(t reflect.Type, path string) error {
+	tok, err := c.dec.Token()
+	if err != nil {
+		return c.syntaxError(err, path)
+	}
+	switch tok {
+	case json.Delim('{'):
+		return c.object(t, path)
+	case json.Delim('['):
+		return c.array(t, path)
+	}
+	return nil
+}
+
+func (c *jsonChecker) object(t reflect.Type, path string) error {
+	t = opaque(t)
+	var fields map[string]reflect.Type
+	if t != nil && t.Kind() == reflect.Struct {
+		fields = map[string]reflect.Type{}
+		structFields(t, fields)
+	}
+	seen := map[string]bool{}
+	for c.dec.More() {
+		tok, err := c.dec.Token()
+		if err != nil {
+			return c.syntaxError(err, path)
+		}
+		key := tok.(string)
+		p := path + "." + key
+		// the fields of the structs are matched regardless of their case, the
+		// keys of the maps are not
+		name := key
+		if fields != nil {
+			name = strings.ToLower(key)
+		}
+		if seen[name] {
+			return ErrInvalidBody(ErrorCodeDuplicateField, p, "Duplicate field "+key)
+		}
+		seen[name] = true
+		var ft reflect.Type
+		switch {
+		case fields != nil:
+			var ok bool
+			if ft, ok = lookupField(fields, key); !ok {
+				return ErrInvalidBody(ErrorCodeUnknownField, p, "Unknown field "+key)
+			}
+		case t != nil && t.Kind() == reflect.Map:
+			ft = t.Elem()
+		}
+		if err := c.value(ft, p); err != nil {
+			return err
+		}
+	}
+	if _, err := c.dec.Token(); err != nil {
+		return c.syntaxError(err, path)
+	}
+	return nil
+}
+
+func (c *jsonChecker) array(t reflect.Type, path string) error {
+	t = opaque(t)
+	var elem reflect.Type
+	if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		elem = t.Elem()
+	}
+	for i := 0; c.dec.More(); i++ {
+		if err := c.value(elem, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	if _, err := c.dec.Token(); err != nil {
+		return c.syntaxError(err, path)
+	}
+	return nil
+}
+
+// opaque dereferences t and returns nil when the type decodes itself, in
+// which case its content is not checked
+func opaque(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return nil
+	}
+	pt := reflect.PtrTo(t)
+	if pt.Implements(jsonUnmarshalerType) || pt.Implements(textUnmarshalerType) {
+		return nil
+	}
+	return t
+}
+
+// structFields lists the JSON fields of a struct the same way encoding/json
+// does, including the ones promoted from embedded structs
+func structFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				structFields(ft, fields)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+}
+
+// lookupField finds a field by name, falling back on a case insensitive
+// match as encoding/json does
+func lookupField(fields map[string]reflect.Type, key string) (reflect.Type, bool) {
+	if ft, ok := fields[key]; ok {
+		return ft, true
+	}
+	for name, ft := range fields {
+		if strings.EqualFold(name, key) {
+			return ft, true
+		}
+	}
+	return nil, false
+}
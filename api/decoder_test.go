@@ -0,0 +1,72 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func readAPIError(t *testing.T, body []byte) *api.APIError {
+	apiErr := &api.APIError{}
+	if !assert.NoError(t, json.Unmarshal(body, &api.JSENDData{Data: apiErr})) {
+		t.FailNow()
+	}
+	return apiErr
+}
+
+func TestStrictDecoding(t *testing.T) {
+	prev := *api.Config().Decoding
+	defer func() { *api.Config().Decoding = prev }()
+	api.Config().Decoding.Strict = true
+	api.Config().Decoding.MaxBodySize = 1024
+	api.SetStore(api.NewPaymentInMemStore())
+	handler := api.Routes()
+
+	tests := []struct {
+		body string
+		code api.ErrorCode
+		path string
+	}{
+		{`{"ammount": "42"}`, api.ErrorCodeUnknownField, "$.ammount"},
+		{`{"beneficiary": {"bankID": "1", "bankCode": "2"}}`, api.ErrorCodeUnknownField, "$.beneficiary.bankCode"},
+		{`{"chargesInformation": {"senderCharges": [{"amount": "1"}, {"amout": "2"}]}}`, api.ErrorCodeUnknownField, "$.chargesInformation.senderCharges[1].amout"},
+		{`{"amount": "42", "amount": "43"}`, api.ErrorCodeDuplicateField, "$.amount"},
+		{`{"amount": "42", "Amount": "43"}`, api.ErrorCodeDuplicateField, "$.Amount"},
+		{`{"amount": "42"} {}`, api.ErrorCodeTrailingData, "$"},
+		{`{"amount": 42}`, api.ErrorCodeInvalidType, "$.amount"},
+		{`{"fx": {"exchangeRate": true}}`, api.ErrorCodeInvalidType, "$.fx.exchangeRate"},
+		{`{"amount": "42"`, api.ErrorCodeMalformedBody, "$"},
+	}
+	for _, test := range tests {
+		resp := doHTTPReq(handler, http.MethodPost, "/v1/payments", test.body)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, test.body)
+		apiErr := readAPIError(t, readBody(resp))
+		assert.Equal(t, test.code, apiErr.AppCode, test.body)
+		assert.Equal(t, test.path, apiErr.Path, test.body)
+	}
+
+	resp := doHTTPReq(handler, http.MethodPost, "/v1/payments", `{"Amount": "42", "beneficiary": {"bankId": "1"}}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp = doHTTPReq(handler, http.MethodPost, "/v1/payments", `{"purpose": "`+strings.Repeat("a", 2048)+`"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.Equal(t, api.ErrorCodeBodyTooLarge, readErrorCode(readBody(resp)))
+}
+
+func TestLenientDecoding(t *testing.T) {
+	prev := *api.Config().Decoding
+	defer func() { *api.Config().Decoding = prev }()
+	api.Config().Decoding.Strict = false
+	api.SetStore(api.NewPaymentInMemStore())
+	handler := api.Routes()
+
+	resp := doHTTPReq(handler, http.MethodPost, "/v1/payments", `{"ammount": "42"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp = doHTTPReq(handler, http.MethodPost, "/v1/payments", `{"amount": 42}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, api.ErrorCodeInvalidType, readErrorCode(readBody(resp)))
+}
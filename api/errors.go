@@ -1,50 +1,62 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"net/http"
+
+	"github.com/ganitzsh/f3-te/api/wire"
 )
 
+// The errors are defined in the wire package, shared with the clients
+
 // ErrorCode is a standarized string that identifies issues across the API
-type ErrorCode string
+type ErrorCode = wire.ErrorCode
 
 // APIError is the content that is returned on error
-type APIError struct {
-	Message string    `json:"error"`
-	AppCode ErrorCode `json:"code,omitempty"`
+type APIError = wire.APIError
 
-	DataError  bool  `json:"-"`
-	StatusCode int   `json:"-"`
-	Err        error `json:"-"`
+func NewAPIError(dataError bool, message string) *APIError {
+	return wire.NewAPIError(dataError, message)
 }
 
-func (e APIError) Error() string {
-	ret := e.Message
-	if e.Err != nil {
-		ret += ": " + e.Err.Error()
-	}
-	return ret
-}
+const (
+	ErrorCodeInternalError  = wire.ErrorCodeInternalError
+	ErrorCodeNotImplemented = wire.ErrorCodeNotImplemented
+	ErrorCodeMaintainance   = wire.ErrorCodeMaintainance
+	ErrorCodeNotFound       = wire.ErrorCodeNotFound
+	ErrorCodeConflict       = wire.ErrorCodeConflict
+	ErrorCodeInvalidInput   = wire.ErrorCodeInvalidInput
 
-func (e *APIError) Render(w http.ResponseWriter, r *http.Request) error {
-	return nil
-}
+	ErrorCodeSignatureMissing = wire.ErrorCodeSignatureMissing
+	ErrorCodeSignatureInvalid = wire.ErrorCodeSignatureInvalid
+	ErrorCodeUnknownClient    = wire.ErrorCodeUnknownClient
+	ErrorCodeDigestMismatch   = wire.ErrorCodeDigestMismatch
+	ErrorCodeRequestExpired   = wire.ErrorCodeRequestExpired
+	ErrorCodeReplayedRequest  = wire.ErrorCodeReplayedRequest
+	ErrorCodeRateLimited      = wire.ErrorCodeRateLimited
 
-func NewAPIError(dataError bool, message string) *APIError {
-	return &APIError{
-		DataError: dataError,
-		Message:   message,
-	}
-}
+	ErrorCodeBodyTooLarge   = wire.ErrorCodeBodyTooLarge
+	ErrorCodeMalformedBody  = wire.ErrorCodeMalformedBody
+	ErrorCodeInvalidType    = wire.ErrorCodeInvalidType
+	ErrorCodeUnknownField   = wire.ErrorCodeUnknownField
+	ErrorCodeDuplicateField = wire.ErrorCodeDuplicateField
+	ErrorCodeTrailingData   = wire.ErrorCodeTrailingData
 
-const (
-	ErrorCodeInternalError  ErrorCode = "internal_error"
-	ErrorCodeNotImplemented ErrorCode = "not_implemented"
-	ErrorCodeMaintainance   ErrorCode = "undergoing_maintenance"
-	ErrorCodeNotFound       ErrorCode = "not_found"
-	ErrorCodeInvalidInput   ErrorCode = "invalid_input"
+	ErrorCodeInvalidQuery    = wire.ErrorCodeInvalidQuery
+	ErrorCodeQueryTooDeep    = wire.ErrorCodeQueryTooDeep
+	ErrorCodeQueryTooComplex = wire.ErrorCodeQueryTooComplex
+
+	ErrorCodeInvalidResponse = wire.ErrorCodeInvalidResponse
+
+	ErrorCodeTimeout         = wire.ErrorCodeTimeout
+	ErrorCodeRequestCanceled = wire.ErrorCodeRequestCanceled
 )
 
+// StatusClientClosedRequest is the non-standard status of the requests
+// canceled by the client before a response was written
+const StatusClientClosedRequest = 499
+
 func ErrSomethingWentWrong(err error) *APIError {
 	return &APIError{
 		Message:    "Something went wrong",
@@ -55,6 +67,31 @@ func ErrSomethingWentWrong(err error) *APIError {
 	}
 }
 
+// ErrInvalidBody is returned when the body of a request could not be decoded,
+// path is the JSON path of the problem
+func ErrInvalidBody(code ErrorCode, path string, message string) *APIError {
+	return &APIError{
+		Message:    message,
+		Path:       path,
+		StatusCode: http.StatusBadRequest,
+		AppCode:    code,
+		DataError:  true,
+	}
+}
+
+// ErrInvalidResponse is returned instead of a response that does not match
+// the OpenAPI document, when the responses are validated
+func ErrInvalidResponse(err *SchemaError) *APIError {
+	return &APIError{
+		Message:    "Invalid response: " + err.Message,
+		Path:       err.Path,
+		SchemaPath: err.SchemaPath,
+		StatusCode: http.StatusInternalServerError,
+		AppCode:    ErrorCodeInvalidResponse,
+		DataError:  false,
+	}
+}
+
 var (
 	ErrNotImplemented = &APIError{
 		Message:    "Feature not implemented",
@@ -74,15 +111,119 @@ var (
 		AppCode:    ErrorCodeNotFound,
 		DataError:  false,
 	}
+	ErrConflict = &APIError{
+		Message:    "Conflicts with an existing resource",
+		StatusCode: http.StatusConflict,
+		AppCode:    ErrorCodeConflict,
+		DataError:  true,
+	}
 	ErrInvalidInput = &APIError{
 		Message:    "Invalid input",
 		StatusCode: http.StatusBadRequest,
 		AppCode:    ErrorCodeInvalidInput,
 		DataError:  true,
 	}
+	ErrSignatureMissing = &APIError{
+		Message:    "Missing request signature",
+		StatusCode: http.StatusUnauthorized,
+		AppCode:    ErrorCodeSignatureMissing,
+		DataError:  true,
+	}
+	ErrSignatureInvalid = &APIError{
+		Message:    "Invalid request signature",
+		StatusCode: http.StatusUnauthorized,
+		AppCode:    ErrorCodeSignatureInvalid,
+		DataError:  true,
+	}
+	ErrUnknownClient = &APIError{
+		Message:    "Unknown client",
+		StatusCode: http.StatusUnauthorized,
+		AppCode:    ErrorCodeUnknownClient,
+		DataError:  true,
+	}
+	ErrDigestMismatch = &APIError{
+		Message:    "Body does not match its digest",
+		StatusCode: http.StatusBadRequest,
+		AppCode:    ErrorCodeDigestMismatch,
+		DataError:  true,
+	}
+	ErrRequestExpired = &APIError{
+		Message:    "Request date is missing or outside the allowed window",
+		StatusCode: http.StatusUnauthorized,
+		AppCode:    ErrorCodeRequestExpired,
+		DataError:  true,
+	}
+	ErrReplayedRequest = &APIError{
+		Message:    "Request has already been received",
+		StatusCode: http.StatusUnauthorized,
+		AppCode:    ErrorCodeReplayedRequest,
+		DataError:  true,
+	}
+	ErrDeliveryPending = &APIError{
+		Message:    "The delivery is pending, it is already scheduled",
+		StatusCode: http.StatusConflict,
+		AppCode:    ErrorCodeConflict,
+		DataError:  true,
+	}
+	ErrRateLimited = &APIError{
+		Message:    "Too many requests",
+		StatusCode: http.StatusTooManyRequests,
+		AppCode:    ErrorCodeRateLimited,
+		DataError:  true,
+	}
+	ErrQueryTooDeep = &APIError{
+		Message:    "Query is too deep",
+		StatusCode: http.StatusBadRequest,
+		AppCode:    ErrorCodeQueryTooDeep,
+		DataError:  true,
+	}
+	ErrQueryTooComplex = &APIError{
+		Message:    "Query is too complex",
+		StatusCode: http.StatusBadRequest,
+		AppCode:    ErrorCodeQueryTooComplex,
+		DataError:  true,
+	}
+	ErrTimeout = &APIError{
+		Message:    "Request timed out",
+		StatusCode: http.StatusGatewayTimeout,
+		AppCode:    ErrorCodeTimeout,
+		DataError:  false,
+	}
+	ErrRequestCanceled = &APIError{
+		Message:    "Request canceled",
+		StatusCode: StatusClientClosedRequest,
+		AppCode:    ErrorCodeRequestCanceled,
+		DataError:  false,
+	}
+	ErrBodyTooLarge = &APIError{
+		Message:    "Request body is too large",
+		StatusCode: http.StatusRequestEntityTooLarge,
+		AppCode:    ErrorCodeBodyTooLarge,
+		DataError:  true,
+	}
 
 	ErrNilValue               = errors.New("Cannot use nil value")
 	ErrUnknownFilterType      = errors.New("Unknown filter type")
+	ErrUnknownFilterField     = errors.New("Unknown filter field")
 	ErrUnsupportedFilterType  = errors.New("Unsupported filter type")
 	ErrUnsupportedFilterValue = errors.New("Unsupported filter value")
+	// ErrStoreUnavailable is returned by a store while its circuit breaker is
+	// open or before its database is reached, it is answered like
+	// ErrAPIMaintainance
+	ErrStoreUnavailable = errors.New("The store is unavailable")
 )
+
+// contextError returns ErrTimeout or ErrRequestCanceled when err was caused
+// by the end of a context, ErrAPIMaintainance when the store is unavailable,
+// err otherwise
+func contextError(err error) error {
+	switch {
+	case errors.Is(err, ErrStoreUnavailable):
+		return ErrAPIMaintainance
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrRequestCanceled
+	}
+	return err
+}
@@ -0,0 +1,24 @@
+package api
+
+import "github.com/ganitzsh/f3-te/api/wire"
+
+// The events are defined in the wire package, shared with the clients
+
+// PaymentEventType identifies what happened to a payment
+type PaymentEventType = wire.PaymentEventType
+
+const (
+	PaymentEventCreated = wire.PaymentEventCreated
+	PaymentEventUpdated = wire.PaymentEventUpdated
+	PaymentEventDeleted = wire.PaymentEventDeleted
+)
+
+// PaymentEventTypes lists all the known event types
+var PaymentEventTypes = wire.PaymentEventTypes
+
+// PaymentEvent is emitted every time a payment is altered
+type PaymentEvent = wire.PaymentEvent
+
+func NewPaymentEvent(typ PaymentEventType, p *Payment) *PaymentEvent {
+	return wire.NewPaymentEvent(typ, p)
+}
@@ -0,0 +1,584 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	graphqlSchema     graphql.Schema
+	graphqlSchemaErr  error
+	graphqlSchemaOnce sync.Once
+)
+
+// GraphQLSchema returns the schema served on /v1/graphql
+func GraphQLSchema() (graphql.Schema, error) {
+	graphqlSchemaOnce.Do(func() {
+		graphqlSchema, graphqlSchemaErr = newGraphQLSchema()
+	})
+	return graphqlSchema, graphqlSchemaErr
+}
+
+// graphqlError logs err with the context of the request and returns the
+// APIError sent to the client, without the internal error it may wrap
+func graphqlError(ctx context.Context, err error) error {
+	logrus.WithContext(ctx).Error(err)
+	apiErr, ok := contextError(err).(*APIError)
+	if !ok {
+		apiErr = ErrSomethingWentWrong(err)
+	}
+	ret := *apiErr
+	ret.Err = nil
+	return &ret
+}
+
+func stringFields(names ...string) graphql.Fields {
+	ret := graphql.Fields{}
+	for _, name := range names {
+		ret[name] = &graphql.Field{Type: graphql.String}
+	}
+	return ret
+}
+
+func stringInputFields(names ...string) graphql.InputObjectConfigFieldMap {
+	ret := graphql.InputObjectConfigFieldMap{}
+	for _, name := range names {
+		ret[name] = &graphql.InputObjectFieldConfig{Type: graphql.String}
+	}
+	return ret
+}
+
+// paymentStringFields returns the JSON names of the string fields of Payment
+func paymentStringFields() []string {
+	ret := []string{}
+	for name := range PaymentFilterFields {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+var (
+	partyFields   = []string{"accountName", "accountNumber", "accountNumberCode", "bankId", "bankIdCode", "name", "address"}
+	chargeFields  = []string{"amount", "currency"}
+	chargesFields = []string{"bearerCode", "receiverChargesAmount", "receiverChargesCurrency"}
+	fxFields      = []string{"contractReference", "exchangeRate", "originalAmount", "originalCurrency"}
+)
+
+func newGraphQLSchema() (graphql.Schema, error) {
+	partyType := graphql.NewObject(graphql.ObjectConfig{
+		Name:   "PaymentParty",
+		Fields: stringFields(partyFields...),
+	})
+	chargeType := graphql.NewObject(graphql.ObjectConfig{
+		Name:   "SenderCharge",
+		Fields: stringFields(chargeFields...),
+	})
+	charges := stringFields(chargesFields...)
+	charges["senderCharges"] = &graphql.Field{Type: graphql.NewList(chargeType)}
+	chargesType := graphql.NewObject(graphql.ObjectConfig{
+		Name:   "ChargesInformation",
+		Fields: charges,
+	})
+	fxType := graphql.NewObject(graphql.ObjectConfig{
+		Name:   "FX",
+		Fields: stringFields(fxFields...),
+	})
+
+	payment := stringFields(paymentStringFields()...)
+	payment["id"] = &graphql.Field{
+		Type: graphql.NewNonNull(graphql.ID),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return p.Source.(*Payment).ID.String(), nil
+		},
+	}
+	payment["createdAt"] = &graphql.Field{Type: graphql.DateTime}
+	payment["updatedAt"] = &graphql.Field{Type: graphql.DateTime}
+	payment["beneficiary"] = &graphql.Field{Type: partyType}
+	payment["debitorParty"] = &graphql.Field{Type: partyType}
+	payment["chargesInformation"] = &graphql.Field{Type: chargesType}
+	payment["fx"] = &graphql.Field{Type: fxType}
+	paymentType := graphql.NewObject(graphql.ObjectConfig{
+		Name:   "Payment",
+		Fields: payment,
+	})
+	listType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PaymentList",
+		Fields: graphql.Fields{
+			"total":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"subTotal": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"results":  &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(paymentType)))},
+		},
+	})
+
+	filters := graphql.InputObjectConfigFieldMap{}
+	sortValues := graphql.EnumValueConfigMap{
+		PaymentSortCreatedAt: &graphql.EnumValueConfig{Value: PaymentSortCreatedAt},
+		PaymentSortUpdatedAt: &graphql.EnumValueConfig{Value: PaymentSortUpdatedAt},
+	}
+	for _, name := range paymentStringFields() {
+		filters[name] = &graphql.InputObjectFieldConfig{
+			Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+			Description: "Matches the payments whose " + name + " is any of the values",
+		}
+		sortValues[name] = &graphql.EnumValueConfig{Value: name}
+	}
+	filterType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:   "PaymentFilter",
+		Fields: filters,
+	})
+	sortType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "PaymentSort",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"field": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.NewEnum(graphql.EnumConfig{
+					Name:   "PaymentSortField",
+					Values: sortValues,
+				})),
+			},
+			"desc": &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+		},
+	})
+
+	partyInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:   "PaymentPartyInput",
+		Fields: stringInputFields(partyFields...),
+	})
+	chargesInput := stringInputFields(chargesFields...)
+	chargesInput["senderCharges"] = &graphql.InputObjectFieldConfig{
+		Type: graphql.NewList(graphql.NewNonNull(graphql.NewInputObject(graphql.InputObjectConfig{
+			Name:   "SenderChargeInput",
+			Fields: stringInputFields(chargeFields...),
+		}))),
+	}
+	paymentInput := stringInputFields(paymentStringFields()...)
+	paymentInput["beneficiary"] = &graphql.InputObjectFieldConfig{Type: partyInput}
+	paymentInput["debitorParty"] = &graphql.InputObjectFieldConfig{Type: partyInput}
+	paymentInput["chargesInformation"] = &graphql.InputObjectFieldConfig{
+		Type: graphql.NewInputObject(graphql.InputObjectConfig{
+			Name:   "ChargesInformationInput",
+			Fields: chargesInput,
+		}),
+	}
+	paymentInput["fx"] = &graphql.InputObjectFieldConfig{
+		Type: graphql.NewInputObject(graphql.InputObjectConfig{
+			Name:   "FXInput",
+			Fields: stringInputFields(fxFields...),
+		}),
+	}
+	paymentInputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:   "PaymentInput",
+		Fields: paymentInput,
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"payment": &graphql.Field{
+				Type: paymentType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: resolvePayment,
+			},
+			"payments": &graphql.Field{
+				Type: graphql.NewNonNull(listType),
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: filterType},
+					"sort":   &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(sortType))},
+					"limit": &graphql.ArgumentConfig{
+						Type:         graphql.Int,
+						DefaultValue: DefaultGraphQLPageSize,
+						Description:  "Maximum amount of payments returned, 0 returns all of them",
+					},
+					"offset": &graphql.ArgumentConfig{Type: graphql.Int},
+					"page": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Page wanted, ignored when offset is given",
+					},
+				},
+				Resolve: resolvePayments,
+			},
+		},
+	})
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"savePayment": &graphql.Field{
+				Type:        graphql.NewNonNull(paymentType),
+				Description: "Creates a payment, or updates it when id is given",
+				Args: graphql.FieldConfigArgument{
+					"id":      &graphql.ArgumentConfig{Type: graphql.ID},
+					"payment": &graphql.ArgumentConfig{Type: graphql.NewNonNull(paymentInputType)},
+				},
+				Resolve: resolveSavePayment,
+			},
+			"deletePayment": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.ID),
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: resolveDeletePayment,
+			},
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    query,
+		Mutation: mutation,
+	})
+}
+
+func graphqlPaymentID(value interface{}) (uuid.UUID, error) {
+	s, _ := value.(string)
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return uuid.Nil, ErrInvalidBody(ErrorCodeInvalidInput, "id", "Invalid payment ID")
+	}
+	return id, nil
+}
+
+func resolvePayment(p graphql.ResolveParams) (interface{}, error) {
+	id, err := graphqlPaymentID(p.Args["id"])
+	if err != nil {
+		return nil, graphqlError(p.Context, err)
+	}
+	payment, err := store.GetByID(p.Context, id)
+	if err != nil {
+		return nil, graphqlError(p.Context, err)
+	}
+	return payment, nil
+}
+
+func readGraphQLSorts(value interface{}) []PaymentSort {
+	ret := []PaymentSort{}
+	list, _ := value.([]interface{})
+	for _, item := range list {
+		m, _ := item.(map[string]interface{})
+		field, _ := m["field"].(string)
+		desc, _ := m["desc"].(bool)
+		ret = append(ret, PaymentSort{Field: field, Desc: desc})
+	}
+	return ret
+}
+
+func readGraphQLFilters(value interface{}) []*PaymentStoreFilter {
+	ret := []*PaymentStoreFilter{}
+	m, _ := value.(map[string]interface{})
+	for name, v := range m {
+		list, _ := v.([]interface{})
+		values := []string{}
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		if field, ok := PaymentFilterFields[name]; ok && len(values) > 0 {
+			ret = append(ret, newValuesFilter(field, values))
+		}
+	}
+	return ret
+}
+
+// resolvePayments lists the payments, sorted when a sort is requested
+func resolvePayments(p graphql.ResolveParams) (interface{}, error) {
+	filters := readGraphQLFilters(p.Args["filter"])
+	sorts := readGraphQLSorts(p.Args["sort"])
+	limit, _ := p.Args["limit"].(int)
+	offset, hasOffset := p.Args["offset"].(int)
+	if page, ok := p.Args["page"].(int); ok && !hasOffset {
+		offset = page * limit
+	}
+	if limit < 0 || offset < 0 {
+		return nil, graphqlError(p.Context, ErrInvalidBody(ErrorCodeInvalidInput, "limit", "Negative limit or offset"))
+	}
+	if len(sorts) == 0 {
+		list, err := store.GetMany(p.Context, limit, offset, filters...)
+		if err != nil {
+			return nil, graphqlError(p.Context, err)
+		}
+		return list, nil
+	}
+	list, err := GetManySorted(p.Context, store, limit, offset, sorts, filters...)
+	if err != nil {
+		return nil, graphqlError(p.Context, err)
+	}
+	return list, nil
+}
+
+func resolveSavePayment(p graphql.ResolveParams) (interface{}, error) {
+	payment := NewPayment()
+	b, err := json.Marshal(p.Args["payment"])
+	if err != nil {
+		return nil, graphqlError(p.Context, err)
+	}
+	if err := json.Unmarshal(b, payment); err != nil {
+		return nil, graphqlError(p.Context, ErrInvalidBody(ErrorCodeInvalidInput, "payment", err.Error()))
+	}
+	if p.Args["id"] != nil {
+		id, err := graphqlPaymentID(p.Args["id"])
+		if err != nil {
+			return nil, graphqlError(p.Context, err)
+		}
+		existing, err := store.GetByID(p.Context, id)
+		if err != nil {
+			return nil, graphqlError(p.Context, err)
+		}
+		payment.ID = existing.ID
+		payment.CreatedAt = existing.CreatedAt
+		payment.UpdatedAt = existing.UpdatedAt
+	}
+	if err := store.Save(p.Context, payment); err != nil {
+		return nil, graphqlError(p.Context, err)
+	}
+	return payment, nil
+}
+
+func resolveDeletePayment(p graphql.ResolveParams) (interface{}, error) {
+	id, err := graphqlPaymentID(p.Args["id"])
+	if err != nil {
+		return nil, graphqlError(p.Context, err)
+	}
+	if _, err := store.GetByID(p.Context, id); err != nil {
+		return nil, graphqlError(p.Context, err)
+	}
+	if err := store.Delete(p.Context, id); err != nil {
+		return nil, graphqlError(p.Context, err)
+	}
+	return id.String(), nil
+}
+
+// graphqlLimits computes the depth and the complexity of the operation. Each
+// field costs one, the fields selected below a field with a limit argument
+// are counted once per item requested. Introspection fields are free.
+type graphqlLimits struct {
+	ctx       context.Context
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	visiting  map[string]bool
+}
+
+func (l *graphqlLimits) limit(f *ast.Field) int {
+	for _, arg := range f.Arguments {
+		if arg.Name.Value != "limit" {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			n, _ := strconv.Atoi(v.Value)
+			return n
+		case *ast.Variable:
+			if n, ok := l.variables[v.Name.Value].(float64); ok {
+				return int(n)
+			}
+			if n, ok := l.variables[v.Name.Value].(int); ok {
+				return n
+			}
+		}
+		return DefaultGraphQLPageSize
+	}
+	if f.Name.Value == "payments" {
+		return DefaultGraphQLPageSize
+	}
+	return 1
+}
+
+func (l *graphqlLimits) measure(set *ast.SelectionSet) (depth int, cost int) {
+	if set == nil {
+		return 0, 0
+	}
+	for _, sel := range set.Selections {
+		var d, c int
+		switch s := sel.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(s.Name.Value, "__") {
+				continue
+			}
+			d, c = l.measure(s.SelectionSet)
+			n := l.limit(s)
+			if n <= 0 && store != nil {
+				// An unlimited list costs as much as the whole store
+				n = store.Total(l.ctx)
+			}
+			d, c = d+1, 1+n*c
+		case *ast.InlineFragment:
+			d, c = l.measure(s.SelectionSet)
+		case *ast.FragmentSpread:
+			name := s.Name.Value
+			fragment, ok := l.fragments[name]
+			if !ok || l.visiting[name] {
+				continue
+			}
+			l.visiting[name] = true
+			d, c = l.measure(fragment.SelectionSet)
+			delete(l.visiting, name)
+		}
+		if d > depth {
+			depth = d
+		}
+		cost += c
+	}
+	return depth, cost
+}
+
+// checkGraphQLLimits returns an error when the operation is too deep or too
+// complex
+func checkGraphQLLimits(ctx context.Context, doc *ast.Document, op *ast.OperationDefinition, variables map[string]interface{}) error {
+	settings := &GraphQLSettings{
+		MaxDepth:      DefaultGraphQLDepth,
+		MaxComplexity: DefaultGraphQLCost,
+	}
+	if config != nil && config.GraphQL != nil {
+		settings = config.GraphQL
+	}
+	l := &graphqlLimits{
+		ctx:       ctx,
+		fragments: map[string]*ast.FragmentDefinition{},
+		variables: variables,
+		visiting:  map[string]bool{},
+	}
+	for _, def := range doc.Definitions {
+		if f, ok := def.(*ast.FragmentDefinition); ok {
+			l.fragments[f.Name.Value] = f
+		}
+	}
+	depth, cost := l.measure(op.SelectionSet)
+	if settings.MaxDepth > 0 && depth > settings.MaxDepth {
+		return ErrQueryTooDeep
+	}
+	if settings.MaxComplexity > 0 && cost > settings.MaxComplexity {
+		return ErrQueryTooComplex
+	}
+	return nil
+}
+
+// findOperation returns the operation to execute, nil if it is ambiguous or
+// unknown
+func findOperation(doc *ast.Document, name string) *ast.OperationDefinition {
+	var ret *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			if ret != nil {
+				return nil
+			}
+			ret = op
+		} else if op.Name != nil && op.Name.Value == name {
+			return op
+		}
+	}
+	return ret
+}
+
+// GraphQLReq is the payload of a GraphQL request
+type GraphQLReq struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	Extensions    map[string]interface{} `json:"extensions"`
+}
+
+func (q *GraphQLReq) Bind(r *http.Request) error {
+	if strings.TrimSpace(q.Query) == "" {
+		return ErrInvalidBody(ErrorCodeInvalidInput, "query", "Missing query")
+	}
+	return nil
+}
+
+func graphqlErrorResult(err error) *graphql.Result {
+	return &graphql.Result{Errors: []gqlerrors.FormattedError{
+		gqlerrors.FormatError(gqlerrors.NewError(err.Error(), nil, "", nil, nil, err)),
+	}}
+}
+
+// ExecuteGraphQL parses, validates and executes the request. The returned
+// boolean is false when the request was rejected before its execution.
+func ExecuteGraphQL(ctx context.Context, req *GraphQLReq, allowMutations bool) (*graphql.Result, bool) {
+	schema, err := GraphQLSchema()
+	if err != nil {
+		return graphqlErrorResult(graphqlError(ctx, err)), false
+	}
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{
+			Body: []byte(req.Query),
+			Name: "GraphQL request",
+		}),
+	})
+	if err != nil {
+		res := &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+		for i := range res.Errors {
+			res.Errors[i].Extensions = map[string]interface{}{"code": ErrorCodeInvalidQuery}
+		}
+		return res, false
+	}
+	validation := graphql.ValidateDocument(&schema, doc, nil)
+	if !validation.IsValid {
+		for i := range validation.Errors {
+			validation.Errors[i].Extensions = map[string]interface{}{"code": ErrorCodeInvalidQuery}
+		}
+		return &graphql.Result{Errors: validation.Errors}, false
+	}
+	op := findOperation(doc, req.OperationName)
+	if op == nil {
+		return graphqlErrorResult(ErrInvalidBody(ErrorCodeInvalidQuery, "operationName", "Unknown or ambiguous operation")), false
+	}
+	if op.Operation == ast.OperationTypeMutation && !allowMutations {
+		return graphqlErrorResult(ErrInvalidBody(ErrorCodeInvalidQuery, "query", "Mutations are only allowed with POST")), false
+	}
+	if err := checkGraphQLLimits(ctx, doc, op, req.Variables); err != nil {
+		return graphqlErrorResult(err), false
+	}
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        schema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       ctx,
+	}), true
+}
+
+// GraphQL serves the GraphQL queries, sent as JSON with POST or in the query
+// with GET. GET only accepts queries. The response follows the GraphQL
+// format instead of JSend, the errors carry their ErrorCode in extensions.
+func GraphQL(w http.ResponseWriter, r *http.Request) {
+	req := &GraphQLReq{}
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+		if v := query.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				handleError(w, r, ErrInvalidBody(ErrorCodeMalformedBody, "variables", "Malformed variables"))
+				return
+			}
+		}
+		if err := req.Bind(r); err != nil {
+			handleError(w, r, err)
+			return
+		}
+	} else if err := bindRequest(r, req); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	res, executed := ExecuteGraphQL(r.Context(), req, r.Method == http.MethodPost)
+	if !executed {
+		render.Status(r, http.StatusBadRequest)
+	}
+	render.JSON(w, r, res)
+}
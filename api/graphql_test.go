@@ -0,0 +1,153 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/stretchr/testify/assert"
+)
+
+type graphqlResult struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func (res *graphqlResult) ErrorCode() api.ErrorCode {
+	if len(res.Errors) == 0 {
+		return ""
+	}
+	code, _ := res.Errors[0].Extensions["code"].(string)
+	return api.ErrorCode(code)
+}
+
+func doGraphQL(t *testing.T, query string, variables map[string]interface{}) (*http.Response, *graphqlResult) {
+	b, _ := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	})
+	resp := doHTTPReq(api.Routes(), http.MethodPost, "/v1/graphql", string(b))
+	res := &graphqlResult{}
+	assert.NoError(t, json.Unmarshal(readBody(resp), res))
+	return resp, res
+}
+
+func TestGraphQLQueries(t *testing.T) {
+	db := newTestDBInMem()
+	api.SetStore(db.Store)
+
+	resp, res := doGraphQL(t, `query($id: ID!) {
+		payment(id: $id) { id scheme beneficiary { accountName } fx { exchangeRate } }
+	}`, map[string]interface{}{"id": db.ID1.String()})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, res.Errors)
+	payment := struct {
+		ID          string `json:"id"`
+		Scheme      string `json:"scheme"`
+		Beneficiary struct {
+			AccountName string `json:"accountName"`
+		} `json:"beneficiary"`
+	}{}
+	assert.NoError(t, json.Unmarshal(res.Data["payment"], &payment))
+	assert.Equal(t, db.ID1.String(), payment.ID)
+	assert.Equal(t, db.Payment1.Beneficiary.AccountName, payment.Beneficiary.AccountName)
+
+	_, res = doGraphQL(t, `{
+		payments(filter: {scheme: ["A", "B"]}, sort: [{field: scheme, desc: true}], limit: 2) {
+			total subTotal results { id scheme }
+		}
+	}`, nil)
+	assert.Empty(t, res.Errors)
+	list := struct {
+		Total    int `json:"total"`
+		SubTotal int `json:"subTotal"`
+		Results  []struct {
+			ID     string `json:"id"`
+			Scheme string `json:"scheme"`
+		} `json:"results"`
+	}{}
+	assert.NoError(t, json.Unmarshal(res.Data["payments"], &list))
+	assert.Equal(t, db.Total, list.Total)
+	if assert.Len(t, list.Results, 2) {
+		assert.Equal(t, db.ID3.String(), list.Results[0].ID)
+		assert.Equal(t, schemeA, list.Results[1].Scheme)
+	}
+
+	_, res = doGraphQL(t, `{ payment(id: "`+newMockPayment().ID.String()+`") { id } }`, nil)
+	assert.Equal(t, api.ErrorCodeNotFound, res.ErrorCode())
+
+	resp, res = doGraphQL(t, `{ payments { nope } }`, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, api.ErrorCodeInvalidQuery, res.ErrorCode())
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	db := newTestDBInMem()
+	api.SetStore(db.Store)
+
+	_, res := doGraphQL(t, `mutation($p: PaymentInput!) {
+		savePayment(payment: $p) { id amount beneficiary { name } }
+	}`, map[string]interface{}{
+		"p": map[string]interface{}{
+			"amount":      "42.00",
+			"beneficiary": map[string]interface{}{"name": "Jane"},
+		},
+	})
+	assert.Empty(t, res.Errors)
+	saved := struct {
+		ID     string `json:"id"`
+		Amount string `json:"amount"`
+	}{}
+	assert.NoError(t, json.Unmarshal(res.Data["savePayment"], &saved))
+	assert.Equal(t, "42.00", saved.Amount)
+	assert.Equal(t, db.Total+1, db.Store.Total(ctx))
+
+	_, res = doGraphQL(t, `mutation($id: ID!) { savePayment(id: $id, payment: {amount: "43.00"}) { amount } }`,
+		map[string]interface{}{"id": saved.ID})
+	assert.Empty(t, res.Errors)
+	assert.Equal(t, db.Total+1, db.Store.Total(ctx))
+
+	_, res = doGraphQL(t, `mutation($id: ID!) { deletePayment(id: $id) }`,
+		map[string]interface{}{"id": saved.ID})
+	assert.Empty(t, res.Errors)
+	assert.Equal(t, db.Total, db.Store.Total(ctx))
+
+	_, res = doGraphQL(t, `mutation { deletePayment(id: "nope") }`, nil)
+	assert.Equal(t, api.ErrorCodeInvalidInput, res.ErrorCode())
+
+	query := url.Values{"query": {`mutation { deletePayment(id: "` + db.ID1.String() + `") }`}}
+	resp := doHTTPReq(api.Routes(), http.MethodGet, "/v1/graphql?"+query.Encode(), "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, db.Total, db.Store.Total(ctx))
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	api.SetStore(newTestDBInMem().Store)
+	settings := api.Config().GraphQL
+	defer func(depth, cost int) {
+		settings.MaxDepth, settings.MaxComplexity = depth, cost
+	}(settings.MaxDepth, settings.MaxComplexity)
+	settings.MaxDepth = 3
+	settings.MaxComplexity = 100
+
+	resp, res := doGraphQL(t, `{ payments { results { beneficiary { name } } } }`, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, api.ErrorCodeQueryTooDeep, res.ErrorCode())
+
+	_, res = doGraphQL(t, `query($n: Int) { payments(limit: $n) { results { id scheme } } }`,
+		map[string]interface{}{"n": 50})
+	assert.Equal(t, api.ErrorCodeQueryTooComplex, res.ErrorCode())
+
+	_, res = doGraphQL(t, `query($n: Int) { payments(limit: $n) { results { id scheme } } }`,
+		map[string]interface{}{"n": 10})
+	assert.Empty(t, res.Errors)
+
+	// Introspection is not limited
+	_, res = doGraphQL(t, `{ __schema { types { name fields { name type { name ofType { name } } } } } }`, nil)
+	assert.Empty(t, res.Errors)
+}
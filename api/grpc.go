@@ -0,0 +1,590 @@
+//go:generate protoc -I ../proto --go_out=pb --go_opt=paths=source_relative --go-grpc_out=pb --go-grpc_opt=paths=source_relative payments.proto
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api/pb"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCErrorDomain is the domain of the ErrorInfo detail attached to the gRPC
+// errors, its reason is the ErrorCode of the APIError
+const GRPCErrorDomain = "payments.v1"
+
+// grpcCodes maps the error codes of the API to the gRPC status codes, the
+// codes missing are mapped from the HTTP status of the error
+var grpcCodes = map[ErrorCode]codes.Code{
+	ErrorCodeInternalError:    codes.Internal,
+	ErrorCodeNotImplemented:   codes.Unimplemented,
+	ErrorCodeMaintainance:     codes.Unavailable,
+	ErrorCodeNotFound:         codes.NotFound,
+	ErrorCodeInvalidInput:     codes.InvalidArgument,
+	ErrorCodeSignatureMissing: codes.Unauthenticated,
+	ErrorCodeSignatureInvalid: codes.Unauthenticated,
+	ErrorCodeUnknownClient:    codes.Unauthenticated,
+	ErrorCodeRequestExpired:   codes.Unauthenticated,
+	ErrorCodeReplayedRequest:  codes.Unauthenticated,
+	ErrorCodeDigestMismatch:   codes.InvalidArgument,
+	ErrorCodeRateLimited:      codes.ResourceExhausted,
+	ErrorCodeBodyTooLarge:     codes.ResourceExhausted,
+	ErrorCodeTimeout:          codes.DeadlineExceeded,
+	ErrorCodeRequestCanceled:  codes.Canceled,
+}
+
+var httpGRPCCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.AlreadyExists,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+	http.StatusGatewayTimeout:      codes.DeadlineExceeded,
+	http.StatusInternalServerError: codes.Internal,
+}
+
+// GRPCCode returns the gRPC status code matching the APIError
+func GRPCCode(e *APIError) codes.Code {
+	if code, ok := grpcCodes[e.AppCode]; ok {
+		return code
+	}
+	if code, ok := httpGRPCCodes[e.StatusCode]; ok {
+		return code
+	}
+	if e.DataError {
+		return codes.InvalidArgument
+	}
+	return codes.Unknown
+}
+
+// GRPCError converts err to a gRPC status error. The ErrorCode and the path
+// of APIErrors are sent in an ErrorInfo detail.
+func GRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	apiErr, ok := contextError(err).(*APIError)
+	if !ok {
+		apiErr = ErrSomethingWentWrong(err)
+	}
+	st := status.New(GRPCCode(apiErr), apiErr.Message)
+	info := &errdetails.ErrorInfo{
+		Reason: string(apiErr.AppCode),
+		Domain: GRPCErrorDomain,
+	}
+	if apiErr.Path != "" {
+		info.Metadata = map[string]string{"path": apiErr.Path}
+	}
+	if withDetails, err := st.WithDetails(info); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+func timeToProto(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func timeFromProto(t *timestamppb.Timestamp) *time.Time {
+	if t == nil {
+		return nil
+	}
+	ret := t.AsTime()
+	return &ret
+}
+
+func partyToProto(p *PaymentParty) *pb.PaymentParty {
+	if p == nil {
+		return nil
+	}
+	return &pb.PaymentParty{
+		AccountName:       p.AccountName,
+		AccountNumber:     p.AccountNumber,
+		AccountNumberCode: p.AccountNumberCode,
+		BankId:            p.BankID,
+		BankIdCode:        p.BankIDCode,
+		Name:              p.Name,
+		Address:           p.Address,
+	}
+}
+
+func partyFromProto(p *pb.PaymentParty) *PaymentParty {
+	if p == nil {
+		return nil
+	}
+	return &PaymentParty{
+		AccountName:       p.AccountName,
+		AccountNumber:     p.AccountNumber,
+		AccountNumberCode: p.AccountNumberCode,
+		BankID:            p.BankId,
+		BankIDCode:        p.BankIdCode,
+		Name:              p.Name,
+		Address:           p.Address,
+	}
+}
+
+// PaymentToProto converts a payment to its protobuf message
+func PaymentToProto(p *Payment) *pb.Payment {
+	if p == nil {
+		return nil
+	}
+	ret := &pb.Payment{
+		Id:                   p.ID.String(),
+		CreatedAt:            timeToProto(p.CreatedAt),
+		UpdatedAt:            timeToProto(p.UpdatedAt),
+		Purpose:              p.Purpose,
+		Scheme:               p.Scheme,
+		Type:                 p.Type,
+		Amount:               p.Amount,
+		Beneficiary:          partyToProto(p.Beneficiary),
+		Currency:             p.Currency,
+		DebitorParty:         partyToProto(p.DebitorParty),
+		EndToEndReference:    p.EndToEndReference,
+		NumericReference:     p.NumericReference,
+		ProcessingDate:       p.ProcessingDate,
+		Reference:            p.Reference,
+		SchemePaymentSubType: p.SchemePaymentSubType,
+		SchemePaymentType:    p.SchemePaymentType,
+		ChargesInformation: &pb.ChargesInformation{
+			BearerCode:              p.ChargesInformation.BearerCode,
+			ReceiverChargesAmount:   p.ChargesInformation.ReceiverChargesAmount,
+			ReceiverChargesCurrency: p.ChargesInformation.ReceiverChargesCurrency,
+		},
+		Fx: &pb.FX{
+			ContractReference: p.FX.ContractReference,
+			ExchangeRate:      p.FX.ExchangeRate,
+			OriginalAmount:    p.FX.OriginalAmount,
+			OriginalCurrency:  p.FX.OriginalCurrency,
+		},
+	}
+	for _, c := range p.ChargesInformation.SenderCharges {
+		ret.ChargesInformation.SenderCharges = append(ret.ChargesInformation.SenderCharges, &pb.SenderCharge{
+			Amount:   c.Amount,
+			Currency: c.Currency,
+		})
+	}
+	return ret
+}
+
+// PaymentFromProto converts a protobuf message to a payment, the ID is left
+// empty when the message has none
+func PaymentFromProto(m *pb.Payment) (*Payment, error) {
+	if m == nil {
+		return nil, ErrInvalidBody(ErrorCodeInvalidInput, "payment", "Missing payment")
+	}
+	p := &Payment{
+		CreatedAt:            timeFromProto(m.CreatedAt),
+		UpdatedAt:            timeFromProto(m.UpdatedAt),
+		Purpose:              m.Purpose,
+		Scheme:               m.Scheme,
+		Type:                 m.Type,
+		Amount:               m.Amount,
+		Beneficiary:          partyFromProto(m.Beneficiary),
+		Currency:             m.Currency,
+		DebitorParty:         partyFromProto(m.DebitorParty),
+		EndToEndReference:    m.EndToEndReference,
+		NumericReference:     m.NumericReference,
+		ProcessingDate:       m.ProcessingDate,
+		Reference:            m.Reference,
+		SchemePaymentSubType: m.SchemePaymentSubType,
+		SchemePaymentType:    m.SchemePaymentType,
+	}
+	if m.Id != "" {
+		id, err := uuid.Parse(m.Id)
+		if err != nil {
+			return nil, ErrInvalidBody(ErrorCodeInvalidInput, "payment.id", "Invalid payment ID")
+		}
+		p.ID = id
+	}
+	if c := m.ChargesInformation; c != nil {
+		p.ChargesInformation.BearerCode = c.BearerCode
+		p.ChargesInformation.ReceiverChargesAmount = c.ReceiverChargesAmount
+		p.ChargesInformation.ReceiverChargesCurrency = c.ReceiverChargesCurrency
+		for _, sc := range c.SenderCharges {
+			p.ChargesInformation.SenderCharges = append(p.ChargesInformation.SenderCharges, struct {
+				Amount   string `json:"amount"`
+				Currency string `json:"currency"`
+			}{sc.Amount, sc.Currency})
+		}
+	}
+	if fx := m.Fx; fx != nil {
+		p.FX.ContractReference = fx.ContractReference
+		p.FX.ExchangeRate = fx.ExchangeRate
+		p.FX.OriginalAmount = fx.OriginalAmount
+		p.FX.OriginalCurrency = fx.OriginalCurrency
+	}
+	return p, nil
+}
+
+// grpcFilters converts the filters of a request, fields are named as in the
+// JSON representation of the payment
+func grpcFilters(filters []*pb.Filter) ([]*PaymentStoreFilter, error) {
+	ret := []*PaymentStoreFilter{}
+	for _, f := range filters {
+		field, ok := PaymentFilterFields[f.Field]
+		if !ok {
+			return nil, ErrInvalidBody(ErrorCodeInvalidInput, "filters", "Unknown filter field: "+f.Field)
+		}
+		if len(f.Values) == 0 {
+			return nil, ErrInvalidBody(ErrorCodeInvalidInput, "filters", "Missing values for filter: "+f.Field)
+		}
+		ret = append(ret, newValuesFilter(field, f.Values))
+	}
+	return ret, nil
+}
+
+func grpcPaymentID(id string) (uuid.UUID, error) {
+	ret, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, ErrInvalidInput
+	}
+	return ret, nil
+}
+
+// PaymentServer implements the PaymentService on top of the store of the API
+type PaymentServer struct {
+	pb.UnimplementedPaymentServiceServer
+}
+
+func (PaymentServer) Get(ctx context.Context, req *pb.GetPaymentRequest) (*pb.Payment, error) {
+	id, err := grpcPaymentID(req.Id)
+	if err != nil {
+		return nil, GRPCError(err)
+	}
+	p, err := store.GetByID(ctx, id)
+	if err != nil {
+		return nil, GRPCError(err)
+	}
+	return PaymentToProto(p), nil
+}
+
+func (PaymentServer) List(ctx context.Context, req *pb.ListPaymentsRequest) (*pb.ListPaymentsResponse, error) {
+	filters, err := grpcFilters(req.Filters)
+	if err != nil {
+		return nil, GRPCError(err)
+	}
+	limit, offset := int(req.Limit), int(req.Offset)
+	if offset == 0 && limit != 0 {
+		offset = int(req.Page) * limit
+	}
+	list, err := store.GetMany(ctx, limit, offset, filters...)
+	if err != nil {
+		return nil, GRPCError(err)
+	}
+	ret := &pb.ListPaymentsResponse{
+		Total:    int32(list.Total),
+		SubTotal: int32(list.SubTotal),
+	}
+	payments, _ := list.Results.([]*Payment)
+	for _, p := range payments {
+		ret.Payments = append(ret.Payments, PaymentToProto(p))
+	}
+	return ret, nil
+}
+
+func (PaymentServer) Save(ctx context.Context, req *pb.SavePaymentRequest) (*pb.Payment, error) {
+	p, err := PaymentFromProto(req.Payment)
+	if err != nil {
+		return nil, GRPCError(err)
+	}
+	if p.ID == uuid.Nil {
+		created := NewPayment()
+		p.ID = created.ID
+		p.CreatedAt = created.CreatedAt
+		p.UpdatedAt = created.UpdatedAt
+	} else {
+		existing, err := store.GetByID(ctx, p.ID)
+		if err != nil {
+			return nil, GRPCError(err)
+		}
+		p.CreatedAt = existing.CreatedAt
+		p.UpdatedAt = existing.UpdatedAt
+	}
+	if err := store.Save(ctx, p); err != nil {
+		return nil, GRPCError(err)
+	}
+	return PaymentToProto(p), nil
+}
+
+func (PaymentServer) Delete(ctx context.Context, req *pb.DeletePaymentRequest) (*emptypb.Empty, error) {
+	id, err := grpcPaymentID(req.Id)
+	if err != nil {
+		return nil, GRPCError(err)
+	}
+	if _, err := store.GetByID(ctx, id); err != nil {
+		return nil, GRPCError(err)
+	}
+	if err := store.Delete(ctx, id); err != nil {
+		return nil, GRPCError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func changeToProto(e *BusEvent) *pb.PaymentChange {
+	return &pb.PaymentChange{
+		Sequence:  e.Sequence,
+		Id:        e.Event.ID.String(),
+		Type:      string(e.Event.Type),
+		CreatedAt: timestamppb.New(e.Event.CreatedAt),
+		Payment:   PaymentToProto(e.Event.Payment),
+	}
+}
+
+// WatchChanges streams the events of the bus like StreamPayments. A client
+// too slow to keep up receives Unavailable and can resume with the sequence
+// of the last change it received.
+func (PaymentServer) WatchChanges(req *pb.WatchChangesRequest, stream pb.PaymentService_WatchChangesServer) error {
+	filters, err := grpcFilters(req.Filters)
+	if err != nil {
+		return GRPCError(err)
+	}
+	match, err := CompileFilters(filters...)
+	if err != nil {
+		return GRPCError(ErrSomethingWentWrong(err))
+	}
+	buffer := DefaultEventsBuffer
+	if config != nil && config.Events != nil {
+		buffer = config.Events.Buffer
+	}
+	since := int64(-1)
+	if req.LastSequence != nil {
+		since = req.GetLastSequence()
+	}
+	sub, missed, complete := bus.Subscribe(since, buffer)
+	defer bus.Unsubscribe(sub)
+
+	if !complete {
+		if err := stream.Send(&pb.PaymentChange{Reset_: true}); err != nil {
+			return err
+		}
+	}
+	for _, e := range missed {
+		if match(e.Event.Payment) {
+			if err := stream.Send(changeToProto(e)); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-closing:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case e, ok := <-sub.C:
+			if !ok {
+				return status.Error(codes.Unavailable, "client too slow, resume from the last sequence received")
+			}
+			if !match(e.Event.Payment) {
+				continue
+			}
+			if err := stream.Send(changeToProto(e)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// grpcHealthy rejects the calls while the data source is not healthy, like
+// the datasourceHealthy middleware
+func grpcHealthy() error {
+	if config != nil && config.DBType == DatabaseTypeMongo && !mongoHealthy() {
+		return GRPCError(ErrAPIMaintainance)
+	}
+	return nil
+}
+
+func grpcUnaryHealthy(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := grpcHealthy(); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func grpcStreamHealthy(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := grpcHealthy(); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// grpcWrites are the methods altering payments, their calls are signed like
+// the REST requests that alter data. The streams only read.
+var grpcWrites = map[string]bool{
+	pb.PaymentService_Save_FullMethodName:   true,
+	pb.PaymentService_Delete_FullMethodName: true,
+}
+
+// grpcSignatureHeaders are the headers of the signature sent in the metadata
+// of the calls, in lower case
+var grpcSignatureHeaders = []string{HeaderDate, HeaderDigest, HeaderNonce, HeaderSignature}
+
+// grpcSignedRequest builds the request whose signature covers a gRPC call: a
+// POST to the full method of the call with the deterministic encoding of the
+// message as its body. The signature headers are read from md.
+func grpcSignedRequest(fullMethod string, msg proto.Message, md metadata.MD) (*http.Request, error) {
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest(http.MethodPost, fullMethod, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range grpcSignatureHeaders {
+		if v := md.Get(h); len(v) > 0 {
+			r.Header.Set(h, v[0])
+		}
+	}
+	return r, nil
+}
+
+// SignGRPC returns a context whose outgoing metadata carries the signature of
+// the call of fullMethod with msg, see SignRequest
+func SignGRPC(ctx context.Context, fullMethod string, msg proto.Message, clientID, secret, nonce string) (context.Context, error) {
+	r, err := grpcSignedRequest(fullMethod, msg, nil)
+	if err != nil {
+		return ctx, err
+	}
+	if err := SignRequest(r, clientID, secret, nonce); err != nil {
+		return ctx, err
+	}
+	kv := make([]string, 0, 2*len(grpcSignatureHeaders))
+	for _, h := range grpcSignatureHeaders {
+		kv = append(kv, strings.ToLower(h), r.Header.Get(h))
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...), nil
+}
+
+// grpcAuthorize verifies the signature of the writes and takes a token from
+// the rate limiter shared with the REST API, like the ipRateLimited,
+// signedRequest and rateLimited middlewares. The returned context carries the verified client.
+// The Retry-After of rejected calls is sent with setHeader.
+func grpcAuthorize(ctx context.Context, fullMethod string, req interface{}, setHeader func(metadata.MD) error) (context.Context, error) {
+	if config == nil {
+		return ctx, nil
+	}
+	write := grpcWrites[fullMethod]
+	limited := config.RateLimit != nil && config.RateLimit.Enabled
+	addr := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	reject := func(res *RateLimitResult, err error) error {
+		if err != nil {
+			return GRPCError(err)
+		}
+		if !res.Allowed {
+			setHeader(metadata.Pairs(strings.ToLower(HeaderRetryAfter), ceilSeconds(res.RetryAfter)))
+			return GRPCError(ErrRateLimited)
+		}
+		return nil
+	}
+	if limited {
+		if err := reject(takeIPRateLimit(config.RateLimit, addr)); err != nil {
+			return ctx, err
+		}
+	}
+	client := ""
+	if write && config.Signature != nil && config.Signature.Enabled {
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return ctx, GRPCError(ErrSignatureMissing)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		r, err := grpcSignedRequest(fullMethod, msg, md)
+		if err != nil {
+			return ctx, GRPCError(err)
+		}
+		if client, err = verifyRequest(r, config.Signature, time.Now()); err != nil {
+			return ctx, GRPCError(err)
+		}
+		ctx = context.WithValue(ctx, ctxKeyClient, client)
+	}
+	if limited {
+		if err := reject(takeRateLimit(config.RateLimit, write, client, addr)); err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+func grpcUnaryAuthorized(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := grpcAuthorize(ctx, info.FullMethod, req, func(md metadata.MD) error {
+		return grpc.SetHeader(ctx, md)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func grpcStreamAuthorized(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if _, err := grpcAuthorize(ss.Context(), info.FullMethod, nil, ss.SetHeader); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// NewGRPCServer creates the gRPC server with the PaymentService registered
+func NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(grpcUnaryHealthy, grpcUnaryAuthorized),
+		grpc.ChainStreamInterceptor(grpcStreamHealthy, grpcStreamAuthorized),
+	}, opts...)
+	s := grpc.NewServer(opts...)
+	pb.RegisterPaymentServiceServer(s, PaymentServer{})
+	return s
+}
+
+// startGRPC starts the gRPC server in the background when it is enabled, it
+// uses the TLS configuration of the REST API
+func startGRPC() (*grpc.Server, error) {
+	if config.GRPC == nil || !config.GRPC.Enabled {
+		return nil, nil
+	}
+	opts := []grpc.ServerOption{}
+	if config.GetTLS() {
+		creds, err := credentials.NewServerTLSFromFile(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	lis, err := net.Listen("tcp", config.GetGRPCHost())
+	if err != nil {
+		return nil, err
+	}
+	s := NewGRPCServer(opts...)
+	logrus.Infof("Starting gRPC server on %s", config.GetGRPCHost())
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			logrus.Errorf("gRPC: %v", err)
+		}
+	}()
+	return s, nil
+}
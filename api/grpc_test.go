@@ -0,0 +1,251 @@
+package api_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/ganitzsh/f3-te/api/pb"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestGRPCClient serves the PaymentService over bufconn with the payments
+// of db, the returned function stops the server
+func newTestGRPCClient(t *testing.T, db *mockDB) (pb.PaymentServiceClient, func()) {
+	api.SetStore(api.NewPaymentEventStore(db.Store, api.Bus()))
+	lis := bufconn.Listen(1 << 20)
+	srv := api.NewGRPCServer()
+	go srv.Serve(lis)
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return pb.NewPaymentServiceClient(conn), func() {
+		conn.Close()
+		srv.Stop()
+	}
+}
+
+// grpcErrorReason returns the status code of err and the ErrorCode it carries
+func grpcErrorReason(err error) (codes.Code, api.ErrorCode) {
+	st := status.Convert(err)
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return st.Code(), api.ErrorCode(info.Reason)
+		}
+	}
+	return st.Code(), ""
+}
+
+func TestGRPCPayments(t *testing.T) {
+	db := newTestDBInMem()
+	client, stop := newTestGRPCClient(t, db)
+	defer stop()
+	ctx := context.Background()
+
+	got, err := client.Get(ctx, &pb.GetPaymentRequest{Id: db.ID1.String()})
+	assert.NoError(t, err)
+	assert.Equal(t, db.Payment1.Amount, got.Amount)
+	assert.Equal(t, db.Payment1.Beneficiary.AccountName, got.Beneficiary.AccountName)
+
+	list, err := client.List(ctx, &pb.ListPaymentsRequest{
+		Filters: []*pb.Filter{{Field: "scheme", Values: []string{schemeA}}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), list.Total)
+	assert.Len(t, list.Payments, 2)
+
+	list, err = client.List(ctx, &pb.ListPaymentsRequest{Limit: 1, Page: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, list.Payments, 1) {
+		assert.Equal(t, db.ID2.String(), list.Payments[0].Id)
+	}
+
+	created, err := client.Save(ctx, &pb.SavePaymentRequest{
+		Payment: &pb.Payment{Scheme: schemeB, Amount: "42.00"},
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, created.Id)
+	assert.NotNil(t, created.CreatedAt)
+
+	created.Amount = "43.00"
+	updated, err := client.Save(ctx, &pb.SavePaymentRequest{Payment: created})
+	assert.NoError(t, err)
+	assert.Equal(t, "43.00", updated.Amount)
+	assert.Equal(t, db.Total+1, db.Store.Total(ctx))
+
+	_, err = client.Delete(ctx, &pb.DeletePaymentRequest{Id: created.Id})
+	assert.NoError(t, err)
+	assert.Equal(t, db.Total, db.Store.Total(ctx))
+
+	_, err = client.Get(ctx, &pb.GetPaymentRequest{Id: created.Id})
+	code, reason := grpcErrorReason(err)
+	assert.Equal(t, codes.NotFound, code)
+	assert.Equal(t, api.ErrorCodeNotFound, reason)
+
+	_, err = client.Delete(ctx, &pb.DeletePaymentRequest{Id: "nope"})
+	code, reason = grpcErrorReason(err)
+	assert.Equal(t, codes.InvalidArgument, code)
+	assert.Equal(t, api.ErrorCodeInvalidInput, reason)
+
+	_, err = client.List(ctx, &pb.ListPaymentsRequest{
+		Filters: []*pb.Filter{{Field: "unknown", Values: []string{"x"}}},
+	})
+	code, _ = grpcErrorReason(err)
+	assert.Equal(t, codes.InvalidArgument, code)
+}
+
+// signGRPC returns a context carrying the signature of the call
+func signGRPC(t *testing.T, method string, msg proto.Message) context.Context {
+	ctx, err := api.SignGRPC(context.Background(), method, msg, testClientID, testClientSecret, uuid.New().String())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return ctx
+}
+
+func TestGRPCSignature(t *testing.T) {
+	defer enableSignature()()
+	db := newTestDBInMem()
+	client, stop := newTestGRPCClient(t, db)
+	defer stop()
+
+	req := &pb.SavePaymentRequest{Payment: &pb.Payment{Scheme: schemeB, Amount: "42.00"}}
+	_, err := client.Save(context.Background(), req)
+	code, reason := grpcErrorReason(err)
+	assert.Equal(t, codes.Unauthenticated, code)
+	assert.Equal(t, api.ErrorCodeSignatureMissing, reason)
+
+	ctx := signGRPC(t, pb.PaymentService_Save_FullMethodName, req)
+	created, err := client.Save(ctx, req)
+	assert.NoError(t, err)
+
+	// the nonce cannot be replayed and the signature covers the message
+	_, err = client.Save(ctx, req)
+	code, reason = grpcErrorReason(err)
+	assert.Equal(t, codes.Unauthenticated, code)
+	assert.Equal(t, api.ErrorCodeReplayedRequest, reason)
+	ctx = signGRPC(t, pb.PaymentService_Save_FullMethodName, req)
+	_, err = client.Save(ctx, &pb.SavePaymentRequest{Payment: &pb.Payment{Scheme: schemeB, Amount: "1.00"}})
+	code, reason = grpcErrorReason(err)
+	assert.Equal(t, codes.InvalidArgument, code)
+	assert.Equal(t, api.ErrorCodeDigestMismatch, reason)
+
+	_, err = client.Delete(context.Background(), &pb.DeletePaymentRequest{Id: created.Id})
+	code, _ = grpcErrorReason(err)
+	assert.Equal(t, codes.Unauthenticated, code)
+	del := &pb.DeletePaymentRequest{Id: created.Id}
+	_, err = client.Delete(signGRPC(t, pb.PaymentService_Delete_FullMethodName, del), del)
+	assert.NoError(t, err)
+
+	_, err = client.Get(context.Background(), &pb.GetPaymentRequest{Id: db.ID1.String()})
+	assert.NoError(t, err)
+}
+
+func TestGRPCRateLimited(t *testing.T) {
+	prev := api.Config().RateLimit
+	defer func() {
+		api.Config().RateLimit = prev
+		api.SetRateLimiter(api.NewInMemRateLimiter())
+	}()
+	api.Config().RateLimit = &api.RateLimitSettings{
+		Enabled: true,
+		Key:     api.RateLimitKeyClient,
+		Read:    &api.RateBudget{Limit: 1, Period: time.Minute},
+		Write:   &api.RateBudget{Limit: 1, Period: time.Minute},
+	}
+	api.SetRateLimiter(api.NewInMemRateLimiter())
+	db := newTestDBInMem()
+	client, stop := newTestGRPCClient(t, db)
+	defer stop()
+	ctx := context.Background()
+
+	_, err := client.Get(ctx, &pb.GetPaymentRequest{Id: db.ID1.String()})
+	assert.NoError(t, err)
+	var header metadata.MD
+	_, err = client.Get(ctx, &pb.GetPaymentRequest{Id: db.ID1.String()}, grpc.Header(&header))
+	code, reason := grpcErrorReason(err)
+	assert.Equal(t, codes.ResourceExhausted, code)
+	assert.Equal(t, api.ErrorCodeRateLimited, reason)
+	assert.Equal(t, []string{"60"}, header.Get(api.HeaderRetryAfter))
+
+	// the writes have their own budget
+	req := &pb.SavePaymentRequest{Payment: &pb.Payment{Scheme: schemeB, Amount: "42.00"}}
+	_, err = client.Save(ctx, req)
+	assert.NoError(t, err)
+	_, err = client.Save(ctx, req)
+	code, _ = grpcErrorReason(err)
+	assert.Equal(t, codes.ResourceExhausted, code)
+
+	// so do the signed clients
+	defer enableSignature()()
+	_, err = client.Save(signGRPC(t, pb.PaymentService_Save_FullMethodName, req), req)
+	assert.NoError(t, err)
+}
+
+func TestGRPCWatchChanges(t *testing.T) {
+	db := newTestDBInMem()
+	client, stop := newTestGRPCClient(t, db)
+	defer stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.WatchChanges(ctx, &pb.WatchChangesRequest{
+		Filters: []*pb.Filter{{Field: "scheme", Values: []string{schemeA}}},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, waitFor(func() bool { return api.Bus().Subscribers() == 1 }))
+
+	_, err = client.Save(ctx, &pb.SavePaymentRequest{Payment: &pb.Payment{Scheme: schemeB}})
+	assert.NoError(t, err)
+	_, err = client.Delete(ctx, &pb.DeletePaymentRequest{Id: db.ID1.String()})
+	assert.NoError(t, err)
+
+	change, err := stream.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, string(api.PaymentEventDeleted), change.Type)
+		assert.Equal(t, db.ID1.String(), change.Payment.Id)
+	}
+
+	// Resuming from before the deletion replays it
+	resumed, err := client.WatchChanges(ctx, &pb.WatchChangesRequest{
+		LastSequence: proto.Int64(change.Sequence - 1),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	change, err = resumed.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, string(api.PaymentEventDeleted), change.Type)
+	}
+
+	cancel()
+	assert.True(t, waitFor(func() bool { return api.Bus().Subscribers() == 0 }))
+}
+
+func TestGRPCCode(t *testing.T) {
+	assert.Equal(t, codes.NotFound, api.GRPCCode(api.ErrNotFound))
+	assert.Equal(t, codes.Unavailable, api.GRPCCode(api.ErrAPIMaintainance))
+	assert.Equal(t, codes.Unauthenticated, api.GRPCCode(api.ErrSignatureInvalid))
+	assert.Equal(t, codes.ResourceExhausted, api.GRPCCode(api.ErrRateLimited))
+	assert.Equal(t, codes.InvalidArgument, api.GRPCCode(api.ErrInvalidBody(api.ErrorCodeUnknownField, "x", "")))
+	assert.Equal(t, codes.Internal, api.GRPCCode(api.ErrSomethingWentWrong(nil)))
+}
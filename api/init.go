@@ -2,9 +2,9 @@ package api
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/globalsign/mgo"
 	"github.com/sirupsen/logrus"
@@ -36,102 +36,416 @@ func InitConfig() {
 	viper.SetDefault(ConfigKeyMongoDatabase, DefaultMongoDatabase)
 	viper.SetDefault(ConfigKeyMongoCollection, DefaultMongoCollection)
 	viper.SetDefault(ConfigKeyMongoURI, DefaultMongoURI)
-	viper.SetDefault(ConfigKeyMongoMaxRetries, DefaultMongoMaxRetries)
+	viper.SetDefault(ConfigKeyMongoIndexes, DefaultMongoIndexes)
+	viper.SetDefault(ConfigKeyMongoConnectTimeout, DefaultMongoConnect)
+	viper.SetDefault(ConfigKeyMongoSocketTimeout, DefaultMongoSocket)
+	viper.SetDefault(ConfigKeyMongoReconnectInterval, DefaultMongoInterval)
+	viper.SetDefault(ConfigKeyMongoReconnectMinBackoff, DefaultMongoMinBackoff)
+	viper.SetDefault(ConfigKeyMongoReconnectMaxBackoff, DefaultMongoMaxBackoff)
+	viper.SetDefault(ConfigKeyMongoReconnectJitter, DefaultMongoJitter)
+	viper.SetDefault(ConfigKeyMongoBreakerThreshold, DefaultMongoThreshold)
+	viper.SetDefault(ConfigKeyMongoBreakerCooldown, DefaultMongoCooldown)
+	viper.SetDefault(ConfigKeyBoltPath, DefaultBoltPath)
+	viper.SetDefault(ConfigKeyBoltTimeout, DefaultBoltTimeout)
+	viper.SetDefault(ConfigKeySQLDriver, DefaultSQLDriver)
+	viper.SetDefault(ConfigKeySQLDSN, DefaultSQLDSN)
+	viper.SetDefault(ConfigKeySQLAutoMigrate, false)
+	viper.SetDefault(ConfigKeyInMemIndexes, DefaultInMemIndexes)
+	viper.SetDefault(ConfigKeyInMemPersist, false)
+	viper.SetDefault(ConfigKeyInMemDir, DefaultInMemDir)
+	viper.SetDefault(ConfigKeyInMemFsync, DefaultInMemFsync)
+	viper.SetDefault(ConfigKeyInMemFsyncInterval, DefaultInMemFsyncEvery)
+	viper.SetDefault(ConfigKeyInMemSnapshotInterval, DefaultInMemSnapshot)
+	viper.SetDefault(ConfigKeyInMemSnapshotRecords, DefaultInMemSnapRecs)
 	viper.SetDefault(ConfigKeyDatabaseType, DatabaseTypeInMem)
+	viper.SetDefault(ConfigKeySignatureEnabled, false)
+	viper.SetDefault(ConfigKeySignatureWindow, DefaultSignatureWindow)
+	viper.SetDefault(ConfigKeyRateLimitEnabled, false)
+	viper.SetDefault(ConfigKeyRateLimitKey, DefaultRateLimitKey)
+	viper.SetDefault(ConfigKeyRateLimitReadLimit, DefaultRateReadLimit)
+	viper.SetDefault(ConfigKeyRateLimitReadPeriod, DefaultRatePeriod)
+	viper.SetDefault(ConfigKeyRateLimitWriteLimit, DefaultRateWriteLimit)
+	viper.SetDefault(ConfigKeyRateLimitWritePeriod, DefaultRatePeriod)
+	viper.SetDefault(ConfigKeyRateLimitIPLimit, DefaultRateIPLimit)
+	viper.SetDefault(ConfigKeyRateLimitIPPeriod, DefaultRatePeriod)
+	viper.SetDefault(ConfigKeyDecodingStrict, DefaultStrictDecoding)
+	viper.SetDefault(ConfigKeyDecodingMaxBodySize, DefaultMaxBodySize)
+	viper.SetDefault(ConfigKeyWebhookMaxAttempts, DefaultWebhookAttempts)
+	viper.SetDefault(ConfigKeyWebhookBackoff, DefaultWebhookBackoff)
+	viper.SetDefault(ConfigKeyWebhookMaxBackoff, DefaultWebhookMaxDelay)
+	viper.SetDefault(ConfigKeyWebhookTimeout, DefaultWebhookTimeout)
+	viper.SetDefault(ConfigKeyWebhookMaxDeliveries, DefaultWebhookLogSize)
+	viper.SetDefault(ConfigKeyOutboxEnabled, false)
+	viper.SetDefault(ConfigKeyOutboxPublisher, DefaultOutboxPublisher)
+	viper.SetDefault(ConfigKeyOutboxTimeout, DefaultOutboxTimeout)
+	viper.SetDefault(ConfigKeyOutboxInterval, DefaultOutboxInterval)
+	viper.SetDefault(ConfigKeyOutboxBatchSize, DefaultOutboxBatchSize)
+	viper.SetDefault(ConfigKeyOutboxRecoverAfter, DefaultOutboxRecovery)
+	viper.SetDefault(ConfigKeyEventsReplay, DefaultEventsReplay)
+	viper.SetDefault(ConfigKeyEventsBuffer, DefaultEventsBuffer)
+	viper.SetDefault(ConfigKeyEventsHeartbeat, DefaultEventsHeartbeat)
+	viper.SetDefault(ConfigKeyGRPCEnabled, false)
+	viper.SetDefault(ConfigKeyGRPCPort, DefaultGRPCPort)
+	viper.SetDefault(ConfigKeyGraphQLMaxDepth, DefaultGraphQLDepth)
+	viper.SetDefault(ConfigKeyGraphQLMaxComplexity, DefaultGraphQLCost)
+	viper.SetDefault(ConfigKeyDebugEnabled, false)
+	viper.SetDefault(ConfigKeyCacheEnabled, false)
+	viper.SetDefault(ConfigKeyCacheSize, DefaultCacheSize)
+	viper.SetDefault(ConfigKeyCacheTTL, DefaultCacheTTL)
+	viper.SetDefault(ConfigKeyCacheLists, false)
+	viper.SetDefault(ConfigKeyCacheListSize, DefaultCacheListSize)
+	viper.SetDefault(ConfigKeyCacheInvalidation, CacheInvalidationNone)
+	viper.SetDefault(ConfigKeyCacheTimeout, DefaultCacheTimeout)
+	viper.SetDefault(ConfigKeyMetricsEnabled, false)
+	viper.SetDefault(ConfigKeyMetricsPath, DefaultMetricsPath)
+	viper.SetDefault(ConfigKeyTracingEnabled, false)
+	viper.SetDefault(ConfigKeyTracingExporter, DefaultTracingExporter)
+	viper.SetDefault(ConfigKeyTracingURL, DefaultTracingURL)
+	viper.SetDefault(ConfigKeyTracingTimeout, DefaultTracingTimeout)
+	viper.SetDefault(ConfigKeyTracingSampleRatio, 1.0)
+	viper.SetDefault(ConfigKeyTracingBatchSize, DefaultTracingBatch)
+	viper.SetDefault(ConfigKeyTracingQueueSize, DefaultTracingQueue)
+	viper.SetDefault(ConfigKeyTracingInterval, DefaultTracingInterval)
+	viper.SetDefault(ConfigKeyTimeoutDefault, DefaultRequestTimeout)
+	viper.SetDefault(ConfigKeyOpenAPIValidateRequests, true)
+	viper.SetDefault(ConfigKeyOpenAPIValidateResponses, false)
 	viper.AutomaticEnv()
 	config = NewAPIConfig()
 }
 
-func mongoHealthCheck() {
-	var attempts int
-	for {
-		prevState := mongoHealthy
-		if mongo == nil {
-			logrus.Error("No mongo handler")
-			mongoHealthy = false
-		} else {
-			if err := mongo.Ping(); err != nil {
-				mongoHealthy = false
-				attempts += 1
-				logrus.Errorf(
-					"Mongo: could not ping database: %v (%d of %d)",
-					err, attempts, config.Mongo.MaxRetries,
-				)
-				if attempts >= config.Mongo.MaxRetries {
-					logrus.Fatalf("could not reach database afer %d attempts", attempts)
-				}
-			} else {
-				mongoHealthy = true
-			}
-			if prevState == false && mongoHealthy {
-				logrus.Info("Mongo: connection reestablished")
-				attempts = 0
-			}
-		}
-		time.Sleep(3 * time.Second)
+// dialMongo opens a session on the configured database and checks it can
+// reach the collections
+func dialMongo() (*mgo.Session, error) {
+	info, err := config.Mongo.DialInfo()
+	if err != nil {
+		return nil, err
+	}
+	session, err := mgo.DialWithInfo(info)
+	if err != nil {
+		return nil, err
+	}
+	if config.Mongo.Timeouts.Socket > 0 {
+		session.SetSocketTimeout(config.Mongo.Timeouts.Socket)
+	}
+	if err = session.Ping(); err != nil {
+		session.Close()
+		return nil, errors.New("could not ping the database")
 	}
+	if _, err = session.DB(config.Mongo.Database).CollectionNames(); err != nil {
+		session.Close()
+		return nil, errors.New("could not retrieve collections, are you logged in?")
+	}
+	return session, nil
 }
 
+// getMongoCollection connects once to the payments collection, for the
+// commands working on the data directly
 func getMongoCollection() (*MgoWrapCollection, error) {
-	var err error
 	logrus.Info("Mongo: connecting")
-	mongo, err = mgo.Dial(config.Mongo.URI)
+	session, err := dialMongo()
 	if err != nil {
 		return nil, err
 	}
-	mongo.SetSocketTimeout(2 * time.Second)
-	if err = mongo.Ping(); err != nil {
-		return nil, errors.New("could not ping the database")
+	mongo = session
+	return &MgoWrapCollection{mongo.DB(config.Mongo.Database).C(config.Mongo.Collection)}, nil
+}
+
+// initMongo starts the supervisor of the connection to the database, it keeps
+// retrying until it connects. The API serves in the meantime and answers 503,
+// the store and its indexes are created once connected. It answers 503 as
+// well while the connection is lost instead of exiting.
+func initMongo() {
+	if err := config.Mongo.Validate(); err != nil {
+		logrus.Fatalf("Mongo: invalid settings: %v", err)
 	}
+	for _, spec := range config.Mongo.Indexes {
+		if _, err := ParseMongoIndex(spec); err != nil {
+			logrus.Fatalf("Mongo: invalid index: %v", err)
+		}
+	}
+	breaker := NewCircuitBreaker("Mongo", config.Mongo.Breaker.Threshold, config.Mongo.Breaker.Cooldown)
+	mongoSupervisor = NewMongoSupervisor(config.Mongo.Reconnect, func() (MongoConn, error) {
+		session, err := dialMongo()
+		if err != nil {
+			return nil, err
+		}
+		return session, nil
+	})
+	mongoSupervisor.Breaker = breaker
+	pending := NewPaymentPendingStore()
+	mongoSupervisor.OnConnect = func(conn MongoConn) error {
+		s, err := newMongoStore(conn.(*mgo.Session), breaker)
+		if err != nil {
+			return err
+		}
+		pending.Set(s)
+		initOutboxRelay(s)
+		logrus.Info("Mongo: ready")
+		return nil
+	}
+	store = pending
+	logrus.Info("Mongo: connecting")
+	mongoSupervisor.Start()
+}
+
+// newMongoStore creates the store of the payments on the session, after
+// creating the declared indexes
+func newMongoStore(session *mgo.Session, breaker *CircuitBreaker) (*PaymentMongoStore, error) {
+	mongo = session
+	c := &MgoWrapCollection{mongo.DB(config.Mongo.Database).C(config.Mongo.Collection)}
+	// the store is usable without its indexes, a sync failing on duplicates
+	// would fail again on every connection
+	created, err := SyncMongoIndexes(c)
+	for _, name := range created {
+		logrus.Infof("Mongo: created index %s", name)
+	}
+	if err != nil {
+		logrus.Errorf("Mongo: %v, fix the collection then run app db indexes sync", err)
+	}
+	s := NewPaymentMongoStore(c)
+	s.Breaker = breaker
+	if config.Outbox.Enabled {
+		if s.Outbox, err = NewMongoOutbox(); err != nil {
+			return nil, fmt.Errorf("could not create the outbox: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// SyncMongoIndexes creates the declared indexes missing from the payments
+// collection
+func SyncMongoIndexes(c *MgoWrapCollection) ([]string, error) {
+	m, err := NewMongoIndexManager(c.Collection, config.Mongo.Indexes)
+	if err != nil {
+		return nil, err
+	}
+	return m.Sync()
+}
+
+// OpenMongoIndexes connects to the configured payments collection to manage
+// its indexes
+func OpenMongoIndexes() (*MongoIndexManager, error) {
+	if config.DBType != DatabaseTypeMongo {
+		return nil, errors.New("the indexes are only managed with the mongo store")
+	}
+	c, err := getMongoCollection()
+	if err != nil {
+		return nil, err
+	}
+	return NewMongoIndexManager(c.Collection, config.Mongo.Indexes)
+}
+
+// NewMongoOutbox creates the outbox stored next to the payments collection,
+// mongo must be connected
+func NewMongoOutbox() (*OutboxMongoStore, error) {
 	db := mongo.DB(config.Mongo.Database)
-	if _, err = db.CollectionNames(); err != nil {
-		return nil, errors.New("could not retrieve collections, are you logged in?")
+	name := config.Mongo.Collection + DefaultOutboxSuffix
+	o := NewOutboxMongoStore(db.C(name), db.C(name+"_counters"))
+	if err := o.EnsureIndexes(); err != nil {
+		return nil, err
 	}
-	mongoHealthy = true
-	go mongoHealthCheck()
-	return &MgoWrapCollection{db.C(config.Mongo.Collection)}, nil
+	return o, nil
 }
 
-func initMongo() {
-	var attempts int
-	for {
+// initOutboxRelay starts the relay of the outbox of the store
+func initOutboxRelay(s *PaymentMongoStore) {
+	if s.Outbox == nil {
+		return
+	}
+	publisher, err := NewEventPublisher(config.Outbox)
+	if err != nil {
+		logrus.Fatalf("Outbox: %v", err)
+	}
+	relay = NewOutboxRelay(s.Outbox, publisher, s, config.Outbox)
+	relay.Start()
+	logrus.Info("Outbox: relay started")
+}
+
+// ReplayOutbox connects to the outbox and publishes again the committed
+// events whose sequence is between from and to included. It returns the
+// amount of events published.
+func ReplayOutbox(from, to int64) (int, error) {
+	if config.DBType != DatabaseTypeMongo {
+		return 0, errors.New("the outbox is only available with the mongo store")
+	}
+	c, err := getMongoCollection()
+	if err != nil {
+		return 0, err
+	}
+	o, err := NewMongoOutbox()
+	if err != nil {
+		return 0, err
+	}
+	publisher, err := NewEventPublisher(config.Outbox)
+	if err != nil {
+		return 0, err
+	}
+	return NewOutboxRelay(o, publisher, NewPaymentMongoStore(c), config.Outbox).Replay(from, to)
+}
+
+// OpenSQLStore connects to the configured SQL database, without migrating it
+func OpenSQLStore() (*PaymentSQLStore, error) {
+	if config.DBType != DatabaseTypeSQL {
+		return nil, errors.New("the migrations are only available with the sql store")
+	}
+	return NewPaymentSQLStore(config.SQL.Driver, config.SQL.DSN)
+}
+
+// initSQL opens the SQL store, the API does not start on a schema that is not
+// up to date unless the migrations are applied automatically
+func initSQL() {
+	s, err := OpenSQLStore()
+	if err != nil {
+		logrus.Fatalf("SQL: could not connect: %v", err)
+	}
+	if config.SQL.AutoMigrate {
+		applied, err := s.MigrateUp(0)
+		if err != nil {
+			logrus.Fatalf("SQL: could not migrate: %v", err)
+		}
+		for _, m := range applied {
+			logrus.Infof("SQL: applied migration %d %s", m.Version, m.Name)
+		}
+	}
+	pending, err := s.Pending()
+	if err != nil {
+		logrus.Fatalf("SQL: could not read the migrations: %v", err)
+	}
+	if pending > 0 {
+		logrus.Fatalf("SQL: %d migrations pending, run app db migrate up", pending)
+	}
+	store = s
+	storeCloser = s
+}
+
+// OpenStore connects to the configured store without the event bus, the relay
+// and the webhooks of the server, for the commands working on the data
+// directly. The in memory store is not shared between processes and is
+// rejected.
+func OpenStore() (PaymentStore, error) {
+	switch config.DBType {
+	case DatabaseTypeMongo:
 		c, err := getMongoCollection()
 		if err != nil {
-			attempts += 1
-			logrus.Errorf(
-				"Mongo: could not connect %v (attempt %d on %d)",
-				err, attempts, config.Mongo.MaxRetries,
-			)
-			if attempts >= config.Mongo.MaxRetries {
-				logrus.Fatalf("Mongo: could not reach database afer %d attempts", attempts)
+			return nil, err
+		}
+		s := NewPaymentMongoStore(c)
+		if config.Outbox.Enabled {
+			if s.Outbox, err = NewMongoOutbox(); err != nil {
+				return nil, err
 			}
-		} else {
-			store = NewPaymentMongoStore(c)
-			break
 		}
-		time.Sleep(2 * time.Second)
+		return s, nil
+	case DatabaseTypeBolt:
+		return NewPaymentBoltStore(config.Bolt.Path, config.Bolt.Timeout)
+	case DatabaseTypeSQL:
+		return OpenSQLStore()
+	case DatabaseTypeInMem:
+		return nil, errors.New("the in memory store can only be reached through the server")
 	}
-	logrus.Info("Mongo: ready")
+	return nil, errors.New("unknown or empty database type")
 }
 
 func InitStore() {
 	if config == nil {
 		logrus.Fatal("No configuration found")
 	}
+	initTracing()
+	if err := config.RateLimit.Validate(); err != nil {
+		logrus.Fatalf("Rate limit: invalid settings: %v", err)
+	}
 	switch config.DBType {
 	case DatabaseTypeInMem:
-		logrus.Info("Loading in memory store")
-		store = NewPaymentInMemStore()
+		if !config.InMem.Persist {
+			logrus.Info("Loading in memory store")
+			s := NewPaymentInMemStore()
+			if err := s.AddIndexes(config.InMem.Indexes...); err != nil {
+				logrus.Fatalf("Invalid index of the in memory store: %v", err)
+			}
+			store = s
+			break
+		}
+		logrus.Infof("Loading in memory store persisted in %s", config.InMem.Dir)
+		s, err := NewPaymentInMemWALStore(config.InMem)
+		if err != nil {
+			logrus.Fatalf("Could not recover the in memory store: %v", err)
+		}
+		store = s
+		storeCloser = s
 		break
 	case DatabaseTypeMongo:
 		logrus.Info("Loading MongoDB store")
 		initMongo()
 		break
+	case DatabaseTypeBolt:
+		logrus.Infof("Loading embedded store from %s", config.Bolt.Path)
+		s, err := NewPaymentBoltStore(config.Bolt.Path, config.Bolt.Timeout)
+		if err != nil {
+			logrus.Fatalf("Could not open the embedded store: %v", err)
+		}
+		store = s
+		storeCloser = s
+		break
+	case DatabaseTypeSQL:
+		logrus.Infof("Loading %s store", config.SQL.Driver)
+		initSQL()
+		break
 	default:
 		logrus.Fatal("Unknown or empty database type")
 	}
+	store = NewPaymentMetricsStore(store, string(config.DBType))
+	store = NewPaymentTracingStore(store, string(config.DBType))
+	initCache()
+	bus.SetReplaySize(config.Events.Replay)
+	store = NewPaymentEventStore(store, bus)
+	hooks := NewWebhookInMemStore()
+	hooks.MaxDeliveries = config.Webhooks.MaxDeliveries
+	webhooks = NewWebhookDispatcher(hooks, config.Webhooks)
+	webhooks.Listen(bus)
+}
+
+// initCache puts the read-through cache in front of the store when enabled,
+// under the event store so that the events are published after the
+// invalidations
+func initCache() {
+	cache = nil
+	if !config.Cache.Enabled {
+		return
+	}
+	inv, err := NewCacheInvalidator(config.Cache)
+	if err != nil {
+		logrus.Fatalf("Cache: %v", err)
+	}
+	cache = NewPaymentCacheStore(store, config.Cache, inv)
+	store = cache
+	logrus.Infof("Cache: caching up to %d payments for %s", config.Cache.Size, config.Cache.TTL)
+}
+
+// initTracing starts the tracer when enabled and adds the ids of the traces
+// to the logs
+func initTracing() {
+	tracer = nil
+	if !config.Tracing.Enabled {
+		return
+	}
+	exporter, err := NewSpanExporter(config.Tracing)
+	if err != nil {
+		logrus.Fatalf("Tracing: %v", err)
+	}
+	SetTracer(NewTracer(exporter, config.Tracing))
+	tracer.Start()
+	logrus.AddHook(TraceHook{})
+	logrus.Infof("Tracing: exporting %.0f%% of the traces to %s", config.Tracing.SampleRatio*100, config.Tracing.Exporter)
+}
+
+// SetTracer sets the tracer of the requests, nil disables the tracing
+func SetTracer(t *Tracer) {
+	tracer = t
 }
 
+// SetStore sets the store of the API, a *PaymentCacheStore is served by the
+// cache routes as well
 func SetStore(s PaymentStore) {
 	store = s
+	cache, _ = s.(*PaymentCacheStore)
 }
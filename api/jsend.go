@@ -3,21 +3,26 @@ package api
 import (
 	"net/http"
 
+	"github.com/ganitzsh/f3-te/api/wire"
 	"github.com/go-chi/render"
 )
 
-// The API uses the JSEND scheme to communicate with the clients
+// The API uses the JSEND scheme to communicate with the clients, see
+// wire.Envelope
 
 const (
-	JSENDDataStatusSuccess = "success"
-	JSENDDataStatusFail    = "fail"
-	JSENDDataStatusError   = "error"
+	JSENDDataStatusSuccess = wire.JSENDDataStatusSuccess
+	JSENDDataStatusFail    = wire.JSENDDataStatusFail
+	JSENDDataStatusError   = wire.JSENDDataStatusError
 )
 
+// JSENDData is the body of the responses, TraceID is the id of the trace of
+// the request, only sent with the errors
 type JSENDData struct {
-	Data   interface{} `json:"data"`
-	Code   int         `json:"code"`
-	Status string      `json:"status"`
+	Data    interface{} `json:"data"`
+	Code    int         `json:"code"`
+	Status  string      `json:"status"`
+	TraceID string      `json:"traceId,omitempty"`
 }
 
 func NewJSENDData(data interface{}, code ...int) *JSENDData {
@@ -49,6 +54,7 @@ func (p *JSENDData) Render(w http.ResponseWriter, r *http.Request) error {
 		}
 		p.Code = code
 		p.Status = status
+		p.TraceID = TraceIDFromContext(r.Context())
 		render.Status(r, p.Code)
 		return nil
 	}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The metrics are exposed in the Prometheus text format, see MetricsHandler.
+// The requests are labelled by the pattern of their route rather than their
+// path to keep the amount of series bounded.
+
+const (
+	MetricsNamespace = "payment_api"
+	// RouteUnmatched labels the requests no route matched
+	RouteUnmatched = "unmatched"
+)
+
+var metrics = newAPIMetrics()
+
+type apiMetrics struct {
+	registry *prometheus.Registry
+
+	requests       *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+	inFlight       prometheus.Gauge
+	storeDuration  *prometheus.HistogramVec
+	storeErrors    *prometheus.CounterVec
+	mongoUp        *prometheus.Desc
+	mongoFailures  *prometheus.Desc
+	mongoReconnect *prometheus.Desc
+	breakerOpen    *prometheus.Desc
+	cacheHits      *prometheus.Desc
+	cacheMisses    *prometheus.Desc
+	cacheEntries   *prometheus.Desc
+}
+
+func newAPIMetrics() *apiMetrics {
+	m := &apiMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Amount of HTTP requests served.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: MetricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time spent serving the HTTP requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Name:      "http_requests_in_flight",
+			Help:      "Amount of HTTP requests being served.",
+		}),
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: MetricsNamespace,
+			Name:      "store_operation_duration_seconds",
+			Help:      "Time spent in the operations of the payment store.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"backend", "operation"}),
+		storeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "store_operation_errors_total",
+			Help:      "Amount of failed operations of the payment store, missing payments excluded.",
+		}, []string{"backend", "operation"}),
+		mongoUp: prometheus.NewDesc(MetricsNamespace+"_mongo_healthy",
+			"Whether the connection to MongoDB answered its last check.", nil, nil),
+		mongoFailures: prometheus.NewDesc(MetricsNamespace+"_mongo_failed_attempts_total",
+			"Amount of failed attempts to connect or ping MongoDB.", nil, nil),
+		mongoReconnect: prometheus.NewDesc(MetricsNamespace+"_mongo_reconnects_total",
+			"Amount of times the lost connection to MongoDB was reestablished.", nil, nil),
+		breakerOpen: prometheus.NewDesc(MetricsNamespace+"_mongo_breaker_open",
+			"Whether the circuit breaker of the MongoDB store rejects the operations.", nil, nil),
+		cacheHits: prometheus.NewDesc(MetricsNamespace+"_cache_hits_total",
+			"Amount of reads served by the cache.", nil, nil),
+		cacheMisses: prometheus.NewDesc(MetricsNamespace+"_cache_misses_total",
+			"Amount of reads the cache passed to the store.", nil, nil),
+		cacheEntries: prometheus.NewDesc(MetricsNamespace+"_cache_entries",
+			"Amount of payments and pages cached.", []string{"kind"}, nil),
+	}
+	build := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "build_info",
+		Help:      "Version of the running server, always 1.",
+		ConstLabels: prometheus.Labels{
+			"version":    Version,
+			"release":    ReleaseName,
+			"go_version": runtime.Version(),
+		},
+	})
+	build.Set(1)
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.duration,
+		m.inFlight,
+		m.storeDuration,
+		m.storeErrors,
+		build,
+		m,
+	)
+	return m
+}
+
+// Describe sends nothing, the state metrics only exist when the server has a
+// connection to MongoDB or a cache, which makes the collector unchecked
+func (m *apiMetrics) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect reads the state of the connection to MongoDB and of the cache
+func (m *apiMetrics) Collect(ch chan<- prometheus.Metric) {
+	if s := mongoSupervisor; s != nil {
+		health := s.Health()
+		ch <- prometheus.MustNewConstMetric(m.mongoUp, prometheus.GaugeValue, boolValue(health.State == MongoStateHealthy))
+		ch <- prometheus.MustNewConstMetric(m.mongoFailures, prometheus.CounterValue, float64(health.Failures))
+		ch <- prometheus.MustNewConstMetric(m.mongoReconnect, prometheus.CounterValue, float64(health.Reconnects))
+		if health.Breaker != "" {
+			ch <- prometheus.MustNewConstMetric(m.breakerOpen, prometheus.GaugeValue, boolValue(health.Breaker == BreakerOpen))
+		}
+	}
+	if c := cache; c != nil {
+		stats := c.Stats()
+		ch <- prometheus.MustNewConstMetric(m.cacheHits, prometheus.CounterValue, float64(stats.Hits))
+		ch <- prometheus.MustNewConstMetric(m.cacheMisses, prometheus.CounterValue, float64(stats.Misses))
+		ch <- prometheus.MustNewConstMetric(m.cacheEntries, prometheus.GaugeValue, float64(stats.Payments), "payments")
+		ch <- prometheus.MustNewConstMetric(m.cacheEntries, prometheus.GaugeValue, float64(stats.Lists), "lists")
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// observeStore records the duration of a store operation, and its failure
+// unless the payment was missing
+func (m *apiMetrics) observeStore(backend, operation string, start time.Time, err error) {
+	m.storeDuration.WithLabelValues(backend, operation).Observe(time.Since(start).Seconds())
+	if err != nil && err != ErrNotFound {
+		m.storeErrors.WithLabelValues(backend, operation).Inc()
+	}
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metrics.registry, promhttp.HandlerOpts{})
+}
+
+// instrumentRequests counts the requests and their duration by method, route
+// pattern and status, see servedRoute
+func instrumentRequests(routes chi.Routes) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			metrics.inFlight.Inc()
+			defer metrics.inFlight.Dec()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+			route := servedRoute(routes, r)
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			labels := prometheus.Labels{"method": r.Method, "route": route, "status": strconv.Itoa(status)}
+			metrics.requests.With(labels).Inc()
+			metrics.duration.With(labels).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// serveMetrics serves the metrics on their path ahead of the routes and of
+// their middlewares, unless they are disabled or have their own port
+func serveMetrics(next http.Handler) http.Handler {
+	if config == nil || config.Metrics == nil || !config.Metrics.Enabled || config.Metrics.AdminPort != "" {
+		return next
+	}
+	handler := MetricsHandler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == config.Metrics.Path {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
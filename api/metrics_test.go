@@ -0,0 +1,105 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func scrapeMetrics(t *testing.T, handler http.Handler, path string) string {
+	resp := doHTTPReq(handler, http.MethodGet, path, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	return string(readBody(resp))
+}
+
+// failingStore fails every write
+type failingStore struct {
+	api.PaymentStore
+}
+
+func (s *failingStore) Save(ctx context.Context, p *api.Payment) error {
+	return errors.New("disk full")
+}
+
+// metricValue returns the value of the series in the scraped metrics, 0 when
+// it is missing
+func metricValue(body, series string) float64 {
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, series+" ") {
+			v, _ := strconv.ParseFloat(strings.TrimPrefix(line, series+" "), 64)
+			return v
+		}
+	}
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	config := api.Config().Metrics
+	defer func(settings api.MetricsSettings) { *config = settings }(*config)
+	config.Enabled = true
+	db := newTestDBInMem()
+	api.SetStore(api.NewPaymentMetricsStore(&failingStore{db.Store}, "test"))
+	defer api.SetStore(db.Store)
+	handler := api.Routes()
+	series := []string{
+		`payment_api_http_requests_total{method="GET",route="/v1/payments/{paymentID}",status="200"}`,
+		`payment_api_http_requests_total{method="GET",route="/v1/payments/{paymentID}",status="404"}`,
+		`payment_api_http_request_duration_seconds_count{method="GET",route="/v1/payments/{paymentID}",status="200"}`,
+		`payment_api_http_requests_total{method="POST",route="/v1/payments",status="500"}`,
+		`payment_api_http_requests_total{method="GET",route="unmatched",status="404"}`,
+		`payment_api_store_operation_duration_seconds_count{backend="test",operation="get_by_id"}`,
+		`payment_api_store_operation_errors_total{backend="test",operation="save"}`,
+		`payment_api_store_operation_errors_total{backend="test",operation="get_by_id"}`,
+	}
+	before := scrapeMetrics(t, handler, "/metrics")
+
+	doHTTPReq(handler, http.MethodGet, "/v1/payments/"+db.ID1.String(), "")
+	doHTTPReq(handler, http.MethodGet, "/v1/payments/"+uuid.New().String(), "")
+	doHTTPReq(handler, http.MethodGet, "/unknown", "")
+	b, _ := json.Marshal(newMockPayment())
+	resp := doHTTPReq(handler, http.MethodPost, "/v1/payments", string(b))
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	body := scrapeMetrics(t, handler, "/metrics")
+	// a missing payment is not an error of the store
+	expected := []float64{1, 1, 1, 1, 1, 2, 1, 0}
+	for i, s := range series {
+		assert.Equal(t, expected[i], metricValue(body, s)-metricValue(before, s), s)
+	}
+	assert.Equal(t, 0.0, metricValue(body, "payment_api_http_requests_in_flight"))
+	assert.Equal(t, 1.0, metricValue(body, `payment_api_build_info{go_version="`+runtime.Version()+`",release="`+api.ReleaseName+`",version="`+api.Version+`"}`))
+	// the scrapes are not counted
+	assert.NotContains(t, body, `route="/metrics"`)
+}
+
+func TestMetricsAdminPort(t *testing.T) {
+	config := api.Config().Metrics
+	defer func(settings api.MetricsSettings) { *config = settings }(*config)
+
+	// the metrics are not served unless enabled
+	assert.False(t, config.Enabled)
+	resp := doHTTPReq(api.Routes(), http.MethodGet, "/metrics", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	config.Enabled = true
+	config.AdminPort = "9090"
+	resp = doHTTPReq(api.Routes(), http.MethodGet, "/metrics", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	config.AdminPort = ""
+
+	config.Path = "/internal/metrics"
+	handler := api.Routes()
+	assert.Contains(t, scrapeMetrics(t, handler, "/internal/metrics"), "payment_api_build_info")
+	resp = doHTTPReq(handler, http.MethodGet, "/metrics", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
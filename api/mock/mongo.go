@@ -1,6 +1,9 @@
 package mock
 
 import (
+	"context"
+	"strings"
+
 	"github.com/ganitzsh/f3-te/api"
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
@@ -14,6 +17,7 @@ type PaymentQuery struct {
 	fail  bool
 	limit int
 	skip  int
+	sorts []api.PaymentSort
 	store *api.PaymentInMemStore
 }
 
@@ -26,7 +30,7 @@ func (q *PaymentQuery) Count() (n int, err error) {
 	if val, ok := q.query["scheme"].(string); ok {
 		filters = append(filters, api.PaymentStoreFilterIsScheme(val))
 	}
-	tmp, err := q.store.GetMany(q.limit, q.skip, filters...)
+	tmp, err := q.store.GetMany(context.Background(), q.limit, q.skip, filters...)
 	if err != nil {
 		return 0, err
 	}
@@ -39,7 +43,7 @@ func (q *PaymentQuery) All(results interface{}) error {
 	if val, ok := q.query["scheme"].(string); ok {
 		filters = append(filters, api.PaymentStoreFilterIsScheme(val))
 	}
-	tmp, err := q.store.GetMany(q.limit, q.skip, filters...)
+	tmp, err := q.store.GetManySorted(context.Background(), q.limit, q.skip, q.sorts, filters...)
 	if err != nil {
 		return err
 	}
@@ -50,7 +54,7 @@ func (q *PaymentQuery) All(results interface{}) error {
 }
 
 func (q *PaymentQuery) One(result interface{}) error {
-	tmp, err := q.store.GetByID(q.id)
+	tmp, err := q.store.GetByID(context.Background(), q.id)
 	if err != nil {
 		if err == api.ErrNotFound {
 			return mgo.ErrNotFound
@@ -71,6 +75,24 @@ func (q *PaymentQuery) Skip(n int) api.MongoQuery {
 	return q
 }
 
+// Sort reads the keys of the documents back into the sorts of the store, the
+// ids are left out since the store sorts stably
+func (q *PaymentQuery) Sort(fields ...string) api.MongoQuery {
+	for _, f := range fields {
+		s := api.PaymentSort{Field: strings.TrimPrefix(f, "-"), Desc: strings.HasPrefix(f, "-")}
+		switch s.Field {
+		case "_id":
+			continue
+		case strings.ToLower(api.PaymentSortCreatedAt):
+			s.Field = api.PaymentSortCreatedAt
+		case strings.ToLower(api.PaymentSortUpdatedAt):
+			s.Field = api.PaymentSortUpdatedAt
+		}
+		q.sorts = append(q.sorts, s)
+	}
+	return q
+}
+
 type PaymentCollection struct {
 	*mgo.Collection
 	Data *api.PaymentInMemStore
@@ -89,8 +111,16 @@ func (c *PaymentCollection) FindId(id interface{}) api.MongoQuery {
 	}
 }
 
-func (c *PaymentCollection) UpsertID(id interface{}, doc interface{}) (*mgo.ChangeInfo, error) {
-	return nil, c.Data.Save(doc.(*api.Payment))
+// UpsertId reports the id of the document when it is created, like MongoDB
+func (c *PaymentCollection) UpsertId(id interface{}, doc interface{}) (*mgo.ChangeInfo, error) {
+	info := &mgo.ChangeInfo{Matched: 1, Updated: 1}
+	if _, err := c.Data.GetByID(context.Background(), id.(uuid.UUID)); err == api.ErrNotFound {
+		info = &mgo.ChangeInfo{UpsertedId: id}
+	}
+	if err := c.Data.Save(context.Background(), doc.(*api.Payment)); err != nil {
+		return nil, err
+	}
+	return info, nil
 }
 
 func (c *PaymentCollection) Find(query interface{}) api.MongoQuery {
@@ -101,9 +131,9 @@ func (c *PaymentCollection) Find(query interface{}) api.MongoQuery {
 }
 
 func (c *PaymentCollection) RemoveId(id interface{}) error {
-	return c.Data.Delete(id.(uuid.UUID))
+	return c.Data.Delete(context.Background(), id.(uuid.UUID))
 }
 
 func (c *PaymentCollection) Count() (int, error) {
-	return c.Data.Total(), nil
+	return c.Data.Total(context.Background()), nil
 }
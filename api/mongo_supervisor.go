@@ -0,0 +1,259 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The connection to MongoDB is supervised instead of ending the process when
+// the database is lost: the supervisor dials until it succeeds, then checks
+// the connection and retries the failed checks with a backoff. The API
+// answers 503 while the connection is not healthy.
+
+const (
+	MongoStateConnecting = "connecting"
+	MongoStateHealthy    = "healthy"
+	MongoStateUnhealthy  = "unhealthy"
+)
+
+// MongoConn interfaces the part of *mgo.Session the supervisor checks
+type MongoConn interface {
+	Ping() error
+	// Refresh drops the sockets of the session, mgo reconnects on the next
+	// operation
+	Refresh()
+	Close()
+}
+
+// MongoHealth is the state of the connection, Attempts is the amount of
+// failed attempts since it was last healthy. Failures and Reconnects count
+// the failed attempts and the recoveries of a lost connection since the
+// supervisor started. Breaker is the state of the breaker of the store.
+type MongoHealth struct {
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"`
+	Attempts   int       `json:"attempts"`
+	Failures   int64     `json:"failures"`
+	Reconnects int64     `json:"reconnects"`
+	LastError  string    `json:"lastError,omitempty"`
+	Breaker    string    `json:"breaker,omitempty"`
+}
+
+// MongoSupervisor keeps the connection opened by Dial healthy. Breaker is
+// the optional breaker of the operations on the connection, reported with
+// its health. OnConnect, when set, prepares the first connection before it
+// is reported healthy: its failure fails the attempt.
+type MongoSupervisor struct {
+	Settings  *MongoReconnect
+	Dial      func() (MongoConn, error)
+	Breaker   *CircuitBreaker
+	OnConnect func(conn MongoConn) error
+
+	mu       sync.RWMutex
+	conn     MongoConn
+	health   MongoHealth
+	watchers map[chan MongoHealth]bool
+
+	connected chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewMongoSupervisor(settings *MongoReconnect, dial func() (MongoConn, error)) *MongoSupervisor {
+	return &MongoSupervisor{
+		Settings:  settings,
+		Dial:      dial,
+		health:    MongoHealth{State: MongoStateConnecting, Since: time.Now()},
+		watchers:  map[chan MongoHealth]bool{},
+		connected: make(chan struct{}),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start supervises the connection in a goroutine until Stop is called
+func (s *MongoSupervisor) Start() {
+	go s.run()
+}
+
+// Stop stops the supervision and closes the connection
+func (s *MongoSupervisor) Stop() {
+	close(s.stop)
+	<-s.done
+	if conn := s.Conn(); conn != nil {
+		conn.Close()
+	}
+}
+
+// Connected is closed once the first connection is opened
+func (s *MongoSupervisor) Connected() <-chan struct{} {
+	return s.connected
+}
+
+// Conn returns the connection, nil until it is opened
+func (s *MongoSupervisor) Conn() MongoConn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.conn
+}
+
+// Health returns the current state of the connection
+func (s *MongoSupervisor) Health() MongoHealth {
+	s.mu.RLock()
+	health := s.health
+	s.mu.RUnlock()
+	if s.Breaker != nil {
+		health.Breaker = s.Breaker.State()
+	}
+	return health
+}
+
+// Healthy tells whether the connection answered its last check
+func (s *MongoSupervisor) Healthy() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.health.State == MongoStateHealthy
+}
+
+// attempts returns the amount of failed attempts
+func (s *MongoSupervisor) attempts() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.health.Attempts
+}
+
+// Watch returns a channel receiving the health of the connection each time
+// its state changes, a watcher too slow to receive them only gets the last
+// one. The returned function stops the watch.
+func (s *MongoSupervisor) Watch() (<-chan MongoHealth, func()) {
+	ch := make(chan MongoHealth, 1)
+	s.mu.Lock()
+	s.watchers[ch] = true
+	s.mu.Unlock()
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.watchers, ch)
+		s.mu.Unlock()
+	}
+}
+
+// setHealth records the outcome of an attempt, a nil err is a success
+func (s *MongoSupervisor) setHealth(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.health
+	if err == nil {
+		s.health = MongoHealth{
+			State:      MongoStateHealthy,
+			Since:      prev.Since,
+			Failures:   prev.Failures,
+			Reconnects: prev.Reconnects,
+		}
+		if prev.State == MongoStateUnhealthy {
+			s.health.Reconnects++
+		}
+	} else {
+		state := MongoStateUnhealthy
+		if s.conn == nil {
+			state = MongoStateConnecting
+		}
+		s.health = MongoHealth{
+			State:      state,
+			Since:      prev.Since,
+			Attempts:   prev.Attempts + 1,
+			Failures:   prev.Failures + 1,
+			Reconnects: prev.Reconnects,
+			LastError:  err.Error(),
+		}
+	}
+	if s.health.State == prev.State {
+		return
+	}
+	s.health.Since = time.Now()
+	for ch := range s.watchers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- s.health
+	}
+}
+
+// wait waits for d, it returns false when the supervisor is stopped first
+func (s *MongoSupervisor) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.stop:
+		return false
+	}
+}
+
+func (s *MongoSupervisor) run() {
+	defer close(s.done)
+	if !s.connect() {
+		return
+	}
+	for s.wait(s.Settings.Interval) {
+		if !s.check() {
+			return
+		}
+	}
+}
+
+// connect dials until it succeeds, it returns false when the supervisor is
+// stopped first
+func (s *MongoSupervisor) connect() bool {
+	for {
+		conn, err := s.Dial()
+		if err == nil && s.OnConnect != nil {
+			if err = s.OnConnect(conn); err != nil {
+				conn.Close()
+			}
+		}
+		if err == nil {
+			s.mu.Lock()
+			s.conn = conn
+			s.mu.Unlock()
+			s.setHealth(nil)
+			close(s.connected)
+			logrus.Info("Mongo: connected")
+			return true
+		}
+		s.setHealth(err)
+		attempts := s.attempts()
+		delay := s.Settings.Backoff(attempts)
+		logrus.Errorf("Mongo: could not connect: %v (attempt %d, retrying in %s)", err, attempts, delay)
+		if !s.wait(delay) {
+			return false
+		}
+	}
+}
+
+// check pings the connection until it answers, it returns false when the
+// supervisor is stopped first
+func (s *MongoSupervisor) check() bool {
+	conn := s.Conn()
+	for {
+		err := conn.Ping()
+		if err == nil {
+			if !s.Healthy() {
+				logrus.Info("Mongo: connection reestablished")
+			}
+			s.setHealth(nil)
+			return true
+		}
+		s.setHealth(err)
+		conn.Refresh()
+		attempts := s.attempts()
+		delay := s.Settings.Backoff(attempts)
+		logrus.Errorf("Mongo: could not ping database: %v (attempt %d, retrying in %s)", err, attempts, delay)
+		if !s.wait(delay) {
+			return false
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package api_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := api.NewCircuitBreaker("test", 2, 20*time.Millisecond)
+	assert.True(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, api.BreakerClosed, b.State())
+	b.Success()
+	b.Failure()
+	assert.Equal(t, api.BreakerClosed, b.State())
+	b.Failure()
+	assert.Equal(t, api.BreakerOpen, b.State())
+	assert.False(t, b.Allow())
+
+	// a single probe goes through after the cooldown
+	time.Sleep(25 * time.Millisecond)
+	assert.True(t, b.Allow())
+	assert.Equal(t, api.BreakerHalfOpen, b.State())
+	assert.False(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, api.BreakerOpen, b.State())
+	assert.False(t, b.Allow())
+
+	time.Sleep(25 * time.Millisecond)
+	assert.True(t, b.Allow())
+	b.Success()
+	assert.Equal(t, api.BreakerClosed, b.State())
+	assert.True(t, b.Allow())
+
+	// disabled
+	b = api.NewCircuitBreaker("test", 0, time.Second)
+	for i := 0; i < 10; i++ {
+		b.Failure()
+	}
+	assert.True(t, b.Allow())
+	assert.Equal(t, api.BreakerClosed, b.State())
+}
+
+func TestMongoReconnectBackoff(t *testing.T) {
+	s := &api.MongoReconnect{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	expected := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, d := range expected {
+		assert.Equal(t, d*time.Millisecond, s.Backoff(i+1))
+	}
+
+	s.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := s.Backoff(3)
+		assert.True(t, d > 200*time.Millisecond && d <= 400*time.Millisecond, d)
+	}
+}
+
+// fakeConn is a connection whose pings fail while err is set
+type fakeConn struct {
+	mu        sync.Mutex
+	err       error
+	refreshed int
+	closed    bool
+}
+
+func (c *fakeConn) Ping() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *fakeConn) Refresh() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshed++
+}
+
+func (c *fakeConn) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+}
+
+func (c *fakeConn) set(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+func waitHealth(t *testing.T, ch <-chan api.MongoHealth, state string) api.MongoHealth {
+	select {
+	case h := <-ch:
+		assert.Equal(t, state, h.State)
+		return h
+	case <-time.After(time.Second):
+		t.Fatalf("the connection did not become %s", state)
+	}
+	return api.MongoHealth{}
+}
+
+func TestMongoSupervisor(t *testing.T) {
+	conn := &fakeConn{}
+	dials := 0
+	s := api.NewMongoSupervisor(&api.MongoReconnect{
+		Interval:   time.Millisecond,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+		Jitter:     0.2,
+	}, func() (api.MongoConn, error) {
+		if dials++; dials < 3 {
+			return nil, errors.New("no reachable servers")
+		}
+		return conn, nil
+	})
+	s.Breaker = api.NewCircuitBreaker("test", 1, time.Hour)
+	health, stop := s.Watch()
+	defer stop()
+	assert.Equal(t, api.MongoStateConnecting, s.Health().State)
+	assert.Nil(t, s.Conn())
+
+	s.Start()
+	<-s.Connected()
+	waitHealth(t, health, api.MongoStateHealthy)
+	assert.Equal(t, 3, dials)
+	assert.Equal(t, conn, s.Conn())
+	assert.Equal(t, 0, s.Health().Attempts)
+	assert.Equal(t, api.BreakerClosed, s.Health().Breaker)
+
+	// the lost connection is refreshed until it answers again
+	conn.set(errors.New("connection reset"))
+	h := waitHealth(t, health, api.MongoStateUnhealthy)
+	assert.Equal(t, "connection reset", h.LastError)
+	assert.False(t, s.Healthy())
+	conn.set(nil)
+	h = waitHealth(t, health, api.MongoStateHealthy)
+	assert.True(t, s.Healthy())
+	assert.Equal(t, int64(1), h.Reconnects)
+	assert.True(t, h.Failures >= 3)
+	conn.mu.Lock()
+	assert.True(t, conn.refreshed > 0)
+	conn.mu.Unlock()
+
+	s.Stop()
+	assert.True(t, conn.closed)
+}
+
+func TestMongoSupervisorStop(t *testing.T) {
+	s := api.NewMongoSupervisor(&api.MongoReconnect{
+		Interval:   time.Millisecond,
+		MinBackoff: time.Hour,
+		MaxBackoff: time.Hour,
+	}, func() (api.MongoConn, error) {
+		return nil, errors.New("no reachable servers")
+	})
+	s.Start()
+	// stopping does not wait for the backoff
+	done := make(chan bool)
+	go func() {
+		s.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the supervisor did not stop")
+	}
+	assert.Equal(t, api.MongoStateConnecting, s.Health().State)
+	assert.Equal(t, 1, s.Health().Attempts)
+	assert.Nil(t, s.Conn())
+}
+
+func TestMongoSupervisorOnConnect(t *testing.T) {
+	conns := []*fakeConn{}
+	s := api.NewMongoSupervisor(&api.MongoReconnect{
+		Interval:   time.Hour,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+	}, func() (api.MongoConn, error) {
+		conns = append(conns, &fakeConn{})
+		return conns[len(conns)-1], nil
+	})
+	// the first connection could not be prepared, it is closed and dialed again
+	s.OnConnect = func(conn api.MongoConn) error {
+		if len(conns) < 2 {
+			return errors.New("could not create index")
+		}
+		assert.False(t, s.Healthy())
+		return nil
+	}
+	s.Start()
+	defer s.Stop()
+	select {
+	case <-s.Connected():
+	case <-time.After(time.Second):
+		t.Fatal("the supervisor did not connect")
+	}
+	assert.True(t, s.Healthy())
+	if assert.Len(t, conns, 2) {
+		assert.True(t, conns[0].closed)
+		assert.Equal(t, conns[1], s.Conn())
+	}
+	assert.Equal(t, int64(1), s.Health().Failures)
+}
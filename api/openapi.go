@@ -0,0 +1,372 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// OpenAPI is an OpenAPI 3.1 document, only the parts used by the API are
+// defined
+type OpenAPI struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       *OpenAPIInfo               `json:"info"`
+	Paths      map[string]OpenAPIPathItem `json:"paths"`
+	Components *OpenAPIComponents         `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// OpenAPIPathItem holds the operations of a path by lowercase method
+type OpenAPIPathItem map[string]*OpenAPIOperation
+
+type OpenAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []*OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+}
+
+type OpenAPIParameter struct {
+	Name        string      `json:"name"`
+	In          string      `json:"in"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Schema      *JSONSchema `json:"schema"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *JSONSchema `json:"schema"`
+}
+
+type OpenAPIComponents struct {
+	Schemas map[string]*JSONSchema `json:"schemas"`
+}
+
+// JSONSchema is the subset of JSON Schema used to describe the payloads
+type JSONSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 JSONSchemaTypes        `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	AnyOf                []*JSONSchema          `json:"anyOf,omitempty"`
+}
+
+// JSONSchemaTypes is encoded as a single type or as a list of types
+type JSONSchemaTypes []string
+
+func (t JSONSchemaTypes) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *JSONSchemaTypes) UnmarshalJSON(b []byte) error {
+	var typ string
+	if err := json.Unmarshal(b, &typ); err == nil {
+		*t = JSONSchemaTypes{typ}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(t))
+}
+
+// schemaRef returns the reference to a schema of the components
+func schemaRef(name string) *JSONSchema {
+	return &JSONSchema{Ref: "#/components/schemas/" + name}
+}
+
+// nullable returns a schema also accepting null
+func nullable(s *JSONSchema) *JSONSchema {
+	if s.Ref != "" || len(s.Type) == 0 {
+		return &JSONSchema{AnyOf: []*JSONSchema{s, {Type: JSONSchemaTypes{"null"}}}}
+	}
+	s.Type = append(s.Type, "null")
+	return s
+}
+
+// schemaEnums lists the values of the string types with known values
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(PaymentEventType("")): {
+		string(PaymentEventCreated),
+		string(PaymentEventUpdated),
+		string(PaymentEventDeleted),
+	},
+	reflect.TypeOf(WebhookDeliveryStatus("")): {
+		string(WebhookDeliveryPending),
+		string(WebhookDeliverySucceeded),
+		string(WebhookDeliveryDead),
+	},
+}
+
+var (
+	typeTime       = reflect.TypeOf(time.Time{})
+	typeUUID       = reflect.TypeOf(uuid.UUID{})
+	typeRawMessage = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaGenerator builds the schemas of the Go types from their JSON
+// encoding. Named structs are added to the components, the request bodies use
+// an "Input" variant where no property is required.
+type schemaGenerator struct {
+	schemas map[string]*JSONSchema
+}
+
+func (g *schemaGenerator) schema(t reflect.Type, input bool) *JSONSchema {
+	switch t {
+	case typeTime:
+		return &JSONSchema{Type: JSONSchemaTypes{"string"}, Format: "date-time"}
+	case typeUUID:
+		return &JSONSchema{Type: JSONSchemaTypes{"string"}, Format: "uuid"}
+	case typeRawMessage:
+		return &JSONSchema{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return nullable(g.schema(t.Elem(), input))
+	case reflect.String:
+		return &JSONSchema{Type: JSONSchemaTypes{"string"}, Enum: schemaEnums[t]}
+	case reflect.Bool:
+		return &JSONSchema{Type: JSONSchemaTypes{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: JSONSchemaTypes{"integer"}}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: JSONSchemaTypes{"number"}}
+	case reflect.Slice, reflect.Array:
+		return nullable(&JSONSchema{Type: JSONSchemaTypes{"array"}, Items: g.schema(t.Elem(), input)})
+	case reflect.Map:
+		return nullable(&JSONSchema{Type: JSONSchemaTypes{"object"}, AdditionalProperties: g.schema(t.Elem(), input)})
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t, input)
+		}
+		name := t.Name()
+		if input {
+			name += "Input"
+		}
+		if _, ok := g.schemas[name]; !ok {
+			g.schemas[name] = nil
+			g.schemas[name] = g.object(t, input)
+		}
+		return schemaRef(name)
+	}
+	return &JSONSchema{}
+}
+
+// object builds the schema of a struct, the properties that are always
+// encoded are required in the responses
+func (g *schemaGenerator) object(t reflect.Type, input bool) *JSONSchema {
+	ret := &JSONSchema{
+		Type:       JSONSchemaTypes{"object"},
+		Properties: map[string]*JSONSchema{},
+	}
+	g.fields(ret, t, input)
+	sort.Strings(ret.Required)
+	return ret
+}
+
+func (g *schemaGenerator) fields(s *JSONSchema, t reflect.Type, input bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		if f.PkgPath != "" || tag[0] == "-" {
+			continue
+		}
+		ft := f.Type
+		if f.Anonymous && tag[0] == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			g.fields(s, ft, input)
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = f.Name
+		}
+		omitempty := false
+		for _, opt := range tag[1:] {
+			omitempty = omitempty || opt == "omitempty"
+		}
+		s.Properties[name] = g.schema(ft, input)
+		if !input && !omitempty {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// jsendSchema is the schema of a successful JSend response holding data
+func jsendSchema(data *JSONSchema) *JSONSchema {
+	return &JSONSchema{
+		Type: JSONSchemaTypes{"object"},
+		Properties: map[string]*JSONSchema{
+			ReqDataKey: data,
+			ReqCodeKey: {Type: JSONSchemaTypes{"integer"}},
+			"status":   {Type: JSONSchemaTypes{"string"}, Enum: []string{JSENDDataStatusSuccess}},
+		},
+		Required: []string{ReqCodeKey, ReqDataKey, "status"},
+	}
+}
+
+// pageSchema adds the schema of a page of items to the components
+func (g *schemaGenerator) pageSchema(name string, item reflect.Type) *JSONSchema {
+	if _, ok := g.schemas[name]; !ok {
+		integer := &JSONSchema{Type: JSONSchemaTypes{"integer"}}
+		g.schemas[name] = &JSONSchema{
+			Type: JSONSchemaTypes{"object"},
+			Properties: map[string]*JSONSchema{
+				"total":    integer,
+				"subTotal": integer,
+				"results":  {Type: JSONSchemaTypes{"array"}, Items: g.schema(item, false)},
+			},
+			Required: []string{"results", "subTotal", "total"},
+		}
+	}
+	return schemaRef(name)
+}
+
+// errorSchema adds the schema of the JSend error responses to the components
+func (g *schemaGenerator) errorSchema() *JSONSchema {
+	if _, ok := g.schemas["Error"]; !ok {
+		g.schemas["Error"] = &JSONSchema{
+			Type: JSONSchemaTypes{"object"},
+			Properties: map[string]*JSONSchema{
+				ReqDataKey: g.schema(reflect.TypeOf(APIError{}), false),
+				ReqCodeKey: {Type: JSONSchemaTypes{"integer"}},
+				"status": {
+					Type: JSONSchemaTypes{"string"},
+					Enum: []string{JSENDDataStatusFail, JSENDDataStatusError},
+				},
+				"traceId": {Type: JSONSchemaTypes{"string"}},
+			},
+			Required: []string{ReqCodeKey, ReqDataKey, "status"},
+		}
+	}
+	return schemaRef("Error")
+}
+
+func jsonContent(s *JSONSchema) map[string]*OpenAPIMediaType {
+	return map[string]*OpenAPIMediaType{"application/json": {Schema: s}}
+}
+
+var pathParamRegexp = regexp.MustCompile(`\{([^}]+)\}`)
+
+// NewOpenAPI builds the OpenAPI document of the routes. Every route found in
+// the router is documented, with the details of openAPIOperations when it has
+// some and with the default error response only otherwise.
+func NewOpenAPI(routes chi.Routes) (*OpenAPI, error) {
+	doc := &OpenAPI{
+		OpenAPI: "3.1.0",
+		Info: &OpenAPIInfo{
+			Title:       DefaultNodeName,
+			Description: "Payment API, the responses follow the JSend format.",
+			Version:     Version,
+		},
+		Paths:      map[string]OpenAPIPathItem{},
+		Components: &OpenAPIComponents{Schemas: map[string]*JSONSchema{}},
+	}
+	g := &schemaGenerator{schemas: doc.Components.Schemas}
+	operations := openAPIOperations(g)
+	errResponse := &OpenAPIResponse{
+		Description: "Error, the data holds its code",
+		Content:     jsonContent(g.errorSchema()),
+	}
+	err := chi.Walk(routes, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		// Mounted routers appear as /* segments
+		path := strings.Replace(route, "/*/", "/", -1)
+		path = strings.TrimSuffix(strings.TrimSuffix(path, "/*"), "/")
+		if path == "" {
+			path = "/"
+		}
+		op, ok := operations[method+" "+path]
+		if !ok {
+			op = &OpenAPIOperation{Summary: method + " " + path, Responses: map[string]*OpenAPIResponse{}}
+		}
+		op.Responses["default"] = errResponse
+		params := []*OpenAPIParameter{}
+		for _, m := range pathParamRegexp.FindAllStringSubmatch(path, -1) {
+			params = append(params, &OpenAPIParameter{
+				Name:     m[1],
+				In:       "path",
+				Required: true,
+				Schema:   &JSONSchema{Type: JSONSchemaTypes{"string"}, Format: "uuid"},
+			})
+		}
+		op.Parameters = append(params, op.Parameters...)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = OpenAPIPathItem{}
+		}
+		doc.Paths[path][strings.ToLower(method)] = op
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// openAPIHandler serves the OpenAPI document of the routes, it is built on
+// the first request
+func openAPIHandler(routes chi.Routes) http.HandlerFunc {
+	var (
+		once sync.Once
+		body []byte
+		err  error
+	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			var doc *OpenAPI
+			if doc, err = NewOpenAPI(routes); err == nil {
+				body, err = json.MarshalIndent(doc, "", "  ")
+			}
+		})
+		if err != nil {
+			handleError(w, r, ErrSomethingWentWrong(err))
+			return
+		}
+		w.Header().Set(HeaderContentType, ContentTypeJSON)
+		w.Write(body)
+	}
+}
+
+//go:embed docs.html
+var docsPage []byte
+
+// Docs serves the documentation page, it renders the OpenAPI document
+func Docs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(HeaderContentType, "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+func queryParam(name, description string, schema *JSONSchema) *OpenAPIParameter {
+	return &OpenAPIParameter{
+		Name:        name,
+		In:          "query",
+		Description: description,
+		Schema:      schema,
+	}
+}
+
+func positiveInteger() *JSONSchema {
+	zero := 0.0
+	return &JSONSchema{Type: JSONSchemaTypes{"integer"}, Minimum: &zero}
+}
+
+// paginationParams are the query parameters read by readLimOff
+func paginationParams() []*OpenAPIParameter {
+	return []*OpenAPIParameter{
+		queryParam("lim", "Maximum amount of results, all of them when 0", positiveInteger()),
+		queryParam("off", "Amount of results to skip", positiveInteger()),
+		queryParam("page", "Page to return, sets off to page * lim", positiveInteger()),
+	}
+}
+
+// paymentFilterParams are the query parameters read by readFilters
+func paymentFilterParams() []*OpenAPIParameter {
+	names := []string{}
+	for name := range PaymentFilterFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ret := []*OpenAPIParameter{}
+	for _, name := range names {
+		ret = append(ret, queryParam(name, "Comma separated values, matches any of them",
+			&JSONSchema{Type: JSONSchemaTypes{"string"}}))
+	}
+	return ret
+}
+
+// openAPIOperations documents the routes of the API by method and path
+func openAPIOperations(g *schemaGenerator) map[string]*OpenAPIOperation {
+	payment := jsonContent(jsendSchema(g.schema(reflect.TypeOf(Payment{}), false)))
+	paymentBody := &OpenAPIRequestBody{
+		Required: true,
+		Content:  jsonContent(g.schema(reflect.TypeOf(Payment{}), true)),
+	}
+	webhook := jsonContent(jsendSchema(g.schema(reflect.TypeOf(Webhook{}), false)))
+	webhookBody := &OpenAPIRequestBody{
+		Required: true,
+		Content:  jsonContent(g.schema(reflect.TypeOf(Webhook{}), true)),
+	}
+	delivery := jsonContent(jsendSchema(g.schema(reflect.TypeOf(WebhookDelivery{}), false)))
+	graphqlResult := jsonContent(&JSONSchema{
+		Type: JSONSchemaTypes{"object"},
+		Properties: map[string]*JSONSchema{
+			"data":   {},
+			"errors": {Type: JSONSchemaTypes{"array"}, Items: &JSONSchema{Type: JSONSchemaTypes{"object"}}},
+		},
+	})
+	graphqlResponses := func() map[string]*OpenAPIResponse {
+		return map[string]*OpenAPIResponse{
+			"200": {Description: "The GraphQL result", Content: graphqlResult},
+			"400": {Description: "The query could not be executed, the errors hold their code", Content: graphqlResult},
+		}
+	}
+	responses := func(code int, description string, content map[string]*OpenAPIMediaType) map[string]*OpenAPIResponse {
+		return map[string]*OpenAPIResponse{
+			strconv.Itoa(code): {Description: description, Content: content},
+		}
+	}
+	savePayment := func(id, summary string) *OpenAPIOperation {
+		return &OpenAPIOperation{
+			OperationID: id,
+			Summary:     summary,
+			Tags:        []string{"payments"},
+			RequestBody: paymentBody,
+			Responses:   responses(http.StatusOK, "The payment saved", payment),
+		}
+	}
+	saveWebhook := func(id, summary string) *OpenAPIOperation {
+		return &OpenAPIOperation{
+			OperationID: id,
+			Summary:     summary,
+			Description: "The response holds the secret signing the deliveries, it is generated when none is given.",
+			Tags:        []string{"webhooks"},
+			RequestBody: webhookBody,
+			Responses:   responses(http.StatusOK, "The webhook saved", webhook),
+		}
+	}
+	createWebhook := saveWebhook("createWebhook", "Creates a webhook")
+	createWebhook.Responses = responses(http.StatusCreated, "The webhook created", webhook)
+	updateWebhook := func(id, summary string) *OpenAPIOperation {
+		op := saveWebhook(id, summary)
+		op.Description = "The response holds the secret signing the deliveries when the request gives one, the current secret is kept and redacted otherwise."
+		return op
+	}
+
+	return map[string]*OpenAPIOperation{
+		"GET /v1/ping": {
+			OperationID: "ping",
+			Summary:     "Checks that the API is up",
+			Responses:   responses(http.StatusNoContent, "The API is up", nil),
+		},
+		"GET /v1/openapi.json": {
+			OperationID: "getOpenAPI",
+			Summary:     "Returns this document",
+			Responses: responses(http.StatusOK, "The OpenAPI document",
+				jsonContent(&JSONSchema{Type: JSONSchemaTypes{"object"}})),
+		},
+		"GET /v1/docs": {
+			OperationID: "getDocs",
+			Summary:     "Renders this document",
+			Responses: responses(http.StatusOK, "The documentation page", map[string]*OpenAPIMediaType{
+				"text/html": {Schema: &JSONSchema{Type: JSONSchemaTypes{"string"}}},
+			}),
+		},
+		"GET /v1/payments": {
+			OperationID: "listPayments",
+			Summary:     "Lists the payments with pagination",
+			Description: "Total is the amount of payments matching the filters.",
+			Tags:        []string{"payments"},
+			Parameters:  append(paginationParams(), paymentFilterParams()...),
+			Responses: responses(http.StatusOK, "A page of payments",
+				jsonContent(jsendSchema(g.pageSchema("PaymentList", reflect.TypeOf(Payment{}))))),
+		},
+		"POST /v1/payments": {
+			OperationID: "createPayment",
+			Summary:     "Creates a payment",
+			Tags:        []string{"payments"},
+			RequestBody: paymentBody,
+			Responses:   responses(http.StatusCreated, "The payment created", payment),
+		},
+		"GET /v1/payments/events": {
+			OperationID: "streamPayments",
+			Summary:     "Streams the payment events as Server-Sent Events",
+			Description: "Every event has an id, the stream resumes after the Last-Event-ID header.",
+			Tags:        []string{"payments"},
+			Parameters: append([]*OpenAPIParameter{
+				queryParam("lastEventId", "Resumes after this event, like the Last-Event-ID header", positiveInteger()),
+			}, paymentFilterParams()...),
+			Responses: responses(http.StatusOK, "The events stream", map[string]*OpenAPIMediaType{
+				ContentTypeEventStream: {Schema: &JSONSchema{Type: JSONSchemaTypes{"string"}}},
+			}),
+		},
+		"GET /v1/payments/{paymentID}": {
+			OperationID: "getPayment",
+			Summary:     "Retrieves a payment",
+			Tags:        []string{"payments"},
+			Responses:   responses(http.StatusOK, "The payment", payment),
+		},
+		"PUT /v1/payments/{paymentID}":  savePayment("updatePayment", "Updates a payment"),
+		"POST /v1/payments/{paymentID}": savePayment("updatePaymentPost", "Updates a payment, same as PUT"),
+		"DELETE /v1/payments/{paymentID}": {
+			OperationID: "deletePayment",
+			Summary:     "Deletes a payment",
+			Tags:        []string{"payments"},
+			Responses:   responses(http.StatusNoContent, "The payment was deleted", nil),
+		},
+		"GET /v1/debug/mongo": {
+			OperationID: "debugMongo",
+			Summary:     "Shows the effective MongoDB settings, without their secrets",
+			Description: "Only available when debug.enabled is set.",
+			Tags:        []string{"debug"},
+			Responses: responses(http.StatusOK, "The MongoDB settings",
+				jsonContent(jsendSchema(g.schema(reflect.TypeOf(MongoSettings{}), false)))),
+		},
+		"GET /v1/debug/mongo/health": {
+			OperationID: "debugMongoHealth",
+			Summary:     "Shows the health of the connection to MongoDB and the state of the store breaker",
+			Description: "Only available when debug.enabled is set and the server uses the mongo store.",
+			Tags:        []string{"debug"},
+			Responses: responses(http.StatusOK, "The health of the connection",
+				jsonContent(jsendSchema(g.schema(reflect.TypeOf(MongoHealth{}), false)))),
+		},
+		"GET /v1/cache/stats": {
+			OperationID: "getCacheStats",
+			Summary:     "Shows the statistics of the cache of the payments",
+			Description: "Only available when cache.enabled is set.",
+			Tags:        []string{"cache"},
+			Responses: responses(http.StatusOK, "The statistics of the cache",
+				jsonContent(jsendSchema(g.schema(reflect.TypeOf(CacheStats{}), false)))),
+		},
+		"POST /v1/cache/invalidations": {
+			OperationID: "invalidateCache",
+			Summary:     "Drops payments from the cache, sent by the other nodes",
+			Description: "Every payment is dropped when ids is empty, the cached pages always are. " +
+				"Only available when the cache invalidations are shared over HTTP.",
+			Tags: []string{"cache"},
+			RequestBody: &OpenAPIRequestBody{
+				Required: true,
+				Content:  jsonContent(g.schema(reflect.TypeOf(CacheInvalidation{}), true)),
+			},
+			Responses: responses(http.StatusNoContent, "The payments were dropped", nil),
+		},
+		"GET /v1/webhooks": {
+			OperationID: "listWebhooks",
+			Summary:     "Lists the webhooks with pagination, without their secrets",
+			Tags:        []string{"webhooks"},
+			Parameters:  paginationParams(),
+			Responses: responses(http.StatusOK, "A page of webhooks",
+				jsonContent(jsendSchema(g.pageSchema("WebhookList", reflect.TypeOf(Webhook{}))))),
+		},
+		"POST /v1/webhooks": createWebhook,
+		"GET /v1/webhooks/{webhookID}": {
+			OperationID: "getWebhook",
+			Summary:     "Retrieves a webhook, without its secret",
+			Tags:        []string{"webhooks"},
+			Responses:   responses(http.StatusOK, "The webhook", webhook),
+		},
+		"PUT /v1/webhooks/{webhookID}":  updateWebhook("updateWebhook", "Updates a webhook"),
+		"POST /v1/webhooks/{webhookID}": updateWebhook("updateWebhookPost", "Updates a webhook, same as PUT"),
+		"DELETE /v1/webhooks/{webhookID}": {
+			OperationID: "deleteWebhook",
+			Summary:     "Deletes a webhook and its deliveries",
+			Tags:        []string{"webhooks"},
+			Responses:   responses(http.StatusNoContent, "The webhook was deleted", nil),
+		},
+		"GET /v1/webhooks/{webhookID}/deliveries": {
+			OperationID: "listWebhookDeliveries",
+			Summary:     "Lists the deliveries of a webhook, most recent first",
+			Tags:        []string{"webhooks"},
+			Parameters:  paginationParams(),
+			Responses: responses(http.StatusOK, "A page of deliveries",
+				jsonContent(jsendSchema(g.pageSchema("WebhookDeliveryList", reflect.TypeOf(WebhookDelivery{}))))),
+		},
+		"GET /v1/webhooks/{webhookID}/deliveries/{deliveryID}": {
+			OperationID: "getWebhookDelivery",
+			Summary:     "Retrieves a delivery",
+			Tags:        []string{"webhooks"},
+			Responses:   responses(http.StatusOK, "The delivery", delivery),
+		},
+		"POST /v1/webhooks/{webhookID}/deliveries/{deliveryID}/redeliver": {
+			OperationID: "redeliverWebhook",
+			Summary:     "Attempts a delivery again",
+			Description: "A pending delivery is already scheduled, it is answered with a 409.",
+			Tags:        []string{"webhooks"},
+			Responses:   responses(http.StatusAccepted, "The delivery reset", delivery),
+		},
+		"GET /v1/graphql": {
+			OperationID: "graphqlQuery",
+			Summary:     "Runs a GraphQL query, mutations are only accepted with POST",
+			Tags:        []string{"graphql"},
+			Parameters: []*OpenAPIParameter{
+				{Name: "query", In: "query", Required: true, Schema: &JSONSchema{Type: JSONSchemaTypes{"string"}}},
+				queryParam("operationName", "", &JSONSchema{Type: JSONSchemaTypes{"string"}}),
+				queryParam("variables", "JSON encoded variables", &JSONSchema{Type: JSONSchemaTypes{"string"}}),
+			},
+			Responses: graphqlResponses(),
+		},
+		"POST /v1/graphql": {
+			OperationID: "graphqlRequest",
+			Summary:     "Runs a GraphQL query or mutation",
+			Tags:        []string{"graphql"},
+			RequestBody: &OpenAPIRequestBody{
+				Required: true,
+				Content:  jsonContent(g.schema(reflect.TypeOf(GraphQLReq{}), true)),
+			},
+			Responses: graphqlResponses(),
+		},
+	}
+}
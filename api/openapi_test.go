@@ -0,0 +1,158 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPI(t *testing.T) {
+	resp := doHTTPReq(api.Routes(), http.MethodGet, "/v1/openapi.json", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	doc := &api.OpenAPI{}
+	if !assert.NoError(t, json.Unmarshal(readBody(resp), doc)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "3.1.0", doc.OpenAPI)
+
+	// Every route is documented
+	for path, item := range doc.Paths {
+		for method, op := range item {
+			assert.NotEmpty(t, op.OperationID, "%s %s is not documented", method, path)
+			assert.NotNil(t, op.Responses["default"])
+		}
+	}
+
+	item := doc.Paths["/v1/payments/{paymentID}"]
+	for _, method := range []string{"get", "put", "post", "delete"} {
+		assert.NotNil(t, item[method], method)
+	}
+	assert.NotNil(t, item["delete"].Responses["204"])
+	assert.Equal(t, "Deletes a payment", item["delete"].Summary)
+	if assert.Len(t, item["get"].Parameters, 1) {
+		assert.Equal(t, "paymentID", item["get"].Parameters[0].Name)
+		assert.Equal(t, "path", item["get"].Parameters[0].In)
+	}
+
+	payment := doc.Components.Schemas["Payment"]
+	if assert.NotNil(t, payment) {
+		assert.Equal(t, "uuid", payment.Properties["id"].Format)
+		assert.Contains(t, payment.Required, "id")
+		assert.Equal(t, "#/components/schemas/PaymentParty", payment.Properties["beneficiary"].AnyOf[0].Ref)
+	}
+	assert.Empty(t, doc.Components.Schemas["PaymentInput"].Required)
+	assert.Equal(t, api.JSONSchemaTypes{"string", "null"}, payment.Properties["createdAt"].Type)
+	webhook := doc.Components.Schemas["Webhook"]
+	assert.NotContains(t, webhook.Required, "secret")
+	assert.Equal(t, []string{"payment.created", "payment.updated", "payment.deleted"},
+		webhook.Properties["events"].Items.Enum)
+}
+
+// TestOpenAPIFile checks that the document at the root of the repository is
+// up to date, it is generated with `app docgen > openapi.json`
+func TestOpenAPIFile(t *testing.T) {
+	doc, err := api.NewOpenAPI(api.Routes().(*chi.Mux))
+	assert.NoError(t, err)
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetIndent("", "  ")
+	assert.NoError(t, enc.Encode(doc))
+	file, err := ioutil.ReadFile("../openapi.json")
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(buf.Bytes(), file), "openapi.json is outdated, run app docgen > openapi.json")
+}
+
+func TestDocs(t *testing.T) {
+	resp := doHTTPReq(api.Routes(), http.MethodGet, "/v1/docs", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get(api.HeaderContentType), "text/html"))
+	assert.Contains(t, string(readBody(resp)), "openapi.json")
+}
+
+func TestValidateRequests(t *testing.T) {
+	api.SetStore(newTestDBInMem().Store)
+	handler := api.Routes()
+
+	tests := []struct {
+		method, url, body string
+		code              api.ErrorCode
+		path, schemaPath  string
+	}{
+		{http.MethodGet, "/v1/payments/nope", "", api.ErrorCodeInvalidInput, "path.paymentID",
+			"#/paths/~1v1~1payments~1{paymentID}/get/parameters/0/schema/format"},
+		{http.MethodGet, "/v1/payments?lim=-1", "", api.ErrorCodeInvalidInput, "query.lim",
+			"#/paths/~1v1~1payments/get/parameters/0/schema/minimum"},
+		{http.MethodGet, "/v1/payments?off=ten", "", api.ErrorCodeInvalidType, "query.off",
+			"#/paths/~1v1~1payments/get/parameters/1/schema/type"},
+		{http.MethodPost, "/v1/payments", `{"beneficiary": {"name": 1}}`, api.ErrorCodeInvalidType, "$.beneficiary.name",
+			"#/components/schemas/PaymentPartyInput/properties/name/type"},
+		{http.MethodPost, "/v1/payments", `{"createdAt": "yesterday"}`, api.ErrorCodeInvalidInput, "$.createdAt",
+			"#/components/schemas/PaymentInput/properties/createdAt/format"},
+		{http.MethodGet, "/v1/graphql", "", api.ErrorCodeInvalidInput, "query.query",
+			"#/paths/~1v1~1graphql/get/parameters/0/required"},
+	}
+	for _, test := range tests {
+		resp := doHTTPReq(handler, test.method, test.url, test.body)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, test.url)
+		body := readBody(resp)
+		apiErr := readAPIError(t, body)
+		assert.Equal(t, test.code, apiErr.AppCode, test.url)
+		assert.Equal(t, test.path, apiErr.Path, test.url)
+		assert.Equal(t, test.schemaPath, apiErr.SchemaPath, test.url)
+		assert.Contains(t, string(body), `"status":"fail"`)
+	}
+
+	resp := doHTTPReq(handler, http.MethodPost, "/v1/payments", `{"amount": "42.00", "beneficiary": null}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp = doHTTPReq(handler, http.MethodGet, "/v1/payments?lim=1&scheme=A,B", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestValidateRequestsAfterSignature(t *testing.T) {
+	defer enableSignature()()
+	api.SetStore(newTestDBInMem().Store)
+	handler := api.Routes()
+
+	// the unsigned requests are rejected before their body is validated
+	resp := doHTTPReq(handler, http.MethodPost, "/v1/payments", `{"beneficiary": {"name": 1}}`)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, api.ErrorCodeSignatureMissing, readErrorCode(readBody(resp)))
+
+	resp, body := doReq(handler, newSignedReq(t, testClientID, testClientSecret, `{"beneficiary": {"name": 1}}`))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, api.ErrorCodeInvalidType, readErrorCode(body))
+}
+
+func TestValidateResponses(t *testing.T) {
+	doc, err := api.NewOpenAPI(api.Routes().(*chi.Mux))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	v := api.NewOpenAPIValidator(doc)
+	req, _ := http.NewRequest(http.MethodGet, "/v1/payments/"+newMockPayment().ID.String(), nil)
+
+	b, _ := json.Marshal(api.NewJSENDData(newMockPayment(), http.StatusOK))
+	assert.Nil(t, v.ValidateResponse(req, http.StatusOK, api.ContentTypeJSON, b))
+	assert.Nil(t, v.ValidateResponse(req, http.StatusNotFound, api.ContentTypeJSON,
+		[]byte(`{"data": {"error": "Not found", "code": "not_found"}, "code": 404, "status": "error"}`)))
+
+	schemaErr := v.ValidateResponse(req, http.StatusOK, api.ContentTypeJSON,
+		[]byte(`{"data": {"id": 42}, "code": 200, "status": "success"}`))
+	if assert.NotNil(t, schemaErr) {
+		assert.Equal(t, "$.data.amount", schemaErr.Path)
+		assert.Equal(t, "#/components/schemas/Payment/required", schemaErr.SchemaPath)
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, "/v1/payments/"+newMockPayment().ID.String(), nil)
+	schemaErr = v.ValidateResponse(req, http.StatusNoContent, api.ContentTypeJSON, []byte(`{}`))
+	if assert.NotNil(t, schemaErr) {
+		assert.Equal(t, "#/paths/~1v1~1payments~1{paymentID}/delete/responses/204", schemaErr.SchemaPath)
+	}
+}
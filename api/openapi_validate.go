@@ -0,0 +1,458 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// SchemaError is a value that does not match its schema. Path locates the
+// value in the request, SchemaPath is a JSON pointer to the schema in the
+// OpenAPI document.
+type SchemaError struct {
+	Path       string
+	SchemaPath string
+	Message    string
+	// TypeMismatch is set when the value does not have the expected type
+	TypeMismatch bool
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%s: %s (%s)", e.Path, e.Message, e.SchemaPath)
+}
+
+// APIError returns the JSend fail of the error
+func (e *SchemaError) APIError() *APIError {
+	code := ErrorCodeInvalidInput
+	if e.TypeMismatch {
+		code = ErrorCodeInvalidType
+	}
+	ret := ErrInvalidBody(code, e.Path, e.Message)
+	ret.SchemaPath = e.SchemaPath
+	return ret
+}
+
+// pointerEscape escapes a JSON pointer token
+var pointerEscape = strings.NewReplacer("~", "~0", "/", "~1")
+
+// openAPIPath is a path of the document split in segments, the parameters
+// match any segment
+type openAPIPath struct {
+	pattern  string
+	segments []string
+}
+
+func (p *openAPIPath) match(path string) (map[string]string, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) != len(p.segments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, s := range p.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			params[s[1:len(s)-1]] = segments[i]
+		} else if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// OpenAPIValidator checks the requests, and optionally the responses, against
+// an OpenAPI document
+type OpenAPIValidator struct {
+	doc   *OpenAPI
+	paths []*openAPIPath
+}
+
+func NewOpenAPIValidator(doc *OpenAPI) *OpenAPIValidator {
+	v := &OpenAPIValidator{doc: doc}
+	for pattern := range doc.Paths {
+		v.paths = append(v.paths, &openAPIPath{
+			pattern:  pattern,
+			segments: strings.Split(strings.Trim(pattern, "/"), "/"),
+		})
+	}
+	// Static segments take precedence over the parameters
+	sort.Slice(v.paths, func(i, j int) bool {
+		return strings.Count(v.paths[i].pattern, "{") < strings.Count(v.paths[j].pattern, "{")
+	})
+	return v
+}
+
+// Operation finds the operation serving the request, the pattern of its path
+// and the values of the path parameters
+func (v *OpenAPIValidator) Operation(r *http.Request) (*OpenAPIOperation, string, map[string]string) {
+	for _, p := range v.paths {
+		params, ok := p.match(r.URL.Path)
+		if !ok {
+			continue
+		}
+		if op := v.doc.Paths[p.pattern][strings.ToLower(r.Method)]; op != nil {
+			return op, p.pattern, params
+		}
+	}
+	return nil, "", nil
+}
+
+// ValidateRequest checks the parameters and the body of the request. A body
+// that is not valid JSON is left to the decoding of the handler, which
+// reports it more precisely.
+func (v *OpenAPIValidator) ValidateRequest(r *http.Request) *SchemaError {
+	op, pattern, params := v.Operation(r)
+	if op == nil {
+		return nil
+	}
+	base := "#/paths/" + pointerEscape.Replace(pattern) + "/" + strings.ToLower(r.Method)
+	query := r.URL.Query()
+	for i, p := range op.Parameters {
+		var value string
+		var ok bool
+		switch p.In {
+		case "path":
+			value, ok = params[p.Name]
+		case "query":
+			_, ok = query[p.Name]
+			value = query.Get(p.Name)
+		default:
+			continue
+		}
+		schemaPath := fmt.Sprintf("%s/parameters/%d", base, i)
+		if !ok {
+			if p.Required {
+				return &SchemaError{Path: p.In + "." + p.Name, SchemaPath: schemaPath + "/required", Message: "Missing parameter " + p.Name}
+			}
+			continue
+		}
+		if err := v.validate(p.Schema, parameterValue(p.Schema, value), p.In+"."+p.Name, schemaPath+"/schema"); err != nil {
+			return err
+		}
+	}
+
+	if op.RequestBody == nil || r.Body == nil {
+		return nil
+	}
+	media := op.RequestBody.Content["application/json"]
+	if media == nil {
+		return nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), &errReader{err}))
+	if err != nil || len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	value, err := decodeJSONValue(body)
+	if err != nil {
+		return nil
+	}
+	return v.validate(media.Schema, value, JSONPathRoot, base+"/requestBody/content/application~1json/schema")
+}
+
+// ValidateResponse checks the body of a JSON response against the response of
+// the operation for its status code, or the default one
+func (v *OpenAPIValidator) ValidateResponse(r *http.Request, status int, contentType string, body []byte) *SchemaError {
+	op, pattern, _ := v.Operation(r)
+	if op == nil {
+		return nil
+	}
+	code := strconv.Itoa(status)
+	res := op.Responses[code]
+	if res == nil {
+		code = "default"
+		res = op.Responses[code]
+	}
+	schemaPath := "#/paths/" + pointerEscape.Replace(pattern) + "/" + strings.ToLower(r.Method) + "/responses/" + code
+	if res == nil {
+		return &SchemaError{Path: JSONPathRoot, SchemaPath: schemaPath, Message: "Undocumented status " + strconv.Itoa(status)}
+	}
+	if len(res.Content) == 0 {
+		if len(bytes.TrimSpace(body)) > 0 {
+			return &SchemaError{Path: JSONPathRoot, SchemaPath: schemaPath, Message: "Unexpected body"}
+		}
+		return nil
+	}
+	media := res.Content["application/json"]
+	if media == nil || !strings.HasPrefix(contentType, "application/json") {
+		return nil
+	}
+	value, err := decodeJSONValue(body)
+	if err != nil {
+		return &SchemaError{Path: JSONPathRoot, SchemaPath: schemaPath, Message: err.Error()}
+	}
+	return v.validate(media.Schema, value, JSONPathRoot, schemaPath+"/content/application~1json/schema")
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	if r.err == nil {
+		return 0, io.EOF
+	}
+	return 0, r.err
+}
+
+func decodeJSONValue(body []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return value, nil
+}
+
+// parameterValue converts the parameter to a number when its schema expects
+// one, so that it can be validated like a JSON value
+func parameterValue(s *JSONSchema, value string) interface{} {
+	for _, t := range s.Type {
+		if t == "integer" || t == "number" {
+			if _, err := strconv.ParseFloat(value, 64); err == nil {
+				return json.Number(value)
+			}
+		}
+	}
+	return value
+}
+
+// jsonType returns the JSON type of a value decoded with UseNumber
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return ""
+}
+
+func (v *OpenAPIValidator) resolve(s *JSONSchema) (*JSONSchema, string) {
+	name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+	return v.doc.Components.Schemas[name], s.Ref
+}
+
+func (v *OpenAPIValidator) validate(s *JSONSchema, value interface{}, path, schemaPath string) *SchemaError {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		resolved, ref := v.resolve(s)
+		if resolved == nil {
+			return &SchemaError{Path: path, SchemaPath: schemaPath, Message: "Unknown schema " + s.Ref}
+		}
+		return v.validate(resolved, value, path, ref)
+	}
+	if len(s.AnyOf) > 0 {
+		var first *SchemaError
+		for i, sub := range s.AnyOf {
+			err := v.validate(sub, value, path, fmt.Sprintf("%s/anyOf/%d", schemaPath, i))
+			if err == nil {
+				return nil
+			}
+			// The error of the null alternative is the least informative
+			if first == nil && !(len(sub.Type) == 1 && sub.Type[0] == "null") {
+				first = err
+			}
+		}
+		return first
+	}
+
+	typ := jsonType(value)
+	if len(s.Type) > 0 {
+		ok := false
+		for _, t := range s.Type {
+			ok = ok || t == typ || (t == "number" && typ == "integer")
+		}
+		if !ok {
+			return &SchemaError{
+				Path:         path,
+				SchemaPath:   schemaPath + "/type",
+				Message:      fmt.Sprintf("Expected %s, got %s", strings.Join(s.Type, " or "), typ),
+				TypeMismatch: true,
+			}
+		}
+	}
+
+	switch value := value.(type) {
+	case string:
+		if len(s.Enum) > 0 {
+			found := false
+			for _, e := range s.Enum {
+				found = found || e == value
+			}
+			if !found {
+				return &SchemaError{Path: path, SchemaPath: schemaPath + "/enum", Message: "Expected one of " + strings.Join(s.Enum, ", ")}
+			}
+		}
+		if err := checkFormat(s.Format, value); err != nil {
+			return &SchemaError{Path: path, SchemaPath: schemaPath + "/format", Message: err.Error()}
+		}
+	case json.Number:
+		if s.Minimum != nil {
+			if f, _ := value.Float64(); f < *s.Minimum {
+				return &SchemaError{Path: path, SchemaPath: schemaPath + "/minimum", Message: fmt.Sprintf("Must be at least %v", *s.Minimum)}
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			if err := v.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i), schemaPath+"/items"); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				return &SchemaError{Path: path + "." + name, SchemaPath: schemaPath + "/required", Message: "Missing property " + name}
+			}
+		}
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sub, subPath := s.Properties[k], schemaPath+"/properties/"+pointerEscape.Replace(k)
+			if sub == nil {
+				sub, subPath = s.AdditionalProperties, schemaPath+"/additionalProperties"
+			}
+			if err := v.validate(sub, value[k], path+"."+k, subPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkFormat(format, value string) error {
+	switch format {
+	case "uuid":
+		if _, err := uuid.Parse(value); err != nil {
+			return fmt.Errorf("Expected a UUID")
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("Expected an RFC 3339 date-time")
+		}
+	}
+	return nil
+}
+
+// responseRecorder holds the response until it is validated
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// streamed returns true when the operation responds with something else than
+// JSON, those responses are not held
+func streamed(op *OpenAPIOperation) bool {
+	for _, res := range op.Responses {
+		for contentType := range res.Content {
+			if contentType != "application/json" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateOpenAPI is a middleware checking the requests against the OpenAPI
+// document of the routes before they reach the handlers, the violations are
+// answered with a JSend fail holding the path of the schema. When
+// ValidateResponses is set, the JSON responses are held and replaced with an
+// error when they do not match the document.
+func validateOpenAPI(routes chi.Routes) func(http.Handler) http.Handler {
+	var (
+		once      sync.Once
+		validator *OpenAPIValidator
+	)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if config == nil || config.OpenAPI == nil || (!config.OpenAPI.ValidateRequests && !config.OpenAPI.ValidateResponses) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			once.Do(func() {
+				doc, err := NewOpenAPI(routes)
+				if err != nil {
+					logrus.Errorf("OpenAPI: could not build the document: %v", err)
+					return
+				}
+				validator = NewOpenAPIValidator(doc)
+			})
+			if validator == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if config.OpenAPI.ValidateRequests {
+				if err := validator.ValidateRequest(r); err != nil {
+					handleError(w, r, err.APIError())
+					return
+				}
+			}
+			op, _, _ := validator.Operation(r)
+			if !config.OpenAPI.ValidateResponses || op == nil || streamed(op) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			err := validator.ValidateResponse(r, rec.status, w.Header().Get(HeaderContentType), rec.body.Bytes())
+			if err != nil {
+				apiErr := ErrInvalidResponse(err)
+				logrus.WithContext(r.Context()).Error(apiErr)
+				w.Header().Del("Content-Length")
+				render.Render(w, r, NewJSENDData(apiErr))
+				return
+			}
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+		})
+	}
+}
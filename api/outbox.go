@@ -0,0 +1,319 @@
+package api
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// The outbox guarantees that every payment event is published at least once,
+// even if the process crashes between the write and the publication. Since
+// mgo cannot write two documents atomically, the stores use a two-step
+// protocol:
+//
+//   1. the event is prepared in the outbox with its sequence number
+//   2. the payment is written
+//   3. the event is committed
+//
+// A write failing for certain, such as one rejected by a unique index,
+// discards its event instead of committing it.
+//
+// A crash between 1 and 3, or a write whose outcome is unknown, leaves a
+// prepared event behind. The relay resolves it by looking at the payment: if
+// it matches the event, the write went through and the event is committed,
+// otherwise it is discarded.
+//
+// A save only learns whether it created the payment from its write, its
+// event is prepared as an update and committed with the type of the write.
+// A recovered save is published as an update.
+
+// OutboxStatus is the state of an event in the outbox
+type OutboxStatus string
+
+const (
+	OutboxStatusPrepared  OutboxStatus = "prepared"
+	OutboxStatusPending   OutboxStatus = "pending"
+	OutboxStatusPublished OutboxStatus = "published"
+)
+
+// OutboxEvent is a payment event waiting in the outbox. Sequence gives the
+// order in which the events must be published.
+type OutboxEvent struct {
+	ID          uuid.UUID     `json:"id" bson:"_id"`
+	Sequence    int64         `json:"sequence" bson:"seq"`
+	Status      OutboxStatus  `json:"status" bson:"status"`
+	Event       *PaymentEvent `json:"event" bson:"event"`
+	CreatedAt   time.Time     `json:"createdAt" bson:"createdAt"`
+	PublishedAt *time.Time    `json:"publishedAt,omitempty" bson:"publishedAt,omitempty"`
+}
+
+func NewOutboxEvent(e *PaymentEvent) *OutboxEvent {
+	return &OutboxEvent{
+		ID:        e.ID,
+		Status:    OutboxStatusPrepared,
+		Event:     e,
+		CreatedAt: time.Now(),
+	}
+}
+
+// Outbox defines what an outbox should be able to do
+type Outbox interface {
+	// Prepare should assign the next sequence number to the event and store
+	// it as prepared
+	Prepare(e *OutboxEvent) error
+
+	// Commit should mark a prepared event as ready to be published, with the
+	// type the write turned out to have
+	Commit(id uuid.UUID, typ PaymentEventType) error
+
+	// Discard should remove a prepared event whose write did not go through
+	Discard(id uuid.UUID) error
+
+	// Unpublished should return the prepared and pending events ordered by
+	// sequence
+	Unpublished(limit int) ([]*OutboxEvent, error)
+
+	// MarkPublished should mark the event as published
+	MarkPublished(id uuid.UUID) error
+
+	// Range should return the committed events, published or not, whose
+	// sequence is between from and to included, ordered by sequence. A to
+	// lower or equal to 0 means no upper bound.
+	Range(from, to int64) ([]*OutboxEvent, error)
+}
+
+// OutboxInMemStore is an implementation of Outbox with temporary in memory
+// storage
+type OutboxInMemStore struct {
+	mu     sync.Mutex
+	seq    int64
+	events []*OutboxEvent
+}
+
+func NewOutboxInMemStore() *OutboxInMemStore {
+	return &OutboxInMemStore{
+		events: []*OutboxEvent{},
+	}
+}
+
+func (o *OutboxInMemStore) Prepare(e *OutboxEvent) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.seq++
+	e.Sequence = o.seq
+	e.Status = OutboxStatusPrepared
+	cpy := *e
+	o.events = append(o.events, &cpy)
+	return nil
+}
+
+func (o *OutboxInMemStore) setStatus(id uuid.UUID, status OutboxStatus, typ PaymentEventType) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, e := range o.events {
+		if e.ID == id {
+			e.Status = status
+			if typ != "" && typ != e.Event.Type {
+				event := *e.Event
+				event.Type = typ
+				e.Event = &event
+			}
+			if status == OutboxStatusPublished {
+				e.PublishedAt = Now()
+			}
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (o *OutboxInMemStore) Commit(id uuid.UUID, typ PaymentEventType) error {
+	return o.setStatus(id, OutboxStatusPending, typ)
+}
+
+func (o *OutboxInMemStore) MarkPublished(id uuid.UUID) error {
+	return o.setStatus(id, OutboxStatusPublished, "")
+}
+
+func (o *OutboxInMemStore) Discard(id uuid.UUID) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, e := range o.events {
+		if e.ID == id {
+			o.events = append(o.events[:i], o.events[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (o *OutboxInMemStore) find(match func(e *OutboxEvent) bool, limit int) []*OutboxEvent {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ret := []*OutboxEvent{}
+	for _, e := range o.events {
+		if match(e) {
+			cpy := *e
+			ret = append(ret, &cpy)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Sequence < ret[j].Sequence })
+	if limit > 0 && len(ret) > limit {
+		ret = ret[:limit]
+	}
+	return ret
+}
+
+func (o *OutboxInMemStore) Unpublished(limit int) ([]*OutboxEvent, error) {
+	return o.find(func(e *OutboxEvent) bool {
+		return e.Status != OutboxStatusPublished
+	}, limit), nil
+}
+
+func (o *OutboxInMemStore) Range(from, to int64) ([]*OutboxEvent, error) {
+	return o.find(func(e *OutboxEvent) bool {
+		return e.Status != OutboxStatusPrepared &&
+			e.Sequence >= from && (to <= 0 || e.Sequence <= to)
+	}, 0), nil
+}
+
+// OutboxRelay publishes the events of the outbox in order. Prepared events
+// block the ones after them until they are committed, or resolved once they
+// are older than RecoverAfter.
+type OutboxRelay struct {
+	Outbox    Outbox
+	Publisher EventPublisher
+	Payments  PaymentStore
+	Settings  *OutboxSettings
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewOutboxRelay(o Outbox, p EventPublisher, s PaymentStore, settings *OutboxSettings) *OutboxRelay {
+	return &OutboxRelay{
+		Outbox:    o,
+		Publisher: p,
+		Payments:  s,
+		Settings:  settings,
+	}
+}
+
+// Start runs the relay in a goroutine until Stop is called
+func (r *OutboxRelay) Start() {
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.Settings.Interval)
+		defer ticker.Stop()
+		for {
+			if err := r.Flush(); err != nil {
+				logrus.Errorf("Outbox: %v", err)
+			}
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops the relay and waits for the current pass to finish
+func (r *OutboxRelay) Stop() {
+	if r.stop == nil {
+		return
+	}
+	close(r.stop)
+	<-r.done
+}
+
+// Flush publishes the unpublished events in order. It stops at the first
+// event that cannot be published yet.
+func (r *OutboxRelay) Flush() error {
+	for {
+		events, err := r.Outbox.Unpublished(r.Settings.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		for _, e := range events {
+			if e.Status == OutboxStatusPrepared {
+				if time.Since(e.CreatedAt) < r.Settings.RecoverAfter {
+					return nil
+				}
+				committed, err := r.resolve(e)
+				if err != nil {
+					return err
+				}
+				if !committed {
+					logrus.Warnf("Outbox: discarding event %d (%s), the write did not go through", e.Sequence, e.ID)
+					if err := r.Outbox.Discard(e.ID); err != nil {
+						return err
+					}
+					continue
+				}
+				logrus.Warnf("Outbox: recovering event %d (%s)", e.Sequence, e.ID)
+				if err := r.Outbox.Commit(e.ID, e.Event.Type); err != nil {
+					return err
+				}
+			}
+			if err := r.Publisher.Publish(e); err != nil {
+				return err
+			}
+			if err := r.Outbox.MarkPublished(e.ID); err != nil {
+				return err
+			}
+		}
+		if len(events) < r.Settings.BatchSize || r.Settings.BatchSize <= 0 {
+			return nil
+		}
+	}
+}
+
+// resolve checks whether the write of a prepared event went through, the
+// lookup is bounded by the timeout of the relay
+func (r *OutboxRelay) resolve(e *OutboxEvent) (bool, error) {
+	ctx := context.Background()
+	if r.Settings.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Settings.Timeout)
+		defer cancel()
+	}
+	p, err := r.Payments.GetByID(ctx, e.Event.Payment.ID)
+	if err != nil && err != ErrNotFound {
+		return false, err
+	}
+	if e.Event.Type == PaymentEventDeleted {
+		return err == ErrNotFound, nil
+	}
+	if err == ErrNotFound || p.UpdatedAt == nil || e.Event.Payment.UpdatedAt == nil {
+		return false, nil
+	}
+	// Mongo stores dates with a millisecond precision
+	return p.UpdatedAt.Truncate(time.Millisecond).Equal(
+		e.Event.Payment.UpdatedAt.Truncate(time.Millisecond),
+	), nil
+}
+
+// Replay publishes again the committed events whose sequence is between from
+// and to included
+func (r *OutboxRelay) Replay(from, to int64) (int, error) {
+	events, err := r.Outbox.Range(from, to)
+	if err != nil {
+		return 0, err
+	}
+	for i, e := range events {
+		if err := r.Publisher.Publish(e); err != nil {
+			return i, err
+		}
+	}
+	return len(events), nil
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/google/uuid"
+)
+
+// This is an implementation of Outbox backed by MongoDB
+
+const outboxCounterID = "outbox"
+
+// OutboxMongoStore keeps the events in a collection and the last sequence
+// number in a counter collection
+type OutboxMongoStore struct {
+	Events   *mgo.Collection
+	Counters *mgo.Collection
+}
+
+func NewOutboxMongoStore(events, counters *mgo.Collection) *OutboxMongoStore {
+	return &OutboxMongoStore{
+		Events:   events,
+		Counters: counters,
+	}
+}
+
+// EnsureIndexes creates the indexes used to list the events by status and
+// sequence
+func (o *OutboxMongoStore) EnsureIndexes() error {
+	if err := o.Events.EnsureIndex(mgo.Index{Key: []string{"seq"}, Unique: true}); err != nil {
+		return err
+	}
+	return o.Events.EnsureIndex(mgo.Index{Key: []string{"status", "seq"}})
+}
+
+func (o *OutboxMongoStore) nextSequence() (int64, error) {
+	counter := struct {
+		Seq int64 `bson:"seq"`
+	}{}
+	_, err := o.Counters.FindId(outboxCounterID).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"seq": 1}},
+		Upsert:    true,
+		ReturnNew: true,
+	}, &counter)
+	return counter.Seq, err
+}
+
+func (o *OutboxMongoStore) Prepare(e *OutboxEvent) error {
+	seq, err := o.nextSequence()
+	if err != nil {
+		return ErrSomethingWentWrong(err)
+	}
+	e.Sequence = seq
+	e.Status = OutboxStatusPrepared
+	if err := o.Events.Insert(e); err != nil {
+		return mongoWriteError(err)
+	}
+	return nil
+}
+
+func (o *OutboxMongoStore) Commit(id uuid.UUID, typ PaymentEventType) error {
+	if err := o.Events.UpdateId(id, bson.M{
+		"$set": bson.M{"status": OutboxStatusPending, "event.type": typ},
+	}); err != nil {
+		return ErrSomethingWentWrong(err)
+	}
+	return nil
+}
+
+func (o *OutboxMongoStore) MarkPublished(id uuid.UUID) error {
+	if err := o.Events.UpdateId(id, bson.M{
+		"$set": bson.M{"status": OutboxStatusPublished, "publishedAt": Now()},
+	}); err != nil {
+		return ErrSomethingWentWrong(err)
+	}
+	return nil
+}
+
+func (o *OutboxMongoStore) Discard(id uuid.UUID) error {
+	if err := o.Events.RemoveId(id); err != nil && err != mgo.ErrNotFound {
+		return ErrSomethingWentWrong(err)
+	}
+	return nil
+}
+
+func (o *OutboxMongoStore) Unpublished(limit int) ([]*OutboxEvent, error) {
+	ret := []*OutboxEvent{}
+	q := o.Events.Find(bson.M{
+		"status": bson.M{"$in": []OutboxStatus{OutboxStatusPrepared, OutboxStatusPending}},
+	}).Sort("seq")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if err := q.All(&ret); err != nil {
+		return nil, ErrSomethingWentWrong(err)
+	}
+	return ret, nil
+}
+
+func (o *OutboxMongoStore) Range(from, to int64) ([]*OutboxEvent, error) {
+	ret := []*OutboxEvent{}
+	seq := bson.M{"$gte": from}
+	if to > 0 {
+		seq["$lte"] = to
+	}
+	if err := o.Events.Find(bson.M{
+		"status": bson.M{"$ne": OutboxStatusPrepared},
+		"seq":    seq,
+	}).Sort("seq").All(&ret); err != nil {
+		return nil, ErrSomethingWentWrong(err)
+	}
+	return ret, nil
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	PublisherTypeLog  = "log"
+	PublisherTypeFile = "file"
+	PublisherTypeHTTP = "http"
+)
+
+// EventPublisher defines what a publisher of the outbox events should be able
+// to do. Publishing is at least once: consumers should use the sequence or
+// the ID of the event to detect duplicates.
+type EventPublisher interface {
+	Publish(e *OutboxEvent) error
+}
+
+// NewEventPublisher creates the publisher described by the settings
+func NewEventPublisher(settings *OutboxSettings) (EventPublisher, error) {
+	switch settings.Publisher {
+	case PublisherTypeLog:
+		return &LogPublisher{}, nil
+	case PublisherTypeFile:
+		return NewFilePublisher(settings.File)
+	case PublisherTypeHTTP:
+		return NewHTTPPublisher(settings.URL, settings.Timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown publisher type %q", settings.Publisher)
+	}
+}
+
+// LogPublisher writes the events in the logs
+type LogPublisher struct{}
+
+func (p *LogPublisher) Publish(e *OutboxEvent) error {
+	logrus.WithFields(logrus.Fields{
+		"sequence": e.Sequence,
+		"event":    e.ID,
+		"type":     e.Event.Type,
+		"payment":  e.Event.Payment.ID,
+	}).Info("Outbox: event published")
+	return nil
+}
+
+// FilePublisher appends the events to a file, one JSON document per line
+type FilePublisher struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+func NewFilePublisher(path string) (*FilePublisher, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FilePublisher{w: f}, nil
+}
+
+func (p *FilePublisher) Publish(e *OutboxEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.w.Write(append(b, '\n'))
+	return err
+}
+
+func (p *FilePublisher) Close() error {
+	return p.w.Close()
+}
+
+// HTTPPublisher POSTs the events to an URL, any answer other than 2xx is
+// considered a failure
+type HTTPPublisher struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewHTTPPublisher(url string, timeout time.Duration) *HTTPPublisher {
+	return &HTTPPublisher{
+		URL:    url,
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (p *HTTPPublisher) Publish(e *OutboxEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := p.Client.Post(p.URL, ContentTypeJSON, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("publisher endpoint answered %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/ganitzsh/f3-te/api/mock"
+	"github.com/globalsign/mgo"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingPublisher struct {
+	fail      bool
+	published []*api.OutboxEvent
+}
+
+func (p *recordingPublisher) Publish(e *api.OutboxEvent) error {
+	if p.fail {
+		return errors.New("unavailable")
+	}
+	p.published = append(p.published, e)
+	return nil
+}
+
+func (p *recordingPublisher) Types() []api.PaymentEventType {
+	ret := []api.PaymentEventType{}
+	for _, e := range p.published {
+		ret = append(ret, e.Event.Type)
+	}
+	return ret
+}
+
+func newTestOutbox(recoverAfter time.Duration) (*api.PaymentMongoStore, *api.OutboxInMemStore, *recordingPublisher, *api.OutboxRelay) {
+	data := api.NewPaymentInMemStore()
+	s := api.NewPaymentMongoStore(mock.NewCollection(data))
+	o := api.NewOutboxInMemStore()
+	s.Outbox = o
+	p := &recordingPublisher{}
+	relay := api.NewOutboxRelay(o, p, s, &api.OutboxSettings{
+		BatchSize:    2,
+		RecoverAfter: recoverAfter,
+	})
+	return s, o, p, relay
+}
+
+func TestOutboxPublishesInOrder(t *testing.T) {
+	s, o, p, relay := newTestOutbox(time.Hour)
+	payment := newMockPayment()
+	assert.NoError(t, s.Save(ctx, payment))
+	assert.NoError(t, s.Save(ctx, payment))
+	assert.NoError(t, s.Delete(ctx, payment.ID))
+	assert.NoError(t, s.Delete(ctx, payment.ID))
+
+	p.fail = true
+	assert.Error(t, relay.Flush())
+	p.fail = false
+	assert.NoError(t, relay.Flush())
+	assert.Equal(t, []api.PaymentEventType{
+		api.PaymentEventCreated,
+		api.PaymentEventUpdated,
+		api.PaymentEventDeleted,
+	}, p.Types())
+	for i, e := range p.published {
+		assert.Equal(t, int64(i+1), e.Sequence)
+		assert.Equal(t, payment.ID, e.Event.Payment.ID)
+	}
+	events, _ := o.Unpublished(0)
+	assert.Len(t, events, 0)
+
+	p.published = nil
+	n, err := relay.Replay(2, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []api.PaymentEventType{
+		api.PaymentEventUpdated,
+		api.PaymentEventDeleted,
+	}, p.Types())
+}
+
+func TestOutboxRecovery(t *testing.T) {
+	s, o, p, relay := newTestOutbox(time.Hour)
+
+	// The write of the first payment never happened
+	lost := newMockPayment()
+	assert.NoError(t, o.Prepare(api.NewOutboxEvent(api.NewPaymentEvent(api.PaymentEventCreated, lost))))
+
+	// The process crashed before the second event was committed
+	written := newMockPayment()
+	written.UpdatedAt = api.Now()
+	assert.NoError(t, o.Prepare(api.NewOutboxEvent(api.NewPaymentEvent(api.PaymentEventCreated, written))))
+	assert.NoError(t, s.MongoCollection.(*mock.PaymentCollection).Data.Save(ctx, written))
+
+	assert.NoError(t, s.Save(ctx, newMockPayment()))
+
+	// Recent prepared events block the ones after them
+	assert.NoError(t, relay.Flush())
+	assert.Len(t, p.published, 0)
+
+	relay.Settings.RecoverAfter = 0
+	assert.NoError(t, relay.Flush())
+	if assert.Len(t, p.published, 2) {
+		assert.Equal(t, written.ID, p.published[0].Event.Payment.ID)
+		assert.Equal(t, int64(3), p.published[1].Sequence)
+	}
+	events, _ := o.Range(0, 0)
+	assert.Len(t, events, 2)
+}
+
+// slowCollection takes its time to write, past the deadline of the requests
+type slowCollection struct {
+	*mock.PaymentCollection
+	delay time.Duration
+}
+
+func (c slowCollection) UpsertId(id interface{}, doc interface{}) (*mgo.ChangeInfo, error) {
+	time.Sleep(c.delay)
+	return c.PaymentCollection.UpsertId(id, doc)
+}
+
+func (c slowCollection) RemoveId(id interface{}) error {
+	time.Sleep(c.delay)
+	return c.PaymentCollection.RemoveId(id)
+}
+
+func TestOutboxWritePastDeadline(t *testing.T) {
+	s, o, p, relay := newTestOutbox(time.Hour)
+	s.MongoCollection = slowCollection{s.MongoCollection.(*mock.PaymentCollection), 50 * time.Millisecond}
+	payment := newMockPayment()
+
+	// the writes are not given up at the deadline, their events are committed
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, s.Save(timeout, payment))
+	_, err := s.GetByID(ctx, payment.ID)
+	assert.NoError(t, err)
+	timeout, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, s.Delete(timeout, payment.ID))
+
+	events, _ := o.Unpublished(0)
+	if assert.Len(t, events, 2) {
+		for _, e := range events {
+			assert.Equal(t, api.OutboxStatusPending, e.Status)
+		}
+	}
+	assert.NoError(t, relay.Flush())
+	assert.Equal(t, []api.PaymentEventType{
+		api.PaymentEventCreated,
+		api.PaymentEventDeleted,
+	}, p.Types())
+}
+
+func TestOutboxDiscardsRejectedWrites(t *testing.T) {
+	s, o, _, _ := newTestOutbox(time.Hour)
+	c := s.MongoCollection.(*mock.PaymentCollection)
+
+	// the database refused the write, the event is gone
+	s.MongoCollection = &dupCollection{c}
+	assert.Equal(t, api.ErrConflict, s.Save(ctx, newMockPayment()))
+	events, _ := o.Unpublished(0)
+	assert.Len(t, events, 0)
+
+	// the write may have gone through, the relay resolves the event
+	s.MongoCollection = &downCollection{PaymentCollection: c}
+	assert.Error(t, s.Save(ctx, newMockPayment()))
+	s.MongoCollection = &timeoutCollection{c}
+	assert.Equal(t, api.ErrTimeout, s.Save(ctx, newMockPayment()))
+	events, _ = o.Unpublished(0)
+	if assert.Len(t, events, 2) {
+		for _, e := range events {
+			assert.Equal(t, api.OutboxStatusPrepared, e.Status)
+		}
+	}
+}
@@ -0,0 +1,1112 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: payments.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type PaymentParty struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	AccountName       string                 `protobuf:"bytes,1,opt,name=account_name,json=accountName,proto3" json:"account_name,omitempty"`
+	AccountNumber     string                 `protobuf:"bytes,2,opt,name=account_number,json=accountNumber,proto3" json:"account_number,omitempty"`
+	AccountNumberCode string                 `protobuf:"bytes,3,opt,name=account_number_code,json=accountNumberCode,proto3" json:"account_number_code,omitempty"`
+	BankId            string                 `protobuf:"bytes,4,opt,name=bank_id,json=bankId,proto3" json:"bank_id,omitempty"`
+	BankIdCode        string                 `protobuf:"bytes,5,opt,name=bank_id_code,json=bankIdCode,proto3" json:"bank_id_code,omitempty"`
+	Name              string                 `protobuf:"bytes,6,opt,name=name,proto3" json:"name,omitempty"`
+	Address           string                 `protobuf:"bytes,7,opt,name=address,proto3" json:"address,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *PaymentParty) Reset() {
+	*x = PaymentParty{}
+	mi := &file_payments_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PaymentParty) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PaymentParty) ProtoMessage() {}
+
+func (x *PaymentParty) ProtoReflect() protoreflect.Message {
+	mi := &file_payments_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PaymentParty.ProtoReflect.Descriptor instead.
+func (*PaymentParty) Descriptor() ([]byte, []int) {
+	return file_payments_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PaymentParty) GetAccountName() string {
+	if x != nil {
+		return x.AccountName
+	}
+	return ""
+}
+
+func (x *PaymentParty) GetAccountNumber() string {
+	if x != nil {
+		return x.AccountNumber
+	}
+	return ""
+}
+
+func (x *PaymentParty) GetAccountNumberCode() string {
+	if x != nil {
+		return x.AccountNumberCode
+	}
+	return ""
+}
+
+func (x *PaymentParty) GetBankId() string {
+	if x != nil {
+		return x.BankId
+	}
+	return ""
+}
+
+func (x *PaymentParty) GetBankIdCode() string {
+	if x != nil {
+		return x.BankIdCode
+	}
+	return ""
+}
+
+func (x *PaymentParty) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *PaymentParty) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+type SenderCharge struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Amount        string                 `protobuf:"bytes,1,opt,name=amount,proto3" json:"amount,omitempty"`
+	Currency      string                 `protobuf:"bytes,2,opt,name=currency,proto3" json:"currency,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SenderCharge) Reset() {
+	*x = SenderCharge{}
+	mi := &file_payments_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SenderCharge) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SenderCharge) ProtoMessage() {}
+
+func (x *SenderCharge) ProtoReflect() protoreflect.Message {
+	mi := &file_payments_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SenderCharge.ProtoReflect.Descriptor instead.
+func (*SenderCharge) Descriptor() ([]byte, []int) {
+	return file_payments_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SenderCharge) GetAmount() string {
+	if x != nil {
+		return x.Amount
+	}
+	return ""
+}
+
+func (x *SenderCharge) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
+type ChargesInformation struct {
+	state                   protoimpl.MessageState `protogen:"open.v1"`
+	BearerCode              string                 `protobuf:"bytes,1,opt,name=bearer_code,json=bearerCode,proto3" json:"bearer_code,omitempty"`
+	ReceiverChargesAmount   string                 `protobuf:"bytes,2,opt,name=receiver_charges_amount,json=receiverChargesAmount,proto3" json:"receiver_charges_amount,omitempty"`
+	ReceiverChargesCurrency string                 `protobuf:"bytes,3,opt,name=receiver_charges_currency,json=receiverChargesCurrency,proto3" json:"receiver_charges_currency,omitempty"`
+	SenderCharges           []*SenderCharge        `protobuf:"bytes,4,rep,name=sender_charges,json=senderCharges,proto3" json:"sender_charges,omitempty"`
+	unknownFields           protoimpl.UnknownFields
+	sizeCache               protoimpl.SizeCache
+}
+
+func (x *ChargesInformation) Reset() {
+	*x = ChargesInformation{}
+	mi := &file_payments_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChargesInformation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChargesInformation) ProtoMessage() {}
+
+func (x *ChargesInformation) ProtoReflect() protoreflect.Message {
+	mi := &file_payments_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChargesInformation.ProtoReflect.Descriptor instead.
+func (*ChargesInformation) Descriptor() ([]byte, []int) {
+	return file_payments_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ChargesInformation) GetBearerCode() string {
+	if x != nil {
+		return x.BearerCode
+	}
+	return ""
+}
+
+func (x *ChargesInformation) GetReceiverChargesAmount() string {
+	if x != nil {
+		return x.ReceiverChargesAmount
+	}
+	return ""
+}
+
+func (x *ChargesInformation) GetReceiverChargesCurrency() string {
+	if x != nil {
+		return x.ReceiverChargesCurrency
+	}
+	return ""
+}
+
+func (x *ChargesInformation) GetSenderCharges() []*SenderCharge {
+	if x != nil {
+		return x.SenderCharges
+	}
+	return nil
+}
+
+type FX struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	ContractReference string                 `protobuf:"bytes,1,opt,name=contract_reference,json=contractReference,proto3" json:"contract_reference,omitempty"`
+	ExchangeRate      string                 `protobuf:"bytes,2,opt,name=exchange_rate,json=exchangeRate,proto3" json:"exchange_rate,omitempty"`
+	OriginalAmount    string                 `protobuf:"bytes,3,opt,name=original_amount,json=originalAmount,proto3" json:"original_amount,omitempty"`
+	OriginalCurrency  string                 `protobuf:"bytes,4,opt,name=original_currency,json=originalCurrency,proto3" json:"original_currency,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *FX) Reset() {
+	*x = FX{}
+	mi := &file_payments_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FX) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FX) ProtoMessage() {}
+
+func (x *FX) ProtoReflect() protoreflect.Message {
+	mi := &file_payments_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FX.ProtoReflect.Descriptor instead.
+func (*FX) Descriptor() ([]byte, []int) {
+	return file_payments_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *FX) GetContractReference() string {
+	if x != nil {
+		return x.ContractReference
+	}
+	return ""
+}
+
+func (x *FX) GetExchangeRate() string {
+	if x != nil {
+		return x.ExchangeRate
+	}
+	return ""
+}
+
+func (x *FX) GetOriginalAmount() string {
+	if x != nil {
+		return x.OriginalAmount
+	}
+	return ""
+}
+
+func (x *FX) GetOriginalCurrency() string {
+	if x != nil {
+		return x.OriginalCurrency
+	}
+	return ""
+}
+
+type Payment struct {
+	state                protoimpl.MessageState `protogen:"open.v1"`
+	Id                   string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	CreatedAt            *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt            *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	Purpose              string                 `protobuf:"bytes,4,opt,name=purpose,proto3" json:"purpose,omitempty"`
+	Scheme               string                 `protobuf:"bytes,5,opt,name=scheme,proto3" json:"scheme,omitempty"`
+	Type                 string                 `protobuf:"bytes,6,opt,name=type,proto3" json:"type,omitempty"`
+	Amount               string                 `protobuf:"bytes,7,opt,name=amount,proto3" json:"amount,omitempty"`
+	Beneficiary          *PaymentParty          `protobuf:"bytes,8,opt,name=beneficiary,proto3" json:"beneficiary,omitempty"`
+	Currency             string                 `protobuf:"bytes,9,opt,name=currency,proto3" json:"currency,omitempty"`
+	DebitorParty         *PaymentParty          `protobuf:"bytes,10,opt,name=debitor_party,json=debitorParty,proto3" json:"debitor_party,omitempty"`
+	EndToEndReference    string                 `protobuf:"bytes,11,opt,name=end_to_end_reference,json=endToEndReference,proto3" json:"end_to_end_reference,omitempty"`
+	NumericReference     string                 `protobuf:"bytes,12,opt,name=numeric_reference,json=numericReference,proto3" json:"numeric_reference,omitempty"`
+	ProcessingDate       string                 `protobuf:"bytes,13,opt,name=processing_date,json=processingDate,proto3" json:"processing_date,omitempty"`
+	Reference            string                 `protobuf:"bytes,14,opt,name=reference,proto3" json:"reference,omitempty"`
+	SchemePaymentSubType string                 `protobuf:"bytes,15,opt,name=scheme_payment_sub_type,json=schemePaymentSubType,proto3" json:"scheme_payment_sub_type,omitempty"`
+	SchemePaymentType    string                 `protobuf:"bytes,16,opt,name=scheme_payment_type,json=schemePaymentType,proto3" json:"scheme_payment_type,omitempty"`
+	ChargesInformation   *ChargesInformation    `protobuf:"bytes,17,opt,name=charges_information,json=chargesInformation,proto3" json:"charges_information,omitempty"`
+	Fx                   *FX                    `protobuf:"bytes,18,opt,name=fx,proto3" json:"fx,omitempty"`
+	unknownFields        protoimpl.UnknownFields
+	sizeCache            protoimpl.SizeCache
+}
+
+func (x *Payment) Reset() {
+	*x = Payment{}
+	mi := &file_payments_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Payment) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Payment) ProtoMessage() {}
+
+func (x *Payment) ProtoReflect() protoreflect.Message {
+	mi := &file_payments_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Payment.ProtoReflect.Descriptor instead.
+func (*Payment) Descriptor() ([]byte, []int) {
+	return file_payments_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Payment) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Payment) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Payment) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *Payment) GetPurpose() string {
+	if x != nil {
+		return x.Purpose
+	}
+	return ""
+}
+
+func (x *Payment) GetScheme() string {
+	if x != nil {
+		return x.Scheme
+	}
+	return ""
+}
+
+func (x *Payment) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Payment) GetAmount() string {
+	if x != nil {
+		return x.Amount
+	}
+	return ""
+}
+
+func (x *Payment) GetBeneficiary() *PaymentParty {
+	if x != nil {
+		return x.Beneficiary
+	}
+	return nil
+}
+
+func (x *Payment) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
+func (x *Payment) GetDebitorParty() *PaymentParty {
+	if x != nil {
+		return x.DebitorParty
+	}
+	return nil
+}
+
+func (x *Payment) GetEndToEndReference() string {
+	if x != nil {
+		return x.EndToEndReference
+	}
+	return ""
+}
+
+func (x *Payment) GetNumericReference() string {
+	if x != nil {
+		return x.NumericReference
+	}
+	return ""
+}
+
+func (x *Payment) GetProcessingDate() string {
+	if x != nil {
+		return x.ProcessingDate
+	}
+	return ""
+}
+
+func (x *Payment) GetReference() string {
+	if x != nil {
+		return x.Reference
+	}
+	return ""
+}
+
+func (x *Payment) GetSchemePaymentSubType() string {
+	if x != nil {
+		return x.SchemePaymentSubType
+	}
+	return ""
+}
+
+func (x *Payment) GetSchemePaymentType() string {
+	if x != nil {
+		return x.SchemePaymentType
+	}
+	return ""
+}
+
+func (x *Payment) GetChargesInformation() *ChargesInformation {
+	if x != nil {
+		return x.ChargesInformation
+	}
+	return nil
+}
+
+func (x *Payment) GetFx() *FX {
+	if x != nil {
+		return x.Fx
+	}
+	return nil
+}
+
+// Filter matches the payments whose field, named as in the JSON
+// representation (e.g. "scheme"), is equal to one of the values
+type Filter struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Field         string                 `protobuf:"bytes,1,opt,name=field,proto3" json:"field,omitempty"`
+	Values        []string               `protobuf:"bytes,2,rep,name=values,proto3" json:"values,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Filter) Reset() {
+	*x = Filter{}
+	mi := &file_payments_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Filter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Filter) ProtoMessage() {}
+
+func (x *Filter) ProtoReflect() protoreflect.Message {
+	mi := &file_payments_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Filter.ProtoReflect.Descriptor instead.
+func (*Filter) Descriptor() ([]byte, []int) {
+	return file_payments_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Filter) GetField() string {
+	if x != nil {
+		return x.Field
+	}
+	return ""
+}
+
+func (x *Filter) GetValues() []string {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+type GetPaymentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPaymentRequest) Reset() {
+	*x = GetPaymentRequest{}
+	mi := &file_payments_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPaymentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPaymentRequest) ProtoMessage() {}
+
+func (x *GetPaymentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_payments_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPaymentRequest.ProtoReflect.Descriptor instead.
+func (*GetPaymentRequest) Descriptor() ([]byte, []int) {
+	return file_payments_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetPaymentRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+// ListPaymentsRequest pages like the REST API: offset wins over page, page
+// is only used with a limit
+type ListPaymentsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Limit         int32                  `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"`
+	Offset        int32                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	Page          int32                  `protobuf:"varint,3,opt,name=page,proto3" json:"page,omitempty"`
+	Filters       []*Filter              `protobuf:"bytes,4,rep,name=filters,proto3" json:"filters,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPaymentsRequest) Reset() {
+	*x = ListPaymentsRequest{}
+	mi := &file_payments_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPaymentsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPaymentsRequest) ProtoMessage() {}
+
+func (x *ListPaymentsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_payments_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPaymentsRequest.ProtoReflect.Descriptor instead.
+func (*ListPaymentsRequest) Descriptor() ([]byte, []int) {
+	return file_payments_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListPaymentsRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListPaymentsRequest) GetOffset() int32 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *ListPaymentsRequest) GetPage() int32 {
+	if x != nil {
+		return x.Page
+	}
+	return 0
+}
+
+func (x *ListPaymentsRequest) GetFilters() []*Filter {
+	if x != nil {
+		return x.Filters
+	}
+	return nil
+}
+
+type ListPaymentsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Total         int32                  `protobuf:"varint,1,opt,name=total,proto3" json:"total,omitempty"`
+	SubTotal      int32                  `protobuf:"varint,2,opt,name=sub_total,json=subTotal,proto3" json:"sub_total,omitempty"`
+	Payments      []*Payment             `protobuf:"bytes,3,rep,name=payments,proto3" json:"payments,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPaymentsResponse) Reset() {
+	*x = ListPaymentsResponse{}
+	mi := &file_payments_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPaymentsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPaymentsResponse) ProtoMessage() {}
+
+func (x *ListPaymentsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_payments_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPaymentsResponse.ProtoReflect.Descriptor instead.
+func (*ListPaymentsResponse) Descriptor() ([]byte, []int) {
+	return file_payments_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListPaymentsResponse) GetTotal() int32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *ListPaymentsResponse) GetSubTotal() int32 {
+	if x != nil {
+		return x.SubTotal
+	}
+	return 0
+}
+
+func (x *ListPaymentsResponse) GetPayments() []*Payment {
+	if x != nil {
+		return x.Payments
+	}
+	return nil
+}
+
+type SavePaymentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Payment       *Payment               `protobuf:"bytes,1,opt,name=payment,proto3" json:"payment,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SavePaymentRequest) Reset() {
+	*x = SavePaymentRequest{}
+	mi := &file_payments_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SavePaymentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SavePaymentRequest) ProtoMessage() {}
+
+func (x *SavePaymentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_payments_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SavePaymentRequest.ProtoReflect.Descriptor instead.
+func (*SavePaymentRequest) Descriptor() ([]byte, []int) {
+	return file_payments_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *SavePaymentRequest) GetPayment() *Payment {
+	if x != nil {
+		return x.Payment
+	}
+	return nil
+}
+
+type DeletePaymentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeletePaymentRequest) Reset() {
+	*x = DeletePaymentRequest{}
+	mi := &file_payments_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeletePaymentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeletePaymentRequest) ProtoMessage() {}
+
+func (x *DeletePaymentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_payments_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeletePaymentRequest.ProtoReflect.Descriptor instead.
+func (*DeletePaymentRequest) Descriptor() ([]byte, []int) {
+	return file_payments_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *DeletePaymentRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+// WatchChangesRequest resumes after last_sequence when it is set. When some of
+// the events after it are no longer kept by the server, a change with reset
+// set and no payment is sent first and the payments should be reloaded.
+type WatchChangesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Filters       []*Filter              `protobuf:"bytes,1,rep,name=filters,proto3" json:"filters,omitempty"`
+	LastSequence  *int64                 `protobuf:"varint,2,opt,name=last_sequence,json=lastSequence,proto3,oneof" json:"last_sequence,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchChangesRequest) Reset() {
+	*x = WatchChangesRequest{}
+	mi := &file_payments_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchChangesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchChangesRequest) ProtoMessage() {}
+
+func (x *WatchChangesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_payments_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchChangesRequest.ProtoReflect.Descriptor instead.
+func (*WatchChangesRequest) Descriptor() ([]byte, []int) {
+	return file_payments_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *WatchChangesRequest) GetFilters() []*Filter {
+	if x != nil {
+		return x.Filters
+	}
+	return nil
+}
+
+func (x *WatchChangesRequest) GetLastSequence() int64 {
+	if x != nil && x.LastSequence != nil {
+		return *x.LastSequence
+	}
+	return 0
+}
+
+type PaymentChange struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sequence      int64                  `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Id            string                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Type          string                 `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"`
+	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	Payment       *Payment               `protobuf:"bytes,5,opt,name=payment,proto3" json:"payment,omitempty"`
+	Reset_        bool                   `protobuf:"varint,6,opt,name=reset,proto3" json:"reset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PaymentChange) Reset() {
+	*x = PaymentChange{}
+	mi := &file_payments_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PaymentChange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PaymentChange) ProtoMessage() {}
+
+func (x *PaymentChange) ProtoReflect() protoreflect.Message {
+	mi := &file_payments_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PaymentChange.ProtoReflect.Descriptor instead.
+func (*PaymentChange) Descriptor() ([]byte, []int) {
+	return file_payments_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *PaymentChange) GetSequence() int64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *PaymentChange) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *PaymentChange) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *PaymentChange) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *PaymentChange) GetPayment() *Payment {
+	if x != nil {
+		return x.Payment
+	}
+	return nil
+}
+
+func (x *PaymentChange) GetReset_() bool {
+	if x != nil {
+		return x.Reset_
+	}
+	return false
+}
+
+var File_payments_proto protoreflect.FileDescriptor
+
+const file_payments_proto_rawDesc = "" +
+	"\n" +
+	"\x0epayments.proto\x12\vpayments.v1\x1a\x1bgoogle/protobuf/empty.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xf1\x01\n" +
+	"\fPaymentParty\x12!\n" +
+	"\faccount_name\x18\x01 \x01(\tR\vaccountName\x12%\n" +
+	"\x0eaccount_number\x18\x02 \x01(\tR\raccountNumber\x12.\n" +
+	"\x13account_number_code\x18\x03 \x01(\tR\x11accountNumberCode\x12\x17\n" +
+	"\abank_id\x18\x04 \x01(\tR\x06bankId\x12 \n" +
+	"\fbank_id_code\x18\x05 \x01(\tR\n" +
+	"bankIdCode\x12\x12\n" +
+	"\x04name\x18\x06 \x01(\tR\x04name\x12\x18\n" +
+	"\aaddress\x18\a \x01(\tR\aaddress\"B\n" +
+	"\fSenderCharge\x12\x16\n" +
+	"\x06amount\x18\x01 \x01(\tR\x06amount\x12\x1a\n" +
+	"\bcurrency\x18\x02 \x01(\tR\bcurrency\"\xeb\x01\n" +
+	"\x12ChargesInformation\x12\x1f\n" +
+	"\vbearer_code\x18\x01 \x01(\tR\n" +
+	"bearerCode\x126\n" +
+	"\x17receiver_charges_amount\x18\x02 \x01(\tR\x15receiverChargesAmount\x12:\n" +
+	"\x19receiver_charges_currency\x18\x03 \x01(\tR\x17receiverChargesCurrency\x12@\n" +
+	"\x0esender_charges\x18\x04 \x03(\v2\x19.payments.v1.SenderChargeR\rsenderCharges\"\xae\x01\n" +
+	"\x02FX\x12-\n" +
+	"\x12contract_reference\x18\x01 \x01(\tR\x11contractReference\x12#\n" +
+	"\rexchange_rate\x18\x02 \x01(\tR\fexchangeRate\x12'\n" +
+	"\x0foriginal_amount\x18\x03 \x01(\tR\x0eoriginalAmount\x12+\n" +
+	"\x11original_currency\x18\x04 \x01(\tR\x10originalCurrency\"\x85\x06\n" +
+	"\aPayment\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x129\n" +
+	"\n" +
+	"created_at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"updated_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\x12\x18\n" +
+	"\apurpose\x18\x04 \x01(\tR\apurpose\x12\x16\n" +
+	"\x06scheme\x18\x05 \x01(\tR\x06scheme\x12\x12\n" +
+	"\x04type\x18\x06 \x01(\tR\x04type\x12\x16\n" +
+	"\x06amount\x18\a \x01(\tR\x06amount\x12;\n" +
+	"\vbeneficiary\x18\b \x01(\v2\x19.payments.v1.PaymentPartyR\vbeneficiary\x12\x1a\n" +
+	"\bcurrency\x18\t \x01(\tR\bcurrency\x12>\n" +
+	"\rdebitor_party\x18\n" +
+	" \x01(\v2\x19.payments.v1.PaymentPartyR\fdebitorParty\x12/\n" +
+	"\x14end_to_end_reference\x18\v \x01(\tR\x11endToEndReference\x12+\n" +
+	"\x11numeric_reference\x18\f \x01(\tR\x10numericReference\x12'\n" +
+	"\x0fprocessing_date\x18\r \x01(\tR\x0eprocessingDate\x12\x1c\n" +
+	"\treference\x18\x0e \x01(\tR\treference\x125\n" +
+	"\x17scheme_payment_sub_type\x18\x0f \x01(\tR\x14schemePaymentSubType\x12.\n" +
+	"\x13scheme_payment_type\x18\x10 \x01(\tR\x11schemePaymentType\x12P\n" +
+	"\x13charges_information\x18\x11 \x01(\v2\x1f.payments.v1.ChargesInformationR\x12chargesInformation\x12\x1f\n" +
+	"\x02fx\x18\x12 \x01(\v2\x0f.payments.v1.FXR\x02fx\"6\n" +
+	"\x06Filter\x12\x14\n" +
+	"\x05field\x18\x01 \x01(\tR\x05field\x12\x16\n" +
+	"\x06values\x18\x02 \x03(\tR\x06values\"#\n" +
+	"\x11GetPaymentRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\x86\x01\n" +
+	"\x13ListPaymentsRequest\x12\x14\n" +
+	"\x05limit\x18\x01 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x05R\x06offset\x12\x12\n" +
+	"\x04page\x18\x03 \x01(\x05R\x04page\x12-\n" +
+	"\afilters\x18\x04 \x03(\v2\x13.payments.v1.FilterR\afilters\"{\n" +
+	"\x14ListPaymentsResponse\x12\x14\n" +
+	"\x05total\x18\x01 \x01(\x05R\x05total\x12\x1b\n" +
+	"\tsub_total\x18\x02 \x01(\x05R\bsubTotal\x120\n" +
+	"\bpayments\x18\x03 \x03(\v2\x14.payments.v1.PaymentR\bpayments\"D\n" +
+	"\x12SavePaymentRequest\x12.\n" +
+	"\apayment\x18\x01 \x01(\v2\x14.payments.v1.PaymentR\apayment\"&\n" +
+	"\x14DeletePaymentRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\x80\x01\n" +
+	"\x13WatchChangesRequest\x12-\n" +
+	"\afilters\x18\x01 \x03(\v2\x13.payments.v1.FilterR\afilters\x12(\n" +
+	"\rlast_sequence\x18\x02 \x01(\x03H\x00R\flastSequence\x88\x01\x01B\x10\n" +
+	"\x0e_last_sequence\"\xd0\x01\n" +
+	"\rPaymentChange\x12\x1a\n" +
+	"\bsequence\x18\x01 \x01(\x03R\bsequence\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\x12\x12\n" +
+	"\x04type\x18\x03 \x01(\tR\x04type\x129\n" +
+	"\n" +
+	"created_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x12.\n" +
+	"\apayment\x18\x05 \x01(\v2\x14.payments.v1.PaymentR\apayment\x12\x14\n" +
+	"\x05reset\x18\x06 \x01(\bR\x05reset2\xee\x02\n" +
+	"\x0ePaymentService\x12;\n" +
+	"\x03Get\x12\x1e.payments.v1.GetPaymentRequest\x1a\x14.payments.v1.Payment\x12K\n" +
+	"\x04List\x12 .payments.v1.ListPaymentsRequest\x1a!.payments.v1.ListPaymentsResponse\x12=\n" +
+	"\x04Save\x12\x1f.payments.v1.SavePaymentRequest\x1a\x14.payments.v1.Payment\x12C\n" +
+	"\x06Delete\x12!.payments.v1.DeletePaymentRequest\x1a\x16.google.protobuf.Empty\x12N\n" +
+	"\fWatchChanges\x12 .payments.v1.WatchChangesRequest\x1a\x1a.payments.v1.PaymentChange0\x01B%Z#github.com/ganitzsh/f3-te/api/pb;pbb\x06proto3"
+
+var (
+	file_payments_proto_rawDescOnce sync.Once
+	file_payments_proto_rawDescData []byte
+)
+
+func file_payments_proto_rawDescGZIP() []byte {
+	file_payments_proto_rawDescOnce.Do(func() {
+		file_payments_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_payments_proto_rawDesc), len(file_payments_proto_rawDesc)))
+	})
+	return file_payments_proto_rawDescData
+}
+
+var file_payments_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
+var file_payments_proto_goTypes = []any{
+	(*PaymentParty)(nil),          // 0: payments.v1.PaymentParty
+	(*SenderCharge)(nil),          // 1: payments.v1.SenderCharge
+	(*ChargesInformation)(nil),    // 2: payments.v1.ChargesInformation
+	(*FX)(nil),                    // 3: payments.v1.FX
+	(*Payment)(nil),               // 4: payments.v1.Payment
+	(*Filter)(nil),                // 5: payments.v1.Filter
+	(*GetPaymentRequest)(nil),     // 6: payments.v1.GetPaymentRequest
+	(*ListPaymentsRequest)(nil),   // 7: payments.v1.ListPaymentsRequest
+	(*ListPaymentsResponse)(nil),  // 8: payments.v1.ListPaymentsResponse
+	(*SavePaymentRequest)(nil),    // 9: payments.v1.SavePaymentRequest
+	(*DeletePaymentRequest)(nil),  // 10: payments.v1.DeletePaymentRequest
+	(*WatchChangesRequest)(nil),   // 11: payments.v1.WatchChangesRequest
+	(*PaymentChange)(nil),         // 12: payments.v1.PaymentChange
+	(*timestamppb.Timestamp)(nil), // 13: google.protobuf.Timestamp
+	(*emptypb.Empty)(nil),         // 14: google.protobuf.Empty
+}
+var file_payments_proto_depIdxs = []int32{
+	1,  // 0: payments.v1.ChargesInformation.sender_charges:type_name -> payments.v1.SenderCharge
+	13, // 1: payments.v1.Payment.created_at:type_name -> google.protobuf.Timestamp
+	13, // 2: payments.v1.Payment.updated_at:type_name -> google.protobuf.Timestamp
+	0,  // 3: payments.v1.Payment.beneficiary:type_name -> payments.v1.PaymentParty
+	0,  // 4: payments.v1.Payment.debitor_party:type_name -> payments.v1.PaymentParty
+	2,  // 5: payments.v1.Payment.charges_information:type_name -> payments.v1.ChargesInformation
+	3,  // 6: payments.v1.Payment.fx:type_name -> payments.v1.FX
+	5,  // 7: payments.v1.ListPaymentsRequest.filters:type_name -> payments.v1.Filter
+	4,  // 8: payments.v1.ListPaymentsResponse.payments:type_name -> payments.v1.Payment
+	4,  // 9: payments.v1.SavePaymentRequest.payment:type_name -> payments.v1.Payment
+	5,  // 10: payments.v1.WatchChangesRequest.filters:type_name -> payments.v1.Filter
+	13, // 11: payments.v1.PaymentChange.created_at:type_name -> google.protobuf.Timestamp
+	4,  // 12: payments.v1.PaymentChange.payment:type_name -> payments.v1.Payment
+	6,  // 13: payments.v1.PaymentService.Get:input_type -> payments.v1.GetPaymentRequest
+	7,  // 14: payments.v1.PaymentService.List:input_type -> payments.v1.ListPaymentsRequest
+	9,  // 15: payments.v1.PaymentService.Save:input_type -> payments.v1.SavePaymentRequest
+	10, // 16: payments.v1.PaymentService.Delete:input_type -> payments.v1.DeletePaymentRequest
+	11, // 17: payments.v1.PaymentService.WatchChanges:input_type -> payments.v1.WatchChangesRequest
+	4,  // 18: payments.v1.PaymentService.Get:output_type -> payments.v1.Payment
+	8,  // 19: payments.v1.PaymentService.List:output_type -> payments.v1.ListPaymentsResponse
+	4,  // 20: payments.v1.PaymentService.Save:output_type -> payments.v1.Payment
+	14, // 21: payments.v1.PaymentService.Delete:output_type -> google.protobuf.Empty
+	12, // 22: payments.v1.PaymentService.WatchChanges:output_type -> payments.v1.PaymentChange
+	18, // [18:23] is the sub-list for method output_type
+	13, // [13:18] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
+}
+
+func init() { file_payments_proto_init() }
+func file_payments_proto_init() {
+	if File_payments_proto != nil {
+		return
+	}
+	file_payments_proto_msgTypes[11].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_payments_proto_rawDesc), len(file_payments_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   13,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_payments_proto_goTypes,
+		DependencyIndexes: file_payments_proto_depIdxs,
+		MessageInfos:      file_payments_proto_msgTypes,
+	}.Build()
+	File_payments_proto = out.File
+	file_payments_proto_goTypes = nil
+	file_payments_proto_depIdxs = nil
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var limiter RateLimiter = NewInMemRateLimiter()
+
+// RateLimitResult describes the state of a bucket after a request has been
+// accounted for
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+
+	// Reset is the time left before the bucket is full again
+	Reset time.Duration
+
+	// RetryAfter is the time left before the next request is allowed, it is
+	// only set when the request is not allowed
+	RetryAfter time.Duration
+}
+
+// RateLimiter defines what a rate limiter should be able to do. The in memory
+// implementation only works for a single node, deployments with several nodes
+// should plug a shared implementation with SetRateLimiter.
+type RateLimiter interface {
+	// Take should consume a token from the bucket identified by key and return
+	// the state of the bucket
+	Take(key string, budget *RateBudget) (*RateLimitResult, error)
+}
+
+// SetRateLimiter replaces the rate limiter used by the API
+func SetRateLimiter(l RateLimiter) {
+	limiter = l
+}
+
+// tokenBucket keeps the period of its budget, the buckets of the reads and of
+// the writes are pruned according to their own budget
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	period time.Duration
+}
+
+// InMemRateLimiter is a token bucket rate limiter keeping its buckets in
+// memory. Buckets are refilled continuously at Limit tokens per Period.
+type InMemRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+
+	// Now returns the current time and can be overridden for testing
+	Now func() time.Time
+}
+
+func NewInMemRateLimiter() *InMemRateLimiter {
+	return &InMemRateLimiter{
+		buckets: map[string]*tokenBucket{},
+		Now:     time.Now,
+	}
+}
+
+// prune removes the buckets that have been idle long enough to be full again,
+// at most once per period of the current budget
+func (l *InMemRateLimiter) prune(now time.Time, period time.Duration) {
+	if now.Sub(l.lastPrune) < period {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= b.period {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
+
+func (l *InMemRateLimiter) Take(key string, budget *RateBudget) (*RateLimitResult, error) {
+	if budget == nil || budget.Limit <= 0 || budget.Period <= 0 {
+		return &RateLimitResult{Allowed: true}, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.Now()
+	l.prune(now, budget.Period)
+	limit := float64(budget.Limit)
+	rate := limit / budget.Period.Seconds()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: limit, last: now, period: budget.Period}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(limit, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	b.period = budget.Period
+	ret := &RateLimitResult{Limit: budget.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		ret.Allowed = true
+	} else {
+		ret.RetryAfter = secondsToDuration((1 - b.tokens) / rate)
+	}
+	ret.Remaining = int(b.tokens)
+	ret.Reset = secondsToDuration((limit - b.tokens) / rate)
+	return ret, nil
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// ceilSeconds formats a duration as a number of seconds rounded up, as used
+// by the RateLimit-* and Retry-After headers
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// rateLimitKey identifies the client of the request according to the
+// configuration. Clients are identified by the id of their verified signature,
+// the unauthenticated headers are never trusted. It falls back on the IP of
+// remoteAddr when the request is not signed, such as the reads.
+func rateLimitKey(settings *RateLimitSettings, client, remoteAddr string) string {
+	if settings.Key == RateLimitKeyClient && client != "" {
+		return "client:" + client
+	}
+	return "ip:" + remoteIP(remoteAddr)
+}
+
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// takeRateLimit consumes a token from the read or write budget of the client,
+// the REST and gRPC APIs share the buckets
+func takeRateLimit(settings *RateLimitSettings, write bool, client, remoteAddr string) (*RateLimitResult, error) {
+	kind, budget := "read", settings.Read
+	if write {
+		kind, budget = "write", settings.Write
+	}
+	return limiter.Take(kind+":"+rateLimitKey(settings, client, remoteAddr), budget)
+}
+
+// takeIPRateLimit consumes a token from the budget of the IP of remoteAddr,
+// whatever the request. It runs before the signature is verified: the
+// requests failing the verification are limited as well.
+func takeIPRateLimit(settings *RateLimitSettings, remoteAddr string) (*RateLimitResult, error) {
+	return limiter.Take("any:ip:"+remoteIP(remoteAddr), settings.IP)
+}
+
+// rateLimitEnabled tells whether the requests are rate limited
+func rateLimitEnabled() bool {
+	return config != nil && config.RateLimit != nil && config.RateLimit.Enabled
+}
+
+// writeRateLimit sets the RateLimit-* headers of the response and answers
+// 429 when the request is not allowed, in which case it returns false
+func writeRateLimit(w http.ResponseWriter, r *http.Request, res *RateLimitResult, err error) bool {
+	if err != nil {
+		handleError(w, r, err)
+		return false
+	}
+	if res.Limit > 0 {
+		w.Header().Set(HeaderRateLimitLimit, strconv.Itoa(res.Limit))
+		w.Header().Set(HeaderRateLimitRemaining, strconv.Itoa(res.Remaining))
+		w.Header().Set(HeaderRateLimitReset, ceilSeconds(res.Reset))
+	}
+	if !res.Allowed {
+		w.Header().Set(HeaderRetryAfter, ceilSeconds(res.RetryAfter))
+		handleError(w, r, ErrRateLimited)
+		return false
+	}
+	return true
+}
+
+// ipRateLimited is a middleware limiting the amount of requests of every IP
+// before their signature is verified, see takeIPRateLimit. It answers like
+// rateLimited.
+func ipRateLimited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rateLimitEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		res, err := takeIPRateLimit(config.RateLimit, r.RemoteAddr)
+		if writeRateLimit(w, r, res, err) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// rateLimited is a middleware limiting the amount of requests a client can
+// make. Reads and writes have separate budgets. When the budget is exhausted
+// it will return the following body:
+//   {
+//     "data": {
+//       "error": "Too many requests",
+//       "code": "rate_limited"
+//     },
+//     "code": 429,
+//     "status": "fail"
+//   }
+func rateLimited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rateLimitEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		write := true
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			write = false
+		}
+		res, err := takeRateLimit(config.RateLimit, write, signedClient(r.Context()), r.RemoteAddr)
+		if writeRateLimit(w, r, res, err) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMemRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := api.NewInMemRateLimiter()
+	l.Now = func() time.Time { return now }
+	budget := &api.RateBudget{Limit: 2, Period: 2 * time.Second}
+
+	res, err := l.Take("a", budget)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+	res, _ = l.Take("a", budget)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	res, _ = l.Take("a", budget)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, time.Second, res.RetryAfter)
+
+	res, _ = l.Take("b", budget)
+	assert.True(t, res.Allowed)
+
+	now = now.Add(time.Second)
+	res, _ = l.Take("a", budget)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+}
+
+func TestInMemRateLimiterPeriods(t *testing.T) {
+	now := time.Now()
+	l := api.NewInMemRateLimiter()
+	l.Now = func() time.Time { return now }
+	read := &api.RateBudget{Limit: 10, Period: time.Second}
+	write := &api.RateBudget{Limit: 1, Period: time.Hour}
+
+	res, _ := l.Take("write:a", write)
+	assert.True(t, res.Allowed)
+
+	// the reads prune the buckets idle for their own period, not for the
+	// period of the reads
+	now = now.Add(2 * time.Second)
+	res, _ = l.Take("read:a", read)
+	assert.True(t, res.Allowed)
+	res, _ = l.Take("write:a", write)
+	assert.False(t, res.Allowed)
+
+	now = now.Add(time.Hour)
+	res, _ = l.Take("read:a", read)
+	assert.True(t, res.Allowed)
+	res, _ = l.Take("write:a", write)
+	assert.True(t, res.Allowed)
+}
+
+func TestRateLimited(t *testing.T) {
+	prev := api.Config().RateLimit
+	defer func() {
+		api.Config().RateLimit = prev
+		api.SetRateLimiter(api.NewInMemRateLimiter())
+	}()
+	api.Config().RateLimit = &api.RateLimitSettings{
+		Enabled: true,
+		Key:     api.RateLimitKeyClient,
+		Read:    &api.RateBudget{Limit: 2, Period: time.Minute},
+		Write:   &api.RateBudget{Limit: 1, Period: time.Minute},
+	}
+	api.SetRateLimiter(api.NewInMemRateLimiter())
+	api.SetStore(api.NewPaymentInMemStore())
+	handler := api.Routes()
+
+	get := func(key string) *http.Response {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/v1/payments", nil)
+		req.Header.Add(api.HeaderContentType, "application/json")
+		req.Header.Add(api.HeaderAPIKey, key)
+		handler.ServeHTTP(rr, req)
+		return rr.Result()
+	}
+
+	resp := get("a")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get(api.HeaderRateLimitLimit))
+	assert.Equal(t, "1", resp.Header.Get(api.HeaderRateLimitRemaining))
+	assert.Equal(t, http.StatusOK, get("a").StatusCode)
+	resp = get("a")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "30", resp.Header.Get(api.HeaderRetryAfter))
+	assert.Equal(t, api.ErrorCodeRateLimited, readErrorCode(readBody(resp)))
+	// the headers are not authenticated, the unsigned requests are limited
+	// by IP whatever their key
+	assert.Equal(t, http.StatusTooManyRequests, get("b").StatusCode)
+
+	b := `{"amount": "42"}`
+	resp = doHTTPReq(handler, http.MethodPost, "/v1/payments", b)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp = doHTTPReq(handler, http.MethodPost, "/v1/payments", b)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// the signed requests are limited by client
+	defer enableSignature()()
+	resp, _ = doReq(handler, newSignedReq(t, testClientID, testClientSecret, b))
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, _ = doReq(handler, newSignedReq(t, testClientID, testClientSecret, b))
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
+
+func TestIPRateLimited(t *testing.T) {
+	prev := api.Config().RateLimit
+	defer func() {
+		api.Config().RateLimit = prev
+		api.SetRateLimiter(api.NewInMemRateLimiter())
+	}()
+	api.Config().RateLimit = &api.RateLimitSettings{
+		Enabled: true,
+		Key:     api.RateLimitKeyClient,
+		Read:    &api.RateBudget{Limit: 10, Period: time.Minute},
+		Write:   &api.RateBudget{Limit: 10, Period: time.Minute},
+		IP:      &api.RateBudget{Limit: 2, Period: time.Minute},
+	}
+	api.SetRateLimiter(api.NewInMemRateLimiter())
+	api.SetStore(api.NewPaymentInMemStore())
+	defer enableSignature()()
+	handler := api.Routes()
+
+	// the requests failing the signature are limited as well
+	b := `{"amount": "42"}`
+	for i := 0; i < 2; i++ {
+		resp := doHTTPReq(handler, http.MethodPost, "/v1/payments", b)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	resp, _ := doReq(handler, newSignedReq(t, testClientID, testClientSecret, b))
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get(api.HeaderRateLimitLimit))
+	assert.Equal(t, "30", resp.Header.Get(api.HeaderRetryAfter))
+}
+
+func TestRateLimitSettingsValidate(t *testing.T) {
+	s := &api.RateLimitSettings{Enabled: true, Key: api.RateLimitKeyIP}
+	assert.NoError(t, s.Validate())
+	s.Key = "api_key"
+	assert.Error(t, s.Validate())
+	s.Enabled = false
+	assert.NoError(t, s.Validate())
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api/wire"
+)
+
+// Requests altering payments can be signed by the clients with a shared
+// secret, the signature is defined in the wire package with SignRequest
+
+const (
+	SignatureAlgorithm = wire.SignatureAlgorithm
+	DigestPrefix       = wire.DigestPrefix
+)
+
+var signatureNonces = newNonceCache()
+
+// nonceCache remembers the nonces that have been seen during the signature
+// window in order to reject replayed requests. The nonces are queued by
+// expiry as well, the expired ones are dropped from the head of the queue.
+type nonceCache struct {
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	expiry nonceQueue
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{
+		seen: map[string]time.Time{},
+	}
+}
+
+// Add records the nonce until expiry and returns false if it was already
+// known. Expired nonces are pruned on the way.
+func (c *nonceCache) Add(nonce string, now time.Time, expiry time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.expiry) > 0 && now.After(c.expiry[0].expiry) {
+		head := heap.Pop(&c.expiry).(nonceEntry)
+		if c.seen[head.nonce].Equal(head.expiry) {
+			delete(c.seen, head.nonce)
+		}
+	}
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	c.seen[nonce] = expiry
+	heap.Push(&c.expiry, nonceEntry{nonce: nonce, expiry: expiry})
+	return true
+}
+
+type nonceEntry struct {
+	nonce  string
+	expiry time.Time
+}
+
+// nonceQueue is a heap of nonces, the first one expires first
+type nonceQueue []nonceEntry
+
+func (q nonceQueue) Len() int            { return len(q) }
+func (q nonceQueue) Less(i, j int) bool  { return q[i].expiry.Before(q[j].expiry) }
+func (q nonceQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *nonceQueue) Push(x interface{}) { *q = append(*q, x.(nonceEntry)) }
+
+func (q *nonceQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// BodyDigest returns the value of the Digest header for the given body
+func BodyDigest(body []byte) string {
+	return wire.BodyDigest(body)
+}
+
+// SignRequest sets the Date, Digest, X-Nonce and Signature headers on the
+// request. The body is read and replaced so the request can still be sent.
+func SignRequest(r *http.Request, clientID, secret, nonce string) error {
+	return wire.SignRequest(r, clientID, secret, nonce)
+}
+
+// parseSignature reads the Signature header and returns its parameters
+func parseSignature(value string) map[string]string {
+	ret := map[string]string{}
+	for _, part := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		ret[kv[0]] = strings.Trim(kv[1], `"`)
+	}
+	return ret
+}
+
+// verifyRequest checks the signature of the request and returns the id of the
+// signing client, or the matching APIError on failure. The body is read and put
+// back for the next handlers.
+func verifyRequest(r *http.Request, settings *SignatureSettings, now time.Time) (string, error) {
+	header := r.Header.Get(HeaderSignature)
+	if header == "" {
+		return "", ErrSignatureMissing
+	}
+	params := parseSignature(header)
+	if params["keyId"] == "" || params["signature"] == "" {
+		return "", ErrSignatureMissing
+	}
+	if alg := params["algorithm"]; alg != "" && alg != SignatureAlgorithm {
+		return "", ErrSignatureInvalid
+	}
+	secret, ok := settings.Clients[params["keyId"]]
+	if !ok {
+		return "", ErrUnknownClient
+	}
+	date, err := http.ParseTime(r.Header.Get(HeaderDate))
+	if err != nil {
+		return "", ErrRequestExpired
+	}
+	if date.Before(now.Add(-settings.Window)) || date.After(now.Add(settings.Window)) {
+		return "", ErrRequestExpired
+	}
+	var body []byte
+	if r.Body != nil {
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			if apiErr, ok := err.(*APIError); ok {
+				return "", apiErr
+			}
+			return "", ErrSomethingWentWrong(err)
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if !hmac.Equal([]byte(r.Header.Get(HeaderDigest)), []byte(BodyDigest(body))) {
+		return "", ErrDigestMismatch
+	}
+	signature, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil || !hmac.Equal(signature, wire.ComputeSignature(r, secret)) {
+		return "", ErrSignatureInvalid
+	}
+	nonce := r.Header.Get(HeaderNonce)
+	if nonce == "" {
+		return "", ErrSignatureMissing
+	}
+	if !signatureNonces.Add(params["keyId"]+":"+nonce, now, date.Add(settings.Window)) {
+		return "", ErrReplayedRequest
+	}
+	return params["keyId"], nil
+}
+
+// signedClient returns the id of the client whose signature was verified by
+// signedRequest, empty when the request was not signed
+func signedClient(ctx context.Context) string {
+	client, _ := ctx.Value(ctxKeyClient).(string)
+	return client
+}
+
+// signedRequest is a middleware verifying the signature of the requests that
+// alter data when signing is enabled in the configuration. Read only requests
+// are let through. The id of the verified client is put in the context, see
+// signedClient.
+func signedRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config == nil || config.Signature == nil || !config.Signature.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		client, err := verifyRequest(r, config.Signature, time.Now())
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKeyClient, client)))
+	})
+}
@@ -0,0 +1,106 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testClientID     = "client"
+	testClientSecret = "secret"
+)
+
+func enableSignature() func() {
+	prev := api.Config().Signature
+	api.Config().Signature = &api.SignatureSettings{
+		Enabled: true,
+		Window:  time.Minute,
+		Clients: map[string]string{testClientID: testClientSecret},
+	}
+	return func() {
+		api.Config().Signature = prev
+	}
+}
+
+func newSignedReq(t *testing.T, clientID, secret, body string) *http.Request {
+	req, _ := http.NewRequest(http.MethodPost, "/v1/payments", bytes.NewBufferString(body))
+	req.Header.Add(api.HeaderContentType, "application/json")
+	if !assert.NoError(t, api.SignRequest(req, clientID, secret, uuid.New().String())) {
+		t.FailNow()
+	}
+	return req
+}
+
+func doReq(handler http.Handler, req *http.Request) (*http.Response, []byte) {
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	resp := rr.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp, body
+}
+
+func TestSignedRequest(t *testing.T) {
+	defer enableSignature()()
+	api.SetStore(api.NewPaymentInMemStore())
+	handler := api.Routes()
+	b, _ := json.Marshal(newMockPayment())
+
+	resp, body := doReq(handler, newSignedReq(t, testClientID, testClientSecret, string(b)))
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, api.ErrorCode(""), readErrorCode(body))
+
+	resp = doHTTPReq(handler, http.MethodGet, "/v1/payments", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestSignedRequestFailures(t *testing.T) {
+	defer enableSignature()()
+	api.SetStore(api.NewPaymentInMemStore())
+	handler := api.Routes()
+	b, _ := json.Marshal(newMockPayment())
+
+	resp := doHTTPReq(handler, http.MethodPost, "/v1/payments", string(b))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, api.ErrorCodeSignatureMissing, readErrorCode(body))
+
+	resp, body = doReq(handler, newSignedReq(t, "unknown", testClientSecret, string(b)))
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, api.ErrorCodeUnknownClient, readErrorCode(body))
+
+	resp, body = doReq(handler, newSignedReq(t, testClientID, "wrong", string(b)))
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, api.ErrorCodeSignatureInvalid, readErrorCode(body))
+
+	req := newSignedReq(t, testClientID, testClientSecret, string(b))
+	req.Body = ioutil.NopCloser(bytes.NewBufferString(`{"amount":"1"}`))
+	resp, body = doReq(handler, req)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, api.ErrorCodeDigestMismatch, readErrorCode(body))
+
+	req, _ = http.NewRequest(http.MethodPost, "/v1/payments", bytes.NewBuffer(b))
+	req.Header.Add(api.HeaderContentType, "application/json")
+	req.Header.Set(api.HeaderDate, time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	api.SignRequest(req, testClientID, testClientSecret, uuid.New().String())
+	resp, body = doReq(handler, req)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, api.ErrorCodeRequestExpired, readErrorCode(body))
+
+	req = newSignedReq(t, testClientID, testClientSecret, string(b))
+	replay, _ := http.NewRequest(http.MethodPost, "/v1/payments", bytes.NewBuffer(b))
+	replay.Header = req.Header
+	resp, _ = doReq(handler, req)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, body = doReq(handler, replay)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, api.ErrorCodeReplayedRequest, readErrorCode(body))
+}
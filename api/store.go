@@ -1,7 +1,11 @@
 package api
 
 import (
+	"context"
 	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -16,24 +20,127 @@ type PaginatedList struct {
 	Results  interface{} `json:"results"`
 }
 
-// PaymentStore defines what a PaymentStore should be able to do
+// PaymentStore defines what a PaymentStore should be able to do. The methods
+// should give up and return the error of ctx once it is done.
 type PaymentStore interface {
 	// Total should return the total of payments in the data store and return 0
 	// on error
-	Total() int
+	Total(ctx context.Context) int
 
 	// GetMany will take different parameters and should return a list of Payments
 	// accordingly
-	GetMany(limit, offset int, filters ...*PaymentStoreFilter) (*PaginatedList, error)
+	GetMany(ctx context.Context, limit, offset int, filters ...*PaymentStoreFilter) (*PaginatedList, error)
 
 	// GetByID should return a single payment corresponding to the given ID
-	GetByID(id uuid.UUID) (*Payment, error)
+	GetByID(ctx context.Context, id uuid.UUID) (*Payment, error)
 
 	// Save should create or update a Payment
-	Save(p *Payment) error
+	Save(ctx context.Context, p *Payment) error
 
 	// Delete should remove a Payment from the data source
-	Delete(id uuid.UUID) error
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// Sortable fields of the payments that are not strings
+const (
+	PaymentSortCreatedAt = "createdAt"
+	PaymentSortUpdatedAt = "updatedAt"
+)
+
+// PaymentSort sorts the payments on a field, Field is the JSON name of either
+// a filterable field, createdAt or updatedAt
+type PaymentSort struct {
+	Field string
+	Desc  bool
+}
+
+// SortedPaymentStore is implemented by the stores able to sort the payments
+// themselves
+type SortedPaymentStore interface {
+	GetManySorted(ctx context.Context, limit, offset int, sorts []PaymentSort, filters ...*PaymentStoreFilter) (*PaginatedList, error)
+}
+
+// GetManySorted lists the payments of s sorted by the given fields, in order.
+// When s can not sort, all the matching payments are fetched, sorted and
+// paginated.
+func GetManySorted(
+	ctx context.Context,
+	s PaymentStore,
+	limit, offset int,
+	sorts []PaymentSort,
+	filters ...*PaymentStoreFilter,
+) (*PaginatedList, error) {
+	if sorted, ok := s.(SortedPaymentStore); ok {
+		return sorted.GetManySorted(ctx, limit, offset, sorts, filters...)
+	}
+	list, err := s.GetMany(ctx, 0, 0, filters...)
+	if err != nil {
+		return nil, err
+	}
+	payments, _ := list.Results.([]*Payment)
+	sortPayments(payments, sorts)
+	return paginatePayments(list, payments, limit, offset), nil
+}
+
+// paginatePayments sets the page of payments in the results of list
+func paginatePayments(list *PaginatedList, payments []*Payment, limit, offset int) *PaginatedList {
+	if offset > len(payments) {
+		offset = len(payments)
+	}
+	payments = payments[offset:]
+	if limit > 0 && limit < len(payments) {
+		payments = payments[:limit]
+	}
+	list.Results = payments
+	list.SubTotal = len(payments)
+	return list
+}
+
+// comparePayments returns a negative number when a comes before b
+func comparePayments(a, b *Payment, field string) int {
+	switch field {
+	case PaymentSortCreatedAt:
+		return compareTimes(a.CreatedAt, b.CreatedAt)
+	case PaymentSortUpdatedAt:
+		return compareTimes(a.UpdatedAt, b.UpdatedAt)
+	}
+	f, ok := LookupPaymentField(field)
+	if !ok {
+		return 0
+	}
+	return strings.Compare(f.Get(a), f.Get(b))
+}
+
+func compareTimes(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	case a.Before(*b):
+		return -1
+	case a.After(*b):
+		return 1
+	}
+	return 0
+}
+
+// sortPayments sorts the payments by the given fields, in order
+func sortPayments(payments []*Payment, sorts []PaymentSort) {
+	sort.SliceStable(payments, func(i, j int) bool {
+		for _, s := range sorts {
+			c := comparePayments(payments[i], payments[j], s.Field)
+			if s.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
 }
 
 type PaymentStoreFilterType uint
@@ -41,8 +148,17 @@ type PaymentStoreFilterType uint
 const (
 	PaymentStoreFilterTypeEqual = iota
 	PaymentStoreFilterTypeIn
+	PaymentStoreFilterTypeRange
 )
 
+// PaymentStoreRange is the Want of a range filter, the bounds are included and
+// an empty one is not checked. The values are compared as strings, the times
+// in the order of the time.
+type PaymentStoreRange struct {
+	From string
+	To   string
+}
+
 // PaymentStoreFilter defines a filter that can be applied to a store query
 type PaymentStoreFilter struct {
 	// Field is the litteral name of the field in the Payment
@@ -90,3 +206,42 @@ func (f PaymentStoreFilter) Match(has interface{}) (bool, error) {
 		return false, ErrUnknownFilterType
 	}
 }
+
+// filterValues returns the string values a filter accepts, ok is false when
+// the filter is not on strings
+func filterValues(f *PaymentStoreFilter) (values []string, ok bool) {
+	switch f.Type {
+	case PaymentStoreFilterTypeEqual:
+		s, ok := f.Want.(string)
+		return []string{s}, ok
+	case PaymentStoreFilterTypeIn:
+		v := reflect.ValueOf(f.Want)
+		if v.Kind() != reflect.Slice {
+			return nil, false
+		}
+		for i := 0; i < v.Len(); i++ {
+			s, ok := v.Index(i).Interface().(string)
+			if !ok {
+				return nil, false
+			}
+			values = append(values, s)
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// PaymentFilterFields maps the JSON name of the fields a Payment can be
+// filtered on to their Go name
+var PaymentFilterFields = checkPaymentFields()
+
+// MatchPayment returns true if the payment matches all the filters, false
+// when a filter does not compile. Compile the filters once with
+// CompileFilters to match many payments.
+func MatchPayment(p *Payment, filters ...*PaymentStoreFilter) bool {
+	match, err := CompileFilters(filters...)
+	if err != nil {
+		return false
+	}
+	return match(p)
+}
@@ -0,0 +1,340 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// This is an implementation of PaymentStore backed by an embedded single-file
+// key-value store. Every write is a transaction synced to the disk before
+// returning, a crash leaves the file as it was after the last committed one.
+//
+// The payments are stored by their insertion sequence so they are listed in
+// the same order as the other stores. The string fields a payment can be
+// filtered on have an index bucket whose keys are the value followed by the
+// sequence of the payment.
+
+var (
+	boltBucketPayments = []byte("payments")
+	boltBucketIDs      = []byte("ids")
+	boltIndexPrefix    = "index:"
+)
+
+const (
+	boltSeqSize = 8
+	// boltIndexSeparator separates the value from the sequence in the keys of
+	// the indexes
+	boltIndexSeparator = 0
+	// boltCheckEvery is the amount of payments listed between two checks of
+	// the context
+	boltCheckEvery = 1024
+)
+
+type PaymentBoltStore struct {
+	DB *bolt.DB
+}
+
+// NewPaymentBoltStore opens or creates the store at path. The file is locked
+// while the store is open, opening it from another process fails after
+// timeout.
+func NewPaymentBoltStore(path string, timeout time.Duration) (*PaymentBoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+	store := &PaymentBoltStore{DB: db}
+	if err := db.Update(store.init); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// init creates the buckets, the indexes missing from the file are built from
+// the payments already stored
+func (store *PaymentBoltStore) init(tx *bolt.Tx) error {
+	payments, err := tx.CreateBucketIfNotExists(boltBucketPayments)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.CreateBucketIfNotExists(boltBucketIDs); err != nil {
+		return err
+	}
+	for _, field := range PaymentFields {
+		name := []byte(boltIndexPrefix + field.GoName)
+		if tx.Bucket(name) != nil {
+			continue
+		}
+		index, err := tx.CreateBucket(name)
+		if err != nil {
+			return err
+		}
+		err = payments.ForEach(func(seq, data []byte) error {
+			p := &Payment{}
+			if err := json.Unmarshal(data, p); err != nil {
+				return err
+			}
+			return index.Put(boltIndexKey(field.Get(p), seq), nil)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the file
+func (store *PaymentBoltStore) Close() error {
+	return store.DB.Close()
+}
+
+func boltSeq(seq uint64) []byte {
+	ret := make([]byte, boltSeqSize)
+	binary.BigEndian.PutUint64(ret, seq)
+	return ret
+}
+
+func boltIndexKey(value string, seq []byte) []byte {
+	ret := make([]byte, 0, len(value)+1+boltSeqSize)
+	ret = append(ret, value...)
+	ret = append(ret, boltIndexSeparator)
+	return append(ret, seq...)
+}
+
+// lookup returns the sequences of the payments matching the filter in the
+// index of its field
+func (store *PaymentBoltStore) lookup(tx *bolt.Tx, f *checkedFilter) map[string]bool {
+	ret := map[string]bool{}
+	c := tx.Bucket([]byte(boltIndexPrefix + f.field.GoName)).Cursor()
+	if f.typ == PaymentStoreFilterTypeRange {
+		// the keys are sorted by value, then by sequence
+		for k, _ := c.Seek([]byte(f.from)); k != nil; k, _ = c.Next() {
+			n := len(k) - boltSeqSize - 1
+			if n < 0 || k[n] != boltIndexSeparator {
+				continue
+			}
+			if f.to != "" && string(k[:n]) > f.to {
+				break
+			}
+			ret[string(k[n+1:])] = true
+		}
+		return ret
+	}
+	for _, value := range f.values {
+		prefix := boltIndexKey(value, nil)
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			// a longer value can start with the separator, the key must only
+			// hold the sequence after the prefix
+			if len(k) == len(prefix)+boltSeqSize {
+				ret[string(k[len(prefix):])] = true
+			}
+		}
+	}
+	return ret
+}
+
+func (store *PaymentBoltStore) Total(ctx context.Context) int {
+	n := 0
+	store.DB.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(boltBucketPayments).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+func (store *PaymentBoltStore) GetMany(
+	ctx context.Context,
+	limit, offset int,
+	filters ...*PaymentStoreFilter,
+) (*PaginatedList, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, ErrSomethingWentWrong(err)
+	}
+	// every field that compiles is indexed, the filters only read the
+	// payments they match
+	checked, err := checkFilters(filters)
+	if err != nil {
+		return nil, ErrSomethingWentWrong(err)
+	}
+	ret := []*Payment{}
+	total := 0
+	err = store.DB.View(func(tx *bolt.Tx) error {
+		var seqs map[string]bool
+		for _, f := range checked {
+			found := store.lookup(tx, f)
+			if seqs != nil {
+				for seq := range seqs {
+					if !found[seq] {
+						delete(seqs, seq)
+					}
+				}
+			} else {
+				seqs = found
+			}
+		}
+
+		// only the payments of the page are decoded
+		payments := tx.Bucket(boltBucketPayments)
+		add := func(data []byte) error {
+			if total%boltCheckEvery == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			if total >= offset && (limit == 0 || len(ret) < limit) {
+				p := &Payment{}
+				if err := json.Unmarshal(data, p); err != nil {
+					return err
+				}
+				ret = append(ret, p)
+			}
+			total++
+			return nil
+		}
+		if seqs == nil {
+			return payments.ForEach(func(_, data []byte) error {
+				return add(data)
+			})
+		}
+		sorted := make([]string, 0, len(seqs))
+		for seq := range seqs {
+			sorted = append(sorted, seq)
+		}
+		sort.Strings(sorted)
+		for _, seq := range sorted {
+			if err := add(payments.Get([]byte(seq))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, ErrSomethingWentWrong(err)
+	}
+	return &PaginatedList{
+		Total:    total,
+		SubTotal: len(ret),
+		Results:  ret,
+	}, nil
+}
+
+func (store *PaymentBoltStore) GetByID(ctx context.Context, id uuid.UUID) (*Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, ErrSomethingWentWrong(err)
+	}
+	var ret *Payment
+	err := store.DB.View(func(tx *bolt.Tx) error {
+		seq := tx.Bucket(boltBucketIDs).Get(id[:])
+		if seq == nil {
+			return nil
+		}
+		ret = &Payment{}
+		return json.Unmarshal(tx.Bucket(boltBucketPayments).Get(seq), ret)
+	})
+	if err != nil {
+		return nil, ErrSomethingWentWrong(err)
+	}
+	if ret == nil {
+		return nil, ErrNotFound
+	}
+	return ret, nil
+}
+
+// unindex removes the index entries of the payment stored at seq
+func (store *PaymentBoltStore) unindex(tx *bolt.Tx, seq []byte) error {
+	p := &Payment{}
+	if err := json.Unmarshal(tx.Bucket(boltBucketPayments).Get(seq), p); err != nil {
+		return err
+	}
+	for _, field := range PaymentFields {
+		index := tx.Bucket([]byte(boltIndexPrefix + field.GoName))
+		if err := index.Delete(boltIndexKey(field.Get(p), seq)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *PaymentBoltStore) Save(ctx context.Context, p *Payment) error {
+	if p == nil {
+		return ErrSomethingWentWrong(ErrNilValue)
+	}
+	if err := ctx.Err(); err != nil {
+		return ErrSomethingWentWrong(err)
+	}
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	p.UpdatedAt = Now()
+	data, err := json.Marshal(p)
+	if err != nil {
+		return ErrSomethingWentWrong(err)
+	}
+	err = store.DB.Update(func(tx *bolt.Tx) error {
+		ids := tx.Bucket(boltBucketIDs)
+		payments := tx.Bucket(boltBucketPayments)
+		seq := ids.Get(p.ID[:])
+		if seq != nil {
+			seq = append([]byte{}, seq...)
+			if err := store.unindex(tx, seq); err != nil {
+				return err
+			}
+		} else {
+			next, err := payments.NextSequence()
+			if err != nil {
+				return err
+			}
+			seq = boltSeq(next)
+			if err := ids.Put(p.ID[:], seq); err != nil {
+				return err
+			}
+		}
+		if err := payments.Put(seq, data); err != nil {
+			return err
+		}
+		for _, field := range PaymentFields {
+			index := tx.Bucket([]byte(boltIndexPrefix + field.GoName))
+			if err := index.Put(boltIndexKey(field.Get(p), seq), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ErrSomethingWentWrong(err)
+	}
+	return nil
+}
+
+func (store *PaymentBoltStore) Delete(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return ErrSomethingWentWrong(err)
+	}
+	err := store.DB.Update(func(tx *bolt.Tx) error {
+		ids := tx.Bucket(boltBucketIDs)
+		seq := ids.Get(id[:])
+		if seq == nil {
+			return nil
+		}
+		// the slices returned by bolt are only valid until they are modified
+		seq = append([]byte{}, seq...)
+		if err := store.unindex(tx, seq); err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltBucketPayments).Delete(seq); err != nil {
+			return err
+		}
+		return ids.Delete(id[:])
+	})
+	if err != nil {
+		return ErrSomethingWentWrong(err)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// CacheStats counts the reads served by the cache. Payments and Lists are
+// the amount of payments and pages cached.
+type CacheStats struct {
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	HitRatio      float64 `json:"hitRatio"`
+	Evictions     int64   `json:"evictions"`
+	Expirations   int64   `json:"expirations"`
+	Invalidations int64   `json:"invalidations"`
+	Payments      int     `json:"payments"`
+	Lists         int     `json:"lists"`
+}
+
+// PaymentCacheStore is a PaymentStore decorator caching the payments read by
+// id and, when Settings.Lists is set, the pages of payments. The payments
+// saved or deleted through it are invalidated along with every page, and the
+// invalidation is published to the other nodes when Invalidator is set.
+type PaymentCacheStore struct {
+	PaymentStore
+	Settings    *CacheSettings
+	Invalidator CacheInvalidator
+
+	payments *lruCache
+	lists    *lruCache
+	// generation changes on every invalidation, see put
+	generation    int64
+	hits          int64
+	misses        int64
+	invalidations int64
+}
+
+func NewPaymentCacheStore(s PaymentStore, settings *CacheSettings, inv CacheInvalidator) *PaymentCacheStore {
+	store := &PaymentCacheStore{
+		PaymentStore: s,
+		Settings:     settings,
+		Invalidator:  inv,
+		payments:     newLRUCache(settings.Size, settings.TTL),
+	}
+	if settings.Lists {
+		store.lists = newLRUCache(settings.ListSize, settings.TTL)
+	}
+	if inv != nil {
+		inv.Listen(store.Invalidate)
+	}
+	return store
+}
+
+func (store *PaymentCacheStore) hit(ok bool) {
+	if ok {
+		atomic.AddInt64(&store.hits, 1)
+	} else {
+		atomic.AddInt64(&store.misses, 1)
+	}
+}
+
+func (store *PaymentCacheStore) GetByID(ctx context.Context, id uuid.UUID) (*Payment, error) {
+	if v, ok := store.payments.Get(id.String()); ok {
+		store.hit(true)
+		return v.(*Payment).Copy(), nil
+	}
+	store.hit(false)
+	generation := atomic.LoadInt64(&store.generation)
+	p, err := store.PaymentStore.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	store.put(store.payments, id.String(), p.Copy(), generation)
+	return p, nil
+}
+
+// put caches the value read at the given generation, unless an invalidation
+// happened since then. The value is removed again when an invalidation
+// happened while it was being cached.
+func (store *PaymentCacheStore) put(c *lruCache, key string, value interface{}, generation int64) {
+	if atomic.LoadInt64(&store.generation) != generation {
+		return
+	}
+	c.Put(key, value)
+	if atomic.LoadInt64(&store.generation) != generation {
+		c.Remove(key)
+	}
+}
+
+func (store *PaymentCacheStore) GetMany(
+	ctx context.Context,
+	limit, offset int,
+	filters ...*PaymentStoreFilter,
+) (*PaginatedList, error) {
+	return store.cachedList(limit, offset, nil, filters, func() (*PaginatedList, error) {
+		return store.PaymentStore.GetMany(ctx, limit, offset, filters...)
+	})
+}
+
+// GetManySorted lets the decorated store sort the payments when it can
+func (store *PaymentCacheStore) GetManySorted(
+	ctx context.Context,
+	limit, offset int,
+	sorts []PaymentSort,
+	filters ...*PaymentStoreFilter,
+) (*PaginatedList, error) {
+	return store.cachedList(limit, offset, sorts, filters, func() (*PaginatedList, error) {
+		return GetManySorted(ctx, store.PaymentStore, limit, offset, sorts, filters...)
+	})
+}
+
+// cachedList returns the cached page of the query or caches the one returned
+// by read
+func (store *PaymentCacheStore) cachedList(
+	limit, offset int,
+	sorts []PaymentSort,
+	filters []*PaymentStoreFilter,
+	read func() (*PaginatedList, error),
+) (*PaginatedList, error) {
+	if store.lists == nil {
+		return read()
+	}
+	key, err := json.Marshal(struct {
+		Limit   int
+		Offset  int
+		Sorts   []PaymentSort
+		Filters []*PaymentStoreFilter
+	}{limit, offset, sorts, filters})
+	if err != nil {
+		return read()
+	}
+	if v, ok := store.lists.Get(string(key)); ok {
+		store.hit(true)
+		return copyPaymentList(v.(*PaginatedList)), nil
+	}
+	store.hit(false)
+	generation := atomic.LoadInt64(&store.generation)
+	list, err := read()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := list.Results.([]*Payment); ok {
+		store.put(store.lists, string(key), copyPaymentList(list), generation)
+	}
+	return list, nil
+}
+
+// copyPaymentList returns a deep copy of a page of payments
+func copyPaymentList(list *PaginatedList) *PaginatedList {
+	ret := *list
+	payments, _ := list.Results.([]*Payment)
+	cpy := make([]*Payment, len(payments))
+	for i, p := range payments {
+		cpy[i] = p.Copy()
+	}
+	ret.Results = cpy
+	return &ret
+}
+
+func (store *PaymentCacheStore) Save(ctx context.Context, p *Payment) error {
+	err := store.PaymentStore.Save(ctx, p)
+	if p != nil {
+		store.invalidate(p.ID)
+	}
+	return err
+}
+
+func (store *PaymentCacheStore) Delete(ctx context.Context, id uuid.UUID) error {
+	err := store.PaymentStore.Delete(ctx, id)
+	store.invalidate(id)
+	return err
+}
+
+// invalidate invalidates the payment, even when its write failed since it
+// may have gone through, and publishes the invalidation
+func (store *PaymentCacheStore) invalidate(id uuid.UUID) {
+	inv := &CacheInvalidation{IDs: []uuid.UUID{id}}
+	store.Invalidate(inv)
+	if store.Invalidator == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), store.Settings.Timeout)
+		defer cancel()
+		if err := store.Invalidator.Publish(ctx, inv); err != nil {
+			logrus.Warnf("Cache: %v", err)
+		}
+	}()
+}
+
+// Invalidate drops the payments of the invalidation and every page from the
+// cache
+func (store *PaymentCacheStore) Invalidate(inv *CacheInvalidation) {
+	atomic.AddInt64(&store.generation, 1)
+	atomic.AddInt64(&store.invalidations, 1)
+	if len(inv.IDs) == 0 {
+		store.payments.Clear()
+	}
+	for _, id := range inv.IDs {
+		store.payments.Remove(id.String())
+	}
+	if store.lists != nil {
+		store.lists.Clear()
+	}
+}
+
+// Stats returns the statistics of the cache since it was created
+func (store *PaymentCacheStore) Stats() *CacheStats {
+	stats := &CacheStats{
+		Hits:          atomic.LoadInt64(&store.hits),
+		Misses:        atomic.LoadInt64(&store.misses),
+		Invalidations: atomic.LoadInt64(&store.invalidations),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+	stats.Payments, stats.Evictions, stats.Expirations = store.payments.stats()
+	if store.lists != nil {
+		lists, evictions, expirations := store.lists.stats()
+		stats.Lists = lists
+		stats.Evictions += evictions
+		stats.Expirations += expirations
+	}
+	return stats
+}
@@ -0,0 +1,242 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStore counts the reads reaching the store
+type countingStore struct {
+	api.PaymentStore
+	mu    sync.Mutex
+	reads int
+}
+
+func (s *countingStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reads
+}
+
+func (s *countingStore) GetByID(ctx context.Context, id uuid.UUID) (*api.Payment, error) {
+	s.mu.Lock()
+	s.reads++
+	s.mu.Unlock()
+	return s.PaymentStore.GetByID(ctx, id)
+}
+
+func (s *countingStore) GetMany(ctx context.Context, limit, offset int, filters ...*api.PaymentStoreFilter) (*api.PaginatedList, error) {
+	s.mu.Lock()
+	s.reads++
+	s.mu.Unlock()
+	return s.PaymentStore.GetMany(ctx, limit, offset, filters...)
+}
+
+func newCacheSettings() *api.CacheSettings {
+	return &api.CacheSettings{
+		Enabled:  true,
+		Size:     10,
+		TTL:      time.Minute,
+		Lists:    true,
+		ListSize: 10,
+		Timeout:  time.Second,
+	}
+}
+
+// fakeInvalidator records the published invalidations
+type fakeInvalidator struct {
+	published chan *api.CacheInvalidation
+	fn        func(inv *api.CacheInvalidation)
+}
+
+func (i *fakeInvalidator) Publish(ctx context.Context, inv *api.CacheInvalidation) error {
+	i.published <- inv
+	return nil
+}
+
+func (i *fakeInvalidator) Listen(fn func(inv *api.CacheInvalidation)) {
+	i.fn = fn
+}
+
+func TestPaymentCacheStore(t *testing.T) {
+	db := newTestDBInMem()
+	counting := &countingStore{PaymentStore: db.Store}
+	inv := &fakeInvalidator{published: make(chan *api.CacheInvalidation, 10)}
+	store := api.NewPaymentCacheStore(counting, newCacheSettings(), inv)
+
+	p, err := store.GetByID(ctx, db.ID1)
+	assert.NoError(t, err)
+	p.Scheme = "changed"
+	p, err = store.GetByID(ctx, db.ID1)
+	assert.NoError(t, err)
+	assert.Equal(t, db.Payment1.Scheme, p.Scheme)
+	assert.Equal(t, 1, counting.count())
+	_, err = store.GetByID(ctx, uuid.New())
+	assert.Equal(t, api.ErrNotFound, err)
+
+	filter := []*api.PaymentStoreFilter{{Field: "Scheme", Want: schemeA, Type: api.PaymentStoreFilterTypeEqual}}
+	list, err := store.GetMany(ctx, 0, 0, filter...)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, list.Total)
+	_, err = store.GetMany(ctx, 0, 0, filter...)
+	assert.NoError(t, err)
+	_, err = store.GetManySorted(ctx, 0, 0, []api.PaymentSort{{Field: "scheme"}})
+	assert.NoError(t, err)
+	_, err = store.GetManySorted(ctx, 0, 0, []api.PaymentSort{{Field: "scheme"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, counting.count())
+
+	// a write invalidates the payment and the pages, on the other nodes too
+	p.Scheme = schemeB
+	assert.NoError(t, store.Save(ctx, p))
+	assert.Equal(t, []uuid.UUID{db.ID1}, (<-inv.published).IDs)
+	p, err = store.GetByID(ctx, db.ID1)
+	assert.NoError(t, err)
+	assert.Equal(t, schemeB, p.Scheme)
+	list, err = store.GetMany(ctx, 0, 0, filter...)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, list.Total)
+	assert.Equal(t, 6, counting.count())
+
+	assert.NoError(t, store.Delete(ctx, db.ID1))
+	<-inv.published
+	_, err = store.GetByID(ctx, db.ID1)
+	assert.Equal(t, api.ErrNotFound, err)
+
+	// the invalidations of the other nodes
+	store.GetByID(ctx, db.ID2)
+	inv.fn(&api.CacheInvalidation{IDs: []uuid.UUID{db.ID2}})
+	store.GetByID(ctx, db.ID2)
+	assert.Equal(t, 9, counting.count())
+	inv.fn(&api.CacheInvalidation{})
+	store.GetByID(ctx, db.ID2)
+	assert.Equal(t, 10, counting.count())
+
+	stats := store.Stats()
+	assert.Equal(t, int64(3), stats.Hits)
+	assert.Equal(t, int64(10), stats.Misses)
+	assert.Equal(t, int64(4), stats.Invalidations)
+	assert.Equal(t, 1, stats.Payments)
+	assert.Equal(t, 0, stats.Lists)
+	assert.InDelta(t, 3.0/13, stats.HitRatio, 0.001)
+}
+
+func TestPaymentCacheStoreEviction(t *testing.T) {
+	db := newTestDBInMem()
+	counting := &countingStore{PaymentStore: db.Store}
+	settings := newCacheSettings()
+	settings.Size = 2
+	settings.Lists = false
+	store := api.NewPaymentCacheStore(counting, settings, nil)
+
+	store.GetByID(ctx, db.ID1)
+	store.GetByID(ctx, db.ID2)
+	store.GetByID(ctx, db.ID1)
+	// the least recently used is evicted
+	store.GetByID(ctx, db.ID3)
+	store.GetByID(ctx, db.ID1)
+	assert.Equal(t, 3, counting.count())
+	store.GetByID(ctx, db.ID2)
+	assert.Equal(t, 4, counting.count())
+	assert.Equal(t, int64(2), store.Stats().Evictions)
+
+	// the pages are not cached
+	store.GetMany(ctx, 0, 0)
+	store.GetMany(ctx, 0, 0)
+	assert.Equal(t, 6, counting.count())
+	assert.Equal(t, 0, store.Stats().Lists)
+}
+
+func TestPaymentCacheStoreTTL(t *testing.T) {
+	db := newTestDBInMem()
+	counting := &countingStore{PaymentStore: db.Store}
+	settings := newCacheSettings()
+	settings.TTL = 10 * time.Millisecond
+	store := api.NewPaymentCacheStore(counting, settings, nil)
+
+	store.GetByID(ctx, db.ID1)
+	store.GetByID(ctx, db.ID1)
+	assert.Equal(t, 1, counting.count())
+	time.Sleep(15 * time.Millisecond)
+	store.GetByID(ctx, db.ID1)
+	assert.Equal(t, 2, counting.count())
+	assert.Equal(t, int64(1), store.Stats().Expirations)
+}
+
+func TestHTTPInvalidator(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	receiver := api.NewHTTPInvalidator(nil, time.Second)
+	invalidations := make(chan *api.CacheInvalidation, 1)
+	receiver.Listen(func(inv *api.CacheInvalidation) { invalidations <- inv })
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+		receiver.ServeHTTP(w, r)
+	}))
+	defer peer.Close()
+
+	sender := api.NewHTTPInvalidator([]string{peer.URL + "/"}, time.Second)
+	sender.ClientID, sender.Secret = "node", "secret"
+	id := uuid.New()
+	assert.NoError(t, sender.Publish(ctx, &api.CacheInvalidation{IDs: []uuid.UUID{id}}))
+	r := <-received
+	assert.Equal(t, api.APIV1Prefix+"/cache/invalidations", r.URL.Path)
+	assert.NotEmpty(t, r.Header.Get(api.HeaderSignature))
+	assert.Equal(t, []uuid.UUID{id}, (<-invalidations).IDs)
+
+	sender.Peers = append(sender.Peers, "http://127.0.0.1:0")
+	assert.Error(t, sender.Publish(ctx, &api.CacheInvalidation{}))
+
+	_, err := api.NewCacheInvalidator(&api.CacheSettings{Invalidation: api.CacheInvalidationHTTP})
+	assert.Error(t, err)
+	_, err = api.NewCacheInvalidator(&api.CacheSettings{Invalidation: "redis"})
+	assert.Error(t, err)
+	i, err := api.NewCacheInvalidator(&api.CacheSettings{Invalidation: api.CacheInvalidationNone})
+	assert.NoError(t, err)
+	assert.Nil(t, i)
+}
+
+func TestCacheRoutes(t *testing.T) {
+	db := newTestDBInMem()
+	api.SetStore(db.Store)
+	handler := api.Routes()
+	resp := doHTTPReq(handler, http.MethodGet, "/v1/cache/stats", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	store := api.NewPaymentCacheStore(db.Store, newCacheSettings(), nil)
+	api.SetStore(store)
+	doHTTPReq(handler, http.MethodGet, "/v1/payments/"+db.ID1.String(), "")
+	doHTTPReq(handler, http.MethodGet, "/v1/payments/"+db.ID1.String(), "")
+	resp = doHTTPReq(handler, http.MethodGet, "/v1/cache/stats", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	stats := &api.CacheStats{}
+	assert.NoError(t, json.Unmarshal(readBody(resp), &api.JSENDData{Data: stats}))
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, 1, stats.Payments)
+
+	// the invalidations are only received over HTTP when configured
+	body := `{"ids": ["` + db.ID1.String() + `"]}`
+	resp = doHTTPReq(handler, http.MethodPost, "/v1/cache/invalidations", body)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	api.SetStore(api.NewPaymentCacheStore(db.Store, newCacheSettings(), api.NewHTTPInvalidator([]string{"http://peer"}, time.Second)))
+	doHTTPReq(handler, http.MethodGet, "/v1/payments/"+db.ID1.String(), "")
+	resp = doHTTPReq(handler, http.MethodPost, "/v1/cache/invalidations", body)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = doHTTPReq(handler, http.MethodGet, "/v1/cache/stats", "")
+	stats = &api.CacheStats{}
+	assert.NoError(t, json.Unmarshal(readBody(resp), &api.JSENDData{Data: stats}))
+	assert.Equal(t, int64(1), stats.Invalidations)
+	assert.Equal(t, 0, stats.Payments)
+	resp = doHTTPReq(handler, http.MethodPost, "/v1/cache/invalidations", `{"ids": ["nope"]}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	api.SetStore(db.Store)
+}
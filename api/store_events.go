@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// PaymentEventStore is a PaymentStore decorator publishing an event on the
+// bus for every payment saved or deleted through it
+type PaymentEventStore struct {
+	PaymentStore
+	Bus *EventBus
+}
+
+func NewPaymentEventStore(s PaymentStore, b *EventBus) *PaymentEventStore {
+	return &PaymentEventStore{
+		PaymentStore: s,
+		Bus:          b,
+	}
+}
+
+// GetManySorted lets the decorated store sort the payments when it can
+func (store *PaymentEventStore) GetManySorted(
+	ctx context.Context,
+	limit, offset int,
+	sorts []PaymentSort,
+	filters ...*PaymentStoreFilter,
+) (*PaginatedList, error) {
+	return GetManySorted(ctx, store.PaymentStore, limit, offset, sorts, filters...)
+}
+
+func (store *PaymentEventStore) Save(ctx context.Context, p *Payment) error {
+	if p == nil {
+		return store.PaymentStore.Save(ctx, p)
+	}
+	typ := PaymentEventUpdated
+	if _, err := store.PaymentStore.GetByID(ctx, p.ID); err == ErrNotFound {
+		typ = PaymentEventCreated
+	}
+	if err := store.PaymentStore.Save(ctx, p); err != nil {
+		return err
+	}
+	cpy := *p
+	store.Bus.Publish(NewPaymentEvent(typ, &cpy))
+	return nil
+}
+
+func (store *PaymentEventStore) Delete(ctx context.Context, id uuid.UUID) error {
+	p, err := store.PaymentStore.GetByID(ctx, id)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if err := store.PaymentStore.Delete(ctx, id); err != nil {
+		return err
+	}
+	if p != nil {
+		cpy := *p
+		store.Bus.Publish(NewPaymentEvent(PaymentEventDeleted, &cpy))
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// PaymentField is a field of a Payment the payments can be filtered and
+// sorted on. Get reads it as a string without reflection.
+type PaymentField struct {
+	// Name is the JSON name of the field, dotted for the fields of a party
+	Name string
+	// GoName is the name of the field in the Payment struct
+	GoName string
+	// Time is true for the times, Get formats them with PaymentTimeLayout so
+	// that they sort in chronological order
+	Time bool
+	Get  func(p *Payment) string
+}
+
+// PaymentTimeLayout is the format of the times read by a PaymentField, always
+// in UTC and with a fixed width
+const PaymentTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+func paymentStringField(name, goName string, get func(p *Payment) string) *PaymentField {
+	return &PaymentField{Name: name, GoName: goName, Get: get}
+}
+
+func paymentTimeField(name, goName string, get func(p *Payment) *time.Time) *PaymentField {
+	return &PaymentField{Name: name, GoName: goName, Time: true, Get: func(p *Payment) string {
+		return formatPaymentTime(get(p))
+	}}
+}
+
+func formatPaymentTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(PaymentTimeLayout)
+}
+
+func partyBankID(party *PaymentParty) string {
+	if party == nil {
+		return ""
+	}
+	return party.BankID
+}
+
+// PaymentFields is the registry of the filterable fields of a Payment, it must
+// list every string field of the struct
+var PaymentFields = []*PaymentField{
+	paymentStringField("purpose", "Purpose", func(p *Payment) string { return p.Purpose }),
+	paymentStringField("scheme", "Scheme", func(p *Payment) string { return p.Scheme }),
+	paymentStringField("type", "Type", func(p *Payment) string { return p.Type }),
+	paymentStringField("amount", "Amount", func(p *Payment) string { return p.Amount }),
+	paymentStringField("currency", "Currency", func(p *Payment) string { return p.Currency }),
+	paymentStringField("endToEndReference", "EndToEndReference", func(p *Payment) string { return p.EndToEndReference }),
+	paymentStringField("numericReference", "NumericReference", func(p *Payment) string { return p.NumericReference }),
+	paymentStringField("processingDate", "ProcessingDate", func(p *Payment) string { return p.ProcessingDate }),
+	paymentStringField("reference", "Reference", func(p *Payment) string { return p.Reference }),
+	paymentStringField("schemePaymentSubType", "SchemePaymentSubType", func(p *Payment) string { return p.SchemePaymentSubType }),
+	paymentStringField("schemePaymentType", "SchemePaymentType", func(p *Payment) string { return p.SchemePaymentType }),
+	// the following ones are not string fields of the struct, they are only
+	// filterable through the stores
+	paymentTimeField("createdAt", "CreatedAt", func(p *Payment) *time.Time { return p.CreatedAt }),
+	paymentTimeField("updatedAt", "UpdatedAt", func(p *Payment) *time.Time { return p.UpdatedAt }),
+	paymentStringField("beneficiary.bankId", "Beneficiary.BankID", func(p *Payment) string { return partyBankID(p.Beneficiary) }),
+	paymentStringField("debitorParty.bankId", "DebitorParty.BankID", func(p *Payment) string { return partyBankID(p.DebitorParty) }),
+}
+
+// isStructString returns true when the field is a string field of Payment
+func (f *PaymentField) isStructString() bool {
+	return !f.Time && !strings.Contains(f.GoName, ".")
+}
+
+// paymentFieldsByName indexes PaymentFields by lowercased JSON and Go name
+var paymentFieldsByName = paymentFieldsIndex()
+
+func paymentFieldsIndex() map[string]*PaymentField {
+	ret := map[string]*PaymentField{}
+	for _, f := range PaymentFields {
+		ret[strings.ToLower(f.Name)] = f
+		ret[strings.ToLower(f.GoName)] = f
+	}
+	return ret
+}
+
+// LookupPaymentField returns the field of a Payment from its JSON or Go name,
+// whatever the case
+func LookupPaymentField(name string) (*PaymentField, bool) {
+	f, ok := paymentFieldsByName[strings.ToLower(name)]
+	return f, ok
+}
+
+// PaymentMatcher tells whether a payment matches compiled filters
+type PaymentMatcher func(p *Payment) bool
+
+func matchAll(p *Payment) bool {
+	return p != nil
+}
+
+// checkedFilter is a filter whose field is known and whose values are
+// normalized, the stores translate it to their own queries
+type checkedFilter struct {
+	field *PaymentField
+	typ   PaymentStoreFilterType
+	// values are the accepted values of an equal or in filter
+	values []string
+	// from and to are the bounds of a range filter
+	from, to string
+}
+
+// check looks the field of the filter up and normalizes its values, the times
+// are parsed from RFC 3339
+func (f *PaymentStoreFilter) check() (*checkedFilter, error) {
+	field, ok := LookupPaymentField(f.Field)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFilterField, f.Field)
+	}
+	ret := &checkedFilter{field: field, typ: f.Type}
+	switch f.Type {
+	case PaymentStoreFilterTypeEqual, PaymentStoreFilterTypeIn:
+		values, ok := filterValues(f)
+		if !ok {
+			return nil, fmt.Errorf("%w for %s: %v", ErrUnsupportedFilterValue, field.Name, f.Want)
+		}
+		ret.values = values
+	case PaymentStoreFilterTypeRange:
+		switch r := f.Want.(type) {
+		case PaymentStoreRange:
+			ret.from, ret.to = r.From, r.To
+		case *PaymentStoreRange:
+			if r == nil {
+				return nil, fmt.Errorf("%w for %s: nil range", ErrUnsupportedFilterValue, field.Name)
+			}
+			ret.from, ret.to = r.From, r.To
+		default:
+			return nil, fmt.Errorf("%w for %s: %v", ErrUnsupportedFilterValue, field.Name, f.Want)
+		}
+	default:
+		return nil, ErrUnknownFilterType
+	}
+	if field.Time {
+		if err := ret.normalizeTimes(); err != nil {
+			return nil, fmt.Errorf("%w for %s: %v", ErrUnsupportedFilterValue, field.Name, err)
+		}
+	}
+	return ret, nil
+}
+
+// normalizeTimes formats the values and the bounds like the field does, the
+// empty ones are kept
+func (f *checkedFilter) normalizeTimes() error {
+	normalize := func(v string) (string, error) {
+		if v == "" {
+			return "", nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return "", err
+		}
+		return formatPaymentTime(&t), nil
+	}
+	var err error
+	for i, v := range f.values {
+		if f.values[i], err = normalize(v); err != nil {
+			return err
+		}
+	}
+	if f.from, err = normalize(f.from); err != nil {
+		return err
+	}
+	f.to, err = normalize(f.to)
+	return err
+}
+
+// inRange returns true when the value is within the bounds of a range filter
+func (f *checkedFilter) inRange(v string) bool {
+	return (f.from == "" || v >= f.from) && (f.to == "" || v <= f.to)
+}
+
+// matcher turns the filter into a predicate
+func (f *checkedFilter) matcher() PaymentMatcher {
+	get := f.field.Get
+	if f.typ == PaymentStoreFilterTypeRange {
+		return func(p *Payment) bool { return p != nil && f.inRange(get(p)) }
+	}
+	switch len(f.values) {
+	case 0:
+		return func(p *Payment) bool { return false }
+	case 1:
+		want := f.values[0]
+		return func(p *Payment) bool { return p != nil && get(p) == want }
+	}
+	set := make(map[string]struct{}, len(f.values))
+	for _, v := range f.values {
+		set[v] = struct{}{}
+	}
+	return func(p *Payment) bool {
+		if p == nil {
+			return false
+		}
+		_, ok := set[get(p)]
+		return ok
+	}
+}
+
+// checkFilters checks all the filters, it fails on the first one that does
+// not check
+func checkFilters(filters []*PaymentStoreFilter) ([]*checkedFilter, error) {
+	ret := make([]*checkedFilter, 0, len(filters))
+	for _, f := range filters {
+		c, err := f.check()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
+
+// Compile checks the filter and turns it into a predicate, the field and the
+// values are only looked up once
+func (f *PaymentStoreFilter) Compile() (PaymentMatcher, error) {
+	c, err := f.check()
+	if err != nil {
+		return nil, err
+	}
+	return c.matcher(), nil
+}
+
+// CompileFilters compiles the filters into a predicate matching the payments
+// that match all of them. It fails on the first filter that does not compile.
+func CompileFilters(filters ...*PaymentStoreFilter) (PaymentMatcher, error) {
+	checked, err := checkFilters(filters)
+	if err != nil {
+		return nil, err
+	}
+	return matchChecked(checked), nil
+}
+
+// matchChecked returns the predicate matching all the checked filters
+func matchChecked(filters []*checkedFilter) PaymentMatcher {
+	matchers := make([]PaymentMatcher, 0, len(filters))
+	for _, f := range filters {
+		matchers = append(matchers, f.matcher())
+	}
+	switch len(matchers) {
+	case 0:
+		return matchAll
+	case 1:
+		return matchers[0]
+	}
+	return func(p *Payment) bool {
+		for _, m := range matchers {
+			if !m(p) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// checkPaymentFields panics when the registry does not match the string
+// fields of Payment, so that a field added to the struct is not forgotten
+func checkPaymentFields() map[string]string {
+	ret := map[string]string{}
+	t := reflect.TypeOf(Payment{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type.Kind() != reflect.String {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if field, ok := LookupPaymentField(name); !ok || field.GoName != f.Name || field.Name != name {
+			panic("api: PaymentFields does not register Payment." + f.Name)
+		}
+		ret[name] = f.Name
+	}
+	n := 0
+	for _, f := range PaymentFields {
+		if f.isStructString() {
+			n++
+		}
+	}
+	if len(ret) != n {
+		panic("api: PaymentFields registers fields that are not strings of Payment")
+	}
+	return ret
+}
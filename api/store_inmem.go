@@ -1,26 +1,47 @@
 package api
 
 import (
-	"reflect"
-	"strings"
+	"container/list"
+	"context"
+	"sync"
 
 	"github.com/google/uuid"
 )
 
-// This is an implementation of PaymentStore with temporary in memory storage
+// This is an implementation of PaymentStore with temporary in memory storage.
+//
+// The payments are indexed by ID and chained in insertion order, so that a
+// lookup, a save or a delete do not depend on the amount of payments stored
+// and the pages are stable. The store keeps its own copies: the payments it
+// returns can be modified by the callers, and modifying a payment after
+// saving it does not change the store. The secondary indexes of
+// store_inmem_index.go narrow the payments a query reads down.
 
 type PaymentInMemStore struct {
-	Database []*Payment
+	mu sync.RWMutex
+	// byID indexes the elements of payments, whose values are *inMemEntry
+	byID     map[uuid.UUID]*list.Element
+	payments *list.List
+	// seq is the sequence of the last payment inserted
+	seq uint64
+	// indexes are the secondary indexes by JSON name of their field
+	indexes map[string]*inMemIndex
 }
 
+// NewPaymentInMemStore returns an empty store without secondary indexes, see
+// AddIndexes
 func NewPaymentInMemStore() *PaymentInMemStore {
 	return &PaymentInMemStore{
-		Database: []*Payment{},
+		byID:     map[uuid.UUID]*list.Element{},
+		payments: list.New(),
+		indexes:  map[string]*inMemIndex{},
 	}
 }
 
-func (store *PaymentInMemStore) Total() int {
-	return len(store.Database)
+func (store *PaymentInMemStore) Total(ctx context.Context) int {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return len(store.byID)
 }
 
 func PaymentStoreFilterIsScheme(typ string) *PaymentStoreFilter {
@@ -32,53 +53,31 @@ func PaymentStoreFilterIsScheme(typ string) *PaymentStoreFilter {
 }
 
 func (store *PaymentInMemStore) GetMany(
+	ctx context.Context,
 	limit, offset int,
 	filters ...*PaymentStoreFilter,
 ) (*PaginatedList, error) {
-	to := limit + offset
-	if to > len(store.Database) {
-		return &PaginatedList{Results: []*Payment{}}, nil
+	checked, err := checkFilters(filters)
+	if err != nil {
+		return nil, ErrSomethingWentWrong(err)
 	}
-	subset := []*Payment{}
-	if filters != nil && len(filters) > 0 {
-		for _, filter := range filters {
-			for _, d := range store.Database {
-				e := reflect.ValueOf(d).Elem()
-				for i := 0; i < e.NumField(); i++ {
-					varName := e.Type().Field(i).Name
-					varValue := e.Field(i).Interface()
-					if strings.ToLower(varName) == strings.ToLower(filter.Field) {
-						isMatching, _ := filter.Match(varValue)
-						if isMatching {
-							subset = append(subset, d)
-						}
-					}
-				}
-			}
+	match := matchChecked(checked)
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	ret := []*Payment{}
+	total := 0
+	err = store.each(ctx, store.plan(checked), func(e *inMemEntry) {
+		if !match(e.payment) {
+			return
 		}
-	} else {
-		subset = append(subset, store.Database...)
-	}
-	total := len(subset)
-	if offset > len(subset) {
-		return &PaginatedList{Results: []*Payment{}}, nil
-	}
-	if offset == 0 && limit == 0 {
-		return &PaginatedList{
-			Total:    total,
-			SubTotal: len(subset),
-			Results:  subset,
-		}, nil
-	}
-	if offset > 0 && limit == 0 {
-		ret := subset[offset:]
-		return &PaginatedList{
-			Total:    total,
-			SubTotal: len(ret),
-			Results:  ret,
-		}, nil
+		if total >= offset && (limit == 0 || len(ret) < limit) {
+			ret = append(ret, e.payment.Copy())
+		}
+		total++
+	})
+	if err != nil {
+		return nil, ErrSomethingWentWrong(err)
 	}
-	ret := subset[offset:to]
 	return &PaginatedList{
 		Total:    total,
 		SubTotal: len(ret),
@@ -86,38 +85,96 @@ func (store *PaymentInMemStore) GetMany(
 	}, nil
 }
 
-func (store *PaymentInMemStore) GetByID(id uuid.UUID) (*Payment, error) {
-	for _, p := range store.Database {
-		if p.ID.String() == id.String() {
-			return p, nil
-		}
+func (store *PaymentInMemStore) GetByID(ctx context.Context, id uuid.UUID) (*Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, ErrSomethingWentWrong(err)
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if e, ok := store.byID[id]; ok {
+		return e.Value.(*inMemEntry).payment.Copy(), nil
 	}
 	return nil, ErrNotFound
 }
 
-func (store *PaymentInMemStore) Save(d *Payment) error {
+// has returns true when a payment with this ID is stored
+func (store *PaymentInMemStore) has(id uuid.UUID) bool {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	_, ok := store.byID[id]
+	return ok
+}
+
+// all returns a copy of every payment, in insertion order
+func (store *PaymentInMemStore) all() []*Payment {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	ret := make([]*Payment, 0, len(store.byID))
+	for e := store.payments.Front(); e != nil; e = e.Next() {
+		ret = append(ret, e.Value.(*inMemEntry).payment.Copy())
+	}
+	return ret
+}
+
+func (store *PaymentInMemStore) Save(ctx context.Context, d *Payment) error {
 	if d == nil {
 		return ErrSomethingWentWrong(ErrNilValue)
 	}
-	if d.ID.String() != "" {
-		for i, storedDocument := range store.Database {
-			if d.ID.String() == storedDocument.ID.String() {
-				d.UpdatedAt = Now()
-				store.Database[i] = d
-				return nil
-			}
-		}
+	if err := ctx.Err(); err != nil {
+		return ErrSomethingWentWrong(err)
 	}
-	store.Database = append(store.Database, d)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.byID[d.ID]; ok {
+		d.UpdatedAt = Now()
+	}
+	store.putLocked(d.Copy())
 	return nil
 }
 
-func (store *PaymentInMemStore) Delete(id uuid.UUID) error {
-	for i, storedDocument := range store.Database {
-		if storedDocument.ID.String() == id.String() {
-			store.Database = store.Database[:i+copy(store.Database[i:], store.Database[i+1:])]
-			return nil
+// put stores a copy of the payment as is, replacing the one with the same ID
+func (store *PaymentInMemStore) put(d *Payment) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.putLocked(d.Copy())
+}
+
+func (store *PaymentInMemStore) putLocked(d *Payment) {
+	if el, ok := store.byID[d.ID]; ok {
+		e := el.Value.(*inMemEntry)
+		old := e.payment
+		e.payment = d
+		for _, idx := range store.indexes {
+			idx.update(e, old)
 		}
+		return
 	}
+	store.seq++
+	e := &inMemEntry{seq: store.seq, payment: d}
+	store.byID[d.ID] = store.payments.PushBack(e)
+	for _, idx := range store.indexes {
+		idx.add(e)
+	}
+}
+
+func (store *PaymentInMemStore) Delete(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return ErrSomethingWentWrong(err)
+	}
+	store.delete(id)
 	return nil
 }
+
+// delete removes the payment with this ID if there is one
+func (store *PaymentInMemStore) delete(id uuid.UUID) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if el, ok := store.byID[id]; ok {
+		e := el.Value.(*inMemEntry)
+		for _, idx := range store.indexes {
+			idx.remove(e, e.payment)
+		}
+		store.payments.Remove(el)
+		delete(store.byID, id)
+	}
+}
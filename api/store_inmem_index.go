@@ -0,0 +1,375 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/btree"
+)
+
+// The in memory store can keep secondary indexes on the fields of
+// PaymentFields. A hash index maps each value of its field to the payments
+// having it, it serves the equal and in filters. A sorted index also orders
+// the values, it serves the range filters and the sorts on its field as well.
+//
+// The payments of a value are ordered by their insertion sequence, so that a
+// query reading an index returns them in the same order as a scan. The query
+// planner counts the candidates of every index a query can use and reads the
+// one with the fewest, the remaining filters are checked on each candidate.
+
+const (
+	PaymentIndexHash   = "hash"
+	PaymentIndexSorted = "sorted"
+
+	inMemIndexDegree = 32
+	// inMemCheckEvery is the amount of candidates read between two checks of
+	// the context of a query
+	inMemCheckEvery = 1024
+)
+
+// inMemEntry is a stored payment, seq is its insertion order
+type inMemEntry struct {
+	seq     uint64
+	payment *Payment
+}
+
+func lessInMemEntry(a, b *inMemEntry) bool {
+	return a.seq < b.seq
+}
+
+// inMemBucket holds the entries having the same value in an index
+type inMemBucket struct {
+	value   string
+	entries *btree.BTreeG[*inMemEntry]
+}
+
+func lessInMemBucket(a, b *inMemBucket) bool {
+	return a.value < b.value
+}
+
+type inMemIndex struct {
+	field   *PaymentField
+	kind    string
+	buckets map[string]*inMemBucket
+	// values orders the buckets of a sorted index, it is nil for a hash one
+	values *btree.BTreeG[*inMemBucket]
+}
+
+func newInMemIndex(field *PaymentField, kind string) *inMemIndex {
+	idx := &inMemIndex{
+		field:   field,
+		kind:    kind,
+		buckets: map[string]*inMemBucket{},
+	}
+	if kind == PaymentIndexSorted {
+		idx.values = btree.NewG(inMemIndexDegree, lessInMemBucket)
+	}
+	return idx
+}
+
+func (idx *inMemIndex) add(e *inMemEntry) {
+	value := idx.field.Get(e.payment)
+	b, ok := idx.buckets[value]
+	if !ok {
+		b = &inMemBucket{value: value, entries: btree.NewG(inMemIndexDegree, lessInMemEntry)}
+		idx.buckets[value] = b
+		if idx.values != nil {
+			idx.values.ReplaceOrInsert(b)
+		}
+	}
+	b.entries.ReplaceOrInsert(e)
+}
+
+// remove removes the entry, p is the payment it was added with
+func (idx *inMemIndex) remove(e *inMemEntry, p *Payment) {
+	value := idx.field.Get(p)
+	b, ok := idx.buckets[value]
+	if !ok {
+		return
+	}
+	b.entries.Delete(e)
+	if b.entries.Len() == 0 {
+		delete(idx.buckets, value)
+		if idx.values != nil {
+			idx.values.Delete(b)
+		}
+	}
+}
+
+// update moves the entry when the value of its field changed from old
+func (idx *inMemIndex) update(e *inMemEntry, old *Payment) {
+	if idx.field.Get(old) == idx.field.Get(e.payment) {
+		return
+	}
+	idx.remove(e, old)
+	idx.add(e)
+}
+
+// serves returns true when the index can read the candidates of the filter
+func (idx *inMemIndex) serves(f *checkedFilter) bool {
+	return f.typ != PaymentStoreFilterTypeRange || idx.values != nil
+}
+
+// eachBucket calls fn on the buckets matching the filter until it returns
+// false, in the order of the values for a sorted index
+func (idx *inMemIndex) eachBucket(f *checkedFilter, fn func(b *inMemBucket) bool) {
+	if f.typ == PaymentStoreFilterTypeRange {
+		idx.values.AscendGreaterOrEqual(&inMemBucket{value: f.from}, func(b *inMemBucket) bool {
+			if f.to != "" && b.value > f.to {
+				return false
+			}
+			return fn(b)
+		})
+		return
+	}
+	seen := map[string]bool{}
+	for _, v := range f.values {
+		if b, ok := idx.buckets[v]; ok && !seen[v] {
+			seen[v] = true
+			if !fn(b) {
+				return
+			}
+		}
+	}
+}
+
+// count returns the amount of entries matching the filter, it stops counting
+// once it is over max
+func (idx *inMemIndex) count(f *checkedFilter, max int) int {
+	n := 0
+	idx.eachBucket(f, func(b *inMemBucket) bool {
+		n += b.entries.Len()
+		return n <= max
+	})
+	return n
+}
+
+// each calls fn on the entries matching the filter in insertion order, until
+// it returns false
+func (idx *inMemIndex) each(f *checkedFilter, fn func(e *inMemEntry) bool) {
+	buckets := []*inMemBucket{}
+	idx.eachBucket(f, func(b *inMemBucket) bool {
+		buckets = append(buckets, b)
+		return true
+	})
+	if len(buckets) == 1 {
+		buckets[0].entries.Ascend(fn)
+		return
+	}
+	entries := []*inMemEntry{}
+	for _, b := range buckets {
+		b.entries.Ascend(func(e *inMemEntry) bool {
+			entries = append(entries, e)
+			return true
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+	for _, e := range entries {
+		if !fn(e) {
+			return
+		}
+	}
+}
+
+// ParsePaymentIndex parses the definition of an index, the JSON name of a
+// field followed by its kind: "scheme:hash" or "createdAt:sorted". The kind
+// defaults to hash.
+func ParsePaymentIndex(spec string) (*PaymentField, string, error) {
+	name, kind := spec, PaymentIndexHash
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		name, kind = spec[:i], spec[i+1:]
+	}
+	field, ok := LookupPaymentField(name)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %q", ErrUnknownFilterField, name)
+	}
+	if kind != PaymentIndexHash && kind != PaymentIndexSorted {
+		return nil, "", fmt.Errorf("unknown index kind %q, expected %s or %s", kind, PaymentIndexHash, PaymentIndexSorted)
+	}
+	return field, kind, nil
+}
+
+// AddIndexes builds the indexes defined by the specs of ParsePaymentIndex,
+// an index on a field already indexed replaces it. Nothing is built when a
+// spec is invalid.
+func (store *PaymentInMemStore) AddIndexes(specs ...string) error {
+	indexes := make([]*inMemIndex, 0, len(specs))
+	for _, spec := range specs {
+		field, kind, err := ParsePaymentIndex(spec)
+		if err != nil {
+			return err
+		}
+		indexes = append(indexes, newInMemIndex(field, kind))
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for _, idx := range indexes {
+		for e := store.payments.Front(); e != nil; e = e.Next() {
+			idx.add(e.Value.(*inMemEntry))
+		}
+		store.indexes[idx.field.Name] = idx
+	}
+	return nil
+}
+
+// PaymentQueryPlan tells how the in memory store runs a query. Index is the
+// field of the index the candidates are read from, empty when all the
+// payments are scanned. Estimate is the amount of candidates.
+type PaymentQueryPlan struct {
+	Index    string `json:"index"`
+	Kind     string `json:"kind"`
+	Estimate int    `json:"estimate"`
+
+	index  *inMemIndex
+	filter *checkedFilter
+}
+
+// Plan returns how the store would run a query with the filters
+func (store *PaymentInMemStore) Plan(filters ...*PaymentStoreFilter) (*PaymentQueryPlan, error) {
+	checked, err := checkFilters(filters)
+	if err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.plan(checked), nil
+}
+
+// plan picks the index with the fewest candidates for the filters, a scan
+// when none has fewer than the whole store. It must be called with the lock
+// held.
+func (store *PaymentInMemStore) plan(filters []*checkedFilter) *PaymentQueryPlan {
+	best := &PaymentQueryPlan{Estimate: len(store.byID)}
+	for _, f := range filters {
+		idx, ok := store.indexes[f.field.Name]
+		if !ok || !idx.serves(f) {
+			continue
+		}
+		if n := idx.count(f, best.Estimate); n < best.Estimate {
+			best = &PaymentQueryPlan{
+				Index:    idx.field.Name,
+				Kind:     idx.kind,
+				Estimate: n,
+				index:    idx,
+				filter:   f,
+			}
+		}
+	}
+	return best
+}
+
+// each calls fn on the candidates of the plan, in insertion order. It stops
+// with the error of ctx once it is done.
+func (store *PaymentInMemStore) each(ctx context.Context, plan *PaymentQueryPlan, fn func(e *inMemEntry)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var err error
+	n := 0
+	visit := func(e *inMemEntry) bool {
+		if n++; n%inMemCheckEvery == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		fn(e)
+		return true
+	}
+	if plan.index != nil {
+		plan.index.each(plan.filter, visit)
+		return err
+	}
+	for e := store.payments.Front(); e != nil; e = e.Next() {
+		if !visit(e.Value.(*inMemEntry)) {
+			break
+		}
+	}
+	return err
+}
+
+// GetManySorted reads the payments in the order of a sorted index on the
+// first sort field when there is one and no index narrows the filters down,
+// they are sorted after being filtered otherwise
+func (store *PaymentInMemStore) GetManySorted(
+	ctx context.Context,
+	limit, offset int,
+	sorts []PaymentSort,
+	filters ...*PaymentStoreFilter,
+) (*PaginatedList, error) {
+	checked, err := checkFilters(filters)
+	if err != nil {
+		return nil, ErrSomethingWentWrong(err)
+	}
+	match := matchChecked(checked)
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	plan := store.plan(checked)
+	var idx *inMemIndex
+	if len(sorts) > 0 && plan.index == nil {
+		if field, ok := LookupPaymentField(sorts[0].Field); ok {
+			idx = store.indexes[field.Name]
+		}
+	}
+	if idx == nil || idx.values == nil {
+		payments := []*Payment{}
+		err := store.each(ctx, plan, func(e *inMemEntry) {
+			if match(e.payment) {
+				payments = append(payments, e.payment)
+			}
+		})
+		if err != nil {
+			return nil, ErrSomethingWentWrong(err)
+		}
+		sortPayments(payments, sorts)
+		list := paginatePayments(&PaginatedList{Total: len(payments)}, payments, limit, offset)
+		page := list.Results.([]*Payment)
+		for i, p := range page {
+			page[i] = p.Copy()
+		}
+		return list, nil
+	}
+
+	ret := []*Payment{}
+	total := 0
+	n := 0
+	add := func(b *inMemBucket) bool {
+		if n++; n%inMemCheckEvery == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		group := []*Payment{}
+		b.entries.Ascend(func(e *inMemEntry) bool {
+			if match(e.payment) {
+				group = append(group, e.payment)
+			}
+			return true
+		})
+		sortPayments(group, sorts[1:])
+		for _, p := range group {
+			if total >= offset && (limit == 0 || len(ret) < limit) {
+				ret = append(ret, p.Copy())
+			}
+			total++
+		}
+		return true
+	}
+	if sorts[0].Desc {
+		idx.values.Descend(add)
+	} else {
+		idx.values.Ascend(add)
+	}
+	if err != nil {
+		return nil, ErrSomethingWentWrong(err)
+	}
+	return &PaginatedList{
+		Total:    total,
+		SubTotal: len(ret),
+		Results:  ret,
+	}, nil
+}
@@ -0,0 +1,439 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// This is a persistence mode of PaymentInMemStore: every write is appended to
+// a write-ahead log before being applied, and the whole store is regularly
+// written to a snapshot that makes the older logs useless.
+//
+// The logs are numbered by generation. Taking a snapshot starts a new
+// generation, the snapshot holds the state before the first record of the
+// log of its generation. Recovery loads the snapshot and replays the logs of
+// its generation and the following ones.
+//
+// A record is its length and CRC32 on 4 bytes each, followed by its JSON.
+// A crash in the middle of a write leaves a torn record at the end of the
+// log, it is dropped on recovery. A record failing its checksum with more
+// data after it is not the end of a write: the recovery fails instead of
+// dropping the records after it, the files are left untouched.
+
+const (
+	FsyncAlways   = "always"
+	FsyncInterval = "interval"
+	FsyncNever    = "never"
+
+	walSnapshotFile = "snapshot.json"
+	walFilePrefix   = "wal-"
+	walFileSuffix   = ".log"
+	walHeaderSize   = 8
+
+	walOpSave   = "save"
+	walOpDelete = "delete"
+)
+
+type walRecord struct {
+	Op      string    `json:"op"`
+	Payment *Payment  `json:"payment,omitempty"`
+	ID      uuid.UUID `json:"id"`
+}
+
+type walSnapshot struct {
+	Generation int        `json:"generation"`
+	Payments   []*Payment `json:"payments"`
+}
+
+// PaymentInMemWALStore is a PaymentInMemStore persisted in the directory of
+// its settings, it must be closed to stop its background tasks
+type PaymentInMemWALStore struct {
+	*PaymentInMemStore
+
+	settings *InMemSettings
+	// mu orders the writes in the log like they are applied to the store,
+	// the reads only rely on the lock of the store
+	mu sync.Mutex
+	// snapshotMu prevents two snapshots from running at the same time
+	snapshotMu sync.Mutex
+	generation int
+	wal        *os.File
+	walSize    int64
+	dirty      bool
+	// records is the amount of records appended since the last snapshot
+	records  int
+	snapshot chan struct{}
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewPaymentInMemWALStore recovers the store from its directory, compacts
+// what was recovered in a new snapshot and starts logging the writes
+func NewPaymentInMemWALStore(settings *InMemSettings) (*PaymentInMemWALStore, error) {
+	switch settings.Fsync {
+	case FsyncAlways, FsyncInterval, FsyncNever:
+	default:
+		return nil, fmt.Errorf("unknown fsync policy %q, expected %s, %s or %s",
+			settings.Fsync, FsyncAlways, FsyncInterval, FsyncNever)
+	}
+	if err := os.MkdirAll(settings.Dir, 0700); err != nil {
+		return nil, err
+	}
+	store := &PaymentInMemWALStore{
+		PaymentInMemStore: NewPaymentInMemStore(),
+		settings:          settings,
+		snapshot:          make(chan struct{}, 1),
+		done:              make(chan struct{}),
+	}
+	if err := store.AddIndexes(settings.Indexes...); err != nil {
+		return nil, err
+	}
+	if err := store.recover(); err != nil {
+		return nil, err
+	}
+	if err := store.Snapshot(); err != nil {
+		return nil, err
+	}
+	store.wg.Add(1)
+	go store.run()
+	return store, nil
+}
+
+func (store *PaymentInMemWALStore) walPath(generation int) string {
+	return filepath.Join(store.settings.Dir, fmt.Sprintf("%s%06d%s", walFilePrefix, generation, walFileSuffix))
+}
+
+// walGenerations returns the generations of the logs in the directory, in
+// order
+func (store *PaymentInMemWALStore) walGenerations() ([]int, error) {
+	names, err := filepath.Glob(filepath.Join(store.settings.Dir, walFilePrefix+"*"+walFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	ret := []int{}
+	for _, name := range names {
+		var generation int
+		base := strings.TrimSuffix(filepath.Base(name), walFileSuffix)
+		if _, err := fmt.Sscanf(base, walFilePrefix+"%d", &generation); err == nil {
+			ret = append(ret, generation)
+		}
+	}
+	sort.Ints(ret)
+	return ret, nil
+}
+
+// recover loads the snapshot and replays the logs written after it
+func (store *PaymentInMemWALStore) recover() error {
+	data, err := os.ReadFile(filepath.Join(store.settings.Dir, walSnapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		snapshot := &walSnapshot{}
+		if err := json.Unmarshal(data, snapshot); err != nil {
+			return fmt.Errorf("corrupted snapshot: %v", err)
+		}
+		for _, p := range snapshot.Payments {
+			store.put(p)
+		}
+		store.generation = snapshot.Generation
+	}
+	generations, err := store.walGenerations()
+	if err != nil {
+		return err
+	}
+	replayed := 0
+	for _, generation := range generations {
+		if generation < store.generation {
+			continue
+		}
+		n, err := store.replay(store.walPath(generation))
+		if err != nil {
+			return err
+		}
+		replayed += n
+		store.generation = generation
+	}
+	logrus.Infof("In memory store: recovered %d payments, %d records replayed", store.Total(context.Background()), replayed)
+	return nil
+}
+
+// replay applies the records of a log. A torn or corrupted record ends it
+// when it is the last one, it is an error otherwise.
+func (store *PaymentInMemWALStore) replay(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	n := 0
+	for {
+		record, err := readWALRecord(r)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			if _, peekErr := r.Peek(1); peekErr != io.EOF {
+				return n, fmt.Errorf("%s is corrupted after %d records: %v", path, n, err)
+			}
+			logrus.Warnf("In memory store: %s is truncated after %d records: %v", path, n, err)
+			return n, nil
+		}
+		switch record.Op {
+		case walOpSave:
+			store.put(record.Payment)
+		case walOpDelete:
+			store.delete(record.ID)
+		}
+		n++
+	}
+}
+
+var errTornRecord = errors.New("torn record")
+
+func readWALRecord(r io.Reader) (*walRecord, error) {
+	header := make([]byte, walHeaderSize)
+	if n, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF && n == 0 {
+			return nil, io.EOF
+		}
+		return nil, errTornRecord
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[:4]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, errTornRecord
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, errors.New("checksum mismatch")
+	}
+	record := &walRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, err
+	}
+	if record.Op == walOpSave && record.Payment == nil {
+		return nil, errors.New("save record without payment")
+	}
+	return record, nil
+}
+
+// append writes a record to the log, it must be called with the lock held
+func (store *PaymentInMemWALStore) append(record *walRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, walHeaderSize, walHeaderSize+len(data))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(data))
+	n, err := store.wal.Write(append(buf, data...))
+	if err != nil {
+		// a partial record would hide the following ones on recovery
+		store.wal.Truncate(store.walSize)
+		return err
+	}
+	store.walSize += int64(n)
+	if store.settings.Fsync == FsyncAlways {
+		if err := store.wal.Sync(); err != nil {
+			return err
+		}
+	} else {
+		store.dirty = true
+	}
+	store.records++
+	if store.settings.SnapshotRecords > 0 && store.records >= store.settings.SnapshotRecords {
+		select {
+		case store.snapshot <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Snapshot writes the store to a new snapshot and removes the logs it makes
+// useless. The writes are only blocked while the store is encoded.
+func (store *PaymentInMemWALStore) Snapshot() error {
+	store.snapshotMu.Lock()
+	defer store.snapshotMu.Unlock()
+
+	store.mu.Lock()
+	data, err := json.Marshal(&walSnapshot{
+		Generation: store.generation + 1,
+		Payments:   store.all(),
+	})
+	if err != nil {
+		store.mu.Unlock()
+		return err
+	}
+	wal, err := os.OpenFile(store.walPath(store.generation+1), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		store.mu.Unlock()
+		return err
+	}
+	if store.wal != nil {
+		store.wal.Sync()
+		store.wal.Close()
+	}
+	store.wal = wal
+	store.walSize = 0
+	store.generation++
+	store.records = 0
+	store.dirty = false
+	generation := store.generation
+	store.mu.Unlock()
+
+	if err := writeFileSync(filepath.Join(store.settings.Dir, walSnapshotFile), data); err != nil {
+		return err
+	}
+	generations, err := store.walGenerations()
+	if err != nil {
+		return err
+	}
+	for _, g := range generations {
+		if g < generation {
+			os.Remove(store.walPath(g))
+		}
+	}
+	return nil
+}
+
+// writeFileSync replaces the file at path atomically, its content is on the
+// disk once it returns
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// sync flushes the log to the disk when it was written since the last time
+func (store *PaymentInMemWALStore) sync() {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if !store.dirty {
+		return
+	}
+	if err := store.wal.Sync(); err != nil {
+		logrus.Errorf("In memory store: could not sync the log: %v", err)
+		return
+	}
+	store.dirty = false
+}
+
+func (store *PaymentInMemWALStore) run() {
+	defer store.wg.Done()
+	var syncs, snapshots <-chan time.Time
+	if store.settings.Fsync == FsyncInterval && store.settings.FsyncInterval > 0 {
+		t := time.NewTicker(store.settings.FsyncInterval)
+		defer t.Stop()
+		syncs = t.C
+	}
+	if store.settings.SnapshotInterval > 0 {
+		t := time.NewTicker(store.settings.SnapshotInterval)
+		defer t.Stop()
+		snapshots = t.C
+	}
+	for {
+		select {
+		case <-store.done:
+			return
+		case <-syncs:
+			store.sync()
+		case <-snapshots:
+			store.runSnapshot()
+		case <-store.snapshot:
+			store.runSnapshot()
+		}
+	}
+}
+
+func (store *PaymentInMemWALStore) runSnapshot() {
+	if err := store.Snapshot(); err != nil {
+		logrus.Errorf("In memory store: could not write a snapshot: %v", err)
+	}
+}
+
+// Close stops the background tasks and flushes the log
+func (store *PaymentInMemWALStore) Close() error {
+	close(store.done)
+	store.wg.Wait()
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if err := store.wal.Sync(); err != nil {
+		return err
+	}
+	return store.wal.Close()
+}
+
+// Save logs the payment before storing it, nothing is stored when the log
+// can not be written
+func (store *PaymentInMemWALStore) Save(ctx context.Context, p *Payment) error {
+	if p == nil {
+		return ErrSomethingWentWrong(ErrNilValue)
+	}
+	if err := ctx.Err(); err != nil {
+		return ErrSomethingWentWrong(err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.has(p.ID) {
+		p.UpdatedAt = Now()
+	}
+	if err := store.append(&walRecord{Op: walOpSave, Payment: p}); err != nil {
+		return ErrSomethingWentWrong(err)
+	}
+	store.put(p)
+	return nil
+}
+
+func (store *PaymentInMemWALStore) Delete(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return ErrSomethingWentWrong(err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if !store.has(id) {
+		return nil
+	}
+	if err := store.append(&walRecord{Op: walOpDelete, ID: id}); err != nil {
+		return ErrSomethingWentWrong(err)
+	}
+	store.delete(id)
+	return nil
+}
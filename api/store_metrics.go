@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PaymentMetricsStore is a PaymentStore decorator recording the duration and
+// the failures of the operations of the decorated store, labelled by Backend
+type PaymentMetricsStore struct {
+	PaymentStore
+	Backend string
+}
+
+func NewPaymentMetricsStore(s PaymentStore, backend string) *PaymentMetricsStore {
+	return &PaymentMetricsStore{PaymentStore: s, Backend: backend}
+}
+
+func (store *PaymentMetricsStore) Total(ctx context.Context) int {
+	defer metrics.observeStore(store.Backend, "total", time.Now(), nil)
+	return store.PaymentStore.Total(ctx)
+}
+
+func (store *PaymentMetricsStore) GetMany(
+	ctx context.Context,
+	limit, offset int,
+	filters ...*PaymentStoreFilter,
+) (list *PaginatedList, err error) {
+	defer func(start time.Time) { metrics.observeStore(store.Backend, "get_many", start, err) }(time.Now())
+	return store.PaymentStore.GetMany(ctx, limit, offset, filters...)
+}
+
+// GetManySorted lets the decorated store sort the payments when it can
+func (store *PaymentMetricsStore) GetManySorted(
+	ctx context.Context,
+	limit, offset int,
+	sorts []PaymentSort,
+	filters ...*PaymentStoreFilter,
+) (list *PaginatedList, err error) {
+	defer func(start time.Time) { metrics.observeStore(store.Backend, "get_many_sorted", start, err) }(time.Now())
+	return GetManySorted(ctx, store.PaymentStore, limit, offset, sorts, filters...)
+}
+
+func (store *PaymentMetricsStore) GetByID(ctx context.Context, id uuid.UUID) (p *Payment, err error) {
+	defer func(start time.Time) { metrics.observeStore(store.Backend, "get_by_id", start, err) }(time.Now())
+	return store.PaymentStore.GetByID(ctx, id)
+}
+
+func (store *PaymentMetricsStore) Save(ctx context.Context, p *Payment) (err error) {
+	defer func(start time.Time) { metrics.observeStore(store.Backend, "save", start, err) }(time.Now())
+	return store.PaymentStore.Save(ctx, p)
+}
+
+func (store *PaymentMetricsStore) Delete(ctx context.Context, id uuid.UUID) (err error) {
+	defer func(start time.Time) { metrics.observeStore(store.Backend, "delete", start, err) }(time.Now())
+	return store.PaymentStore.Delete(ctx, id)
+}
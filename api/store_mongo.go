@@ -1,7 +1,12 @@
 package api
 
 import (
-	"reflect"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
 
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
@@ -18,6 +23,7 @@ type MongoQuery interface {
 	Count() (n int, err error)
 	Skip(n int) MongoQuery
 	Limit(n int) MongoQuery
+	Sort(fields ...string) MongoQuery
 }
 
 // MongoCollection interfaces the *mgo.Collection type
@@ -48,6 +54,13 @@ func (q *MgoWrapQuery) Limit(n int) MongoQuery {
 	return &MgoWrapQuery{Query: q.Query.Limit(n)}
 }
 
+func (q *MgoWrapQuery) Sort(fields ...string) MongoQuery {
+	if q.Query == nil {
+		return q
+	}
+	return &MgoWrapQuery{Query: q.Query.Sort(fields...)}
+}
+
 type MgoWrapCollection struct {
 	*mgo.Collection
 }
@@ -66,62 +79,220 @@ func (c *MgoWrapCollection) Find(query interface{}) MongoQuery {
 	return &MgoWrapQuery{Query: c.Collection.Find(query)}
 }
 
+// WithContext returns a collection on a copy of the session, its socket
+// timeout is the time left before the deadline of ctx. The copy must be
+// released once the operation is done.
+func (c *MgoWrapCollection) WithContext(ctx context.Context) (MongoCollection, func()) {
+	if c.Collection == nil {
+		return c, func() {}
+	}
+	s := c.Database.Session.Copy()
+	if deadline, ok := ctx.Deadline(); ok {
+		if d := time.Until(deadline); d > 0 {
+			s.SetSocketTimeout(d)
+		}
+	}
+	return &MgoWrapCollection{Collection: c.Collection.With(s)}, s.Close
+}
+
+// mongoContextCollection is implemented by the collections able to bound an
+// operation with a context
+type mongoContextCollection interface {
+	WithContext(ctx context.Context) (MongoCollection, func())
+}
+
 type PaymentMongoStore struct {
 	MongoCollection
+
+	// Outbox receives the events of the payments saved or deleted through the
+	// store, it is optional
+	Outbox Outbox
+	// Breaker stops the operations while the database keeps failing, they
+	// fail with ErrStoreUnavailable. It is optional.
+	Breaker *CircuitBreaker
 }
 
 func NewPaymentMongoStore(c MongoCollection) *PaymentMongoStore {
-	return &PaymentMongoStore{c}
+	return &PaymentMongoStore{MongoCollection: c}
+}
+
+// do runs fn on the collection, unless the breaker is open, and records its
+// outcome in the breaker
+func (store *PaymentMongoStore) do(ctx context.Context, fn func(c MongoCollection) error) error {
+	return store.exec(ctx, false, fn)
+}
+
+// write runs a write like do but waits for its outcome, even past the end of
+// ctx: a write giving up on its context could still go through, with its
+// event left uncommitted. The write is bounded by the socket timeout of its
+// session instead, the event of a write timing out is resolved by the relay
+// like after a crash.
+func (store *PaymentMongoStore) write(ctx context.Context, fn func(c MongoCollection) error) error {
+	return store.exec(ctx, true, fn)
 }
 
-func (store *PaymentMongoStore) Total() int {
-	n, _ := store.Count()
+func (store *PaymentMongoStore) exec(ctx context.Context, wait bool, fn func(c MongoCollection) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if store.Breaker == nil {
+		return store.run(ctx, wait, fn)
+	}
+	if !store.Breaker.Allow() {
+		return ErrStoreUnavailable
+	}
+	err := store.run(ctx, wait, fn)
+	if mongoFailure(err) {
+		store.Breaker.Failure()
+	} else if !errors.Is(err, context.Canceled) {
+		store.Breaker.Success()
+	}
+	return err
+}
+
+// mongoFailure tells whether err is a failure of the database rather than its
+// answer to the operation. An operation running past its deadline is a
+// failure, a canceled one is not.
+func mongoFailure(err error) bool {
+	switch err.(type) {
+	case *mgo.QueryError, *mgo.LastError:
+		return false
+	}
+	return err != nil && err != mgo.ErrNotFound && !errors.Is(err, context.Canceled)
+}
+
+// run runs fn on the collection until ctx is done, the error of ctx is
+// returned if it is done first unless wait is set. mgo does not take a
+// context: fn keeps running on its own copy of the session until the socket
+// timeout.
+func (store *PaymentMongoStore) run(ctx context.Context, wait bool, fn func(c MongoCollection) error) error {
+	c, release := store.MongoCollection, func() {}
+	if cc, ok := c.(mongoContextCollection); ok {
+		c, release = cc.WithContext(ctx)
+	}
+	if wait {
+		defer release()
+		return fn(c)
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer release()
+		done <- fn(c)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (store *PaymentMongoStore) Total(ctx context.Context) int {
+	n := 0
+	store.do(ctx, func(c MongoCollection) error {
+		var err error
+		n, err = c.Count()
+		return err
+	})
 	return n
 }
 
 func (store *PaymentMongoStore) GetMany(
+	ctx context.Context,
 	limit, offset int,
 	filters ...*PaymentStoreFilter,
 ) (*PaginatedList, error) {
-	ret := []*Payment{}
-	query := bson.M{}
-	if filters != nil {
-		t := reflect.TypeOf(Payment{})
-		for _, f := range filters {
-			for i := 0; i < t.NumField(); i++ {
-				if t.Field(i).Name == f.Field {
-					tagValue := t.Field(i).Tag.Get("bson")
-					if tagValue == "" {
-						tagValue = t.Field(i).Tag.Get("json")
-					} else if tagValue == "" {
-						tagValue = f.Field
-					}
-					switch f.Type {
-					case PaymentStoreFilterTypeEqual:
-						query[tagValue] = f.Want
-					case PaymentStoreFilterTypeIn:
-						if t.Kind() != reflect.Slice {
-							logrus.Warn("This filter is expecting a slice")
-						} else {
-							query[tagValue] = bson.M{
-								"$in": f.Want,
-							}
-						}
-					}
-				}
-			}
+	return store.GetManySorted(ctx, limit, offset, nil, filters...)
+}
+
+// mongoSortKey returns the key of the documents a sort applies to, prefixed
+// with - when descending
+func mongoSortKey(s PaymentSort) (string, error) {
+	key := ""
+	switch s.Field {
+	case PaymentSortCreatedAt, PaymentSortUpdatedAt:
+		key = strings.ToLower(s.Field)
+	default:
+		f, ok := LookupPaymentField(s.Field)
+		if !ok {
+			return "", fmt.Errorf("unknown sort field %q", s.Field)
 		}
+		key = strings.ToLower(f.GoName)
 	}
-	q := store.Find(query)
-	total, err := q.Count()
+	if s.Desc {
+		key = "-" + key
+	}
+	return key, nil
+}
+
+// GetManySorted lets MongoDB filter, sort and paginate the payments, the ids
+// break the ties so the pages are stable. The payments are in natural order
+// when there is no sort.
+func (store *PaymentMongoStore) GetManySorted(
+	ctx context.Context,
+	limit, offset int,
+	sorts []PaymentSort,
+	filters ...*PaymentStoreFilter,
+) (*PaginatedList, error) {
+	order := []string{}
+	for _, s := range sorts {
+		key, err := mongoSortKey(s)
+		if err != nil {
+			return nil, ErrSomethingWentWrong(err)
+		}
+		order = append(order, key)
+	}
+	if len(order) > 0 {
+		order = append(order, "_id")
+	}
+	checked, err := checkFilters(filters)
 	if err != nil {
 		return nil, ErrSomethingWentWrong(err)
 	}
-	q = q.Skip(offset)
-	if limit > 0 {
-		q = q.Limit(limit)
+	ret := []*Payment{}
+	query := bson.M{}
+	for _, f := range checked {
+		// mgo names the fields after their lowercased Go name by default
+		key := strings.ToLower(f.field.GoName)
+		switch f.typ {
+		case PaymentStoreFilterTypeEqual:
+			query[key] = mongoFilterValue(f.field, f.values[0])
+		case PaymentStoreFilterTypeIn:
+			values := make([]interface{}, len(f.values))
+			for i, v := range f.values {
+				values[i] = mongoFilterValue(f.field, v)
+			}
+			query[key] = bson.M{"$in": values}
+		case PaymentStoreFilterTypeRange:
+			bounds := bson.M{}
+			if f.from != "" {
+				bounds["$gte"] = mongoFilterValue(f.field, f.from)
+			}
+			if f.to != "" {
+				bounds["$lte"] = mongoFilterValue(f.field, f.to)
+			}
+			if len(bounds) > 0 {
+				query[key] = bounds
+			}
+		}
 	}
-	if err := q.All(&ret); err != nil {
+	total := 0
+	err = store.do(ctx, func(c MongoCollection) error {
+		q := c.Find(query)
+		var err error
+		if total, err = q.Count(); err != nil {
+			return err
+		}
+		if len(order) > 0 {
+			q = q.Sort(order...)
+		}
+		q = q.Skip(offset)
+		if limit > 0 {
+			q = q.Limit(limit)
+		}
+		return q.All(&ret)
+	})
+	if err != nil {
 		return nil, ErrSomethingWentWrong(err)
 	}
 	return &PaginatedList{
@@ -131,9 +302,47 @@ func (store *PaymentMongoStore) GetMany(
 	}, nil
 }
 
-func (store *PaymentMongoStore) GetByID(id uuid.UUID) (*Payment, error) {
+// mongoWriteError returns ErrConflict when a write broke a unique index,
+// ErrTimeout when it ran past the socket timeout of its session
+func mongoWriteError(err error) *APIError {
+	if mgo.IsDup(err) {
+		return ErrConflict
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return ErrTimeout
+	}
+	return ErrSomethingWentWrong(err)
+}
+
+// mongoWriteRejected tells whether a write failed for certain: the database
+// answered with an error, or the write was not sent at all. The outcome of
+// the other failures, such as a timeout, is unknown.
+func mongoWriteRejected(err error) bool {
+	switch err.(type) {
+	case *mgo.QueryError, *mgo.LastError:
+		return true
+	}
+	return errors.Is(err, ErrStoreUnavailable) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// mongoFilterValue returns the value a field is compared with, the times are
+// stored as dates
+func mongoFilterValue(field *PaymentField, v string) interface{} {
+	if !field.Time {
+		return v
+	}
+	t, _ := time.Parse(PaymentTimeLayout, v)
+	return t
+}
+
+func (store *PaymentMongoStore) GetByID(ctx context.Context, id uuid.UUID) (*Payment, error) {
 	ret := Payment{}
-	if err := store.FindId(id).One(&ret); err != nil {
+	err := store.do(ctx, func(c MongoCollection) error {
+		return c.FindId(id).One(&ret)
+	})
+	if err != nil {
 		if err != mgo.ErrNotFound {
 			return nil, ErrSomethingWentWrong(err)
 		} else {
@@ -143,22 +352,96 @@ func (store *PaymentMongoStore) GetByID(id uuid.UUID) (*Payment, error) {
 	return &ret, nil
 }
 
-func (store *PaymentMongoStore) Save(p *Payment) error {
+// prepareEvent is the first step of the outbox protocol, it does nothing if
+// the store has no outbox
+func (store *PaymentMongoStore) prepareEvent(typ PaymentEventType, p *Payment) (*OutboxEvent, error) {
+	if store.Outbox == nil {
+		return nil, nil
+	}
+	e := NewOutboxEvent(NewPaymentEvent(typ, p))
+	if err := store.Outbox.Prepare(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// commitEvent is the last step of the outbox protocol, typ is the type the
+// write turned out to have. A failure is not returned since the write went
+// through: the relay will recover the event. It is logged with the context of
+// the write.
+func (store *PaymentMongoStore) commitEvent(ctx context.Context, e *OutboxEvent, typ PaymentEventType) {
+	if e == nil {
+		return
+	}
+	if err := store.Outbox.Commit(e.ID, typ); err != nil {
+		logrus.WithContext(ctx).Warnf("Outbox: could not commit event %d, it will be recovered: %v", e.Sequence, err)
+	}
+}
+
+// discardEvent undoes the first step of the outbox protocol when the write
+// failed for certain. The event of a write whose outcome is unknown is left
+// to the relay, which checks the payment.
+func (store *PaymentMongoStore) discardEvent(ctx context.Context, e *OutboxEvent, err error) {
+	if e == nil || !mongoWriteRejected(err) {
+		return
+	}
+	if err := store.Outbox.Discard(e.ID); err != nil {
+		logrus.WithContext(ctx).Warnf("Outbox: could not discard event %d, it will be recovered: %v", e.Sequence, err)
+	}
+}
+
+func (store *PaymentMongoStore) Save(ctx context.Context, p *Payment) error {
+	if p == nil {
+		return ErrSomethingWentWrong(ErrNilValue)
+	}
 	if len(p.ID) == 0 {
 		p.ID = uuid.New()
 	}
 	p.UpdatedAt = Now()
-	if _, err := store.UpsertId(p.ID, p); err != nil {
-		return ErrSomethingWentWrong(err)
+	// the write tells whether the payment was created, the event is
+	// prepared as an update until then
+	e, err := store.prepareEvent(PaymentEventUpdated, p)
+	if err != nil {
+		return err
 	}
+	var info *mgo.ChangeInfo
+	err = store.write(ctx, func(c MongoCollection) error {
+		var err error
+		info, err = c.UpsertId(p.ID, p)
+		return err
+	})
+	if err != nil {
+		store.discardEvent(ctx, e, err)
+		return mongoWriteError(err)
+	}
+	typ := PaymentEventUpdated
+	if info != nil && info.UpsertedId != nil {
+		typ = PaymentEventCreated
+	}
+	store.commitEvent(ctx, e, typ)
 	return nil
 }
 
-func (store *PaymentMongoStore) Delete(id uuid.UUID) error {
-	if err := store.RemoveId(id); err != nil {
-		if err != mgo.ErrNotFound {
-			return ErrSomethingWentWrong(err)
+func (store *PaymentMongoStore) Delete(ctx context.Context, id uuid.UUID) error {
+	var e *OutboxEvent
+	if store.Outbox != nil {
+		p, err := store.GetByID(ctx, id)
+		if err == ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
 		}
+		if e, err = store.prepareEvent(PaymentEventDeleted, p); err != nil {
+			return err
+		}
+	}
+	err := store.write(ctx, func(c MongoCollection) error {
+		return c.RemoveId(id)
+	})
+	if err != nil && err != mgo.ErrNotFound {
+		store.discardEvent(ctx, e, err)
+		return mongoWriteError(err)
 	}
+	store.commitEvent(ctx, e, PaymentEventDeleted)
 	return nil
 }
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/sirupsen/logrus"
+)
+
+// The indexes of the payments collection are declared in the configuration
+// and ensured when the API starts. A declaration lists the keys of the
+// documents, prefixed with a dash for a descending order, followed by
+// ":unique" for a unique index: "scheme" or
+// "organisation,endtoendreference:unique". The keys are the ones of the
+// documents, mgo names the fields after their lowercased Go name.
+//
+// A unique index only covers the documents whose keys are non-empty strings:
+// the payments leaving a field blank, or without it, do not conflict.
+
+const (
+	MongoIndexUnique = "unique"
+
+	MongoIndexStatusOK      = "ok"
+	MongoIndexStatusMissing = "missing"
+	MongoIndexStatusUnknown = "unknown"
+	// MongoIndexStatusChanged is the status of a declared index existing
+	// with other options, it must be dropped to be ensured again
+	MongoIndexStatusChanged = "changed"
+
+	mongoIndexID = "_id_"
+	// mongoCodeNamespaceNotFound is returned when listing the indexes of a
+	// collection that does not exist yet
+	mongoCodeNamespaceNotFound = 26
+)
+
+// MongoIndexer interfaces the index management of *mgo.Collection
+type MongoIndexer interface {
+	EnsureIndex(index mgo.Index) error
+	Indexes() ([]mgo.Index, error)
+	DropIndexName(name string) error
+}
+
+// ParseMongoIndex parses the declaration of an index
+func ParseMongoIndex(spec string) (mgo.Index, error) {
+	index := mgo.Index{}
+	keys := spec
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		keys = spec[:i]
+		if option := spec[i+1:]; option != MongoIndexUnique {
+			return index, fmt.Errorf("unknown index option %q in %q, expected %s", option, spec, MongoIndexUnique)
+		}
+		index.Unique = true
+	}
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		if strings.TrimPrefix(key, "-") == "" {
+			return index, fmt.Errorf("empty key in index %q", spec)
+		}
+		index.Key = append(index.Key, key)
+	}
+	index.Name = mongoIndexName(index.Key)
+	if index.Unique {
+		index.PartialFilter = mongoIndexPartialFilter(index.Key)
+	}
+	return index, nil
+}
+
+// mongoIndexPartialFilter returns the filter of the documents whose keys are
+// non-empty strings
+func mongoIndexPartialFilter(keys []string) bson.M {
+	filter := bson.M{}
+	for _, key := range keys {
+		filter[strings.TrimPrefix(key, "-")] = bson.M{"$gt": ""}
+	}
+	return filter
+}
+
+// mongoIndexChanged tells whether an existing index has other options than
+// its declaration
+func mongoIndexChanged(found, declared mgo.Index) bool {
+	if found.Unique != declared.Unique {
+		return true
+	}
+	if len(found.PartialFilter) == 0 && len(declared.PartialFilter) == 0 {
+		return false
+	}
+	return !reflect.DeepEqual(found.PartialFilter, declared.PartialFilter)
+}
+
+// mongoIndexName returns the name mgo gives to an index on the keys
+func mongoIndexName(keys []string) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		if strings.HasPrefix(key, "-") {
+			parts[i] = key[1:] + "_-1"
+		} else {
+			parts[i] = key + "_1"
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+// MongoIndexStatus tells whether an index of the collection is declared
+type MongoIndexStatus struct {
+	Name   string   `json:"name"`
+	Key    []string `json:"key"`
+	Unique bool     `json:"unique"`
+	Status string   `json:"status"`
+}
+
+// MongoIndexManager keeps the indexes of a collection in line with the
+// declared ones
+type MongoIndexManager struct {
+	Collection MongoIndexer
+	Declared   []mgo.Index
+}
+
+// NewMongoIndexManager parses the declarations of specs, see ParseMongoIndex
+func NewMongoIndexManager(c MongoIndexer, specs []string) (*MongoIndexManager, error) {
+	m := &MongoIndexManager{Collection: c}
+	for _, spec := range specs {
+		index, err := ParseMongoIndex(spec)
+		if err != nil {
+			return nil, err
+		}
+		m.Declared = append(m.Declared, index)
+	}
+	return m, nil
+}
+
+func (m *MongoIndexManager) existing() (map[string]mgo.Index, error) {
+	indexes, err := m.Collection.Indexes()
+	if qerr, ok := err.(*mgo.QueryError); ok && qerr.Code == mongoCodeNamespaceNotFound {
+		return map[string]mgo.Index{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	ret := map[string]mgo.Index{}
+	for _, index := range indexes {
+		ret[index.Name] = index
+	}
+	return ret, nil
+}
+
+// Sync creates the declared indexes missing from the collection and returns
+// their names. Creating a unique index fails when the documents already hold
+// duplicates, the other indexes are created anyway and the failures are
+// returned together. The changed indexes are left as they are, with a
+// warning.
+func (m *MongoIndexManager) Sync() ([]string, error) {
+	existing, err := m.existing()
+	if err != nil {
+		return nil, err
+	}
+	created, failures := []string{}, []string{}
+	for _, index := range m.Declared {
+		if found, ok := existing[index.Name]; ok {
+			if mongoIndexChanged(found, index) {
+				logrus.Warnf("Mongo: index %s exists with other options, it must be dropped to be created again", index.Name)
+			}
+			continue
+		}
+		if err := m.Collection.EnsureIndex(index); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", index.Name, err))
+			continue
+		}
+		created = append(created, index.Name)
+	}
+	if len(failures) > 0 {
+		return created, fmt.Errorf("could not create the indexes %s", strings.Join(failures, "; "))
+	}
+	return created, nil
+}
+
+// List returns the declared indexes followed by the unknown ones, the index
+// of the ids is left out
+func (m *MongoIndexManager) List() ([]*MongoIndexStatus, error) {
+	existing, err := m.existing()
+	if err != nil {
+		return nil, err
+	}
+	ret := []*MongoIndexStatus{}
+	declared := map[string]bool{}
+	for _, index := range m.Declared {
+		declared[index.Name] = true
+		status := MongoIndexStatusMissing
+		if found, ok := existing[index.Name]; ok {
+			status = MongoIndexStatusOK
+			if mongoIndexChanged(found, index) {
+				status = MongoIndexStatusChanged
+			}
+		}
+		ret = append(ret, &MongoIndexStatus{
+			Name:   index.Name,
+			Key:    index.Key,
+			Unique: index.Unique,
+			Status: status,
+		})
+	}
+	for _, index := range m.unknown(existing, declared) {
+		ret = append(ret, &MongoIndexStatus{
+			Name:   index.Name,
+			Key:    index.Key,
+			Unique: index.Unique,
+			Status: MongoIndexStatusUnknown,
+		})
+	}
+	return ret, nil
+}
+
+// unknown returns the indexes of the collection that are not declared,
+// sorted by name
+func (m *MongoIndexManager) unknown(existing map[string]mgo.Index, declared map[string]bool) []mgo.Index {
+	ret := []mgo.Index{}
+	for name, index := range existing {
+		if name != mongoIndexID && !declared[name] {
+			ret = append(ret, index)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// DropUnknown drops the indexes of the collection that are not declared and
+// returns their names, nothing is dropped with dryRun
+func (m *MongoIndexManager) DropUnknown(dryRun bool) ([]string, error) {
+	existing, err := m.existing()
+	if err != nil {
+		return nil, err
+	}
+	declared := map[string]bool{}
+	for _, index := range m.Declared {
+		declared[index.Name] = true
+	}
+	dropped := []string{}
+	for _, index := range m.unknown(existing, declared) {
+		if !dryRun {
+			if err := m.Collection.DropIndexName(index.Name); err != nil {
+				return dropped, fmt.Errorf("could not drop index %s: %w", index.Name, err)
+			}
+		}
+		dropped = append(dropped, index.Name)
+	}
+	return dropped, nil
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// PaymentPendingStore is a PaymentStore standing for a store created once its
+// database is reachable, the API serves before it is. The operations fail
+// with ErrStoreUnavailable until the store is set.
+type PaymentPendingStore struct {
+	mu    sync.RWMutex
+	store PaymentStore
+}
+
+func NewPaymentPendingStore() *PaymentPendingStore {
+	return &PaymentPendingStore{}
+}
+
+// Set sets the store the operations go to
+func (store *PaymentPendingStore) Set(s PaymentStore) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.store = s
+}
+
+// Ready tells whether the store is set
+func (store *PaymentPendingStore) Ready() bool {
+	return store.get() != nil
+}
+
+func (store *PaymentPendingStore) get() PaymentStore {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.store
+}
+
+func (store *PaymentPendingStore) Total(ctx context.Context) int {
+	s := store.get()
+	if s == nil {
+		return 0
+	}
+	return s.Total(ctx)
+}
+
+func (store *PaymentPendingStore) GetMany(
+	ctx context.Context,
+	limit, offset int,
+	filters ...*PaymentStoreFilter,
+) (*PaginatedList, error) {
+	s := store.get()
+	if s == nil {
+		return nil, ErrStoreUnavailable
+	}
+	return s.GetMany(ctx, limit, offset, filters...)
+}
+
+// GetManySorted lets the store sort the payments when it can
+func (store *PaymentPendingStore) GetManySorted(
+	ctx context.Context,
+	limit, offset int,
+	sorts []PaymentSort,
+	filters ...*PaymentStoreFilter,
+) (*PaginatedList, error) {
+	s := store.get()
+	if s == nil {
+		return nil, ErrStoreUnavailable
+	}
+	return GetManySorted(ctx, s, limit, offset, sorts, filters...)
+}
+
+func (store *PaymentPendingStore) GetByID(ctx context.Context, id uuid.UUID) (*Payment, error) {
+	s := store.get()
+	if s == nil {
+		return nil, ErrStoreUnavailable
+	}
+	return s.GetByID(ctx, id)
+}
+
+func (store *PaymentPendingStore) Save(ctx context.Context, p *Payment) error {
+	s := store.get()
+	if s == nil {
+		return ErrStoreUnavailable
+	}
+	return s.Save(ctx, p)
+}
+
+func (store *PaymentPendingStore) Delete(ctx context.Context, id uuid.UUID) error {
+	s := store.get()
+	if s == nil {
+		return ErrStoreUnavailable
+	}
+	return s.Delete(ctx, id)
+}
@@ -0,0 +1,528 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	// Registers the postgres driver
+	_ "github.com/lib/pq"
+	// Registers the sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// This is an implementation of PaymentStore on database/sql, for SQLite and
+// PostgreSQL. The schema is normalised: the parties and the sender charges of
+// a payment have their own tables. It is created by the migrations of
+// SQLMigrations.
+
+const (
+	SQLDriverSQLite   = "sqlite3"
+	SQLDriverPostgres = "postgres"
+
+	sqlPartyBeneficiary = "beneficiary"
+	sqlPartyDebitor     = "debitor"
+
+	// SQLBatchSize is the default number of payments whose parties and
+	// charges are loaded by a single query, one parameter each. It is below
+	// the parameters limit of SQLite, 999 before 3.32.
+	SQLBatchSize = 500
+)
+
+// SQLDialect holds what differs between the supported databases
+type SQLDialect struct {
+	Name string
+	// Serial is the definition of an auto incremented primary key
+	Serial    string
+	UUID      string
+	Timestamp string
+	// NoLimit is the LIMIT of a query with only an OFFSET
+	NoLimit string
+	// Numbered is true when the placeholders are numbered, $1 instead of ?
+	Numbered bool
+}
+
+var SQLDialects = map[string]*SQLDialect{
+	SQLDriverSQLite: {
+		Name:      SQLDriverSQLite,
+		Serial:    "INTEGER PRIMARY KEY AUTOINCREMENT",
+		UUID:      "TEXT",
+		Timestamp: "TIMESTAMP",
+		NoLimit:   "-1",
+	},
+	SQLDriverPostgres: {
+		Name:      SQLDriverPostgres,
+		Serial:    "BIGSERIAL PRIMARY KEY",
+		UUID:      "UUID",
+		Timestamp: "TIMESTAMPTZ",
+		NoLimit:   "ALL",
+		Numbered:  true,
+	},
+}
+
+// Rebind replaces the ? placeholders of query by the ones of the dialect
+func (d *SQLDialect) Rebind(query string) string {
+	if !d.Numbered {
+		return query
+	}
+	b := strings.Builder{}
+	n := 0
+	for _, c := range query {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+// sqlPaymentColumns are the columns of the payments table, in the order
+// sqlPaymentValues and scanPayment use them
+var sqlPaymentColumns = []string{
+	"id", "created_at", "updated_at", "purpose", "scheme", "type", "amount",
+	"currency", "end_to_end_reference", "numeric_reference", "processing_date",
+	"reference", "scheme_payment_sub_type", "scheme_payment_type",
+	"bearer_code", "receiver_charges_amount", "receiver_charges_currency",
+	"fx_contract_reference", "fx_exchange_rate", "fx_original_amount",
+	"fx_original_currency",
+}
+
+var sqlPartyColumns = []string{
+	"account_name", "account_number", "account_number_code", "bank_id",
+	"bank_id_code", "name", "address",
+}
+
+// SQLColumns maps the JSON name of the fields the payments can be filtered
+// and sorted on to their column
+var SQLColumns = sqlColumns()
+
+func sqlColumns() map[string]string {
+	ret := map[string]string{
+		PaymentSortCreatedAt: "created_at",
+		PaymentSortUpdatedAt: "updated_at",
+	}
+	for name := range PaymentFilterFields {
+		column := strings.Builder{}
+		for _, c := range name {
+			if c >= 'A' && c <= 'Z' {
+				column.WriteByte('_')
+				c += 'a' - 'A'
+			}
+			column.WriteRune(c)
+		}
+		ret[name] = column.String()
+	}
+	return ret
+}
+
+// sqlFilterColumn returns the expression of the field of a filter, the fields
+// of the parties are read from their table
+func sqlFilterColumn(field *PaymentField) string {
+	switch field.Name {
+	case "beneficiary.bankId":
+		return sqlPartyColumn(sqlPartyBeneficiary, "bank_id")
+	case "debitorParty.bankId":
+		return sqlPartyColumn(sqlPartyDebitor, "bank_id")
+	}
+	return SQLColumns[field.Name]
+}
+
+func sqlPartyColumn(role, column string) string {
+	return "COALESCE((SELECT " + column + " FROM payment_parties" +
+		" WHERE payment_id = payments.id AND role = '" + role + "'), '')"
+}
+
+// sqlFilterValue returns the argument a value of a filter is compared with,
+// the times are compared as times
+func sqlFilterValue(field *PaymentField, v string) interface{} {
+	if !field.Time {
+		return v
+	}
+	t, _ := time.Parse(PaymentTimeLayout, v)
+	return sqlTime(&t)
+}
+
+type PaymentSQLStore struct {
+	DB      *sql.DB
+	Dialect *SQLDialect
+	// BatchSize is the number of payments whose parties and charges are
+	// loaded by a single query
+	BatchSize int
+}
+
+// NewPaymentSQLStore opens the database with the given driver, sqlite3 or
+// postgres. The schema is not migrated.
+func NewPaymentSQLStore(driver, dsn string) (*PaymentSQLStore, error) {
+	dialect, ok := SQLDialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown SQL driver %q, expected %s or %s", driver, SQLDriverSQLite, SQLDriverPostgres)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == SQLDriverSQLite {
+		// the writes of SQLite are serialised anyway, a single connection
+		// avoids the "database is locked" errors
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &PaymentSQLStore{DB: db, Dialect: dialect, BatchSize: SQLBatchSize}, nil
+}
+
+// Close closes the database
+func (store *PaymentSQLStore) Close() error {
+	return store.DB.Close()
+}
+
+func (store *PaymentSQLStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := store.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *PaymentSQLStore) Total(ctx context.Context) int {
+	n := 0
+	store.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM payments").Scan(&n)
+	return n
+}
+
+// where compiles the filters to a WHERE clause and its arguments
+func (store *PaymentSQLStore) where(filters []*PaymentStoreFilter) (string, []interface{}, error) {
+	clauses := []string{}
+	args := []interface{}{}
+	checked, err := checkFilters(filters)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, f := range checked {
+		column := sqlFilterColumn(f.field)
+		if f.typ == PaymentStoreFilterTypeRange {
+			if f.from != "" {
+				clauses = append(clauses, column+" >= ?")
+				args = append(args, sqlFilterValue(f.field, f.from))
+			}
+			if f.to != "" {
+				clauses = append(clauses, column+" <= ?")
+				args = append(args, sqlFilterValue(f.field, f.to))
+			}
+			continue
+		}
+		if len(f.values) == 0 {
+			clauses = append(clauses, "1 = 0")
+			continue
+		}
+		if f.typ == PaymentStoreFilterTypeEqual {
+			clauses = append(clauses, column+" = ?")
+		} else {
+			clauses = append(clauses, column+" IN (?"+strings.Repeat(", ?", len(f.values)-1)+")")
+		}
+		for _, v := range f.values {
+			args = append(args, sqlFilterValue(f.field, v))
+		}
+	}
+	if len(clauses) == 0 {
+		return "", args, nil
+	}
+	return " WHERE " + strings.Join(clauses, " AND "), args, nil
+}
+
+func (store *PaymentSQLStore) GetMany(
+	ctx context.Context,
+	limit, offset int,
+	filters ...*PaymentStoreFilter,
+) (*PaginatedList, error) {
+	return store.GetManySorted(ctx, limit, offset, nil, filters...)
+}
+
+// GetManySorted compiles the filters, the sorts and the pagination to a
+// single query, the payments are in insertion order otherwise
+func (store *PaymentSQLStore) GetManySorted(
+	ctx context.Context,
+	limit, offset int,
+	sorts []PaymentSort,
+	filters ...*PaymentStoreFilter,
+) (*PaginatedList, error) {
+	where, args, err := store.where(filters)
+	if err != nil {
+		return nil, ErrSomethingWentWrong(err)
+	}
+	order := []string{}
+	for _, s := range sorts {
+		column, ok := SQLColumns[s.Field]
+		if !ok {
+			return nil, ErrSomethingWentWrong(fmt.Errorf("unknown sort field %q", s.Field))
+		}
+		if s.Desc {
+			column += " DESC"
+		}
+		order = append(order, column)
+	}
+	order = append(order, "seq")
+
+	total := 0
+	err = store.DB.QueryRowContext(ctx, store.Dialect.Rebind("SELECT COUNT(*) FROM payments"+where), args...).Scan(&total)
+	if err != nil {
+		return nil, ErrSomethingWentWrong(err)
+	}
+	lim := store.Dialect.NoLimit
+	if limit > 0 {
+		lim = strconv.Itoa(limit)
+	}
+	query := "SELECT " + strings.Join(sqlPaymentColumns, ", ") + " FROM payments" + where +
+		" ORDER BY " + strings.Join(order, ", ") +
+		" LIMIT " + lim + " OFFSET " + strconv.Itoa(offset)
+	ret, err := store.query(ctx, store.Dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, ErrSomethingWentWrong(err)
+	}
+	return &PaginatedList{
+		Total:    total,
+		SubTotal: len(ret),
+		Results:  ret,
+	}, nil
+}
+
+func (store *PaymentSQLStore) GetByID(ctx context.Context, id uuid.UUID) (*Payment, error) {
+	query := "SELECT " + strings.Join(sqlPaymentColumns, ", ") + " FROM payments WHERE id = ?"
+	ret, err := store.query(ctx, store.Dialect.Rebind(query), id.String())
+	if err != nil {
+		return nil, ErrSomethingWentWrong(err)
+	}
+	if len(ret) == 0 {
+		return nil, ErrNotFound
+	}
+	return ret[0], nil
+}
+
+// query returns the payments selected by query along with their parties and
+// sender charges, loaded by batches of BatchSize payments
+func (store *PaymentSQLStore) query(ctx context.Context, query string, args ...interface{}) ([]*Payment, error) {
+	rows, err := store.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []*Payment{}
+	byID := map[uuid.UUID]*Payment{}
+	ids := []interface{}{}
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, p)
+		byID[p.ID] = p
+		ids = append(ids, p.ID.String())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ret) == 0 {
+		return ret, nil
+	}
+	size := store.BatchSize
+	if size <= 0 {
+		size = SQLBatchSize
+	}
+	for len(ids) > 0 {
+		batch := ids
+		if len(batch) > size {
+			batch = batch[:size]
+		}
+		ids = ids[len(batch):]
+		in := " WHERE payment_id IN (?" + strings.Repeat(", ?", len(batch)-1) + ")"
+		if err := store.queryParties(ctx, in, batch, byID); err != nil {
+			return nil, err
+		}
+		if err := store.queryCharges(ctx, in, batch, byID); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func (store *PaymentSQLStore) queryParties(ctx context.Context, where string, ids []interface{}, byID map[uuid.UUID]*Payment) error {
+	query := "SELECT payment_id, role, " + strings.Join(sqlPartyColumns, ", ") + " FROM payment_parties" + where
+	rows, err := store.DB.QueryContext(ctx, store.Dialect.Rebind(query), ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uuid.UUID
+		var role string
+		party := &PaymentParty{}
+		err := rows.Scan(&id, &role, &party.AccountName, &party.AccountNumber, &party.AccountNumberCode,
+			&party.BankID, &party.BankIDCode, &party.Name, &party.Address)
+		if err != nil {
+			return err
+		}
+		switch role {
+		case sqlPartyBeneficiary:
+			byID[id].Beneficiary = party
+		case sqlPartyDebitor:
+			byID[id].DebitorParty = party
+		}
+	}
+	return rows.Err()
+}
+
+func (store *PaymentSQLStore) queryCharges(ctx context.Context, where string, ids []interface{}, byID map[uuid.UUID]*Payment) error {
+	query := "SELECT payment_id, amount, currency FROM payment_sender_charges" + where + " ORDER BY payment_id, position"
+	rows, err := store.DB.QueryContext(ctx, store.Dialect.Rebind(query), ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uuid.UUID
+		charge := struct {
+			Amount   string `json:"amount"`
+			Currency string `json:"currency"`
+		}{}
+		if err := rows.Scan(&id, &charge.Amount, &charge.Currency); err != nil {
+			return err
+		}
+		charges := &byID[id].ChargesInformation.SenderCharges
+		*charges = append(*charges, charge)
+	}
+	return rows.Err()
+}
+
+func scanPayment(rows *sql.Rows) (*Payment, error) {
+	p := &Payment{}
+	var createdAt, updatedAt sql.NullTime
+	err := rows.Scan(
+		&p.ID, &createdAt, &updatedAt, &p.Purpose, &p.Scheme, &p.Type, &p.Amount,
+		&p.Currency, &p.EndToEndReference, &p.NumericReference, &p.ProcessingDate,
+		&p.Reference, &p.SchemePaymentSubType, &p.SchemePaymentType,
+		&p.ChargesInformation.BearerCode, &p.ChargesInformation.ReceiverChargesAmount,
+		&p.ChargesInformation.ReceiverChargesCurrency,
+		&p.FX.ContractReference, &p.FX.ExchangeRate, &p.FX.OriginalAmount,
+		&p.FX.OriginalCurrency,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if createdAt.Valid {
+		p.CreatedAt = &createdAt.Time
+	}
+	if updatedAt.Valid {
+		p.UpdatedAt = &updatedAt.Time
+	}
+	return p, nil
+}
+
+func sqlPaymentValues(p *Payment) []interface{} {
+	return []interface{}{
+		p.ID.String(), sqlTime(p.CreatedAt), sqlTime(p.UpdatedAt), p.Purpose, p.Scheme, p.Type, p.Amount,
+		p.Currency, p.EndToEndReference, p.NumericReference, p.ProcessingDate,
+		p.Reference, p.SchemePaymentSubType, p.SchemePaymentType,
+		p.ChargesInformation.BearerCode, p.ChargesInformation.ReceiverChargesAmount,
+		p.ChargesInformation.ReceiverChargesCurrency,
+		p.FX.ContractReference, p.FX.ExchangeRate, p.FX.OriginalAmount,
+		p.FX.OriginalCurrency,
+	}
+}
+
+func sqlTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// Save upserts the payment and replaces its parties and sender charges in a
+// single transaction
+func (store *PaymentSQLStore) Save(ctx context.Context, p *Payment) error {
+	if p == nil {
+		return ErrSomethingWentWrong(ErrNilValue)
+	}
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	p.UpdatedAt = Now()
+	updates := []string{}
+	for _, column := range sqlPaymentColumns[1:] {
+		updates = append(updates, column+" = excluded."+column)
+	}
+	upsert := "INSERT INTO payments (" + strings.Join(sqlPaymentColumns, ", ") + ")" +
+		" VALUES (?" + strings.Repeat(", ?", len(sqlPaymentColumns)-1) + ")" +
+		" ON CONFLICT (id) DO UPDATE SET " + strings.Join(updates, ", ")
+	insertParty := "INSERT INTO payment_parties (payment_id, role, " + strings.Join(sqlPartyColumns, ", ") + ")" +
+		" VALUES (?, ?" + strings.Repeat(", ?", len(sqlPartyColumns)) + ")"
+	insertCharge := "INSERT INTO payment_sender_charges (payment_id, position, amount, currency) VALUES (?, ?, ?, ?)"
+	err := store.inTx(ctx, func(tx *sql.Tx) error {
+		id := p.ID.String()
+		if _, err := tx.ExecContext(ctx, store.Dialect.Rebind(upsert), sqlPaymentValues(p)...); err != nil {
+			return err
+		}
+		if err := store.deleteChildren(ctx, tx, id); err != nil {
+			return err
+		}
+		parties := map[string]*PaymentParty{
+			sqlPartyBeneficiary: p.Beneficiary,
+			sqlPartyDebitor:     p.DebitorParty,
+		}
+		for role, party := range parties {
+			if party == nil {
+				continue
+			}
+			_, err := tx.ExecContext(ctx, store.Dialect.Rebind(insertParty), id, role,
+				party.AccountName, party.AccountNumber, party.AccountNumberCode,
+				party.BankID, party.BankIDCode, party.Name, party.Address)
+			if err != nil {
+				return err
+			}
+		}
+		for i, charge := range p.ChargesInformation.SenderCharges {
+			if _, err := tx.ExecContext(ctx, store.Dialect.Rebind(insertCharge), id, i, charge.Amount, charge.Currency); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ErrSomethingWentWrong(err)
+	}
+	return nil
+}
+
+func (store *PaymentSQLStore) deleteChildren(ctx context.Context, tx *sql.Tx, id string) error {
+	for _, table := range []string{"payment_parties", "payment_sender_charges"} {
+		if _, err := tx.ExecContext(ctx, store.Dialect.Rebind("DELETE FROM "+table+" WHERE payment_id = ?"), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *PaymentSQLStore) Delete(ctx context.Context, id uuid.UUID) error {
+	err := store.inTx(ctx, func(tx *sql.Tx) error {
+		if err := store.deleteChildren(ctx, tx, id.String()); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, store.Dialect.Rebind("DELETE FROM payments WHERE id = ?"), id.String())
+		return err
+	})
+	if err != nil {
+		return ErrSomethingWentWrong(err)
+	}
+	return nil
+}
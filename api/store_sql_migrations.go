@@ -0,0 +1,233 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SQLMigration is a versioned change of the schema of the SQL store. Up and
+// Down return the statements applying and reverting it for a dialect.
+type SQLMigration struct {
+	Version int
+	Name    string
+	Up      func(d *SQLDialect) []string
+	Down    func(d *SQLDialect) []string
+}
+
+// SQLMigrationStatus tells whether a migration is applied, AppliedAt is nil
+// when it is pending
+type SQLMigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt"`
+}
+
+const sqlMigrationsTable = "schema_migrations"
+
+// SQLMigrations is the list of the migrations of the SQL store, in order. A
+// migration must never be changed once released, add a new one instead.
+var SQLMigrations = []*SQLMigration{
+	{
+		Version: 1,
+		Name:    "create payments",
+		Up: func(d *SQLDialect) []string {
+			return []string{
+				`CREATE TABLE payments (
+					seq ` + d.Serial + `,
+					id ` + d.UUID + ` NOT NULL UNIQUE,
+					created_at ` + d.Timestamp + `,
+					updated_at ` + d.Timestamp + `,
+					purpose TEXT NOT NULL DEFAULT '',
+					scheme TEXT NOT NULL DEFAULT '',
+					type TEXT NOT NULL DEFAULT '',
+					amount TEXT NOT NULL DEFAULT '',
+					currency TEXT NOT NULL DEFAULT '',
+					end_to_end_reference TEXT NOT NULL DEFAULT '',
+					numeric_reference TEXT NOT NULL DEFAULT '',
+					processing_date TEXT NOT NULL DEFAULT '',
+					reference TEXT NOT NULL DEFAULT '',
+					scheme_payment_sub_type TEXT NOT NULL DEFAULT '',
+					scheme_payment_type TEXT NOT NULL DEFAULT '',
+					bearer_code TEXT NOT NULL DEFAULT '',
+					receiver_charges_amount TEXT NOT NULL DEFAULT '',
+					receiver_charges_currency TEXT NOT NULL DEFAULT '',
+					fx_contract_reference TEXT NOT NULL DEFAULT '',
+					fx_exchange_rate TEXT NOT NULL DEFAULT '',
+					fx_original_amount TEXT NOT NULL DEFAULT '',
+					fx_original_currency TEXT NOT NULL DEFAULT ''
+				)`,
+				`CREATE TABLE payment_parties (
+					payment_id ` + d.UUID + ` NOT NULL REFERENCES payments (id) ON DELETE CASCADE,
+					role TEXT NOT NULL,
+					account_name TEXT NOT NULL DEFAULT '',
+					account_number TEXT NOT NULL DEFAULT '',
+					account_number_code TEXT NOT NULL DEFAULT '',
+					bank_id TEXT NOT NULL DEFAULT '',
+					bank_id_code TEXT NOT NULL DEFAULT '',
+					name TEXT NOT NULL DEFAULT '',
+					address TEXT NOT NULL DEFAULT '',
+					PRIMARY KEY (payment_id, role)
+				)`,
+				`CREATE TABLE payment_sender_charges (
+					payment_id ` + d.UUID + ` NOT NULL REFERENCES payments (id) ON DELETE CASCADE,
+					position INTEGER NOT NULL,
+					amount TEXT NOT NULL DEFAULT '',
+					currency TEXT NOT NULL DEFAULT '',
+					PRIMARY KEY (payment_id, position)
+				)`,
+			}
+		},
+		Down: func(d *SQLDialect) []string {
+			return []string{
+				"DROP TABLE payment_sender_charges",
+				"DROP TABLE payment_parties",
+				"DROP TABLE payments",
+			}
+		},
+	},
+	{
+		Version: 2,
+		Name:    "index the filtered fields",
+		Up: func(d *SQLDialect) []string {
+			return []string{
+				"CREATE INDEX payments_scheme ON payments (scheme)",
+				"CREATE INDEX payments_type ON payments (type)",
+				"CREATE INDEX payments_currency ON payments (currency)",
+				"CREATE INDEX payments_reference ON payments (reference)",
+			}
+		},
+		Down: func(d *SQLDialect) []string {
+			return []string{
+				"DROP INDEX payments_reference",
+				"DROP INDEX payments_currency",
+				"DROP INDEX payments_type",
+				"DROP INDEX payments_scheme",
+			}
+		},
+	},
+}
+
+func (store *PaymentSQLStore) initMigrations() error {
+	_, err := store.DB.Exec(`CREATE TABLE IF NOT EXISTS ` + sqlMigrationsTable + ` (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at ` + store.Dialect.Timestamp + ` NOT NULL
+	)`)
+	return err
+}
+
+// MigrationStatus returns every known migration with the time it was
+// applied at
+func (store *PaymentSQLStore) MigrationStatus() ([]*SQLMigrationStatus, error) {
+	if err := store.initMigrations(); err != nil {
+		return nil, err
+	}
+	rows, err := store.DB.Query("SELECT version, applied_at FROM " + sqlMigrationsTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	ret := []*SQLMigrationStatus{}
+	for _, m := range SQLMigrations {
+		status := &SQLMigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			status.AppliedAt = &at
+		}
+		ret = append(ret, status)
+	}
+	return ret, nil
+}
+
+// Pending returns the amount of migrations not applied yet
+func (store *PaymentSQLStore) Pending() (int, error) {
+	status, err := store.MigrationStatus()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, s := range status {
+		if s.AppliedAt == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// migrate runs the statements of a migration and records it in a single
+// transaction
+func (store *PaymentSQLStore) migrate(m *SQLMigration, up bool) error {
+	return store.inTx(context.Background(), func(tx *sql.Tx) error {
+		statements := m.Down(store.Dialect)
+		record := store.Dialect.Rebind("DELETE FROM " + sqlMigrationsTable + " WHERE version = ?")
+		args := []interface{}{m.Version}
+		if up {
+			statements = m.Up(store.Dialect)
+			record = store.Dialect.Rebind("INSERT INTO " + sqlMigrationsTable + " (version, name, applied_at) VALUES (?, ?, ?)")
+			args = append(args, m.Name, time.Now().UTC())
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return fmt.Errorf("migration %d: %v", m.Version, err)
+			}
+		}
+		_, err := tx.Exec(record, args...)
+		return err
+	})
+}
+
+// MigrateUp applies the pending migrations in order, at most steps of them
+// unless steps is 0. It returns the migrations applied.
+func (store *PaymentSQLStore) MigrateUp(steps int) ([]*SQLMigration, error) {
+	status, err := store.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+	ret := []*SQLMigration{}
+	for i, s := range status {
+		if s.AppliedAt != nil {
+			continue
+		}
+		if steps > 0 && len(ret) == steps {
+			break
+		}
+		if err := store.migrate(SQLMigrations[i], true); err != nil {
+			return ret, err
+		}
+		ret = append(ret, SQLMigrations[i])
+	}
+	return ret, nil
+}
+
+// MigrateDown reverts the last steps applied migrations, most recent first.
+// It returns the migrations reverted.
+func (store *PaymentSQLStore) MigrateDown(steps int) ([]*SQLMigration, error) {
+	status, err := store.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+	ret := []*SQLMigration{}
+	for i := len(status) - 1; i >= 0 && len(ret) < steps; i-- {
+		if status[i].AppliedAt == nil {
+			continue
+		}
+		if err := store.migrate(SQLMigrations[i], false); err != nil {
+			return ret, err
+		}
+		ret = append(ret, SQLMigrations[i])
+	}
+	return ret, nil
+}
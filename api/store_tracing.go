@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// PaymentTracingStore is a PaymentStore decorator tracing the operations of
+// the decorated store, their spans carry the Backend as db.system
+type PaymentTracingStore struct {
+	PaymentStore
+	Backend string
+}
+
+func NewPaymentTracingStore(s PaymentStore, backend string) *PaymentTracingStore {
+	return &PaymentTracingStore{PaymentStore: s, Backend: backend}
+}
+
+func (store *PaymentTracingStore) startSpan(ctx context.Context, operation string) (context.Context, *Span) {
+	ctx, span := StartSpan(ctx, "PaymentStore."+operation)
+	span.SetAttribute("db.system", store.Backend)
+	return ctx, span
+}
+
+// finishStoreSpan finishes the span of an operation, a missing payment is
+// not recorded as a failure
+func finishStoreSpan(span *Span, err error) {
+	if err != ErrNotFound {
+		span.SetError(err)
+	}
+	span.Finish()
+}
+
+func (store *PaymentTracingStore) Total(ctx context.Context) int {
+	ctx, span := store.startSpan(ctx, "Total")
+	defer span.Finish()
+	return store.PaymentStore.Total(ctx)
+}
+
+func (store *PaymentTracingStore) GetMany(
+	ctx context.Context,
+	limit, offset int,
+	filters ...*PaymentStoreFilter,
+) (list *PaginatedList, err error) {
+	ctx, span := store.startSpan(ctx, "GetMany")
+	defer func() { finishStoreSpan(span, err) }()
+	return store.PaymentStore.GetMany(ctx, limit, offset, filters...)
+}
+
+// GetManySorted lets the decorated store sort the payments when it can
+func (store *PaymentTracingStore) GetManySorted(
+	ctx context.Context,
+	limit, offset int,
+	sorts []PaymentSort,
+	filters ...*PaymentStoreFilter,
+) (list *PaginatedList, err error) {
+	ctx, span := store.startSpan(ctx, "GetManySorted")
+	defer func() { finishStoreSpan(span, err) }()
+	return GetManySorted(ctx, store.PaymentStore, limit, offset, sorts, filters...)
+}
+
+func (store *PaymentTracingStore) GetByID(ctx context.Context, id uuid.UUID) (p *Payment, err error) {
+	ctx, span := store.startSpan(ctx, "GetByID")
+	span.SetAttribute("payment.id", id.String())
+	defer func() { finishStoreSpan(span, err) }()
+	return store.PaymentStore.GetByID(ctx, id)
+}
+
+func (store *PaymentTracingStore) Save(ctx context.Context, p *Payment) (err error) {
+	ctx, span := store.startSpan(ctx, "Save")
+	if p != nil {
+		span.SetAttribute("payment.id", p.ID.String())
+	}
+	defer func() { finishStoreSpan(span, err) }()
+	return store.PaymentStore.Save(ctx, p)
+}
+
+func (store *PaymentTracingStore) Delete(ctx context.Context, id uuid.UUID) (err error) {
+	ctx, span := store.startSpan(ctx, "Delete")
+	span.SetAttribute("payment.id", id.String())
+	defer func() { finishStoreSpan(span, err) }()
+	return store.PaymentStore.Delete(ctx, id)
+}
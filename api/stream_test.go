@@ -0,0 +1,115 @@
+package api_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/stretchr/testify/assert"
+)
+
+type streamEvent struct {
+	ID    string
+	Type  string
+	Event *api.PaymentEvent
+}
+
+// readStreamEvent reads the next event of the stream, skipping comments and
+// the retry field
+func readStreamEvent(t *testing.T, r *bufio.Reader) *streamEvent {
+	e := &streamEvent{}
+	for {
+		line, err := r.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && e.Type != "":
+			return e
+		case strings.HasPrefix(line, "id: "):
+			e.ID = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			e.Type = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && e.Type != api.StreamEventReset:
+			e.Event = &api.PaymentEvent{}
+			assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), e.Event))
+		}
+	}
+}
+
+func openStream(t *testing.T, ctx context.Context, url, lastEventID string) *bufio.Reader {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req = req.WithContext(ctx)
+	if lastEventID != "" {
+		req.Header.Set(api.HeaderLastEventID, lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, api.ContentTypeEventStream, resp.Header.Get(api.HeaderContentType))
+	return bufio.NewReader(resp.Body)
+}
+
+func TestStreamPayments(t *testing.T) {
+	s := api.NewPaymentEventStore(api.NewPaymentInMemStore(), api.Bus())
+	api.SetStore(s)
+	srv := httptest.NewServer(api.Routes())
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := openStream(t, ctx, srv.URL+"/v1/payments/events?scheme="+schemeA, "")
+	assert.True(t, waitFor(func() bool { return api.Bus().Subscribers() == 1 }))
+
+	ignored := newMockPayment().SetScheme(schemeB)
+	payment := newMockPayment().SetScheme(schemeA)
+	assert.NoError(t, s.Save(ctx, ignored))
+	assert.NoError(t, s.Save(ctx, payment))
+	assert.NoError(t, s.Save(ctx, payment))
+	assert.NoError(t, s.Delete(ctx, payment.ID))
+
+	created := readStreamEvent(t, stream)
+	assert.Equal(t, string(api.PaymentEventCreated), created.Type)
+	assert.Equal(t, payment.ID, created.Event.Payment.ID)
+	assert.Equal(t, string(api.PaymentEventUpdated), readStreamEvent(t, stream).Type)
+	assert.Equal(t, string(api.PaymentEventDeleted), readStreamEvent(t, stream).Type)
+
+	cancel()
+	assert.True(t, waitFor(func() bool { return api.Bus().Subscribers() == 0 }))
+
+	// Resuming from the first event replays the ones after it
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	stream = openStream(t, ctx, srv.URL+"/v1/payments/events?scheme="+schemeA, created.ID)
+	assert.Equal(t, string(api.PaymentEventUpdated), readStreamEvent(t, stream).Type)
+	assert.Equal(t, string(api.PaymentEventDeleted), readStreamEvent(t, stream).Type)
+}
+
+func TestStreamPaymentsReset(t *testing.T) {
+	api.SetStore(api.NewPaymentEventStore(api.NewPaymentInMemStore(), api.Bus()))
+	srv := httptest.NewServer(api.Routes())
+	defer srv.Close()
+
+	b := api.Bus()
+	b.SetReplaySize(1)
+	defer b.SetReplaySize(api.DefaultEventsReplay)
+	b.Publish(api.NewPaymentEvent(api.PaymentEventCreated, newMockPayment()))
+	b.Publish(api.NewPaymentEvent(api.PaymentEventCreated, newMockPayment()))
+	seq := b.Sequence()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := openStream(t, ctx, srv.URL+"/v1/payments/events", "0")
+	assert.Equal(t, api.StreamEventReset, readStreamEvent(t, stream).Type)
+	last := readStreamEvent(t, stream)
+	assert.Equal(t, string(api.PaymentEventCreated), last.Type)
+	assert.Equal(t, strconv.FormatInt(seq, 10), last.ID)
+}
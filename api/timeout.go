@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// routePattern returns the pattern of the route serving the request, such as
+// "/v1/payments/{paymentID}", it is empty when no route matches
+func routePattern(routes chi.Routes, r *http.Request) string {
+	rctx := chi.NewRouteContext()
+	if !routes.Match(rctx, r.Method, r.URL.Path) {
+		return ""
+	}
+	return rctx.RoutePattern()
+}
+
+// servedRoute returns the pattern of the route chi routed the request to, or
+// the one it would have been routed to when a middleware answered first.
+// RouteUnmatched is returned when no route matches.
+func servedRoute(routes chi.Routes, r *http.Request) string {
+	route := ""
+	if rctx, ok := r.Context().Value(chi.RouteCtxKey).(*chi.Context); ok {
+		route = rctx.RoutePattern()
+	}
+	if route == "" {
+		route = routePattern(routes, r)
+	}
+	// the routes mounted on URLRoot end with a slash, the paths of the
+	// OpenAPI document do not
+	if len(route) > 1 {
+		route = strings.TrimSuffix(route, "/")
+	}
+	if route == "" {
+		route = RouteUnmatched
+	}
+	return route
+}
+
+// requestTimeout bounds the context of the requests with the timeout of
+// their route, the stores give up once it is exceeded and the handlers answer
+// with ErrTimeout. The exempt patterns, the long-lived streams, only get a
+// timeout when their route has one configured.
+func requestTimeout(routes chi.Routes, exempt ...string) func(http.Handler) http.Handler {
+	skip := map[string]bool{}
+	for _, pattern := range exempt {
+		skip[pattern] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if config == nil || config.Timeouts == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			pattern := routePattern(routes, r)
+			timeout, ok := config.Timeouts.Route(r.Method, pattern)
+			if !ok && !skip[pattern] {
+				timeout = config.Timeouts.Default
+			}
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,408 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	mrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// The requests are traced following the W3C trace context: the trace of an
+// incoming traceparent header is continued, a new one is started otherwise.
+// The spans of the request, of its middlewares and of the store calls are
+// batched and handed to a SpanExporter.
+
+const (
+	HeaderTraceparent = "traceparent"
+
+	SpanKindInternal = "internal"
+	SpanKindServer   = "server"
+)
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// SpanContext identifies a span within its trace, Sampled tells whether the
+// spans of the trace are exported
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid tells whether both ids are set, the W3C trace context forbids
+// zero ids
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats the span context as a version 00 traceparent header
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceparent parses a traceparent header. The fields appended by the
+// versions above 00 are ignored as the specification requires.
+func ParseTraceparent(h string) (SpanContext, error) {
+	sc := SpanContext{}
+	parts := strings.Split(h, "-")
+	if len(parts) < 4 || strings.ToLower(h) != h {
+		return sc, ErrInvalidTraceparent
+	}
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || len(version) != 1 || version[0] == 0xff || (version[0] == 0 && len(parts) != 4) {
+		return sc, ErrInvalidTraceparent
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil || !sc.IsValid() {
+		return sc, ErrInvalidTraceparent
+	}
+	sc.Sampled = flags&1 == 1
+	return sc, nil
+}
+
+// Span is a timed operation of a trace. The spans of the traces that are not
+// sampled are not recorded, they only carry their ids. The methods of a nil
+// span do nothing, which is what StartSpan returns when tracing is disabled.
+type Span struct {
+	Name         string                 `json:"name"`
+	Kind         string                 `json:"kind"`
+	TraceID      string                 `json:"traceId"`
+	SpanID       string                 `json:"spanId"`
+	ParentSpanID string                 `json:"parentSpanId,omitempty"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+
+	context SpanContext
+	tracer  *Tracer
+	mu      sync.Mutex
+	ended   bool
+}
+
+// Context returns the ids of the span, zero for a nil span
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// record applies fn to a span being recorded, the finished spans are not
+// changed anymore since they are read by the exporter
+func (s *Span) record(fn func()) {
+	if s == nil || !s.context.Sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		fn()
+	}
+}
+
+func (s *Span) SetName(name string) {
+	s.record(func() { s.Name = name })
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.record(func() {
+		if s.Attributes == nil {
+			s.Attributes = map[string]interface{}{}
+		}
+		s.Attributes[key] = value
+	})
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(err error) {
+	if err != nil {
+		s.record(func() { s.Error = err.Error() })
+	}
+}
+
+// Finish ends the span and queues it for export, only the first call counts
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+	if s.context.Sampled {
+		s.tracer.queue(s)
+	}
+}
+
+// SpanFromContext returns the current span of the context, nil when there is
+// none
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(ctxKeySpan).(*Span)
+	return s
+}
+
+// TraceIDFromContext returns the id of the trace of the context, empty when
+// there is none
+func TraceIDFromContext(ctx context.Context) string {
+	if s := SpanFromContext(ctx); s != nil {
+		return hex.EncodeToString(s.context.TraceID[:])
+	}
+	return ""
+}
+
+// StartSpan starts a span, child of the current span of the context, and
+// returns the context carrying it. The span must be finished by the caller.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	return tracer.StartSpan(ctx, name, SpanKindInternal, SpanFromContext(ctx).Context())
+}
+
+// Tracer samples the traces and exports their spans by batches of BatchSize,
+// at least every Interval. A span finished while QueueSize of them are
+// waiting is dropped.
+type Tracer struct {
+	Exporter    SpanExporter
+	SampleRatio float64
+	BatchSize   int
+	Interval    time.Duration
+	Timeout     time.Duration
+
+	spans   chan *Span
+	dropped int64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func NewTracer(exporter SpanExporter, settings *TracingSettings) *Tracer {
+	return &Tracer{
+		Exporter:    exporter,
+		SampleRatio: settings.SampleRatio,
+		BatchSize:   settings.BatchSize,
+		Interval:    settings.Interval,
+		Timeout:     settings.Timeout,
+		spans:       make(chan *Span, settings.QueueSize),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// StartSpan starts a span child of parent, a new trace is started when parent is
+// not valid. The new traces are sampled at SampleRatio, the others follow the
+// decision of their parent.
+func (t *Tracer) StartSpan(ctx context.Context, name, kind string, parent SpanContext) (context.Context, *Span) {
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+	if !parent.IsValid() {
+		rand.Read(sc.TraceID[:])
+		sc.Sampled = mrand.Float64() < t.SampleRatio
+	}
+	rand.Read(sc.SpanID[:])
+	s := &Span{context: sc, tracer: t}
+	if sc.Sampled {
+		s.Name = name
+		s.Kind = kind
+		s.TraceID = hex.EncodeToString(sc.TraceID[:])
+		s.SpanID = hex.EncodeToString(sc.SpanID[:])
+		if parent.IsValid() {
+			s.ParentSpanID = hex.EncodeToString(parent.SpanID[:])
+		}
+		s.Start = time.Now()
+	}
+	return context.WithValue(ctx, ctxKeySpan, s), s
+}
+
+func (t *Tracer) queue(s *Span) {
+	select {
+	case t.spans <- s:
+	default:
+		atomic.AddInt64(&t.dropped, 1)
+	}
+}
+
+// Dropped returns the amount of spans dropped because the queue was full
+func (t *Tracer) Dropped() int64 {
+	return atomic.LoadInt64(&t.dropped)
+}
+
+// Start exports the spans in a goroutine until Stop is called
+func (t *Tracer) Start() {
+	go t.run()
+}
+
+// Stop exports the queued spans and closes the exporter when it is an
+// io.Closer
+func (t *Tracer) Stop() {
+	close(t.stop)
+	<-t.done
+	if c, ok := t.Exporter.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			logrus.Errorf("Tracing: could not close the exporter: %v", err)
+		}
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.Interval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, t.BatchSize)
+	for {
+		select {
+		case s := <-t.spans:
+			if batch = append(batch, s); len(batch) >= t.BatchSize {
+				batch = t.export(batch)
+			}
+		case <-ticker.C:
+			batch = t.export(batch)
+		case <-t.stop:
+			for {
+				select {
+				case s := <-t.spans:
+					batch = append(batch, s)
+				default:
+					t.export(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// export hands the batch to the exporter and returns the emptied batch
+func (t *Tracer) export(batch []*Span) []*Span {
+	if len(batch) == 0 {
+		return batch
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.Timeout)
+	defer cancel()
+	if err := t.Exporter.Export(ctx, batch); err != nil {
+		logrus.Errorf("Tracing: could not export %d spans: %v", len(batch), err)
+	}
+	return make([]*Span, 0, t.BatchSize)
+}
+
+// TraceHook adds the ids of the trace and of the span to the entries logged
+// with the context of a traced request, see logrus.WithContext
+type TraceHook struct{}
+
+func (h TraceHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h TraceHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	s := SpanFromContext(entry.Context)
+	if s == nil {
+		return nil
+	}
+	data := make(logrus.Fields, len(entry.Data)+2)
+	for k, v := range entry.Data {
+		data[k] = v
+	}
+	data["trace_id"] = hex.EncodeToString(s.context.TraceID[:])
+	data["span_id"] = hex.EncodeToString(s.context.SpanID[:])
+	entry.Data = data
+	return nil
+}
+
+// AccessLogger logs the requests to out like middleware.Logger, prefixed with
+// the id of their trace when they are traced
+func AccessLogger(out io.Writer) func(http.Handler) http.Handler {
+	return middleware.RequestLogger(&traceLogFormatter{
+		&middleware.DefaultLogFormatter{Logger: log.New(out, "", log.LstdFlags)},
+	})
+}
+
+// traceLogFormatter formats the access logs like its DefaultLogFormatter,
+// with the id of the trace of the request
+type traceLogFormatter struct {
+	*middleware.DefaultLogFormatter
+}
+
+func (f *traceLogFormatter) NewLogEntry(r *http.Request) middleware.LogEntry {
+	traceID := TraceIDFromContext(r.Context())
+	if traceID == "" {
+		return f.DefaultLogFormatter.NewLogEntry(r)
+	}
+	formatter := *f.DefaultLogFormatter
+	formatter.Logger = traceLogger{formatter.Logger, traceID}
+	return formatter.NewLogEntry(r)
+}
+
+// traceLogger prefixes the lines of its logger with the id of a trace
+type traceLogger struct {
+	middleware.LoggerInterface
+	traceID string
+}
+
+func (l traceLogger) Print(v ...interface{}) {
+	l.LoggerInterface.Print(append([]interface{}{"trace_id=" + l.traceID + " "}, v...)...)
+}
+
+// traceRequests starts the span of the requests, continuing the trace of
+// their traceparent header. The span is named after the method and the
+// pattern of the route, see servedRoute.
+func traceRequests(routes chi.Routes) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tracer == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			parent, _ := ParseTraceparent(r.Header.Get(HeaderTraceparent))
+			ctx, span := tracer.StartSpan(r.Context(), r.Method, SpanKindServer, parent)
+			defer span.Finish()
+			span.SetAttribute("http.method", r.Method)
+			span.SetAttribute("http.target", r.URL.Path)
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			r = r.WithContext(ctx)
+			next.ServeHTTP(ww, r)
+			route := servedRoute(routes, r)
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.SetName(r.Method + " " + route)
+			span.SetAttribute("http.route", route)
+			span.SetAttribute("http.status_code", status)
+			if status >= http.StatusInternalServerError {
+				span.SetError(errors.New(http.StatusText(status)))
+			}
+		})
+	}
+}
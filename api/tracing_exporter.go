@@ -0,0 +1,244 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ExporterTypeStdout = "stdout"
+	ExporterTypeFile   = "file"
+	ExporterTypeOTLP   = "otlp"
+
+	// TracingScope names the instrumentation in the exported spans
+	TracingScope = "github.com/ganitzsh/f3-te/api"
+	// otlpContentType is the media type of the JSON encoding of OTLP/HTTP
+	otlpContentType = "application/json"
+)
+
+// SpanExporter sends the finished spans to a tracing backend, an exporter
+// implementing io.Closer is closed when the tracer stops
+type SpanExporter interface {
+	Export(ctx context.Context, spans []*Span) error
+}
+
+// NewSpanExporter creates the exporter described by the settings
+func NewSpanExporter(settings *TracingSettings) (SpanExporter, error) {
+	switch settings.Exporter {
+	case ExporterTypeStdout:
+		return &WriterExporter{w: os.Stdout}, nil
+	case ExporterTypeFile:
+		return NewFileExporter(settings.File)
+	case ExporterTypeOTLP:
+		e := NewOTLPExporter(settings.URL, settings.Timeout)
+		e.Headers = settings.Headers
+		if config != nil {
+			e.ServiceName = config.NodeName
+		}
+		return e, nil
+	default:
+		return nil, fmt.Errorf("unknown exporter type %q", settings.Exporter)
+	}
+}
+
+// WriterExporter writes the spans to stdout or to a file, one JSON document
+// per line
+type WriterExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewFileExporter(path string) (*WriterExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &WriterExporter{w: f}, nil
+}
+
+func (e *WriterExporter) Export(ctx context.Context, spans []*Span) error {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, s := range spans {
+		if err := enc.Encode(s); err != nil {
+			return err
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// Close closes the file of the exporter, stdout is left open
+func (e *WriterExporter) Close() error {
+	if f, ok := e.w.(*os.File); ok && f != os.Stdout {
+		return f.Close()
+	}
+	return nil
+}
+
+// OTLPExporter POSTs the spans to an OTLP/HTTP endpoint, such as
+// http://localhost:4318/v1/traces, in the JSON encoding of the protocol.
+// Headers are added to the requests, for the credentials of the collector.
+type OTLPExporter struct {
+	URL         string
+	Headers     map[string]string
+	ServiceName string
+	Client      *http.Client
+}
+
+func NewOTLPExporter(url string, timeout time.Duration) *OTLPExporter {
+	return &OTLPExporter{
+		URL:         url,
+		ServiceName: DefaultNodeName,
+		Client:      &http.Client{Timeout: timeout},
+	}
+}
+
+// The OTLP/HTTP JSON encoding, the ids are hex encoded and the 64 bits
+// integers are strings
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpAnyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// The kinds and the status codes of the spans in OTLP
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpStatusError      = 2
+)
+
+func otlpValue(v interface{}) otlpAnyValue {
+	switch v := v.(type) {
+	case string:
+		return otlpAnyValue{StringValue: &v}
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+	case int:
+		i := strconv.Itoa(v)
+		return otlpAnyValue{IntValue: &i}
+	case int64:
+		i := strconv.FormatInt(v, 10)
+		return otlpAnyValue{IntValue: &i}
+	case float64:
+		return otlpAnyValue{DoubleValue: &v}
+	default:
+		s := fmt.Sprint(v)
+		return otlpAnyValue{StringValue: &s}
+	}
+}
+
+func otlpSpanOf(s *Span) otlpSpan {
+	span := otlpSpan{
+		TraceID:           s.TraceID,
+		SpanID:            s.SpanID,
+		ParentSpanID:      s.ParentSpanID,
+		Name:              s.Name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+	}
+	if s.Kind == SpanKindServer {
+		span.Kind = otlpSpanKindServer
+	}
+	keys := make([]string, 0, len(s.Attributes))
+	for k := range s.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		span.Attributes = append(span.Attributes, otlpKeyValue{Key: k, Value: otlpValue(s.Attributes[k])})
+	}
+	if s.Error != "" {
+		span.Status = otlpStatus{Code: otlpStatusError, Message: s.Error}
+	}
+	return span
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, spans []*Span) error {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: TracingScope, Version: Version}}
+	for _, s := range spans {
+		scope.Spans = append(scope.Spans, otlpSpanOf(s))
+	}
+	b, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{Key: "service.name", Value: otlpValue(e.ServiceName)},
+			{Key: "service.version", Value: otlpValue(Version)},
+		}},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(HeaderContentType, otlpContentType)
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("collector answered %d", resp.StatusCode)
+	}
+	return nil
+}
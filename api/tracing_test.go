@@ -0,0 +1,324 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testTraceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testParentID    = "00f067aa0ba902b7"
+	testTraceparent = "00-" + testTraceID + "-" + testParentID + "-01"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := api.ParseTraceparent(testTraceparent)
+	assert.NoError(t, err)
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, testTraceparent, sc.Traceparent())
+
+	sc, err = api.ParseTraceparent("00-" + testTraceID + "-" + testParentID + "-00")
+	assert.NoError(t, err)
+	assert.False(t, sc.Sampled)
+	// the fields of the future versions are ignored
+	_, err = api.ParseTraceparent("01-" + testTraceID + "-" + testParentID + "-01-what-ever")
+	assert.NoError(t, err)
+
+	invalid := []string{
+		"",
+		"00-" + testTraceID + "-" + testParentID,
+		"00-" + testTraceID + "-" + testParentID + "-01-extra",
+		"ff-" + testTraceID + "-" + testParentID + "-01",
+		"00-" + strings.ToUpper(testTraceID) + "-" + testParentID + "-01",
+		"00-00000000000000000000000000000000-" + testParentID + "-01",
+		"00-" + testTraceID + "-0000000000000000-01",
+		"00-" + testTraceID + "-" + testParentID + "0-01",
+		"00-" + testTraceID + "-" + testParentID + "-zz",
+		"00-" + testTraceID[1:] + "x-" + testParentID + "-01",
+	}
+	for _, h := range invalid {
+		_, err := api.ParseTraceparent(h)
+		assert.Equal(t, api.ErrInvalidTraceparent, err, h)
+	}
+}
+
+// recordingExporter keeps the exported spans
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []*api.Span
+}
+
+func (e *recordingExporter) Export(ctx context.Context, spans []*api.Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func newTestTracer(exporter api.SpanExporter, ratio float64) *api.Tracer {
+	return api.NewTracer(exporter, &api.TracingSettings{
+		SampleRatio: ratio,
+		BatchSize:   100,
+		QueueSize:   100,
+		Interval:    time.Hour,
+		Timeout:     time.Second,
+	})
+}
+
+func doTracedReq(handler http.Handler, method, url, traceparent string) *http.Response {
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, url, nil)
+	req.Header.Set(api.HeaderTraceparent, traceparent)
+	handler.ServeHTTP(rr, req)
+	return rr.Result()
+}
+
+func TestTracing(t *testing.T) {
+	db := newTestDBInMem()
+	api.SetStore(api.NewPaymentTracingStore(db.Store, "inmem"))
+	defer api.SetStore(db.Store)
+	exporter := &recordingExporter{}
+	tracer := newTestTracer(exporter, 1)
+	api.SetTracer(tracer)
+	defer api.SetTracer(nil)
+	tracer.Start()
+	handler := api.Routes()
+
+	resp := doTracedReq(handler, http.MethodGet, "/v1/payments/"+db.ID1.String(), testTraceparent)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	missing := uuid.New()
+	resp = doTracedReq(handler, http.MethodGet, "/v1/payments/"+missing.String(), testTraceparent)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	body := &api.JSENDData{}
+	assert.NoError(t, json.Unmarshal(readBody(resp), body))
+	assert.Equal(t, testTraceID, body.TraceID)
+	// the traces that are not sampled are not exported but keep their id
+	resp = doTracedReq(handler, http.MethodGet, "/v1/payments/"+missing.String(), "00-"+strings.Repeat("a", 32)+"-"+testParentID+"-00")
+	body = &api.JSENDData{}
+	assert.NoError(t, json.Unmarshal(readBody(resp), body))
+	assert.Equal(t, strings.Repeat("a", 32), body.TraceID)
+	// a new trace is started without traceparent
+	resp = doHTTPReq(handler, http.MethodGet, "/v1/payments/"+missing.String(), "")
+	body = &api.JSENDData{}
+	assert.NoError(t, json.Unmarshal(readBody(resp), body))
+	assert.Len(t, body.TraceID, 32)
+	assert.NotEqual(t, testTraceID, body.TraceID)
+	tracer.Stop()
+
+	spans := map[string][]*api.Span{}
+	for _, s := range exporter.spans {
+		spans[s.TraceID] = append(spans[s.TraceID], s)
+	}
+	assert.Len(t, spans, 2)
+	assert.Empty(t, spans[strings.Repeat("a", 32)])
+	traced := spans[testTraceID]
+	if !assert.Len(t, traced, 8) {
+		t.FailNow()
+	}
+	byName := map[string][]*api.Span{}
+	for _, s := range traced {
+		byName[s.Name] = append(byName[s.Name], s)
+	}
+	requests := byName["GET /v1/payments/{paymentID}"]
+	assert.Len(t, requests, 2)
+	for _, s := range requests {
+		assert.Equal(t, api.SpanKindServer, s.Kind)
+		assert.Equal(t, testParentID, s.ParentSpanID)
+		assert.Equal(t, "/v1/payments/{paymentID}", s.Attributes["http.route"])
+	}
+	assert.Equal(t, 200, requests[0].Attributes["http.status_code"])
+	assert.Len(t, byName["datasourceHealthy"], 2)
+	assert.Len(t, byName["paymentContext"], 2)
+	stores := byName["PaymentStore.GetByID"]
+	assert.Len(t, stores, 2)
+	// the store is called by the middleware, which is called by the request
+	assert.Equal(t, byName["paymentContext"][0].SpanID, stores[0].ParentSpanID)
+	assert.Equal(t, requests[0].SpanID, byName["paymentContext"][0].ParentSpanID)
+	assert.Equal(t, requests[0].SpanID, byName["datasourceHealthy"][0].ParentSpanID)
+	assert.Equal(t, "inmem", stores[0].Attributes["db.system"])
+	assert.Empty(t, stores[1].Error)
+	assert.Equal(t, api.ErrNotFound.Error(), byName["paymentContext"][1].Error)
+}
+
+func TestTracingDisabled(t *testing.T) {
+	db := newTestDBInMem()
+	api.SetStore(api.NewPaymentTracingStore(db.Store, "inmem"))
+	defer api.SetStore(db.Store)
+	resp := doTracedReq(api.Routes(), http.MethodGet, "/v1/payments/"+uuid.New().String(), testTraceparent)
+	body := &api.JSENDData{}
+	assert.NoError(t, json.Unmarshal(readBody(resp), body))
+	assert.Empty(t, body.TraceID)
+}
+
+func TestTracerQueue(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := api.NewTracer(exporter, &api.TracingSettings{
+		SampleRatio: 1,
+		BatchSize:   2,
+		QueueSize:   2,
+		Interval:    time.Hour,
+		Timeout:     time.Second,
+	})
+	for i := 0; i < 3; i++ {
+		_, span := tracer.StartSpan(ctx, "test", api.SpanKindInternal, api.SpanContext{})
+		span.Finish()
+		span.Finish()
+	}
+	// the queue holds 2 spans until the tracer starts
+	assert.Equal(t, int64(1), tracer.Dropped())
+	tracer.Start()
+	tracer.Stop()
+	assert.Len(t, exporter.spans, 2)
+
+	// nothing is recorded when the trace is not sampled
+	tracer = newTestTracer(exporter, 0)
+	_, span := tracer.StartSpan(ctx, "test", api.SpanKindInternal, api.SpanContext{})
+	span.SetAttribute("key", "value")
+	assert.True(t, span.Context().IsValid())
+	assert.Empty(t, span.Name)
+	assert.Nil(t, span.Attributes)
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	exporter, err := api.NewSpanExporter(&api.TracingSettings{Exporter: api.ExporterTypeFile, File: path})
+	assert.NoError(t, err)
+	tracer := newTestTracer(exporter, 1)
+	tracer.Start()
+	_, span := tracer.StartSpan(ctx, "test", api.SpanKindInternal, api.SpanContext{})
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("failed"))
+	span.Finish()
+	tracer.Stop()
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	lines := bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+	assert.Len(t, lines, 1)
+	exported := &api.Span{}
+	assert.NoError(t, json.Unmarshal(lines[0], exported))
+	assert.Equal(t, span.TraceID, exported.TraceID)
+	assert.Equal(t, "test", exported.Name)
+	assert.Equal(t, "failed", exported.Error)
+	assert.Equal(t, "value", exported.Attributes["key"])
+
+	_, err = api.NewSpanExporter(&api.TracingSettings{Exporter: "zipkin"})
+	assert.Error(t, err)
+}
+
+func TestOTLPExporter(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		received <- r
+		bodies <- b
+	}))
+	defer collector.Close()
+
+	exporter, err := api.NewSpanExporter(&api.TracingSettings{
+		Exporter: api.ExporterTypeOTLP,
+		URL:      collector.URL + "/v1/traces",
+		Headers:  map[string]string{"authorization": "Bearer token"},
+		Timeout:  time.Second,
+	})
+	assert.NoError(t, err)
+	tracer := newTestTracer(exporter, 1)
+	parent, _ := api.ParseTraceparent(testTraceparent)
+	_, span := tracer.StartSpan(ctx, "GET /v1/payments", api.SpanKindServer, parent)
+	span.SetAttribute("http.status_code", 500)
+	span.SetError(errors.New("Internal Server Error"))
+	span.Finish()
+	tracer.Start()
+	tracer.Stop()
+
+	r := <-received
+	assert.Equal(t, "/v1/traces", r.URL.Path)
+	assert.Equal(t, "application/json", r.Header.Get(api.HeaderContentType))
+	assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+	traces := struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+					Kind         int    `json:"kind"`
+					Attributes   []struct {
+						Key   string
+						Value map[string]interface{}
+					}
+					Status struct {
+						Code    int
+						Message string
+					}
+				}
+			}
+		}
+	}{}
+	assert.NoError(t, json.Unmarshal(<-bodies, &traces))
+	exported := traces.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	assert.Equal(t, testTraceID, exported.TraceID)
+	assert.Equal(t, testParentID, exported.ParentSpanID)
+	assert.Equal(t, "GET /v1/payments", exported.Name)
+	assert.Equal(t, 2, exported.Kind)
+	assert.Equal(t, "http.status_code", exported.Attributes[0].Key)
+	assert.Equal(t, "500", exported.Attributes[0].Value["intValue"])
+	assert.Equal(t, 2, exported.Status.Code)
+}
+
+func TestTraceHook(t *testing.T) {
+	tracer := newTestTracer(&recordingExporter{}, 1)
+	ctx, span := tracer.StartSpan(ctx, "test", api.SpanKindInternal, api.SpanContext{})
+	out := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.Out = out
+	logger.Formatter = &logrus.JSONFormatter{}
+	logger.AddHook(api.TraceHook{})
+
+	logger.WithContext(ctx).Error("failed")
+	entry := map[string]string{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &entry))
+	assert.Equal(t, span.TraceID, entry["trace_id"])
+	assert.Equal(t, span.SpanID, entry["span_id"])
+
+	out.Reset()
+	logger.WithContext(context.Background()).Error("failed")
+	entry = map[string]string{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &entry))
+	assert.Empty(t, entry["trace_id"])
+}
+
+func TestAccessLogger(t *testing.T) {
+	tracer := newTestTracer(&recordingExporter{}, 1)
+	out := &bytes.Buffer{}
+	handler := api.AccessLogger(out)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	parent, _ := api.ParseTraceparent(testTraceparent)
+	traced, _ := tracer.StartSpan(ctx, "GET /v1/ping", api.SpanKindServer, parent)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/ping", nil).WithContext(traced))
+	assert.Contains(t, out.String(), "trace_id="+testTraceID+` `)
+	assert.Contains(t, out.String(), "/v1/ping")
+
+	out.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/ping", nil))
+	assert.Contains(t, out.String(), "/v1/ping")
+	assert.NotContains(t, out.String(), "trace_id=")
+}
@@ -0,0 +1,324 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebhookDeliveryStatus is the state of a webhook delivery
+type WebhookDeliveryStatus string
+
+const (
+	// WebhookDeliveryPending means the delivery is waiting for its next attempt
+	WebhookDeliveryPending WebhookDeliveryStatus = "pending"
+
+	// WebhookDeliverySucceeded means the receiver acknowledged the delivery
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+
+	// WebhookDeliveryDead means all the attempts failed, the delivery will not
+	// be retried unless it is manually redelivered
+	WebhookDeliveryDead WebhookDeliveryStatus = "dead"
+)
+
+// Webhook is a subscription to payment events. The events are POSTed to URL
+// and signed with Secret.
+type Webhook struct {
+	ID        uuid.UUID          `json:"id"`
+	CreatedAt *time.Time         `json:"createdAt"`
+	UpdatedAt *time.Time         `json:"updatedAt"`
+	URL       string             `json:"url"`
+	Events    []PaymentEventType `json:"events"`
+	Secret    string             `json:"secret,omitempty"`
+}
+
+func NewWebhook() *Webhook {
+	now := time.Now()
+	return &Webhook{
+		ID:        uuid.New(),
+		CreatedAt: &now,
+		UpdatedAt: &now,
+	}
+}
+
+// Redacted returns a copy of the webhook without its secret
+func (w Webhook) Redacted() *Webhook {
+	w.Secret = ""
+	return &w
+}
+
+// Subscribed returns true if the webhook wants to receive the given event
+func (w *Webhook) Subscribed(typ PaymentEventType) bool {
+	for _, e := range w.Events {
+		if e == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the webhook and generates a secret if none is given
+func (w *Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidBody(ErrorCodeInvalidInput, "$.url", "URL must be an absolute HTTP(S) URL")
+	}
+	if len(w.Events) == 0 {
+		return ErrInvalidBody(ErrorCodeInvalidInput, "$.events", "At least one event is required")
+	}
+	for _, e := range w.Events {
+		if !e.IsValid() {
+			return ErrInvalidBody(ErrorCodeInvalidInput, "$.events", "Unknown event "+string(e))
+		}
+	}
+	if w.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return ErrSomethingWentWrong(err)
+		}
+		w.Secret = hex.EncodeToString(b)
+	}
+	return nil
+}
+
+// WebhookDelivery is the log of the delivery of an event to a webhook
+type WebhookDelivery struct {
+	ID             uuid.UUID             `json:"id"`
+	WebhookID      uuid.UUID             `json:"webhookId"`
+	EventID        uuid.UUID             `json:"eventId"`
+	Event          PaymentEventType      `json:"event"`
+	Payload        json.RawMessage       `json:"payload"`
+	Status         WebhookDeliveryStatus `json:"status"`
+	Attempts       int                   `json:"attempts"`
+	LastStatusCode int                   `json:"lastStatusCode,omitempty"`
+	LastError      string                `json:"lastError,omitempty"`
+	LastAttemptAt  *time.Time            `json:"lastAttemptAt,omitempty"`
+	NextAttemptAt  *time.Time            `json:"nextAttemptAt,omitempty"`
+	CreatedAt      *time.Time            `json:"createdAt"`
+}
+
+// WebhookStore defines what a WebhookStore should be able to do
+type WebhookStore interface {
+	// GetMany should return a list of webhooks
+	GetMany(limit, offset int) (*PaginatedList, error)
+
+	// GetByID should return a single webhook corresponding to the given ID
+	GetByID(id uuid.UUID) (*Webhook, error)
+
+	// Save should create or update a webhook
+	Save(w *Webhook) error
+
+	// Delete should remove a webhook and its deliveries
+	Delete(id uuid.UUID) error
+
+	// Subscribed should return the webhooks subscribed to the given event
+	Subscribed(typ PaymentEventType) ([]*Webhook, error)
+
+	// GetDeliveries should return the deliveries of a webhook, most recent
+	// first
+	GetDeliveries(webhookID uuid.UUID, limit, offset int) (*PaginatedList, error)
+
+	// GetDelivery should return a single delivery
+	GetDelivery(id uuid.UUID) (*WebhookDelivery, error)
+
+	// SaveDelivery should create or update a delivery
+	SaveDelivery(d *WebhookDelivery) error
+}
+
+// WebhookInMemStore is an implementation of WebhookStore with temporary in
+// memory storage. It stores and returns copies so it is safe to use from
+// the dispatcher and the handlers concurrently.
+type WebhookInMemStore struct {
+	mu       sync.RWMutex
+	webhooks []*Webhook
+	// deliveries are in creation order, deliveriesByID indexes them
+	deliveries     []*WebhookDelivery
+	deliveriesByID map[uuid.UUID]*WebhookDelivery
+
+	// MaxDeliveries is the amount of deliveries kept, the oldest ones are
+	// dropped beyond it unless they are pending. 0 keeps them all.
+	MaxDeliveries int
+}
+
+func NewWebhookInMemStore() *WebhookInMemStore {
+	return &WebhookInMemStore{
+		webhooks:       []*Webhook{},
+		deliveries:     []*WebhookDelivery{},
+		deliveriesByID: map[uuid.UUID]*WebhookDelivery{},
+	}
+}
+
+func paginate(total int, limit, offset int) (from, to int) {
+	if offset > total {
+		offset = total
+	}
+	to = total
+	if limit > 0 && offset+limit < total {
+		to = offset + limit
+	}
+	return offset, to
+}
+
+func (s *WebhookInMemStore) GetMany(limit, offset int) (*PaginatedList, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	from, to := paginate(len(s.webhooks), limit, offset)
+	ret := []*Webhook{}
+	for _, w := range s.webhooks[from:to] {
+		cpy := *w
+		ret = append(ret, &cpy)
+	}
+	return &PaginatedList{
+		Total:    len(s.webhooks),
+		SubTotal: len(ret),
+		Results:  ret,
+	}, nil
+}
+
+func (s *WebhookInMemStore) GetByID(id uuid.UUID) (*Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, w := range s.webhooks {
+		if w.ID == id {
+			cpy := *w
+			return &cpy, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *WebhookInMemStore) Save(w *Webhook) error {
+	if w == nil {
+		return ErrSomethingWentWrong(ErrNilValue)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cpy := *w
+	for i, stored := range s.webhooks {
+		if stored.ID == w.ID {
+			w.UpdatedAt = Now()
+			cpy.UpdatedAt = w.UpdatedAt
+			s.webhooks[i] = &cpy
+			return nil
+		}
+	}
+	s.webhooks = append(s.webhooks, &cpy)
+	return nil
+}
+
+func (s *WebhookInMemStore) Delete(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, stored := range s.webhooks {
+		if stored.ID == id {
+			s.webhooks = append(s.webhooks[:i], s.webhooks[i+1:]...)
+			break
+		}
+	}
+	s.dropDeliveries(func(d *WebhookDelivery) bool {
+		return d.WebhookID == id
+	})
+	return nil
+}
+
+// dropDeliveries removes the deliveries matching drop, it must be called with
+// the lock held
+func (s *WebhookInMemStore) dropDeliveries(drop func(d *WebhookDelivery) bool) {
+	kept := s.deliveries[:0]
+	for _, d := range s.deliveries {
+		if drop(d) {
+			delete(s.deliveriesByID, d.ID)
+			continue
+		}
+		kept = append(kept, d)
+	}
+	for i := len(kept); i < len(s.deliveries); i++ {
+		s.deliveries[i] = nil
+	}
+	s.deliveries = kept
+}
+
+// pruneDeliveries drops the oldest deliveries beyond MaxDeliveries, the
+// pending ones are kept since they are still to be attempted. It must be
+// called with the lock held.
+func (s *WebhookInMemStore) pruneDeliveries() {
+	excess := len(s.deliveries) - s.MaxDeliveries
+	if s.MaxDeliveries <= 0 || excess <= 0 {
+		return
+	}
+	s.dropDeliveries(func(d *WebhookDelivery) bool {
+		if excess > 0 && d.Status != WebhookDeliveryPending {
+			excess--
+			return true
+		}
+		return false
+	})
+}
+
+func (s *WebhookInMemStore) Subscribed(typ PaymentEventType) ([]*Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ret := []*Webhook{}
+	for _, w := range s.webhooks {
+		if w.Subscribed(typ) {
+			cpy := *w
+			ret = append(ret, &cpy)
+		}
+	}
+	return ret, nil
+}
+
+func (s *WebhookInMemStore) GetDeliveries(webhookID uuid.UUID, limit, offset int) (*PaginatedList, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := []*WebhookDelivery{}
+	for _, d := range s.deliveries {
+		if d.WebhookID == webhookID {
+			cpy := *d
+			all = append(all, &cpy)
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].CreatedAt.After(*all[j].CreatedAt)
+	})
+	from, to := paginate(len(all), limit, offset)
+	return &PaginatedList{
+		Total:    len(all),
+		SubTotal: to - from,
+		Results:  all[from:to],
+	}, nil
+}
+
+func (s *WebhookInMemStore) GetDelivery(id uuid.UUID) (*WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.deliveriesByID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cpy := *d
+	return &cpy, nil
+}
+
+func (s *WebhookInMemStore) SaveDelivery(d *WebhookDelivery) error {
+	if d == nil {
+		return ErrSomethingWentWrong(ErrNilValue)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored, ok := s.deliveriesByID[d.ID]; ok {
+		*stored = *d
+		return nil
+	}
+	cpy := *d
+	s.deliveries = append(s.deliveries, &cpy)
+	s.deliveriesByID[d.ID] = &cpy
+	s.pruneDeliveries()
+	return nil
+}
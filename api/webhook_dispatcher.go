@@ -0,0 +1,249 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Webhook deliveries are POSTed with the following headers:
+//
+//   X-Webhook-ID: the ID of the webhook
+//   X-Webhook-Delivery: the ID of the delivery, it stays the same on retries
+//   X-Webhook-Event: the type of the event
+//   X-Webhook-Signature: t=<unix timestamp>,v1=<hex HMAC-SHA256>
+//
+// The signature is computed with the secret of the webhook over the
+// timestamp and the body joined by a dot: "<timestamp>.<body>".
+
+const (
+	HeaderWebhookID        = "X-Webhook-ID"
+	HeaderWebhookDelivery  = "X-Webhook-Delivery"
+	HeaderWebhookEvent     = "X-Webhook-Event"
+	HeaderWebhookSignature = "X-Webhook-Signature"
+)
+
+var webhooks *WebhookDispatcher
+
+// SetWebhookDispatcher replaces the dispatcher used by the API
+func SetWebhookDispatcher(d *WebhookDispatcher) {
+	webhooks = d
+}
+
+// WebhookSignature computes the value of the X-Webhook-Signature header
+func WebhookSignature(secret string, timestamp int64, body []byte) string {
+	ts := strconv.FormatInt(timestamp, 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// WebhookDispatcher delivers the payment events to the subscribed webhooks.
+// Failed deliveries are retried with an exponential backoff until MaxAttempts
+// is reached, after which they are marked as dead.
+type WebhookDispatcher struct {
+	Store    WebhookStore
+	Client   *http.Client
+	Settings *WebhookSettings
+
+	mu       sync.Mutex
+	timers   map[uuid.UUID]*time.Timer
+	wg       sync.WaitGroup
+	stopped  bool
+	unlisten func()
+	// redeliverMu serialises the redeliveries, the status of a delivery is
+	// checked and reset at once
+	redeliverMu sync.Mutex
+}
+
+func NewWebhookDispatcher(s WebhookStore, settings *WebhookSettings) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		Store:    s,
+		Settings: settings,
+		Client:   &http.Client{Timeout: settings.Timeout},
+		timers:   map[uuid.UUID]*time.Timer{},
+	}
+}
+
+// Listen subscribes the dispatcher to the events published on the bus until
+// it is stopped
+func (d *WebhookDispatcher) Listen(b *EventBus) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.unlisten = b.Handle(d.Publish)
+}
+
+// Stop cancels the scheduled attempts and waits for the running ones
+func (d *WebhookDispatcher) Stop() {
+	d.mu.Lock()
+	d.stopped = true
+	if d.unlisten != nil {
+		d.unlisten()
+		d.unlisten = nil
+	}
+	for id, t := range d.timers {
+		if t.Stop() {
+			d.wg.Done()
+		}
+		delete(d.timers, id)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// Publish creates a delivery for each webhook subscribed to the event and
+// schedules them
+func (d *WebhookDispatcher) Publish(e *PaymentEvent) {
+	hooks, err := d.Store.Subscribed(e.Type)
+	if err != nil {
+		logrus.Errorf("Webhooks: could not list subscriptions: %v", err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		logrus.Errorf("Webhooks: could not encode event %s: %v", e.ID, err)
+		return
+	}
+	for _, hook := range hooks {
+		delivery := &WebhookDelivery{
+			ID:        uuid.New(),
+			WebhookID: hook.ID,
+			EventID:   e.ID,
+			Event:     e.Type,
+			Payload:   payload,
+			Status:    WebhookDeliveryPending,
+			CreatedAt: Now(),
+		}
+		d.schedule(delivery, 0)
+	}
+}
+
+// Redeliver resets the delivery and attempts it right away. A pending
+// delivery is already scheduled or being attempted, it fails with
+// ErrDeliveryPending.
+func (d *WebhookDispatcher) Redeliver(id uuid.UUID) (*WebhookDelivery, error) {
+	d.redeliverMu.Lock()
+	defer d.redeliverMu.Unlock()
+	delivery, err := d.Store.GetDelivery(id)
+	if err != nil {
+		return nil, err
+	}
+	if delivery.Status == WebhookDeliveryPending {
+		return nil, ErrDeliveryPending
+	}
+	delivery.Status = WebhookDeliveryPending
+	delivery.Attempts = 0
+	d.schedule(delivery, 0)
+	return delivery, nil
+}
+
+// schedule saves the delivery and plans its next attempt after delay
+func (d *WebhookDispatcher) schedule(delivery *WebhookDelivery, delay time.Duration) {
+	next := time.Now().Add(delay)
+	delivery.NextAttemptAt = &next
+	if err := d.Store.SaveDelivery(delivery); err != nil {
+		logrus.Errorf("Webhooks: could not save delivery %s: %v", delivery.ID, err)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return
+	}
+	if t, ok := d.timers[delivery.ID]; ok && t.Stop() {
+		d.wg.Done()
+	}
+	id := delivery.ID
+	d.wg.Add(1)
+	d.timers[id] = time.AfterFunc(delay, func() {
+		defer d.wg.Done()
+		d.mu.Lock()
+		delete(d.timers, id)
+		d.mu.Unlock()
+		d.attempt(id)
+	})
+}
+
+// backoff returns the delay before the next attempt
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	delay := d.Settings.Backoff
+	for i := 1; i < attempts && delay < d.Settings.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if d.Settings.MaxBackoff > 0 && delay > d.Settings.MaxBackoff {
+		delay = d.Settings.MaxBackoff
+	}
+	return delay
+}
+
+func (d *WebhookDispatcher) attempt(id uuid.UUID) {
+	delivery, err := d.Store.GetDelivery(id)
+	if err != nil {
+		return
+	}
+	hook, err := d.Store.GetByID(delivery.WebhookID)
+	if err != nil {
+		return
+	}
+	delivery.Attempts++
+	delivery.LastAttemptAt = Now()
+	delivery.NextAttemptAt = nil
+	code, err := d.send(hook, delivery)
+	delivery.LastStatusCode = code
+	delivery.LastError = ""
+	if err != nil {
+		delivery.LastError = err.Error()
+	}
+	switch {
+	case err == nil:
+		delivery.Status = WebhookDeliverySucceeded
+	case delivery.Attempts >= d.Settings.MaxAttempts:
+		logrus.Warnf("Webhooks: delivery %s is dead after %d attempts: %v", id, delivery.Attempts, err)
+		delivery.Status = WebhookDeliveryDead
+	default:
+		d.schedule(delivery, d.backoff(delivery.Attempts))
+		return
+	}
+	if err := d.Store.SaveDelivery(delivery); err != nil {
+		logrus.Errorf("Webhooks: could not save delivery %s: %v", id, err)
+	}
+}
+
+// send posts the payload to the webhook and returns the status code received
+func (d *WebhookDispatcher) send(hook *Webhook, delivery *WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(HeaderContentType, ContentTypeJSON)
+	req.Header.Set(HeaderWebhookID, hook.ID.String())
+	req.Header.Set(HeaderWebhookDelivery, delivery.ID.String())
+	req.Header.Set(HeaderWebhookEvent, string(delivery.Event))
+	req.Header.Set(HeaderWebhookSignature, WebhookSignature(hook.Secret, time.Now().Unix(), delivery.Payload))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return resp.StatusCode, fmt.Errorf("receiver answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
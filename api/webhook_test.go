@@ -0,0 +1,320 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	failures int
+	received []*api.PaymentEvent
+}
+
+// newWebhookReceiver starts a receiver answering 500 to the first failures
+// requests and checking the signatures with secret
+func newWebhookReceiver(t *testing.T, secret string, failures int) *webhookReceiver {
+	recv := &webhookReceiver{failures: failures}
+	recv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recv.mu.Lock()
+		defer recv.mu.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		sig := r.Header.Get(api.HeaderWebhookSignature)
+		ts, _ := strconv.ParseInt(strings.TrimPrefix(strings.Split(sig, ",")[0], "t="), 10, 64)
+		assert.Equal(t, api.WebhookSignature(secret, ts, body), sig)
+		if recv.failures > 0 {
+			recv.failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		e := &api.PaymentEvent{}
+		assert.NoError(t, json.Unmarshal(body, e))
+		assert.Equal(t, string(e.Type), r.Header.Get(api.HeaderWebhookEvent))
+		recv.received = append(recv.received, e)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return recv
+}
+
+func (recv *webhookReceiver) Received() []*api.PaymentEvent {
+	recv.mu.Lock()
+	defer recv.mu.Unlock()
+	return append([]*api.PaymentEvent{}, recv.received...)
+}
+
+func newTestDispatcher() *api.WebhookDispatcher {
+	d := api.NewWebhookDispatcher(api.NewWebhookInMemStore(), &api.WebhookSettings{
+		MaxAttempts: 3,
+		Backoff:     10 * time.Millisecond,
+		MaxBackoff:  20 * time.Millisecond,
+		Timeout:     time.Second,
+	})
+	d.Listen(api.Bus())
+	return d
+}
+
+// waitFor polls cond until it is true or a second elapsed
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func createWebhook(t *testing.T, handler http.Handler, url string, events ...api.PaymentEventType) *api.Webhook {
+	b, _ := json.Marshal(map[string]interface{}{
+		"url":    url,
+		"events": events,
+		"secret": "secret",
+	})
+	resp := doHTTPReq(handler, http.MethodPost, "/v1/webhooks", string(b))
+	body := readBody(resp)
+	if !assert.Equal(t, http.StatusCreated, resp.StatusCode, string(body)) {
+		t.FailNow()
+	}
+	hook := &api.Webhook{}
+	assert.NoError(t, json.Unmarshal(body, &api.JSENDData{Data: hook}))
+	return hook
+}
+
+func listDeliveries(t *testing.T, handler http.Handler, hook *api.Webhook) []*api.WebhookDelivery {
+	resp := doHTTPReq(handler, http.MethodGet, "/v1/webhooks/"+hook.ID.String()+"/deliveries", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	ret := []*api.WebhookDelivery{}
+	json.Unmarshal(readBody(resp), &api.JSENDData{Data: &api.PaginatedList{Results: &ret}})
+	return ret
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	d := newTestDispatcher()
+	defer d.Stop()
+	api.SetWebhookDispatcher(d)
+	defer api.SetWebhookDispatcher(nil)
+	api.SetStore(api.NewPaymentEventStore(api.NewPaymentInMemStore(), api.Bus()))
+	handler := api.Routes()
+	recv := newWebhookReceiver(t, "secret", 1)
+	defer recv.Close()
+
+	hook := createWebhook(t, handler, recv.URL, api.PaymentEventCreated, api.PaymentEventDeleted)
+	assert.Equal(t, "secret", hook.Secret)
+	resp := doHTTPReq(handler, http.MethodGet, "/v1/webhooks/"+hook.ID.String(), "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, string(readBody(resp)), "secret")
+
+	b, _ := json.Marshal(newMockPayment())
+	resp = doHTTPReq(handler, http.MethodPost, "/v1/payments", string(b))
+	p := api.JSENDData{Data: new(api.Payment)}
+	json.Unmarshal(readBody(resp), &p)
+	id := p.Data.(*api.Payment).ID
+	doHTTPReq(handler, http.MethodPut, "/v1/payments/"+id.String(), string(b))
+	doHTTPReq(handler, http.MethodDelete, "/v1/payments/"+id.String(), "")
+
+	assert.True(t, waitFor(func() bool { return len(recv.Received()) == 2 }))
+	types := map[api.PaymentEventType]*api.PaymentEvent{}
+	for _, e := range recv.Received() {
+		types[e.Type] = e
+		assert.Equal(t, id, e.Payment.ID)
+	}
+	assert.Contains(t, types, api.PaymentEventCreated)
+	assert.Contains(t, types, api.PaymentEventDeleted)
+
+	deliveries := listDeliveries(t, handler, hook)
+	assert.Len(t, deliveries, 2)
+	attempts := 0
+	for _, delivery := range deliveries {
+		assert.Equal(t, api.WebhookDeliverySucceeded, delivery.Status)
+		assert.Equal(t, http.StatusNoContent, delivery.LastStatusCode)
+		attempts += delivery.Attempts
+	}
+	assert.Equal(t, 3, attempts)
+}
+
+func TestWebhookDeadLetterAndRedeliver(t *testing.T) {
+	d := newTestDispatcher()
+	defer d.Stop()
+	api.SetWebhookDispatcher(d)
+	defer api.SetWebhookDispatcher(nil)
+	api.SetStore(api.NewPaymentEventStore(api.NewPaymentInMemStore(), api.Bus()))
+	handler := api.Routes()
+	recv := newWebhookReceiver(t, "secret", 3)
+	defer recv.Close()
+
+	hook := createWebhook(t, handler, recv.URL, api.PaymentEventCreated)
+	b, _ := json.Marshal(newMockPayment())
+	doHTTPReq(handler, http.MethodPost, "/v1/payments", string(b))
+
+	var deliveries []*api.WebhookDelivery
+	assert.True(t, waitFor(func() bool {
+		deliveries = listDeliveries(t, handler, hook)
+		return len(deliveries) == 1 && deliveries[0].Status == api.WebhookDeliveryDead
+	}))
+	assert.Equal(t, 3, deliveries[0].Attempts)
+	assert.Equal(t, http.StatusInternalServerError, deliveries[0].LastStatusCode)
+	assert.Len(t, recv.Received(), 0)
+
+	resp := doHTTPReq(handler, http.MethodPost, "/v1/webhooks/"+hook.ID.String()+
+		"/deliveries/"+deliveries[0].ID.String()+"/redeliver", "")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.True(t, waitFor(func() bool { return len(recv.Received()) == 1 }))
+	assert.True(t, waitFor(func() bool {
+		deliveries = listDeliveries(t, handler, hook)
+		return deliveries[0].Status == api.WebhookDeliverySucceeded
+	}))
+}
+
+func TestSaveWebhookValidation(t *testing.T) {
+	d := newTestDispatcher()
+	defer d.Stop()
+	api.SetWebhookDispatcher(d)
+	defer api.SetWebhookDispatcher(nil)
+	handler := api.Routes()
+
+	resp := doHTTPReq(handler, http.MethodPost, "/v1/webhooks", `{"url": "nope", "events": ["payment.created"]}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "$.url", readAPIError(t, readBody(resp)).Path)
+
+	resp = doHTTPReq(handler, http.MethodPost, "/v1/webhooks", `{"url": "http://localhost", "events": ["payment.paid"]}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	apiErr := readAPIError(t, readBody(resp))
+	assert.Equal(t, "$.events[0]", apiErr.Path)
+	assert.Equal(t, "#/components/schemas/WebhookInput/properties/events/items/enum", apiErr.SchemaPath)
+
+	hook := createWebhook(t, handler, "http://localhost", api.PaymentEventCreated)
+	resp = doHTTPReq(handler, http.MethodPut, "/v1/webhooks/"+hook.ID.String(),
+		`{"url": "http://localhost/hook", "events": ["payment.updated"]}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	updated := &api.Webhook{}
+	json.Unmarshal(readBody(resp), &api.JSENDData{Data: updated})
+	assert.Equal(t, hook.ID, updated.ID)
+	assert.Empty(t, updated.Secret)
+	assert.Equal(t, []api.PaymentEventType{api.PaymentEventUpdated}, updated.Events)
+	stored, _ := d.Store.GetByID(hook.ID)
+	assert.Equal(t, "secret", stored.Secret)
+
+	// a secret given by the update is returned
+	resp = doHTTPReq(handler, http.MethodPut, "/v1/webhooks/"+hook.ID.String(),
+		`{"url": "http://localhost/hook", "events": ["payment.updated"], "secret": "other"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	updated = &api.Webhook{}
+	json.Unmarshal(readBody(resp), &api.JSENDData{Data: updated})
+	assert.Equal(t, "other", updated.Secret)
+
+	resp = doHTTPReq(handler, http.MethodDelete, "/v1/webhooks/"+hook.ID.String(), "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = doHTTPReq(handler, http.MethodGet, "/v1/webhooks/"+hook.ID.String(), "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestWebhooksSigned(t *testing.T) {
+	defer enableSignature()()
+	d := newTestDispatcher()
+	defer d.Stop()
+	api.SetWebhookDispatcher(d)
+	defer api.SetWebhookDispatcher(nil)
+	handler := api.Routes()
+	body := `{"url": "http://localhost/hook", "events": ["payment.created"]}`
+
+	// the webhooks cannot be registered without a signature
+	resp := doHTTPReq(handler, http.MethodPost, "/v1/webhooks", body)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, api.ErrorCodeSignatureMissing, readErrorCode(readBody(resp)))
+	resp = doHTTPReq(handler, http.MethodGet, "/v1/webhooks", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	list := []*api.Webhook{}
+	json.Unmarshal(readBody(resp), &api.JSENDData{Data: &api.PaginatedList{Results: &list}})
+	assert.Empty(t, list)
+
+	req, _ := http.NewRequest(http.MethodPost, "/v1/webhooks", strings.NewReader(body))
+	req.Header.Add(api.HeaderContentType, "application/json")
+	assert.NoError(t, api.SignRequest(req, testClientID, testClientSecret, "webhook-nonce"))
+	resp, _ = doReq(handler, req)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+}
+
+func TestWebhookRedeliverPending(t *testing.T) {
+	release := make(chan struct{})
+	recv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer recv.Close()
+	s := api.NewWebhookInMemStore()
+	d := api.NewWebhookDispatcher(s, &api.WebhookSettings{MaxAttempts: 1, Timeout: time.Second})
+	defer d.Stop()
+	hook := api.NewWebhook()
+	hook.URL = recv.URL
+	hook.Events = []api.PaymentEventType{api.PaymentEventCreated}
+	assert.NoError(t, s.Save(hook))
+
+	d.Publish(api.NewPaymentEvent(api.PaymentEventCreated, newMockPayment()))
+	list, _ := s.GetDeliveries(hook.ID, 0, 0)
+	deliveries := list.Results.([]*api.WebhookDelivery)
+	if !assert.Len(t, deliveries, 1) {
+		close(release)
+		return
+	}
+	id := deliveries[0].ID
+
+	// the attempt is running, it is not scheduled again
+	_, err := d.Redeliver(id)
+	assert.Equal(t, api.ErrDeliveryPending, err)
+	close(release)
+	assert.True(t, waitFor(func() bool {
+		delivery, _ := s.GetDelivery(id)
+		return delivery.Status == api.WebhookDeliverySucceeded
+	}))
+	delivery, err := d.Redeliver(id)
+	if assert.NoError(t, err) {
+		assert.Equal(t, api.WebhookDeliveryPending, delivery.Status)
+	}
+}
+
+func TestWebhookInMemStoreMaxDeliveries(t *testing.T) {
+	s := api.NewWebhookInMemStore()
+	s.MaxDeliveries = 2
+	hookID := uuid.New()
+	save := func(status api.WebhookDeliveryStatus) *api.WebhookDelivery {
+		d := &api.WebhookDelivery{ID: uuid.New(), WebhookID: hookID, Status: status, CreatedAt: api.Now()}
+		assert.NoError(t, s.SaveDelivery(d))
+		return d
+	}
+	pending := save(api.WebhookDeliveryPending)
+	dead := save(api.WebhookDeliveryDead)
+	last := save(api.WebhookDeliverySucceeded)
+
+	// the oldest delivery is pending, the next one is dropped instead
+	_, err := s.GetDelivery(dead.ID)
+	assert.Equal(t, api.ErrNotFound, err)
+	for _, d := range []*api.WebhookDelivery{pending, last} {
+		_, err := s.GetDelivery(d.ID)
+		assert.NoError(t, err)
+	}
+	list, _ := s.GetDeliveries(hookID, 0, 0)
+	assert.Equal(t, 2, list.Total)
+
+	// the updates are not new deliveries
+	pending.Status = api.WebhookDeliverySucceeded
+	assert.NoError(t, s.SaveDelivery(pending))
+	stored, err := s.GetDelivery(pending.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, api.WebhookDeliverySucceeded, stored.Status)
+	}
+	assert.NoError(t, s.Delete(hookID))
+	_, err = s.GetDelivery(last.ID)
+	assert.Equal(t, api.ErrNotFound, err)
+}
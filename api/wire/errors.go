@@ -0,0 +1,89 @@
+package wire
+
+import "net/http"
+
+// ErrorCode is a standarized string that identifies issues across the API
+type ErrorCode string
+
+// APIError is the content that is returned on error
+type APIError struct {
+	Message string    `json:"error"`
+	AppCode ErrorCode `json:"code,omitempty"`
+	Path    string    `json:"path,omitempty"`
+	// SchemaPath points to the schema of the OpenAPI document the request
+	// does not match
+	SchemaPath string `json:"schemaPath,omitempty"`
+
+	DataError  bool  `json:"-"`
+	StatusCode int   `json:"-"`
+	Err        error `json:"-"`
+}
+
+func (e APIError) Error() string {
+	ret := e.Message
+	if e.Err != nil {
+		ret += ": " + e.Err.Error()
+	}
+	return ret
+}
+
+// Unwrap returns the underlying error, if any
+func (e APIError) Unwrap() error {
+	return e.Err
+}
+
+func (e *APIError) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Extensions returns the extensions of the error in a GraphQL response
+func (e *APIError) Extensions() map[string]interface{} {
+	ret := map[string]interface{}{"code": e.AppCode}
+	if e.Path != "" {
+		ret["path"] = e.Path
+	}
+	if e.SchemaPath != "" {
+		ret["schemaPath"] = e.SchemaPath
+	}
+	return ret
+}
+
+func NewAPIError(dataError bool, message string) *APIError {
+	return &APIError{
+		DataError: dataError,
+		Message:   message,
+	}
+}
+
+const (
+	ErrorCodeInternalError  ErrorCode = "internal_error"
+	ErrorCodeNotImplemented ErrorCode = "not_implemented"
+	ErrorCodeMaintainance   ErrorCode = "undergoing_maintenance"
+	ErrorCodeNotFound       ErrorCode = "not_found"
+	ErrorCodeConflict       ErrorCode = "conflict"
+	ErrorCodeInvalidInput   ErrorCode = "invalid_input"
+
+	ErrorCodeSignatureMissing ErrorCode = "signature_missing"
+	ErrorCodeSignatureInvalid ErrorCode = "signature_invalid"
+	ErrorCodeUnknownClient    ErrorCode = "unknown_client"
+	ErrorCodeDigestMismatch   ErrorCode = "digest_mismatch"
+	ErrorCodeRequestExpired   ErrorCode = "request_expired"
+	ErrorCodeReplayedRequest  ErrorCode = "replayed_request"
+	ErrorCodeRateLimited      ErrorCode = "rate_limited"
+
+	ErrorCodeBodyTooLarge   ErrorCode = "body_too_large"
+	ErrorCodeMalformedBody  ErrorCode = "malformed_body"
+	ErrorCodeInvalidType    ErrorCode = "invalid_type"
+	ErrorCodeUnknownField   ErrorCode = "unknown_field"
+	ErrorCodeDuplicateField ErrorCode = "duplicate_field"
+	ErrorCodeTrailingData   ErrorCode = "trailing_data"
+
+	ErrorCodeInvalidQuery    ErrorCode = "invalid_query"
+	ErrorCodeQueryTooDeep    ErrorCode = "query_too_deep"
+	ErrorCodeQueryTooComplex ErrorCode = "query_too_complex"
+
+	ErrorCodeInvalidResponse ErrorCode = "invalid_response"
+
+	ErrorCodeTimeout         ErrorCode = "timeout"
+	ErrorCodeRequestCanceled ErrorCode = "request_canceled"
+)
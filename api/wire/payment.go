@@ -0,0 +1,144 @@
+package wire
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PaymentParty struct {
+	AccountName       string `json:"accountName"`
+	AccountNumber     string `json:"accountNumber"`
+	AccountNumberCode string `json:"accountNumberCode"`
+	BankID            string `json:"bankId"`
+	BankIDCode        string `json:"bankIdCode"`
+	Name              string `json:"name"`
+	Address           string `json:"address"`
+}
+
+// Payment represents a payment
+type Payment struct {
+	ID                   uuid.UUID     `json:"id" bson:"_id,omitempty"`
+	CreatedAt            *time.Time    `json:"createdAt"`
+	UpdatedAt            *time.Time    `json:"updatedAt"`
+	Purpose              string        `json:"purpose"`
+	Scheme               string        `json:"scheme"`
+	Type                 string        `json:"type"`
+	Amount               string        `json:"amount"`
+	Beneficiary          *PaymentParty `json:"beneficiary"`
+	Currency             string        `json:"currency"`
+	DebitorParty         *PaymentParty `json:"debitorParty"`
+	EndToEndReference    string        `json:"endToEndReference"`
+	NumericReference     string        `json:"numericReference"`
+	ProcessingDate       string        `json:"processingDate"`
+	Reference            string        `json:"reference"`
+	SchemePaymentSubType string        `json:"schemePaymentSubType"`
+	SchemePaymentType    string        `json:"schemePaymentType"`
+	ChargesInformation   struct {
+		BearerCode              string `json:"bearerCode"`
+		ReceiverChargesAmount   string `json:"receiverChargesAmount"`
+		ReceiverChargesCurrency string `json:"receiverChargesCurrency"`
+		SenderCharges           []struct {
+			Amount   string `json:"amount"`
+			Currency string `json:"currency"`
+		} `json:"senderCharges"`
+	} `json:"chargesInformation"`
+	FX struct {
+		ContractReference string `json:"contractReference"`
+		ExchangeRate      string `json:"exchangeRate"`
+		OriginalAmount    string `json:"originalAmount"`
+		OriginalCurrency  string `json:"originalCurrency"`
+	} `json:"fx"`
+}
+
+func NewPayment() *Payment {
+	now := time.Now()
+	return &Payment{
+		ID:        uuid.New(),
+		CreatedAt: &now,
+		UpdatedAt: &now,
+	}
+}
+
+// Copy returns a deep copy of the payment, nil for a nil payment
+func (p *Payment) Copy() *Payment {
+	if p == nil {
+		return nil
+	}
+	ret := *p
+	if p.CreatedAt != nil {
+		t := *p.CreatedAt
+		ret.CreatedAt = &t
+	}
+	if p.UpdatedAt != nil {
+		t := *p.UpdatedAt
+		ret.UpdatedAt = &t
+	}
+	if p.Beneficiary != nil {
+		party := *p.Beneficiary
+		ret.Beneficiary = &party
+	}
+	if p.DebitorParty != nil {
+		party := *p.DebitorParty
+		ret.DebitorParty = &party
+	}
+	if p.ChargesInformation.SenderCharges != nil {
+		ret.ChargesInformation.SenderCharges = append(
+			p.ChargesInformation.SenderCharges[:0:0],
+			p.ChargesInformation.SenderCharges...,
+		)
+	}
+	return &ret
+}
+
+func (p *Payment) SetScheme(value string) *Payment {
+	p.Scheme = value
+	return p
+}
+
+func (p *Payment) Validate() error {
+	return nil
+}
+
+// PaymentEventType identifies what happened to a payment
+type PaymentEventType string
+
+const (
+	PaymentEventCreated PaymentEventType = "payment.created"
+	PaymentEventUpdated PaymentEventType = "payment.updated"
+	PaymentEventDeleted PaymentEventType = "payment.deleted"
+)
+
+// PaymentEventTypes lists all the known event types
+var PaymentEventTypes = []PaymentEventType{
+	PaymentEventCreated,
+	PaymentEventUpdated,
+	PaymentEventDeleted,
+}
+
+// IsValid returns true if the event type is known
+func (t PaymentEventType) IsValid() bool {
+	for _, known := range PaymentEventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// PaymentEvent is emitted every time a payment is altered
+type PaymentEvent struct {
+	ID        uuid.UUID        `json:"id" bson:"_id"`
+	Type      PaymentEventType `json:"type"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Payment   *Payment         `json:"payment"`
+}
+
+func NewPaymentEvent(typ PaymentEventType, p *Payment) *PaymentEvent {
+	return &PaymentEvent{
+		ID:        uuid.New(),
+		Type:      typ,
+		CreatedAt: time.Now(),
+		Payment:   p,
+	}
+}
@@ -0,0 +1,81 @@
+package wire
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Requests altering payments can be signed by the clients with a shared
+// secret. The signature is an HMAC-SHA256 over the following string, each
+// element being separated by a new line:
+//
+//   <method> <request uri>
+//   <Date header>
+//   <Digest header>
+//   <X-Nonce header>
+//
+// The Digest header holds the SHA-256 of the body and the Signature header
+// has the following form:
+//
+//   keyId="client",algorithm="hmac-sha256",signature="<base64>"
+
+const (
+	SignatureAlgorithm = "hmac-sha256"
+	DigestPrefix       = "SHA-256="
+)
+
+// BodyDigest returns the value of the Digest header for the given body
+func BodyDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return DigestPrefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func signingString(r *http.Request) string {
+	return strings.Join([]string{
+		strings.ToLower(r.Method) + " " + r.URL.RequestURI(),
+		r.Header.Get(HeaderDate),
+		r.Header.Get(HeaderDigest),
+		r.Header.Get(HeaderNonce),
+	}, "\n")
+}
+
+// ComputeSignature returns the HMAC of the request with secret, which the
+// server compares with the one of the Signature header
+func ComputeSignature(r *http.Request, secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingString(r)))
+	return mac.Sum(nil)
+}
+
+// SignRequest sets the Date, Digest, X-Nonce and Signature headers on the
+// request. The body is read and replaced so the request can still be sent.
+func SignRequest(r *http.Request, clientID, secret, nonce string) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if r.Header.Get(HeaderDate) == "" {
+		r.Header.Set(HeaderDate, time.Now().UTC().Format(http.TimeFormat))
+	}
+	r.Header.Set(HeaderDigest, BodyDigest(body))
+	r.Header.Set(HeaderNonce, nonce)
+	r.Header.Set(HeaderSignature, fmt.Sprintf(
+		`keyId="%s",algorithm="%s",signature="%s"`,
+		clientID,
+		SignatureAlgorithm,
+		base64.StdEncoding.EncodeToString(ComputeSignature(r, secret)),
+	))
+	return nil
+}
@@ -0,0 +1,42 @@
+// Package wire holds what the Payment API and its clients agree on: the
+// payments and their events, the JSend envelope of the responses, the error
+// codes and the signature of the requests. It only depends on the standard
+// library and uuid so the clients do not pull the dependencies of the server.
+package wire
+
+import "encoding/json"
+
+const (
+	APIV1Prefix = "/v1"
+
+	HeaderContentType = "Content-Type"
+	HeaderDate        = "Date"
+	HeaderDigest      = "Digest"
+	HeaderNonce       = "X-Nonce"
+	HeaderSignature   = "Signature"
+	HeaderAPIKey      = "X-API-Key"
+
+	HeaderLastEventID      = "Last-Event-ID"
+	ContentTypeEventStream = "text/event-stream"
+
+	// StreamEventReset is sent when the client resumes from an event that is
+	// no longer in the replay buffer, it should reload the payments
+	StreamEventReset = "reset"
+)
+
+// The API uses the JSEND scheme to communicate with the clients
+
+const (
+	JSENDDataStatusSuccess = "success"
+	JSENDDataStatusFail    = "fail"
+	JSENDDataStatusError   = "error"
+)
+
+// Envelope is the JSend envelope of the responses as read by the clients, the
+// data is decoded once the status is known
+type Envelope struct {
+	Data    json.RawMessage `json:"data"`
+	Code    int             `json:"code"`
+	Status  string          `json:"status"`
+	TraceID string          `json:"traceId,omitempty"`
+}
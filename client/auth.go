@@ -0,0 +1,64 @@
+package client
+
+import (
+	"net/http"
+
+	"github.com/ganitzsh/f3-te/api/wire"
+	"github.com/google/uuid"
+)
+
+// Authenticator adds the credentials to a request before each attempt, the
+// body of the request can be read with GetBody
+type Authenticator interface {
+	Authenticate(r *http.Request) error
+}
+
+// AuthFunc turns a function into an Authenticator
+type AuthFunc func(r *http.Request) error
+
+func (f AuthFunc) Authenticate(r *http.Request) error {
+	return f(r)
+}
+
+// APIKey sends the key in the X-API-Key header
+func APIKey(key string) Authenticator {
+	return AuthFunc(func(r *http.Request) error {
+		r.Header.Set(wire.HeaderAPIKey, key)
+		return nil
+	})
+}
+
+// BearerToken sends the token in the Authorization header
+func BearerToken(token string) Authenticator {
+	return AuthFunc(func(r *http.Request) error {
+		r.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// HMACSigner signs the requests altering payments with the secret shared
+// with the API, see the request signing section of the README
+type HMACSigner struct {
+	ClientID string
+	Secret   string
+}
+
+func (s *HMACSigner) Authenticate(r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	return wire.SignRequest(r, s.ClientID, s.Secret, uuid.New().String())
+}
+
+// Chain applies the authenticators in order
+func Chain(auths ...Authenticator) Authenticator {
+	return AuthFunc(func(r *http.Request) error {
+		for _, a := range auths {
+			if err := a.Authenticate(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
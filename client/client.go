@@ -0,0 +1,353 @@
+// Package client is a Go client for the Payment API.
+//
+// It unwraps the JSend envelope of the responses, returns the API errors as
+// *Error values comparable with errors.Is and retries the requests while the
+// API is undergoing maintenance:
+//
+//	c := client.New("http://localhost:8080", client.WithAuth(client.APIKey("key")))
+//	p, err := c.GetPayment(ctx, id)
+//	if errors.Is(err, client.ErrNotFound) {
+//		...
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api/wire"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultMaxAttempts = 3
+	DefaultBackoff     = 200 * time.Millisecond
+	DefaultMaxBackoff  = 5 * time.Second
+	DefaultPageSize    = 100
+)
+
+// RetryPolicy defines how the requests are retried when the API is undergoing
+// maintenance. The delay starts at Backoff and doubles up to MaxBackoff.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// Client calls the Payment API, it is safe for concurrent use
+type Client struct {
+	// BaseURL is the root of the API, without the version prefix
+	BaseURL    string
+	HTTPClient *http.Client
+	Auth       Authenticator
+	Retry      RetryPolicy
+}
+
+// Option configures a Client
+type Option func(c *Client)
+
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) {
+		c.HTTPClient = h
+	}
+}
+
+func WithAuth(a Authenticator) Option {
+	return func(c *Client) {
+		c.Auth = a
+	}
+}
+
+func WithRetry(p RetryPolicy) Option {
+	return func(c *Client) {
+		c.Retry = p
+	}
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+		Retry: RetryPolicy{
+			MaxAttempts: DefaultMaxAttempts,
+			Backoff:     DefaultBackoff,
+			MaxBackoff:  DefaultMaxBackoff,
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// readError builds the Error of a failed response
+func readError(resp *http.Response, body []byte) *Error {
+	ret := &Error{
+		StatusCode: resp.StatusCode,
+		Status:     wire.JSENDDataStatusError,
+		Message:    http.StatusText(resp.StatusCode),
+	}
+	env := &wire.Envelope{}
+	apiErr := &wire.APIError{}
+	if json.Unmarshal(body, env) != nil || json.Unmarshal(env.Data, apiErr) != nil {
+		if msg := strings.TrimSpace(string(body)); msg != "" {
+			ret.Message = msg
+		}
+		return ret
+	}
+	ret.Status = env.Status
+	ret.Code = apiErr.AppCode
+	ret.Path = apiErr.Path
+	ret.SchemaPath = apiErr.SchemaPath
+	if apiErr.Message != "" {
+		ret.Message = apiErr.Message
+	}
+	return ret
+}
+
+// do sends the request and decodes the data of the response in out when it
+// is not nil. Requests are retried while the API is undergoing maintenance.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	u := c.BaseURL + wire.APIV1Prefix + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	attempts := c.Retry.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		err := c.attempt(ctx, method, u, body, out)
+		apiErr, ok := err.(*Error)
+		if !ok || apiErr.Code != wire.ErrorCodeMaintainance || attempt >= attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.Retry.delay(attempt)):
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, method, u string, body []byte, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set(wire.HeaderContentType, "application/json")
+	}
+	if c.Auth != nil {
+		if err := c.Auth.Authenticate(req); err != nil {
+			return err
+		}
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return readError(resp, data)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	env := &wire.Envelope{}
+	if err := json.Unmarshal(data, env); err != nil {
+		return fmt.Errorf("could not decode response: %v", err)
+	}
+	if err := json.Unmarshal(env.Data, out); err != nil {
+		return fmt.Errorf("could not decode response data: %v", err)
+	}
+	return nil
+}
+
+// ListOptions selects the payments to list. Filters are sent as query
+// parameters, a field with several values matches any of them.
+type ListOptions struct {
+	Limit   int
+	Offset  int
+	Filters url.Values
+}
+
+func (o *ListOptions) query() url.Values {
+	ret := url.Values{}
+	if o == nil {
+		return ret
+	}
+	for k, v := range o.Filters {
+		ret[k] = v
+	}
+	if o.Limit > 0 {
+		ret.Set("lim", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		ret.Set("off", strconv.Itoa(o.Offset))
+	}
+	return ret
+}
+
+// PaymentList is a page of payments, Total is the amount of payments matching
+// the filters
+type PaymentList struct {
+	Total    int             `json:"total"`
+	SubTotal int             `json:"subTotal"`
+	Payments []*wire.Payment `json:"results"`
+}
+
+func (c *Client) ListPayments(ctx context.Context, opts *ListOptions) (*PaymentList, error) {
+	ret := &PaymentList{}
+	if err := c.do(ctx, http.MethodGet, "/payments", opts.query(), nil, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (c *Client) GetPayment(ctx context.Context, id uuid.UUID) (*wire.Payment, error) {
+	ret := &wire.Payment{}
+	if err := c.do(ctx, http.MethodGet, "/payments/"+id.String(), nil, nil, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// paymentBody encodes the payment without the fields managed by the API
+func paymentBody(p *wire.Payment) (map[string]json.RawMessage, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return nil, err
+	}
+	delete(ret, "id")
+	delete(ret, "createdAt")
+	delete(ret, "updatedAt")
+	return ret, nil
+}
+
+// SavePayment creates the payment when its ID is not set, and updates it
+// otherwise. The payment saved by the API is returned.
+func (c *Client) SavePayment(ctx context.Context, p *wire.Payment) (*wire.Payment, error) {
+	method, path := http.MethodPost, "/payments"
+	if p.ID != uuid.Nil {
+		method, path = http.MethodPut, "/payments/"+p.ID.String()
+	}
+	body, err := paymentBody(p)
+	if err != nil {
+		return nil, err
+	}
+	ret := &wire.Payment{}
+	if err := c.do(ctx, method, path, nil, body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (c *Client) DeletePayment(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, http.MethodDelete, "/payments/"+id.String(), nil, nil, nil)
+}
+
+// PaymentIterator walks through the payments page by page:
+//
+//	it := c.Payments(ctx, nil)
+//	for it.Next() {
+//		p := it.Payment()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type PaymentIterator struct {
+	c       *Client
+	ctx     context.Context
+	opts    ListOptions
+	page    []*wire.Payment
+	current *wire.Payment
+	done    bool
+	err     error
+}
+
+// Payments returns an iterator over the payments matching opts, Limit is the
+// size of the pages requested
+func (c *Client) Payments(ctx context.Context, opts *ListOptions) *PaymentIterator {
+	it := &PaymentIterator{c: c, ctx: ctx}
+	if opts != nil {
+		it.opts = *opts
+	}
+	if it.opts.Limit <= 0 {
+		it.opts.Limit = DefaultPageSize
+	}
+	return it
+}
+
+// Next fetches the next payment, it returns false at the end or on error
+func (it *PaymentIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if len(it.page) == 0 && !it.done {
+		list, err := it.c.ListPayments(it.ctx, &it.opts)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page = list.Payments
+		it.opts.Offset += len(list.Payments)
+		it.done = len(list.Payments) < it.opts.Limit || it.opts.Offset >= list.Total
+	}
+	if len(it.page) == 0 {
+		return false
+	}
+	it.current, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Payment returns the current payment
+func (it *PaymentIterator) Payment() *wire.Payment {
+	return it.current
+}
+
+// Err returns the error that stopped the iteration
+func (it *PaymentIterator) Err() error {
+	return it.err
+}
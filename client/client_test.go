@@ -0,0 +1,227 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/ganitzsh/f3-te/client"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	api.InitConfig()
+}
+
+var testRetry = client.RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     time.Millisecond,
+	MaxBackoff:  5 * time.Millisecond,
+}
+
+func newPayment(scheme string) *api.Payment {
+	return &api.Payment{
+		Scheme:      scheme,
+		Amount:      "42.00",
+		Currency:    "GBP",
+		Beneficiary: &api.PaymentParty{Name: "Jane"},
+	}
+}
+
+// newTestServer serves the API with an empty in memory store
+func newTestServer(opts ...client.Option) (*client.Client, *api.PaymentInMemStore, func()) {
+	s := api.NewPaymentInMemStore()
+	api.SetStore(s)
+	srv := httptest.NewServer(api.Routes())
+	opts = append([]client.Option{client.WithRetry(testRetry)}, opts...)
+	return client.New(srv.URL, opts...), s, srv.Close
+}
+
+func TestClientPayments(t *testing.T) {
+	c, s, stop := newTestServer()
+	defer stop()
+	ctx := context.Background()
+
+	created, err := c.SavePayment(ctx, newPayment("A"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, uuid.Nil, created.ID)
+	assert.NotNil(t, created.CreatedAt)
+	_, err = c.SavePayment(ctx, newPayment("B"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, s.Total(ctx))
+
+	got, err := c.GetPayment(ctx, created.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Jane", got.Beneficiary.Name)
+
+	got.Amount = "43.00"
+	updated, err := c.SavePayment(ctx, got)
+	assert.NoError(t, err)
+	assert.Equal(t, created.ID, updated.ID)
+	assert.Equal(t, "43.00", updated.Amount)
+	assert.Equal(t, 2, s.Total(ctx))
+
+	list, err := c.ListPayments(ctx, &client.ListOptions{Filters: url.Values{"scheme": {"A"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, list.Total)
+	if assert.Len(t, list.Payments, 1) {
+		assert.Equal(t, created.ID, list.Payments[0].ID)
+	}
+
+	assert.NoError(t, c.DeletePayment(ctx, created.ID))
+	_, err = c.GetPayment(ctx, created.ID)
+	assert.True(t, errors.Is(err, client.ErrNotFound))
+	assert.True(t, errors.Is(err, api.ErrNotFound))
+	assert.False(t, errors.Is(err, client.ErrInvalidInput))
+	apiErr := &client.Error{}
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	}
+
+	_, err = c.SavePayment(ctx, &api.Payment{ID: uuid.New()})
+	assert.True(t, errors.Is(err, client.ErrNotFound))
+}
+
+func TestClientIterator(t *testing.T) {
+	c, _, stop := newTestServer()
+	defer stop()
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, err := c.SavePayment(ctx, newPayment("A"))
+		assert.NoError(t, err)
+	}
+
+	ids := map[uuid.UUID]bool{}
+	it := c.Payments(ctx, &client.ListOptions{Limit: 1})
+	for it.Next() {
+		ids[it.Payment().ID] = true
+	}
+	assert.NoError(t, it.Err())
+	assert.Len(t, ids, 3)
+
+	it = c.Payments(ctx, &client.ListOptions{Filters: url.Values{"scheme": {"B"}}})
+	assert.False(t, it.Next())
+	assert.NoError(t, it.Err())
+}
+
+// maintenance answers 503 undergoing_maintenance to the first failures
+// requests before forwarding them to next
+func maintenance(failures int32, next http.Handler) (http.Handler, *int32) {
+	var calls int32
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			render.Render(w, r, api.NewJSENDData(api.ErrAPIMaintainance))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}), &calls
+}
+
+func TestClientRetry(t *testing.T) {
+	api.SetStore(api.NewPaymentInMemStore())
+	handler, calls := maintenance(2, api.Routes())
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	ctx := context.Background()
+
+	c := client.New(srv.URL, client.WithRetry(testRetry))
+	_, err := c.ListPayments(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(calls))
+
+	atomic.StoreInt32(calls, -10)
+	_, err = c.ListPayments(ctx, nil)
+	assert.True(t, errors.Is(err, client.ErrMaintenance))
+	assert.Equal(t, int32(-7), atomic.LoadInt32(calls))
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = client.New(srv.URL).ListPayments(ctx, nil)
+	assert.Error(t, err)
+}
+
+func TestClientAuth(t *testing.T) {
+	prev := api.Config().Signature
+	defer func() { api.Config().Signature = prev }()
+	api.Config().Signature = &api.SignatureSettings{
+		Enabled: true,
+		Window:  time.Minute,
+		Clients: map[string]string{"client": "secret"},
+	}
+
+	c, s, stop := newTestServer(client.WithAuth(&client.HMACSigner{ClientID: "client", Secret: "secret"}))
+	defer stop()
+	_, err := c.SavePayment(context.Background(), newPayment("A"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, s.Total(context.Background()))
+
+	c.Auth = &client.HMACSigner{ClientID: "client", Secret: "wrong"}
+	_, err = c.SavePayment(context.Background(), newPayment("A"))
+	assert.True(t, errors.Is(err, client.ErrSignature))
+
+	var header string
+	c.Auth = client.Chain(client.APIKey("key"), client.AuthFunc(func(r *http.Request) error {
+		header = r.Header.Get(api.HeaderAPIKey)
+		return nil
+	}))
+	_, err = c.ListPayments(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "key", header)
+}
+
+var errStop = errors.New("stop")
+
+func TestClientWatch(t *testing.T) {
+	s := api.NewPaymentEventStore(api.NewPaymentInMemStore(), api.Bus())
+	api.SetStore(s)
+	srv := httptest.NewServer(api.Routes())
+	defer srv.Close()
+	c := client.New(srv.URL, client.WithRetry(testRetry))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	events := make(chan *client.Event, 10)
+	done := make(chan error, 1)
+	subscribers := api.Bus().Subscribers()
+	go func() {
+		done <- c.Watch(ctx, &client.WatchOptions{Filters: url.Values{"scheme": {"A"}}}, func(e *client.Event) error {
+			events <- e
+			if len(events) == 2 {
+				return errStop
+			}
+			return nil
+		})
+	}()
+	for api.Bus().Subscribers() == subscribers {
+		time.Sleep(time.Millisecond)
+	}
+
+	p := newPayment("A")
+	p.ID = uuid.New()
+	assert.NoError(t, s.Save(ctx, newPayment("B")))
+	assert.NoError(t, s.Save(ctx, p))
+	assert.NoError(t, s.Delete(ctx, p.ID))
+	assert.Equal(t, errStop, <-done)
+
+	created, deleted := <-events, <-events
+	assert.Equal(t, api.PaymentEventCreated, created.Type)
+	assert.Equal(t, p.ID, created.Payment.ID)
+	assert.Equal(t, api.PaymentEventDeleted, deleted.Type)
+	assert.True(t, deleted.Sequence > created.Sequence)
+
+	// Resuming replays the events following the last one received
+	err := c.Watch(ctx, &client.WatchOptions{LastEventID: created.Sequence}, func(e *client.Event) error {
+		events <- e
+		return errStop
+	})
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, deleted.Sequence, (<-events).Sequence)
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/ganitzsh/f3-te/api/wire"
+)
+
+// Error is returned when the API answers with a JSend fail or error. It can be
+// compared with errors.Is to the errors of this package or to the APIErrors
+// of the wire package, only the error code is compared.
+type Error struct {
+	StatusCode int
+	Status     string
+	Code       wire.ErrorCode
+	Message    string
+	Path       string
+	// SchemaPath points to the schema of the OpenAPI document the request did
+	// not match
+	SchemaPath string
+}
+
+func (e *Error) Error() string {
+	ret := fmt.Sprintf("%d %s", e.StatusCode, e.Message)
+	if e.Code != "" {
+		ret += " (" + string(e.Code) + ")"
+	}
+	if e.Path != "" {
+		ret += " at " + e.Path
+	}
+	return ret
+}
+
+func (e *Error) Is(target error) bool {
+	switch t := target.(type) {
+	case *Error:
+		return t.Code != "" && t.Code == e.Code
+	case *wire.APIError:
+		return t.AppCode != "" && t.AppCode == e.Code
+	}
+	return false
+}
+
+// Temporary returns true when the request can be sent again later
+func (e *Error) Temporary() bool {
+	return e.Code == wire.ErrorCodeMaintainance || e.Code == wire.ErrorCodeRateLimited
+}
+
+var (
+	ErrNotFound      = &Error{Code: wire.ErrorCodeNotFound}
+	ErrInvalidInput  = &Error{Code: wire.ErrorCodeInvalidInput}
+	ErrInternal      = &Error{Code: wire.ErrorCodeInternalError}
+	ErrMaintenance   = &Error{Code: wire.ErrorCodeMaintainance}
+	ErrRateLimited   = &Error{Code: wire.ErrorCodeRateLimited}
+	ErrUnknownClient = &Error{Code: wire.ErrorCodeUnknownClient}
+	ErrSignature     = &Error{Code: wire.ErrorCodeSignatureInvalid}
+)
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api/wire"
+)
+
+// Event is a payment event received from the events stream. Reset is set
+// when the events following the last one received are no longer available,
+// the payments should then be reloaded.
+type Event struct {
+	Sequence int64 `json:"sequence"`
+	Reset    bool  `json:"reset,omitempty"`
+	*wire.PaymentEvent
+}
+
+// WatchOptions selects the events to receive, the stream resumes after
+// LastEventID when it is set
+type WatchOptions struct {
+	Filters     url.Values
+	LastEventID int64
+}
+
+// errStreamClosed is returned when the server closed the stream
+var errStreamClosed = fmt.Errorf("stream closed by the server")
+
+// Watch calls fn for every payment event until ctx is done or fn returns an
+// error. The stream is resumed from the last event received when the server
+// closes it.
+func (c *Client) Watch(ctx context.Context, opts *WatchOptions, fn func(*Event) error) error {
+	o := WatchOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	for attempt := 1; ; attempt++ {
+		err := c.stream(ctx, &o, fn)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == errStreamClosed {
+			attempt = 0
+		} else if apiErr, ok := err.(*Error); !ok || apiErr.Code != wire.ErrorCodeMaintainance || attempt >= c.Retry.MaxAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.Retry.delay(attempt)):
+		}
+	}
+}
+
+// stream reads the events until the connection is closed, LastEventID is
+// updated with every event received
+func (c *Client) stream(ctx context.Context, o *WatchOptions, fn func(*Event) error) error {
+	u := c.BaseURL + wire.APIV1Prefix + "/payments/events"
+	if len(o.Filters) > 0 {
+		u += "?" + o.Filters.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", wire.ContentTypeEventStream)
+	if o.LastEventID > 0 {
+		req.Header.Set(wire.HeaderLastEventID, strconv.FormatInt(o.LastEventID, 10))
+	}
+	if c.Auth != nil {
+		if err := c.Auth.Authenticate(req); err != nil {
+			return err
+		}
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return readError(resp, body)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	e := &Event{}
+	var typ, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if typ == "" {
+				continue
+			}
+			if typ == wire.StreamEventReset {
+				e.Reset = true
+			} else {
+				e.PaymentEvent = &wire.PaymentEvent{}
+				if err := json.Unmarshal([]byte(data), e.PaymentEvent); err != nil {
+					return fmt.Errorf("could not decode event %d: %v", e.Sequence, err)
+				}
+				o.LastEventID = e.Sequence
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+			e, typ, data = &Event{}, "", ""
+		case strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "id:"):
+			e.Sequence, _ = strconv.ParseInt(strings.TrimSpace(line[3:]), 10, 64)
+		case strings.HasPrefix(line, "event:"):
+			typ = strings.TrimSpace(line[6:])
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimPrefix(line[5:], " ")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errStreamClosed
+}
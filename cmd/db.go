@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateSteps int
+	dropDryRun   bool
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the database of the configured store",
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply or revert the migrations of the sql store",
+}
+
+// openSQLStore opens the configured SQL store or exits
+func openSQLStore() *api.PaymentSQLStore {
+	api.InitConfig()
+	s, err := api.OpenSQLStore()
+	if err != nil {
+		logrus.Fatalf("Could not open the database: %v", err)
+	}
+	return s
+}
+
+var dbMigrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply the pending migrations, all of them unless --steps is given",
+	Run: func(cmd *cobra.Command, args []string) {
+		s := openSQLStore()
+		defer s.Close()
+		applied, err := s.MigrateUp(migrateSteps)
+		for _, m := range applied {
+			logrus.Infof("Applied migration %d %s", m.Version, m.Name)
+		}
+		if err != nil {
+			logrus.Fatalf("Could not migrate: %v", err)
+		}
+		if len(applied) == 0 {
+			logrus.Info("The database is up to date")
+		}
+	},
+}
+
+var dbMigrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Revert the last applied migrations, one unless --steps is given",
+	Run: func(cmd *cobra.Command, args []string) {
+		s := openSQLStore()
+		defer s.Close()
+		reverted, err := s.MigrateDown(migrateSteps)
+		for _, m := range reverted {
+			logrus.Infof("Reverted migration %d %s", m.Version, m.Name)
+		}
+		if err != nil {
+			logrus.Fatalf("Could not migrate: %v", err)
+		}
+		if len(reverted) == 0 {
+			logrus.Info("No migration to revert")
+		}
+	},
+}
+
+var dbMigrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "List the migrations and whether they are applied",
+	Run: func(cmd *cobra.Command, args []string) {
+		s := openSQLStore()
+		defer s.Close()
+		status, err := s.MigrationStatus()
+		if err != nil {
+			logrus.Fatalf("Could not read the migrations: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, m := range status {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = m.AppliedAt.Local().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.Name, applied)
+		}
+		w.Flush()
+	},
+}
+
+var dbIndexesCmd = &cobra.Command{
+	Use:   "indexes",
+	Short: "Manage the indexes of the mongo store",
+}
+
+// openMongoIndexes connects to the configured payments collection or exits
+func openMongoIndexes() *api.MongoIndexManager {
+	api.InitConfig()
+	m, err := api.OpenMongoIndexes()
+	if err != nil {
+		logrus.Fatalf("Could not open the database: %v", err)
+	}
+	return m
+}
+
+var dbIndexesSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Create the declared indexes missing from the collection",
+	Run: func(cmd *cobra.Command, args []string) {
+		created, err := openMongoIndexes().Sync()
+		for _, name := range created {
+			logrus.Infof("Created index %s", name)
+		}
+		if err != nil {
+			logrus.Fatalf("Could not sync the indexes: %v", err)
+		}
+		if len(created) == 0 {
+			logrus.Info("The indexes are up to date")
+		}
+	},
+}
+
+var dbIndexesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the declared indexes and the unknown ones",
+	Run: func(cmd *cobra.Command, args []string) {
+		status, err := openMongoIndexes().List()
+		if err != nil {
+			logrus.Fatalf("Could not list the indexes: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tKEY\tUNIQUE\tSTATUS")
+		for _, index := range status {
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", index.Name, strings.Join(index.Key, ","), index.Unique, index.Status)
+		}
+		w.Flush()
+	},
+}
+
+var dbIndexesDropUnknownCmd = &cobra.Command{
+	Use:   "drop-unknown",
+	Short: "Drop the indexes of the collection that are not declared",
+	Run: func(cmd *cobra.Command, args []string) {
+		dropped, err := openMongoIndexes().DropUnknown(dropDryRun)
+		for _, name := range dropped {
+			if dropDryRun {
+				logrus.Infof("Would drop index %s", name)
+			} else {
+				logrus.Infof("Dropped index %s", name)
+			}
+		}
+		if err != nil {
+			logrus.Fatalf("Could not drop the indexes: %v", err)
+		}
+		if len(dropped) == 0 {
+			logrus.Info("No unknown index")
+		}
+	},
+}
+
+func init() {
+	dbMigrateUpCmd.Flags().IntVar(&migrateSteps, "steps", 0, "Amount of migrations to apply, 0 for all of them")
+	dbMigrateDownCmd.Flags().IntVar(&migrateSteps, "steps", 1, "Amount of migrations to revert")
+	dbMigrateCmd.AddCommand(dbMigrateUpCmd)
+	dbMigrateCmd.AddCommand(dbMigrateDownCmd)
+	dbMigrateCmd.AddCommand(dbMigrateStatusCmd)
+	dbCmd.AddCommand(dbMigrateCmd)
+	dbIndexesDropUnknownCmd.Flags().BoolVar(&dropDryRun, "dry-run", false, "List the indexes without dropping them")
+	dbIndexesCmd.AddCommand(dbIndexesSyncCmd)
+	dbIndexesCmd.AddCommand(dbIndexesListCmd)
+	dbIndexesCmd.AddCommand(dbIndexesDropUnknownCmd)
+	dbCmd.AddCommand(dbIndexesCmd)
+}
@@ -1,21 +1,51 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/ganitzsh/f3-te/api"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/docgen"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+const (
+	DocFormatOpenAPI  = "openapi"
+	DocFormatMarkdown = "markdown"
+)
+
+var docgenFormat string
+
 var docgenCmd = &cobra.Command{
 	Use:   "docgen",
-	Short: "Output the routes as Markdown",
+	Short: "Output the OpenAPI document of the API, or the routes as Markdown",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println(docgen.MarkdownRoutesDoc((api.Routes()).(*chi.Mux), docgen.MarkdownOpts{
-			ProjectPath: "github.com/ganitzsh/f3-te",
-			Intro:       "Generated doc for Payment API",
-		}))
+		routes := (api.Routes()).(*chi.Mux)
+		switch docgenFormat {
+		case DocFormatOpenAPI:
+			doc, err := api.NewOpenAPI(routes)
+			if err != nil {
+				logrus.Fatalf("Could not build the OpenAPI document: %v", err)
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(doc); err != nil {
+				logrus.Fatal(err)
+			}
+		case DocFormatMarkdown:
+			fmt.Println(docgen.MarkdownRoutesDoc(routes, docgen.MarkdownOpts{
+				ProjectPath: "github.com/ganitzsh/f3-te",
+				Intro:       "Generated doc for Payment API",
+			}))
+		default:
+			logrus.Fatalf("Unknown format %q, expected %s or %s", docgenFormat, DocFormatOpenAPI, DocFormatMarkdown)
+		}
 	},
 }
+
+func init() {
+	docgenCmd.Flags().StringVar(&docgenFormat, "format", DocFormatOpenAPI, "Output format: openapi or markdown")
+}
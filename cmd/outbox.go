@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	replayFrom int64
+	replayTo   int64
+)
+
+var outboxCmd = &cobra.Command{
+	Use:   "outbox",
+	Short: "Manage the outbox of payment events",
+}
+
+var outboxReplayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Publish again the events of the outbox in a range of sequences",
+	Run: func(cmd *cobra.Command, args []string) {
+		api.InitConfig()
+		n, err := api.ReplayOutbox(replayFrom, replayTo)
+		if err != nil {
+			logrus.Fatalf("Could not replay the outbox after %d events: %v", n, err)
+		}
+		logrus.Infof("%d events published", n)
+	},
+}
+
+func init() {
+	outboxReplayCmd.Flags().Int64Var(&replayFrom, "from", 1, "First sequence to publish")
+	outboxReplayCmd.Flags().Int64Var(&replayTo, "to", 0, "Last sequence to publish, 0 for no limit")
+	outboxCmd.AddCommand(outboxReplayCmd)
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/ganitzsh/f3-te/client"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+// printer writes the payments and the events in the chosen output format
+type printer struct {
+	w      io.Writer
+	format string
+	header bool
+}
+
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case OutputTable, OutputJSON, OutputYAML:
+		return &printer{w: w, format: format}, nil
+	}
+	return nil, fmt.Errorf("unknown output %q, expected %s, %s or %s", format, OutputTable, OutputJSON, OutputYAML)
+}
+
+var paymentColumns = "ID\tSCHEME\tTYPE\tAMOUNT\tCURRENCY\tPROCESSING DATE\tREFERENCE\tUPDATED"
+
+func paymentRow(p *api.Payment) string {
+	updated := ""
+	if p.UpdatedAt != nil {
+		updated = p.UpdatedAt.Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s",
+		p.ID, p.Scheme, p.Type, p.Amount, p.Currency, p.ProcessingDate, p.Reference, updated)
+}
+
+// Payments prints a page of payments, the table ends with the totals
+func (p *printer) Payments(list *client.PaymentList) error {
+	if p.format != OutputTable {
+		return p.encode(list)
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, paymentColumns)
+	for _, payment := range list.Payments {
+		fmt.Fprintln(tw, paymentRow(payment))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(p.w, "%d of %d payments\n", len(list.Payments), list.Total)
+	return err
+}
+
+func (p *printer) Payment(payment *api.Payment) error {
+	if p.format != OutputTable {
+		return p.encode(payment)
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, paymentColumns)
+	fmt.Fprintln(tw, paymentRow(payment))
+	return tw.Flush()
+}
+
+// Event prints an event as soon as it is received: a row of the table, a line
+// of JSON or a YAML document
+func (p *printer) Event(e *client.Event) error {
+	switch p.format {
+	case OutputJSON:
+		return json.NewEncoder(p.w).Encode(e)
+	case OutputYAML:
+		fmt.Fprintln(p.w, "---")
+		return p.encode(e)
+	}
+	if !p.header {
+		fmt.Fprintln(p.w, "SEQUENCE\tEVENT\t"+paymentColumns)
+		p.header = true
+	}
+	if e.Reset {
+		_, err := fmt.Fprintf(p.w, "%d\t%s\n", e.Sequence, api.StreamEventReset)
+		return err
+	}
+	row := "-"
+	if e.Payment != nil {
+		row = paymentRow(e.Payment)
+	}
+	_, err := fmt.Fprintf(p.w, "%d\t%s\t%s\n", e.Sequence, e.Type, row)
+	return err
+}
+
+// encode writes v as indented JSON or as YAML. The YAML is produced from the
+// JSON encoding so that both use the field names of the API.
+func (p *printer) encode(v interface{}) error {
+	if p.format == OutputJSON {
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var out yaml.MapSlice
+	if err := yaml.Unmarshal(b, &out); err != nil {
+		return err
+	}
+	b, err = yaml.Marshal(out)
+	if err != nil {
+		return err
+	}
+	_, err = p.w.Write(b)
+	return err
+}
+
+// readPayment reads a payment in JSON or YAML from the file, or from stdin
+// when the file is empty or -
+func readPayment(file string) (*api.Payment, error) {
+	var (
+		b   []byte
+		err error
+	)
+	if file == "" || file == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodePayment(b)
+}
+
+// decodePayment decodes a payment in JSON or YAML, the YAML is converted to
+// JSON and decoded like the bodies of the REST API
+func decodePayment(b []byte) (*api.Payment, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("invalid payload: %v", err)
+	}
+	b, err := json.Marshal(jsonValue(doc))
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload: %v", err)
+	}
+	ret, err := api.DecodePayment(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload: %v", err)
+	}
+	return ret, nil
+}
+
+// jsonValue converts the maps decoded from YAML into maps encodable in JSON
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			ret[fmt.Sprint(k)] = jsonValue(val)
+		}
+		return ret
+	case []interface{}:
+		for i, val := range v {
+			v[i] = jsonValue(val)
+		}
+	}
+	return v
+}
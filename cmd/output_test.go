@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/ganitzsh/f3-te/client"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func newTestPayment() *api.Payment {
+	updated := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	return &api.Payment{
+		ID:             uuid.MustParse("4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43"),
+		UpdatedAt:      &updated,
+		Scheme:         "FPS",
+		Type:           "Payment",
+		Amount:         "42.00",
+		Currency:       "GBP",
+		ProcessingDate: "2021-03-04",
+		Reference:      "Payment for Em's piano lessons",
+	}
+}
+
+func TestNewPrinter(t *testing.T) {
+	for _, format := range []string{OutputTable, OutputJSON, OutputYAML} {
+		_, err := newPrinter(&bytes.Buffer{}, format)
+		assert.NoError(t, err, format)
+	}
+	_, err := newPrinter(&bytes.Buffer{}, "xml")
+	assert.Error(t, err)
+}
+
+func TestPrinterPayments(t *testing.T) {
+	p := newTestPayment()
+	list := &client.PaymentList{Total: 3, SubTotal: 1, Payments: []*api.Payment{p}}
+
+	tests := []struct {
+		format string
+		check  func(t *testing.T, out string)
+	}{
+		{OutputTable, func(t *testing.T, out string) {
+			lines := strings.Split(strings.TrimSpace(out), "\n")
+			if assert.Len(t, lines, 3) {
+				assert.Equal(t, []string{"ID", "SCHEME", "TYPE", "AMOUNT"}, strings.Fields(lines[0])[:4])
+				assert.Equal(t, []string{p.ID.String(), "FPS", "Payment", "42.00", "GBP", "2021-03-04"}, strings.Fields(lines[1])[:6])
+				assert.Contains(t, lines[1], "2021-03-04 05:06:07")
+				assert.Equal(t, "1 of 3 payments", lines[2])
+			}
+		}},
+		{OutputJSON, func(t *testing.T, out string) {
+			got := &client.PaymentList{}
+			if assert.NoError(t, json.Unmarshal([]byte(out), got)) {
+				assert.Equal(t, 3, got.Total)
+				assert.Equal(t, list.Payments, got.Payments)
+			}
+		}},
+		{OutputYAML, func(t *testing.T, out string) {
+			got := map[string]interface{}{}
+			if assert.NoError(t, yaml.Unmarshal([]byte(out), &got)) {
+				assert.Equal(t, 3, got["total"])
+				results := got["results"].([]interface{})
+				if assert.Len(t, results, 1) {
+					payment := results[0].(map[interface{}]interface{})
+					assert.Equal(t, "FPS", payment["scheme"])
+					assert.Equal(t, "2021-03-04", payment["processingDate"])
+				}
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			pr, _ := newPrinter(out, test.format)
+			assert.NoError(t, pr.Payments(list))
+			test.check(t, out.String())
+		})
+	}
+}
+
+func TestPrinterPayment(t *testing.T) {
+	out := &bytes.Buffer{}
+	pr, _ := newPrinter(out, OutputTable)
+	assert.NoError(t, pr.Payment(newTestPayment()))
+	assert.Len(t, strings.Split(strings.TrimSpace(out.String()), "\n"), 2)
+
+	out.Reset()
+	pr, _ = newPrinter(out, OutputJSON)
+	assert.NoError(t, pr.Payment(newTestPayment()))
+	got := &api.Payment{}
+	if assert.NoError(t, json.Unmarshal(out.Bytes(), got)) {
+		assert.Equal(t, newTestPayment(), got)
+	}
+}
+
+func TestPrinterEvent(t *testing.T) {
+	events := []*client.Event{
+		{Sequence: 1, PaymentEvent: &api.PaymentEvent{Type: api.PaymentEventCreated, Payment: newTestPayment()}},
+		{Sequence: 2, PaymentEvent: &api.PaymentEvent{Type: api.PaymentEventDeleted}},
+		{Sequence: 3, Reset: true},
+	}
+
+	tests := []struct {
+		format string
+		check  func(t *testing.T, out string)
+	}{
+		{OutputTable, func(t *testing.T, out string) {
+			lines := strings.Split(strings.TrimSpace(out), "\n")
+			if assert.Len(t, lines, 4) {
+				assert.True(t, strings.HasPrefix(lines[0], "SEQUENCE\tEVENT\tID"))
+				assert.True(t, strings.HasPrefix(lines[1], "1\tpayment.created\t"+newTestPayment().ID.String()))
+				assert.Equal(t, "2\tpayment.deleted\t-", lines[2])
+				assert.Equal(t, "3\treset", lines[3])
+			}
+		}},
+		{OutputJSON, func(t *testing.T, out string) {
+			lines := strings.Split(strings.TrimSpace(out), "\n")
+			if assert.Len(t, lines, 3) {
+				got := &client.Event{}
+				assert.NoError(t, json.Unmarshal([]byte(lines[0]), got))
+				assert.Equal(t, int64(1), got.Sequence)
+				assert.Equal(t, api.PaymentEventCreated, got.Type)
+				assert.Contains(t, lines[2], `"reset":true`)
+			}
+		}},
+		{OutputYAML, func(t *testing.T, out string) {
+			docs := strings.Split(out, "---\n")[1:]
+			if assert.Len(t, docs, 3) {
+				got := map[string]interface{}{}
+				assert.NoError(t, yaml.Unmarshal([]byte(docs[1]), &got))
+				assert.Equal(t, 2, got["sequence"])
+				assert.Equal(t, string(api.PaymentEventDeleted), got["type"])
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			pr, _ := newPrinter(out, test.format)
+			for _, e := range events {
+				assert.NoError(t, pr.Event(e))
+			}
+			test.check(t, out.String())
+		})
+	}
+}
+
+func TestReadPayment(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		payload string
+		err     bool
+	}{
+		{"payment.json", `{"scheme": "FPS", "amount": "42.00", "beneficiary": {"bankId": "403000"}}`, false},
+		{"payment.yaml", "scheme: FPS\namount: \"42.00\"\nbeneficiary:\n  bankId: \"403000\"\n", false},
+		{"type.yaml", "scheme: FPS\namount: 42.00\n", true},
+		{"date.json", `{"createdAt": "yesterday"}`, true},
+		{"list.yaml", "- scheme: FPS\n", true},
+		{"broken.json", `{"scheme": `, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(dir, test.name)
+			if !assert.NoError(t, os.WriteFile(file, []byte(test.payload), 0644)) {
+				return
+			}
+			p, err := readPayment(file)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, "FPS", p.Scheme)
+				assert.Equal(t, "42.00", p.Amount)
+				if assert.NotNil(t, p.Beneficiary) {
+					assert.Equal(t, "403000", p.Beneficiary.BankID)
+				}
+			}
+		})
+	}
+
+	_, err := readPayment(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestReadPaymentStrict(t *testing.T) {
+	api.InitConfig()
+	prev := *api.Config().Decoding
+	defer func() { *api.Config().Decoding = prev }()
+	file := filepath.Join(t.TempDir(), "payment.yaml")
+	if !assert.NoError(t, os.WriteFile(file, []byte("scheme: FPS\nammount: \"42.00\"\n"), 0644)) {
+		return
+	}
+
+	api.Config().Decoding.Strict = false
+	p, err := readPayment(file)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "FPS", p.Scheme)
+	}
+
+	api.Config().Decoding.Strict = true
+	_, err = readPayment(file)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "ammount")
+	}
+}
@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/ganitzsh/f3-te/client"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	paymentsURL        string
+	paymentsAPIKey     string
+	paymentsClientID   string
+	paymentsSecret     string
+	paymentsDirect     bool
+	paymentsOutput     string
+	paymentsFile       string
+	paymentsLimit      int
+	paymentsOffset     int
+	paymentsFilterArgs []string
+	paymentsLastID     int64
+)
+
+var paymentsCmd = &cobra.Command{
+	Use:   "payments",
+	Short: "Manage the payments of a running server, or of the store with --direct",
+}
+
+// paymentsContext is cancelled on SIGINT and SIGTERM
+func paymentsContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+	return ctx
+}
+
+// newPaymentsBackend loads the configuration and returns the backend chosen
+// with the flags. The flags take precedence over the client configuration.
+func newPaymentsBackend() paymentsBackend {
+	api.InitConfig()
+	if paymentsDirect {
+		s, err := api.OpenStore()
+		if err != nil {
+			logrus.Fatalf("Could not open the store: %v", err)
+		}
+		return &storeBackend{s}
+	}
+	settings := api.Config().Client
+	url := firstNonEmpty(paymentsURL, settings.URL, api.Config().GetHostURL())
+	auth := []client.Authenticator{}
+	if key := firstNonEmpty(paymentsAPIKey, settings.APIKey); key != "" {
+		auth = append(auth, client.APIKey(key))
+	}
+	id := firstNonEmpty(paymentsClientID, settings.ClientID)
+	secret := firstNonEmpty(paymentsSecret, settings.Secret)
+	if id != "" && secret != "" {
+		auth = append(auth, &client.HMACSigner{ClientID: id, Secret: secret})
+	}
+	return &serverBackend{client.New(url, client.WithAuth(client.Chain(auth...)))}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func newPaymentsPrinter() *printer {
+	p, err := newPrinter(os.Stdout, paymentsOutput)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	return p
+}
+
+func parsePaymentID(arg string) uuid.UUID {
+	id, err := uuid.Parse(arg)
+	if err != nil {
+		logrus.Fatalf("Invalid payment ID %q: %v", arg, err)
+	}
+	return id
+}
+
+func parsePaymentsFilters() client.ListOptions {
+	filters, err := paymentsFilters(paymentsFilterArgs)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	return client.ListOptions{
+		Limit:   paymentsLimit,
+		Offset:  paymentsOffset,
+		Filters: filters,
+	}
+}
+
+var paymentsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the payments",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		p := newPaymentsPrinter()
+		opts := parsePaymentsFilters()
+		list, err := newPaymentsBackend().List(paymentsContext(), &opts)
+		if err != nil {
+			logrus.Fatalf("Could not list the payments: %v", err)
+		}
+		if err := p.Payments(list); err != nil {
+			logrus.Fatal(err)
+		}
+	},
+}
+
+var paymentsGetCmd = &cobra.Command{
+	Use:   "get <id>",
+	Short: "Show a payment",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		p := newPaymentsPrinter()
+		id := parsePaymentID(args[0])
+		payment, err := newPaymentsBackend().Get(paymentsContext(), id)
+		if err != nil {
+			logrus.Fatalf("Could not get the payment: %v", err)
+		}
+		if err := p.Payment(payment); err != nil {
+			logrus.Fatal(err)
+		}
+	},
+}
+
+var paymentsCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a payment from a JSON or YAML payload",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		p := newPaymentsPrinter()
+		backend := newPaymentsBackend()
+		payload, err := readPayment(paymentsFile)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		payment, err := backend.Create(paymentsContext(), payload)
+		if err != nil {
+			logrus.Fatalf("Could not create the payment: %v", err)
+		}
+		if err := p.Payment(payment); err != nil {
+			logrus.Fatal(err)
+		}
+	},
+}
+
+var paymentsUpdateCmd = &cobra.Command{
+	Use:   "update <id>",
+	Short: "Replace a payment with a JSON or YAML payload",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		p := newPaymentsPrinter()
+		id := parsePaymentID(args[0])
+		backend := newPaymentsBackend()
+		payload, err := readPayment(paymentsFile)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		payment, err := backend.Update(paymentsContext(), id, payload)
+		if err != nil {
+			logrus.Fatalf("Could not update the payment: %v", err)
+		}
+		if err := p.Payment(payment); err != nil {
+			logrus.Fatal(err)
+		}
+	},
+}
+
+var paymentsDeleteCmd = &cobra.Command{
+	Use:   "delete <id>",
+	Short: "Delete a payment",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := parsePaymentID(args[0])
+		if err := newPaymentsBackend().Delete(paymentsContext(), id); err != nil {
+			logrus.Fatalf("Could not delete the payment: %v", err)
+		}
+		logrus.Infof("Payment %s deleted", id)
+	},
+}
+
+var paymentsWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Print the payment events of a running server as they happen",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		p := newPaymentsPrinter()
+		opts := parsePaymentsFilters()
+		ctx := paymentsContext()
+		err := newPaymentsBackend().Watch(ctx, &client.WatchOptions{
+			Filters:     opts.Filters,
+			LastEventID: paymentsLastID,
+		}, p.Event)
+		if err != nil && ctx.Err() == nil {
+			logrus.Fatalf("Could not watch the payments: %v", err)
+		}
+	},
+}
+
+func init() {
+	flags := paymentsCmd.PersistentFlags()
+	flags.StringVar(&paymentsURL, "url", "", "URL of the server, defaults to client.url or the address of the server")
+	flags.StringVar(&paymentsAPIKey, "api-key", "", "API key sent to the server, defaults to client.api_key")
+	flags.StringVar(&paymentsClientID, "client-id", "", "Client signing the writes, defaults to client.client_id")
+	flags.StringVar(&paymentsSecret, "secret", "", "Secret signing the writes, defaults to client.secret")
+	flags.BoolVar(&paymentsDirect, "direct", false, "Work on the configured store instead of a running server")
+	flags.StringVarP(&paymentsOutput, "output", "o", OutputTable, "Output format: table, json or yaml")
+
+	for _, c := range []*cobra.Command{paymentsListCmd, paymentsWatchCmd} {
+		c.Flags().StringArrayVar(&paymentsFilterArgs, "filter", nil, "Filter as field=value, repeat or separate values with commas to match any of them")
+	}
+	paymentsListCmd.Flags().IntVar(&paymentsLimit, "limit", 0, "Maximum amount of payments, 0 for all")
+	paymentsListCmd.Flags().IntVar(&paymentsOffset, "offset", 0, "Amount of payments to skip")
+	paymentsWatchCmd.Flags().Int64Var(&paymentsLastID, "last-event-id", 0, "Resume after this event sequence")
+	for _, c := range []*cobra.Command{paymentsCreateCmd, paymentsUpdateCmd} {
+		c.Flags().StringVarP(&paymentsFile, "file", "f", "-", "File holding the payload, - for stdin")
+	}
+
+	paymentsCmd.AddCommand(
+		paymentsListCmd,
+		paymentsGetCmd,
+		paymentsCreateCmd,
+		paymentsUpdateCmd,
+		paymentsDeleteCmd,
+		paymentsWatchCmd,
+	)
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/ganitzsh/f3-te/client"
+	"github.com/google/uuid"
+)
+
+// paymentsBackend is where the payments commands read and write the
+// payments, a running server or the store itself
+type paymentsBackend interface {
+	List(ctx context.Context, opts *client.ListOptions) (*client.PaymentList, error)
+	Get(ctx context.Context, id uuid.UUID) (*api.Payment, error)
+	Create(ctx context.Context, p *api.Payment) (*api.Payment, error)
+	Update(ctx context.Context, id uuid.UUID, p *api.Payment) (*api.Payment, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	Watch(ctx context.Context, opts *client.WatchOptions, fn func(*client.Event) error) error
+}
+
+// serverBackend goes through the REST API of a running server
+type serverBackend struct {
+	c *client.Client
+}
+
+func (b *serverBackend) List(ctx context.Context, opts *client.ListOptions) (*client.PaymentList, error) {
+	return b.c.ListPayments(ctx, opts)
+}
+
+func (b *serverBackend) Get(ctx context.Context, id uuid.UUID) (*api.Payment, error) {
+	return b.c.GetPayment(ctx, id)
+}
+
+func (b *serverBackend) Create(ctx context.Context, p *api.Payment) (*api.Payment, error) {
+	p.ID = uuid.Nil
+	return b.c.SavePayment(ctx, p)
+}
+
+func (b *serverBackend) Update(ctx context.Context, id uuid.UUID, p *api.Payment) (*api.Payment, error) {
+	p.ID = id
+	return b.c.SavePayment(ctx, p)
+}
+
+func (b *serverBackend) Delete(ctx context.Context, id uuid.UUID) error {
+	return b.c.DeletePayment(ctx, id)
+}
+
+func (b *serverBackend) Watch(ctx context.Context, opts *client.WatchOptions, fn func(*client.Event) error) error {
+	return b.c.Watch(ctx, opts, fn)
+}
+
+// storeBackend works on the configured store directly, the payments are
+// validated and saved the way the REST API does
+type storeBackend struct {
+	s api.PaymentStore
+}
+
+func (b *storeBackend) List(ctx context.Context, opts *client.ListOptions) (*client.PaymentList, error) {
+	list, err := b.s.GetMany(ctx, opts.Limit, opts.Offset, api.FiltersFromQuery(opts.Filters)...)
+	if err != nil {
+		return nil, err
+	}
+	payments, _ := list.Results.([]*api.Payment)
+	return &client.PaymentList{
+		Total:    list.Total,
+		SubTotal: list.SubTotal,
+		Payments: payments,
+	}, nil
+}
+
+func (b *storeBackend) Get(ctx context.Context, id uuid.UUID) (*api.Payment, error) {
+	return b.s.GetByID(ctx, id)
+}
+
+func (b *storeBackend) Create(ctx context.Context, p *api.Payment) (*api.Payment, error) {
+	if err := api.ValidatePayment(p); err != nil {
+		return nil, err
+	}
+	created := api.NewPayment()
+	p.ID, p.CreatedAt, p.UpdatedAt = created.ID, created.CreatedAt, created.UpdatedAt
+	if err := b.s.Save(ctx, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (b *storeBackend) Update(ctx context.Context, id uuid.UUID, p *api.Payment) (*api.Payment, error) {
+	if err := api.ValidatePayment(p); err != nil {
+		return nil, err
+	}
+	prev, err := b.s.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	p.ID, p.CreatedAt, p.UpdatedAt = id, prev.CreatedAt, &now
+	if err := b.s.Save(ctx, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (b *storeBackend) Delete(ctx context.Context, id uuid.UUID) error {
+	if _, err := b.s.GetByID(ctx, id); err != nil {
+		return err
+	}
+	return b.s.Delete(ctx, id)
+}
+
+func (b *storeBackend) Watch(ctx context.Context, opts *client.WatchOptions, fn func(*client.Event) error) error {
+	return errors.New("the events are only streamed by a running server, watch without --direct")
+}
+
+// paymentsFilters parses the field=value filters of the command line
+func paymentsFilters(filters []string) (url.Values, error) {
+	ret := url.Values{}
+	for _, f := range filters {
+		kv, err := url.ParseQuery(f)
+		if err != nil || len(kv) != 1 {
+			return nil, errors.New("invalid filter " + f + ", expected field=value")
+		}
+		for k, v := range kv {
+			if _, ok := api.PaymentFilterFields[k]; !ok {
+				return nil, errors.New("unknown filter field " + k)
+			}
+			ret[k] = append(ret[k], v...)
+		}
+	}
+	return ret, nil
+}
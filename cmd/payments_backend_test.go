@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/ganitzsh/f3-te/api"
+	"github.com/ganitzsh/f3-te/client"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentsFilters(t *testing.T) {
+	tests := []struct {
+		args []string
+		want url.Values
+		err  bool
+	}{
+		{nil, url.Values{}, false},
+		{[]string{"scheme=FPS"}, url.Values{"scheme": {"FPS"}}, false},
+		{[]string{"currency=GBP,EUR", "currency=USD"}, url.Values{"currency": {"GBP,EUR", "USD"}}, false},
+		{[]string{"scheme=FPS", "type=Payment"}, url.Values{"scheme": {"FPS"}, "type": {"Payment"}}, false},
+		{[]string{"reference=a%20b"}, url.Values{"reference": {"a b"}}, false},
+		{[]string{"unknown=1"}, nil, true},
+		{[]string{"scheme=FPS&currency=GBP"}, nil, true},
+		{[]string{"scheme=%zz"}, nil, true},
+	}
+	for _, test := range tests {
+		got, err := paymentsFilters(test.args)
+		if test.err {
+			assert.Error(t, err, "%v", test.args)
+			continue
+		}
+		if assert.NoError(t, err, "%v", test.args) {
+			assert.Equal(t, test.want, got, "%v", test.args)
+		}
+	}
+}
+
+func TestStoreBackend(t *testing.T) {
+	ctx := context.Background()
+	b := &storeBackend{api.NewPaymentInMemStore()}
+
+	created, err := b.Create(ctx, &api.Payment{ID: uuid.New(), Scheme: "FPS", Amount: "42.00"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotEqual(t, uuid.Nil, created.ID)
+	assert.NotNil(t, created.CreatedAt)
+	_, err = b.Create(ctx, &api.Payment{Scheme: "SEPA"})
+	assert.NoError(t, err)
+
+	got, err := b.Get(ctx, created.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "42.00", got.Amount)
+	}
+
+	list, err := b.List(ctx, &client.ListOptions{Filters: url.Values{"scheme": {"FPS"}}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, list.Total)
+		if assert.Len(t, list.Payments, 1) {
+			assert.Equal(t, created.ID, list.Payments[0].ID)
+		}
+	}
+	list, err = b.List(ctx, &client.ListOptions{Limit: 1})
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, list.Total)
+		assert.Equal(t, 1, list.SubTotal)
+	}
+
+	updated, err := b.Update(ctx, created.ID, &api.Payment{Scheme: "FPS", Amount: "43.00"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, created.ID, updated.ID)
+		assert.Equal(t, created.CreatedAt, updated.CreatedAt)
+		assert.False(t, updated.UpdatedAt.Before(*created.UpdatedAt))
+	}
+	_, err = b.Update(ctx, uuid.New(), &api.Payment{})
+	assert.Equal(t, api.ErrNotFound, err)
+
+	// the payments are validated before they are written
+	_, err = b.Create(ctx, nil)
+	assert.Equal(t, api.ErrInvalidInput, err)
+	_, err = b.Update(ctx, created.ID, nil)
+	assert.Equal(t, api.ErrInvalidInput, err)
+
+	assert.NoError(t, b.Delete(ctx, created.ID))
+	assert.Equal(t, api.ErrNotFound, b.Delete(ctx, created.ID))
+	_, err = b.Get(ctx, created.ID)
+	assert.Equal(t, api.ErrNotFound, err)
+
+	assert.Error(t, b.Watch(ctx, &client.WatchOptions{}, func(*client.Event) error { return nil }))
+}
@@ -15,6 +15,9 @@ func init() {
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(healthcheckCmd)
 	rootCmd.AddCommand(docgenCmd)
+	rootCmd.AddCommand(outboxCmd)
+	rootCmd.AddCommand(paymentsCmd)
+	rootCmd.AddCommand(dbCmd)
 }
 
 func Execute(mainFunc func()) {